@@ -0,0 +1,170 @@
+// Package boterr classifies the errors a turn can end with, so the bots, the
+// ITSM server and embedders map each kind the same way to a span status, an
+// HTTP status code and a message for the user.
+package boterr
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The kinds of error. Test for them with errors.Is, or get one with Kind.
+var (
+	// ErrRateLimited: the provider's rate limits, a tenant quota or a
+	// spend budget refused the turn for now.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrContextTooLong: the conversation no longer fits the model's
+	// context window.
+	ErrContextTooLong = errors.New("conversation too long for the model's context window")
+	// ErrGuardrailBlocked: the input policy blocked the message before it
+	// reached the model.
+	ErrGuardrailBlocked = errors.New("blocked by the input policy")
+	// ErrProviderUnavailable: the model provider failed, timed out or is
+	// overloaded, or too many calls are queued for it.
+	ErrProviderUnavailable = errors.New("model provider unavailable")
+	// ErrPolicyDenied: the caller may not do what was asked.
+	ErrPolicyDenied = errors.New("denied by policy")
+)
+
+var kinds = []struct {
+	kind    error
+	name    string
+	status  int
+	message string
+	// fault is set for kinds that mean something failed, as opposed to
+	// refusals working as intended
+	fault bool
+}{
+	{ErrRateLimited, "rate_limited", http.StatusTooManyRequests, "Too many requests right now. Please wait a moment and try again.", true},
+	{ErrContextTooLong, "context_too_long", http.StatusRequestEntityTooLarge, "This conversation is too long for the model. Compact it or start a new session.", true},
+	{ErrGuardrailBlocked, "guardrail_blocked", http.StatusUnprocessableEntity, "Sorry, I can't help with that request.", false},
+	{ErrProviderUnavailable, "provider_unavailable", http.StatusServiceUnavailable, "The model is unavailable right now. Please try again shortly.", true},
+	{ErrPolicyDenied, "policy_denied", http.StatusForbidden, "That isn't allowed by policy.", false},
+}
+
+// Error is an error of a known kind. Message, when set, is what the user is
+// told instead of the kind's default message.
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+// New returns an error of kind caused by err. message may be empty.
+func New(kind error, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Kind returns the kind of err: one of the Err* variables, or nil when err
+// is of none of them. Errors from the Anthropic API and the network are
+// classified by their status and type.
+func Kind(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, k := range kinds {
+		if errors.Is(err, k.kind) {
+			return k.kind
+		}
+	}
+	var apiErr *anthropic.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return ErrRateLimited
+		case http.StatusRequestEntityTooLarge:
+			return ErrContextTooLong
+		case http.StatusBadRequest:
+			if strings.Contains(apiErr.Error(), "prompt is too long") {
+				return ErrContextTooLong
+			}
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+			return ErrProviderUnavailable
+		}
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrProviderUnavailable
+	}
+	return nil
+}
+
+// Name is the error.type recorded for err: rate_limited, context_too_long,
+// guardrail_blocked, provider_unavailable, policy_denied, or internal.
+func Name(err error) string {
+	kind := Kind(err)
+	for _, k := range kinds {
+		if k.kind == kind {
+			return k.name
+		}
+	}
+	return "internal"
+}
+
+// HTTPStatus is the status code err is answered with: 429, 413, 422, 503
+// and 403 for the kinds in the order they are declared, 500 otherwise.
+func HTTPStatus(err error) int {
+	kind := Kind(err)
+	for _, k := range kinds {
+		if k.kind == kind {
+			return k.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// UserMessage is what to tell the user about err: its Error's Message, or
+// the default message for its kind. It never includes err's details.
+func UserMessage(err error) string {
+	var e *Error
+	if errors.As(err, &e) && e.Message != "" {
+		return e.Message
+	}
+	kind := Kind(err)
+	for _, k := range kinds {
+		if k.kind == kind {
+			return k.message
+		}
+	}
+	return "Something went wrong. Please try again."
+}
+
+// Record records err on span with its error.type. Failures set the span's
+// status to Error; refusals (ErrGuardrailBlocked, ErrPolicyDenied) leave it
+// unset, since the turn did what it should.
+func Record(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.SetAttributes(attribute.String("error.type", Name(err)))
+	span.RecordError(err)
+	kind := Kind(err)
+	for _, k := range kinds {
+		if k.kind == kind && !k.fault {
+			return
+		}
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,171 @@
+// Package chat holds the model-call logic shared by the bots, and Session,
+// the traced conversation with a persona that go-bot-chat runs.
+package chat
+
+import (
+	"context"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+
+	"go-tracing-demo/tools"
+)
+
+// maxContinuations caps how many follow-up requests are made when a
+// response is cut off by max_tokens.
+const maxContinuations = 2
+
+// maxToolRounds caps how many rounds of tool calls one answer may take.
+const maxToolRounds = 8
+
+// Completion is one logical model answer, possibly stitched together from
+// several requests when the model ran out of output tokens or called tools.
+type Completion struct {
+	Text          string
+	FinishReasons []string
+	InputTokens   int64
+	OutputTokens  int64
+	Continued     bool
+	ToolCalls     []tools.Call
+	// Blocks are the answer's text and tool_use blocks in the order the
+	// model wrote them, with continued text joined into one block.
+	Blocks []Block
+}
+
+// Generate sends params and keeps going until the model has finished:
+// tool_use blocks are run with executor (which may be nil) and their results
+// sent back, and a reply cut off by max_tokens is continued by prefilling the
+// partial answer as the assistant turn.
+func Generate(ctx context.Context, client *anthropic.Client, params anthropic.MessageNewParams, executor *tools.Executor) (Completion, error) {
+	return GenerateStream(ctx, client, params, executor, nil)
+}
+
+// GenerateStream is Generate with the replies streamed: onDelta gets each
+// piece of text as it arrives, across tool rounds and continuations. The
+// pieces don't include the separators Completion.Text has between blocks
+// and segments. A nil onDelta makes it Generate.
+func GenerateStream(ctx context.Context, client *anthropic.Client, params anthropic.MessageNewParams, executor *tools.Executor, onDelta func(text string)) (Completion, error) {
+	var out Completion
+	if executor != nil {
+		params.Tools = executor.Params()
+	}
+	history := params.Messages[:len(params.Messages):len(params.Messages)]
+
+	// Text of the current reply, which may span continuation requests
+	var partial string
+	continuations, toolRounds := 0, 0
+	// Set while the next response continues the last text block
+	var continuing bool
+
+	for {
+		params.Messages = history
+		// The API rejects a prefilled assistant turn ending in whitespace,
+		// so only the prefill is trimmed; the answer keeps its text as sent
+		if prefill := strings.TrimRight(partial, " \t\n"); prefill != "" {
+			params.Messages = append(history,
+				anthropic.NewAssistantMessage(anthropic.NewTextBlock(prefill)),
+			)
+		}
+
+		resp, err := send(ctx, client, params, onDelta)
+		if err != nil {
+			return out, err
+		}
+		out.FinishReasons = append(out.FinishReasons, string(resp.StopReason))
+		out.InputTokens += resp.Usage.InputTokens
+		out.OutputTokens += resp.Usage.OutputTokens
+
+		// Concat all text blocks and collect tool calls
+		var textParts []string
+		var calls []tools.Call
+		for _, block := range resp.Content {
+			switch block.Type {
+			case "text":
+				textParts = append(textParts, block.Text)
+				if n := len(out.Blocks); continuing && n > 0 && out.Blocks[n-1].Type == "text" {
+					out.Blocks[n-1].Text += block.Text
+				} else {
+					out.Blocks = append(out.Blocks, Block{Type: "text", Text: block.Text})
+				}
+			case "tool_use":
+				calls = append(calls, tools.Call{ID: block.ID, Name: block.Name, Input: block.Input})
+				out.Blocks = append(out.Blocks, Block{Type: "tool_use", ID: block.ID, Name: block.Name, Input: block.Input})
+			}
+			continuing = false
+		}
+		partial += strings.Join(textParts, "\n")
+
+		if resp.StopReason == anthropic.StopReasonToolUse && executor != nil && len(calls) > 0 && toolRounds < maxToolRounds {
+			toolRounds++
+			out.ToolCalls = append(out.ToolCalls, calls...)
+
+			assistant := make([]anthropic.ContentBlockParamUnion, 0, len(resp.Content)+1)
+			if text := strings.TrimRight(partial, " \t\n"); text != "" {
+				assistant = append(assistant, anthropic.NewTextBlock(text))
+			}
+			for _, block := range resp.Content {
+				if block.Type == "tool_use" {
+					assistant = append(assistant, block.ToParam())
+				}
+			}
+
+			results := executor.Run(ctx, calls)
+			toolResults := make([]anthropic.ContentBlockParamUnion, len(results))
+			for i, r := range results {
+				toolResults[i] = anthropic.NewToolResultBlock(r.ID, r.Content, r.IsError)
+			}
+
+			history = append(history,
+				anthropic.NewAssistantMessage(assistant...),
+				anthropic.NewUserMessage(toolResults...),
+			)
+			out.Text = joinText(out.Text, partial)
+			partial = ""
+			continue
+		}
+
+		if resp.StopReason == anthropic.StopReasonMaxTokens && continuations < maxContinuations {
+			continuations++
+			out.Continued, continuing = true, true
+			continue
+		}
+
+		out.Text = joinText(out.Text, partial)
+		return out, nil
+	}
+}
+
+// send makes one request, streamed to onDelta when it is set.
+func send(ctx context.Context, client *anthropic.Client, params anthropic.MessageNewParams, onDelta func(text string)) (*anthropic.Message, error) {
+	if onDelta == nil {
+		return client.Messages.New(ctx, params)
+	}
+	stream := client.Messages.NewStreaming(ctx, params)
+	defer stream.Close()
+	var msg anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := msg.Accumulate(event); err != nil {
+			return nil, err
+		}
+		if event.Type == "content_block_delta" && event.Delta.Type == "text_delta" {
+			onDelta(event.Delta.Text)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// joinText appends the text of a later reply segment to an earlier one.
+func joinText(text, more string) string {
+	switch {
+	case strings.TrimSpace(more) == "":
+		return text
+	case text == "":
+		return more
+	default:
+		return text + "\n\n" + more
+	}
+}
@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// TestGenerateContinuesWithoutTrimming cuts a reply off after a paragraph
+// break: the prefill sent to continue it is trimmed, the answer is not.
+func TestGenerateContinuesWithoutTrimming(t *testing.T) {
+	replies := []struct{ text, stop string }{
+		{"First paragraph.\n\n", "max_tokens"},
+		{"Second paragraph.", "end_turn"},
+	}
+	var prefills []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if last := req.Messages[len(req.Messages)-1]; last.Role == "assistant" {
+			prefills = append(prefills, last.Content[0].Text)
+		}
+		reply := replies[len(prefills)]
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","model":"m","content":[{"type":"text","text":%q}],"stop_reason":%q,"usage":{"input_tokens":1,"output_tokens":1}}`,
+			reply.text, reply.stop)
+	}))
+	defer srv.Close()
+	client := anthropic.NewClient(option.WithBaseURL(srv.URL), option.WithAPIKey("k"), option.WithMaxRetries(0))
+
+	out, err := Generate(context.Background(), &client, anthropic.MessageNewParams{
+		Model:     "m",
+		MaxTokens: 10,
+		Messages:  []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("Hi"))},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefills) != 1 || prefills[0] != "First paragraph." {
+		t.Errorf("prefills = %q, want the first reply trimmed", prefills)
+	}
+	want := "First paragraph.\n\nSecond paragraph."
+	if out.Text != want {
+		t.Errorf("Text = %q, want %q", out.Text, want)
+	}
+	if len(out.Blocks) != 1 || out.Blocks[0].Text != want {
+		t.Errorf("Blocks = %+v, want one text block %q", out.Blocks, want)
+	}
+}
@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"github.com/anthropics/anthropic-sdk-go"
+
+	"go-tracing-demo/tools"
+)
+
+// HistoryTokens estimates how many tokens messages add to a request: their
+// text, tool inputs and tool results. Images and documents are not counted.
+func HistoryTokens(messages []anthropic.MessageParam) int {
+	var n int
+	for _, m := range messages {
+		for _, block := range m.Content {
+			switch {
+			case block.OfText != nil:
+				n += tools.EstimateTokens(block.OfText.Text)
+			case block.OfToolUse != nil:
+				n += tools.EstimateTokens(block.OfToolUse.Name) + inputTokens(block.OfToolUse.Input)
+			case block.OfToolResult != nil:
+				for _, c := range block.OfToolResult.Content {
+					if c.OfText != nil {
+						n += tools.EstimateTokens(c.OfText.Text)
+					}
+				}
+			}
+		}
+	}
+	return n
+}
+
+func inputTokens(input any) int {
+	switch v := input.(type) {
+	case json.RawMessage:
+		return tools.EstimateTokens(string(v))
+	case string:
+		return tools.EstimateTokens(v)
+	}
+	data, _ := json.Marshal(input)
+	return tools.EstimateTokens(string(data))
+}
@@ -0,0 +1,39 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+func benchHistory(turns int) []anthropic.MessageParam {
+	var history []anthropic.MessageParam
+	for i := range turns {
+		history = append(history,
+			anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf("Turn %d: I need read access to snowflake prod for 7 days.", i))),
+			anthropic.NewAssistantMessage(
+				anthropic.NewTextBlock(strings.Repeat("Here is your ticket draft. ", 20)),
+				anthropic.NewToolUseBlock("toolu_1", json.RawMessage(`{"ticket_id": "AR-1A2B3C4D"}`), "provision_access"),
+			),
+			anthropic.NewUserMessage(anthropic.NewToolResultBlock("toolu_1", `{"status": "provisioned"}`, false)),
+		)
+	}
+	return history
+}
+
+// BenchmarkHistoryTokens measures estimating the token size of a history,
+// which grows with every turn of a conversation.
+func BenchmarkHistoryTokens(b *testing.B) {
+	for _, turns := range []int{10, 100} {
+		history := benchHistory(turns)
+		b.Run(fmt.Sprintf("turns=%d", turns), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				HistoryTokens(history)
+			}
+		})
+	}
+}
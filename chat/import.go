@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Imported is a conversation started elsewhere, to be continued.
+type Imported struct {
+	// SessionID is the conversation's session_id, if the file gave one.
+	SessionID string
+	Messages  []anthropic.MessageParam
+}
+
+// ChatMessage is an OpenAI or Anthropic chat message. Content is a string
+// or a list of parts, of which only text parts are kept.
+type ChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// ImportConversation reads path: a JSON array of messages, or an object
+// with "messages" and an optional "session_id". System messages are
+// dropped, since the bot brings its own system prompt, and consecutive
+// messages of one role are joined, as the Messages API wants turns to
+// alternate.
+func ImportConversation(path string) (Imported, error) {
+	var out Imported
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return out, err
+	}
+	var messages []ChatMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		var file struct {
+			SessionID string        `json:"session_id"`
+			Messages  []ChatMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return out, fmt.Errorf("parsing %s: want a message array or an object with messages", path)
+		}
+		out.SessionID, messages = file.SessionID, file.Messages
+	}
+
+	// The bot brings its own system prompt
+	if _, out.Messages, err = ConvertMessages(messages); err != nil {
+		return out, fmt.Errorf("%s %w", path, err)
+	}
+	if len(out.Messages) == 0 {
+		return out, fmt.Errorf("%s has no user or assistant messages", path)
+	}
+	return out, nil
+}
+
+// ConvertMessages turns chat messages into Messages API turns, with the
+// text of system and developer messages returned apart. Consecutive
+// messages of one role are joined, as the Messages API wants turns to
+// alternate.
+func ConvertMessages(messages []ChatMessage) (system []string, out []anthropic.MessageParam, err error) {
+	var lastRole string
+	for i, m := range messages {
+		text, err := importedText(m.Content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		var role anthropic.MessageParamRole
+		switch m.Role {
+		case "user":
+			role = anthropic.MessageParamRoleUser
+		case "assistant":
+			role = anthropic.MessageParamRoleAssistant
+		case "system", "developer":
+			if strings.TrimSpace(text) != "" {
+				system = append(system, text)
+			}
+			continue
+		default:
+			return nil, nil, fmt.Errorf("message %d: unknown role %q", i, m.Role)
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if m.Role == lastRole {
+			prev := &out[len(out)-1]
+			prev.Content = append(prev.Content, anthropic.NewTextBlock(text))
+			continue
+		}
+		out = append(out, anthropic.MessageParam{Role: role, Content: []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock(text)}})
+		lastRole = m.Role
+	}
+	return system, out, nil
+}
+
+func importedText(content json.RawMessage) (string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", errors.New("content must be a string or a list of parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" || p.Type == "input_text" || p.Type == "output_text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
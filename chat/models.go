@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default models for each kind of step.
+const (
+	DefaultChatModel       = "claude-sonnet-4-20250514"
+	DefaultClassifierModel = "claude-haiku-4-5-20251001"
+)
+
+// Models names the model each kind of step uses, so the conversation can
+// run on a capable model while the many small side calls use a cheap one.
+type Models struct {
+	// Chat answers the user, and plans and runs planner steps.
+	Chat string
+	// Summary condenses tool results and long histories and writes
+	// escalation handoffs.
+	Summary string
+	// Classifier checks topics and scores justifications.
+	Classifier string
+}
+
+// ModelsFromEnv reads CHAT_MODEL, SUMMARY_MODEL and CLASSIFIER_MODEL,
+// defaulting to DefaultChatModel, SummaryModel and DefaultClassifierModel.
+// Each is resolved with ResolveModel, so aliases come back as the model
+// they name.
+func ModelsFromEnv() (Models, error) {
+	m := Models{Chat: DefaultChatModel, Summary: SummaryModel, Classifier: DefaultClassifierModel}
+	if v := os.Getenv("CHAT_MODEL"); v != "" {
+		m.Chat = v
+	}
+	if v := os.Getenv("SUMMARY_MODEL"); v != "" {
+		m.Summary = v
+	}
+	if v := os.Getenv("CLASSIFIER_MODEL"); v != "" {
+		m.Classifier = v
+	}
+	for _, v := range []struct {
+		name  string
+		model *string
+	}{
+		{"CHAT_MODEL", &m.Chat},
+		{"SUMMARY_MODEL", &m.Summary},
+		{"CLASSIFIER_MODEL", &m.Classifier},
+	} {
+		resolved, err := ResolveModel(*v.model)
+		if err != nil {
+			return m, fmt.Errorf("%s: %w", v.name, err)
+		}
+		*v.model = resolved
+	}
+	return m, nil
+}
+
+// ModelInfo is what the bots need to know about a model.
+type ModelInfo struct {
+	// ContextWindow is how many tokens, input and output together, a
+	// request may use; MaxOutputTokens caps max_tokens.
+	ContextWindow   int
+	MaxOutputTokens int
+	// Prices are USD per million input and output tokens.
+	InputUSDPerMTok  float64
+	OutputUSDPerMTok float64
+	// Feature flags: image input, prompt caching and extended thinking.
+	Vision   bool
+	Caching  bool
+	Thinking bool
+}
+
+// CostUSD is what a request with these token counts costs.
+func (m ModelInfo) CostUSD(inputTokens, outputTokens int64) float64 {
+	return (float64(inputTokens)*m.InputUSDPerMTok + float64(outputTokens)*m.OutputUSDPerMTok) / 1e6
+}
+
+// DefaultContextWindow is assumed for models missing from the registry.
+const DefaultContextWindow = 200_000
+
+// registry holds the models the bots know, by model ID.
+var registry = map[string]ModelInfo{
+	"claude-opus-4-1-20250805":   {ContextWindow: 200_000, MaxOutputTokens: 32_000, InputUSDPerMTok: 15, OutputUSDPerMTok: 75, Vision: true, Caching: true, Thinking: true},
+	"claude-sonnet-4-5-20250929": {ContextWindow: 200_000, MaxOutputTokens: 64_000, InputUSDPerMTok: 3, OutputUSDPerMTok: 15, Vision: true, Caching: true, Thinking: true},
+	"claude-sonnet-4-20250514":   {ContextWindow: 200_000, MaxOutputTokens: 64_000, InputUSDPerMTok: 3, OutputUSDPerMTok: 15, Vision: true, Caching: true, Thinking: true},
+	"claude-haiku-4-5-20251001":  {ContextWindow: 200_000, MaxOutputTokens: 64_000, InputUSDPerMTok: 1, OutputUSDPerMTok: 5, Vision: true, Caching: true, Thinking: true},
+	"claude-3-5-haiku-20241022":  {ContextWindow: 200_000, MaxOutputTokens: 8_192, InputUSDPerMTok: 0.8, OutputUSDPerMTok: 4, Vision: true, Caching: true},
+}
+
+// LookupModel returns what the registry knows about model.
+func LookupModel(model string) (ModelInfo, bool) {
+	m, ok := registry[model]
+	return m, ok
+}
+
+// ValidateModel returns an error naming the known models if model isn't
+// one of them.
+func ValidateModel(model string) error {
+	if _, ok := registry[model]; ok {
+		return nil
+	}
+	known := make([]string, 0, len(registry))
+	for id := range registry {
+		known = append(known, id)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown model %q (known: %s)", model, strings.Join(known, ", "))
+}
+
+// aliases name the current model of each family, so configuration can
+// follow new releases. Moving an alias is a one-line change here.
+var aliases = map[string]string{
+	"opus-latest":   "claude-opus-4-1-20250805",
+	"sonnet-latest": "claude-sonnet-4-5-20250929",
+	"haiku-latest":  "claude-haiku-4-5-20251001",
+
+	// The API's own undated aliases, pinned so traces name one version
+	"claude-opus-4-1":         "claude-opus-4-1-20250805",
+	"claude-sonnet-4-5":       "claude-sonnet-4-5-20250929",
+	"claude-sonnet-4-0":       "claude-sonnet-4-20250514",
+	"claude-haiku-4-5":        "claude-haiku-4-5-20251001",
+	"claude-3-5-haiku-latest": "claude-3-5-haiku-20241022",
+}
+
+// deprecation is when a model stops being served and what to use instead.
+type deprecation struct {
+	Retires     string // YYYY-MM-DD
+	Replacement string
+}
+
+// deprecations lists models Anthropic has announced the retirement of.
+var deprecations = map[string]deprecation{
+	"claude-2.0":                 {"2025-07-21", "claude-sonnet-4-5-20250929"},
+	"claude-2.1":                 {"2025-07-21", "claude-sonnet-4-5-20250929"},
+	"claude-3-sonnet-20240229":   {"2025-07-21", "claude-sonnet-4-5-20250929"},
+	"claude-3-5-sonnet-20240620": {"2025-10-22", "claude-sonnet-4-5-20250929"},
+	"claude-3-5-sonnet-20241022": {"2025-10-22", "claude-sonnet-4-5-20250929"},
+	"claude-3-opus-20240229":     {"2026-01-05", "claude-opus-4-1-20250805"},
+}
+
+// ResolveModel turns an alias such as sonnet-latest into the model it
+// names and checks the result. A deprecated model is logged as a warning
+// until its retirement date and is an error after it; a model the
+// registry doesn't know is an error.
+func ResolveModel(model string) (string, error) {
+	if target, ok := aliases[model]; ok {
+		model = target
+	}
+	if d, ok := deprecations[model]; ok {
+		if time.Now().UTC().Format(time.DateOnly) >= d.Retires {
+			return model, fmt.Errorf("model %q was retired on %s; use %s", model, d.Retires, d.Replacement)
+		}
+		log.Printf("Warning: model %q is deprecated and retires on %s; use %s", model, d.Retires, d.Replacement)
+		return model, nil
+	}
+	return model, ValidateModel(model)
+}
+
+// ContextWindow is model's context window, or DefaultContextWindow for a
+// model the registry doesn't know.
+func ContextWindow(model string) int {
+	if m, ok := registry[model]; ok {
+		return m.ContextWindow
+	}
+	return DefaultContextWindow
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/tools"
+)
+
+// Block is one content block of an answer: text, or a tool call.
+type Block struct {
+	// Type is "text" or "tool_use".
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// TurnResult is what a turn produced. It is the one result shape the bots
+// return to embedders and answer JSON requests with.
+type TurnResult struct {
+	SessionID string `json:"session_id"`
+	// TraceID and SpanID identify the turn span; they are empty with
+	// tracing disabled.
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
+
+	Reply     string       `json:"reply"`
+	Blocks    []Block      `json:"blocks,omitempty"`
+	ToolCalls []tools.Call `json:"tool_calls,omitempty"`
+
+	// Model is the model that answered. InputTokens and OutputTokens are
+	// its usage over all the answer's requests, and CostUSD their price.
+	Model         string   `json:"model"`
+	FinishReasons []string `json:"finish_reasons,omitempty"`
+	InputTokens   int64    `json:"input_tokens"`
+	OutputTokens  int64    `json:"output_tokens"`
+	CostUSD       float64  `json:"cost_usd"`
+
+	// ContextWarning is set when the request came close to the model's
+	// context window.
+	ContextWarning string `json:"context_warning,omitempty"`
+}
+
+// NewTurnResult is the result of a turn of session sessionID that model
+// answered with resp, traced by span. Models without registry pricing cost
+// nothing.
+func NewTurnResult(sessionID, model string, span trace.SpanContext, resp Completion) TurnResult {
+	info, _ := LookupModel(model)
+	result := TurnResult{
+		SessionID:     sessionID,
+		Reply:         resp.Text,
+		Blocks:        resp.Blocks,
+		ToolCalls:     resp.ToolCalls,
+		Model:         model,
+		FinishReasons: resp.FinishReasons,
+		InputTokens:   resp.InputTokens,
+		OutputTokens:  resp.OutputTokens,
+		CostUSD:       info.CostUSD(resp.InputTokens, resp.OutputTokens),
+	}
+	if span.IsValid() {
+		result.TraceID = span.TraceID().String()
+		result.SpanID = span.SpanID().String()
+	}
+	return result
+}
@@ -0,0 +1,286 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/guardrail"
+	"go-tracing-demo/payload"
+	"go-tracing-demo/persona"
+	"go-tracing-demo/tools"
+)
+
+// ErrNoTurn is returned when there is no answered turn to undo or retry.
+var ErrNoTurn = errors.New("no turn to rewind")
+
+// ContextGuard checks a request against the model's context window before
+// it is sent. *historytrim.Guard is one.
+type ContextGuard interface {
+	Check(ctx context.Context, span trace.Span, system string, messages []anthropic.MessageParam, maxTokens int) ([]anthropic.MessageParam, string, error)
+}
+
+// SessionConfig is how a Session answers.
+type SessionConfig struct {
+	// Persona answers the turns and names their spans.
+	Persona *persona.Persona
+	// SystemPrompt is the persona's prompt, localized for Locale.
+	SystemPrompt string
+	Locale       string
+	// Model answers the turns.
+	Model string
+	// Executor runs the persona's tools. It may be nil.
+	Executor *tools.Executor
+	// InputPolicy screens each message before it reaches the model. It may
+	// be nil.
+	InputPolicy *guardrail.Policy
+	// ContextGuard checks each request before it is sent. It may be nil.
+	ContextGuard ContextGuard
+	// Payloads decides how the completion is attached to the turn span.
+	Payloads payload.Options
+}
+
+// Session is one conversation with a persona: its history, threaded in
+// LangSmith by its ID, and the turns that can be undone or retried. Each
+// turn is traced as one span. A Session is not safe for concurrent use.
+type Session struct {
+	client *anthropic.Client
+	tracer trace.Tracer
+	config SessionConfig
+
+	id      string
+	history []anthropic.MessageParam
+
+	// Answered turns, oldest first, so Undo and Retry can rewind them
+	turns []turnRecord
+	// compactedTurns counts the turns folded away by ReplaceHistory; they
+	// can't be undone or retried.
+	compactedTurns int
+
+	// Set after Fork so the next turn links back to where the branch started
+	forkedFrom string
+	forkLinks  []trace.Link
+
+	// imported counts the history messages brought in with Import
+	imported int
+}
+
+// turnRecord remembers an answered turn so it can be undone or regenerated.
+type turnRecord struct {
+	Prompt string
+	Span   trace.SpanContext
+}
+
+// NewSession starts a conversation under a new session ID.
+func NewSession(client *anthropic.Client, tracer trace.Tracer, config SessionConfig) *Session {
+	return &Session{client: client, tracer: tracer, config: config, id: uuid.New().String()}
+}
+
+// ID is the session ID the turns are threaded by.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// History is the conversation so far.
+func (s *Session) History() []anthropic.MessageParam {
+	return s.history
+}
+
+// Import continues an imported conversation, under its session ID when it
+// has one.
+func (s *Session) Import(imported Imported) {
+	s.history = imported.Messages
+	s.imported = len(imported.Messages)
+	if imported.SessionID != "" {
+		s.id = imported.SessionID
+	}
+}
+
+// TurnSpans are the span contexts of the answered turns, oldest first.
+func (s *Session) TurnSpans() []trace.SpanContext {
+	spans := make([]trace.SpanContext, len(s.turns))
+	for i, t := range s.turns {
+		spans[i] = t.Span
+	}
+	return spans
+}
+
+// CanRewind reports whether there is a turn Undo or Retry can rewind.
+func (s *Session) CanRewind() bool {
+	return len(s.turns) > s.compactedTurns
+}
+
+// Fork continues the conversation under a new session ID, keeping the
+// history. The next turn links back to the last one of the old session.
+// It returns the old session ID.
+func (s *Session) Fork() string {
+	s.forkedFrom = s.id
+	s.id = uuid.New().String()
+	s.forkLinks = nil
+	if len(s.turns) > 0 {
+		s.forkLinks = append(s.forkLinks, trace.Link{
+			SpanContext: s.turns[len(s.turns)-1].Span,
+			Attributes: []attribute.KeyValue{
+				attribute.String("langsmith.metadata.forked_from", s.forkedFrom),
+			},
+		})
+	}
+	return s.forkedFrom
+}
+
+// Undo removes the last exchange from the history.
+func (s *Session) Undo() error {
+	if !s.CanRewind() {
+		return ErrNoTurn
+	}
+	s.turns = s.turns[:len(s.turns)-1]
+	s.history = s.history[:len(s.history)-2]
+	return nil
+}
+
+// ReplaceHistory replaces the history, such as with a compacted one. The
+// turns so far can no longer be undone or retried.
+func (s *Session) ReplaceHistory(messages []anthropic.MessageParam) {
+	s.history, s.compactedTurns = messages, len(s.turns)
+}
+
+// RunTurn answers input within one traced turn span. History is only
+// updated once the turn succeeds. Errors are of the boterr kinds where they
+// have one, and are recorded on the turn span; a message the input policy
+// blocks fails with boterr.ErrGuardrailBlocked, whose user message is the
+// policy's response.
+func (s *Session) RunTurn(ctx context.Context, input string) (TurnResult, error) {
+	return s.turn(ctx, input, nil, param.Opt[float64]{})
+}
+
+// Retry answers the last turn's message again, replacing its answer. A
+// valid temperature overrides the model's default.
+func (s *Session) Retry(ctx context.Context, temperature param.Opt[float64]) (TurnResult, error) {
+	if !s.CanRewind() {
+		return TurnResult{}, ErrNoTurn
+	}
+	regenerated := &s.turns[len(s.turns)-1]
+	return s.turn(ctx, regenerated.Prompt, regenerated, temperature)
+}
+
+func (s *Session) turn(ctx context.Context, userMessage string, regenerated *turnRecord, temperature param.Opt[float64]) (result TurnResult, err error) {
+	var messages []anthropic.MessageParam
+	if regenerated != nil {
+		// Answer the last user message again, dropping the previous reply
+		messages = s.history[:len(s.history)-1]
+	} else {
+		messages = append(s.history,
+			anthropic.NewUserMessage(anthropic.NewTextBlock(userMessage)),
+		)
+	}
+
+	// Create a parent span for this conversation turn with thread metadata
+	// This groups all turns with the same session_id into a thread in LangSmith
+	turnIndex := len(s.turns)
+	if regenerated != nil {
+		turnIndex--
+	}
+	bot := s.config.Persona
+	traceName, spanName := bot.TurnNames(persona.NameVars{SessionID: s.id, TurnIndex: turnIndex, Prompt: userMessage})
+	turnAttrs := []attribute.KeyValue{
+		attribute.String("langsmith.trace.name", traceName),
+		attribute.String("langsmith.metadata.session_id", s.id),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("langsmith.metadata.user.locale", s.config.Locale),
+		// Set input on the parent span for Thread view
+		attribute.String("gen_ai.prompt", userMessage),
+		attribute.String("gen_ai.request.model", s.config.Model),
+		// Conversation length, to relate it to latency and cost across traces
+		attribute.Int("conversation.turn_index", turnIndex),
+		attribute.Int("conversation.message_count", len(messages)),
+		attribute.Int("conversation.history_tokens", HistoryTokens(messages)),
+	}
+	turnAttrs = append(turnAttrs, bot.Attributes()...)
+	if s.forkedFrom != "" {
+		turnAttrs = append(turnAttrs, attribute.String("langsmith.metadata.forked_from", s.forkedFrom))
+	}
+	if s.imported > 0 {
+		turnAttrs = append(turnAttrs, attribute.Int("langsmith.metadata.imported_messages", s.imported))
+	}
+	links := s.forkLinks
+	if regenerated != nil {
+		turnAttrs = append(turnAttrs, attribute.Bool("regeneration", true))
+		if temperature.Valid() {
+			turnAttrs = append(turnAttrs, attribute.Float64("gen_ai.request.temperature", temperature.Value))
+		}
+		links = append(links, trace.Link{SpanContext: regenerated.Span})
+	}
+	turnCtx, turnSpan := s.tracer.Start(ctx, spanName,
+		trace.WithAttributes(turnAttrs...),
+		trace.WithLinks(links...),
+	)
+	defer turnSpan.End()
+	defer func() { boterr.Record(turnSpan, err) }()
+	s.forkLinks = nil
+
+	// Screen the input before it reaches the model
+	if policy := s.config.InputPolicy; policy != nil {
+		decision := policy.Check(userMessage)
+		turnSpan.SetAttributes(attribute.String("guardrail.input.outcome", string(decision.Outcome)))
+		if decision.Rule != "" {
+			turnSpan.SetAttributes(attribute.String("guardrail.input.rule", decision.Rule))
+		}
+		if decision.Outcome == guardrail.OutcomeBlocked {
+			turnSpan.SetAttributes(attribute.String("gen_ai.completion", policy.Response))
+			return result, boterr.New(boterr.ErrGuardrailBlocked, policy.Response, fmt.Errorf("message blocked by input policy rule %q", decision.Rule))
+		}
+	}
+
+	// Warn, or trim, before the conversation outgrows the context window
+	request, contextWarning := messages, ""
+	if s.config.ContextGuard != nil {
+		if request, contextWarning, err = s.config.ContextGuard.Check(turnCtx, turnSpan, s.config.SystemPrompt, messages, 1024); err != nil {
+			log.Printf("Checking the context window: %v", err)
+		}
+	}
+	resp, err := Generate(turnCtx, s.client, anthropic.MessageNewParams{
+		Model:       anthropic.Model(s.config.Model),
+		MaxTokens:   1024,
+		Temperature: temperature,
+		System: []anthropic.TextBlockParam{
+			{Text: s.config.SystemPrompt},
+		},
+		Messages: request,
+	}, s.config.Executor)
+	if err != nil {
+		return result, err
+	}
+
+	s.config.Payloads.String(turnSpan, "gen_ai.completion", resp.Text)
+	turnSpan.SetAttributes(
+		attribute.Int64("gen_ai.usage.input_tokens", resp.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", resp.OutputTokens),
+		attribute.StringSlice("gen_ai.response.finish_reasons", resp.FinishReasons),
+		attribute.Bool("continued", resp.Continued),
+		attribute.Int("gen_ai.tool.call_count", len(resp.ToolCalls)),
+	)
+
+	// Add assistant response to history
+	s.history = append(messages,
+		anthropic.NewAssistantMessage(anthropic.NewTextBlock(resp.Text)),
+	)
+
+	record := turnRecord{Prompt: userMessage, Span: turnSpan.SpanContext()}
+	if regenerated != nil {
+		*regenerated = record
+	} else {
+		s.turns = append(s.turns, record)
+	}
+
+	result = NewTurnResult(s.id, s.config.Model, turnSpan.SpanContext(), resp)
+	result.ContextWarning = contextWarning
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+
+	"go-tracing-demo/tools"
+)
+
+// SummaryModel is the small, fast model used to condense tool results.
+const SummaryModel = "claude-haiku-4-5-20251001"
+
+// ToolResultSummarizer returns a tools.Summarizer that condenses oversized
+// tool results with model.
+func ToolResultSummarizer(client *anthropic.Client, model string) tools.Summarizer {
+	return func(ctx context.Context, tool, content string, maxTokens int) (string, error) {
+		resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+			Model:     anthropic.Model(model),
+			MaxTokens: int64(maxTokens),
+			System: []anthropic.TextBlockParam{
+				{Text: fmt.Sprintf("Summarize the output of the %q tool for another assistant. Keep identifiers, names, numbers and errors exactly as written. Use at most %d tokens.", tool, maxTokens)},
+			},
+			Messages: []anthropic.MessageParam{
+				anthropic.NewUserMessage(anthropic.NewTextBlock(content)),
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		var parts []string
+		for _, block := range resp.Content {
+			if block.Type == "text" {
+				parts = append(parts, block.Text)
+			}
+		}
+		return "[summarized] " + strings.Join(parts, "\n"), nil
+	}
+}
@@ -0,0 +1,157 @@
+// Package connector grants approved access on external systems. Every
+// connector call and the API requests it makes are traced.
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Request is the approved access to grant.
+type Request struct {
+	TicketID string
+	// Requester identifies who receives the access (usually an email).
+	Requester   string
+	Resource    string
+	AccessLevel string
+	Duration    string
+	// Origin is the span context of the conversation turn that requested
+	// the access; follow-ups in their own trace, such as revocations, link
+	// to it.
+	Origin trace.SpanContext
+}
+
+// Result describes a completed grant.
+type Result struct {
+	// ExternalID is the target system's identifier for the grant, such as a
+	// GitHub invitation ID.
+	ExternalID string
+	Message    string
+}
+
+// ResourceName is the name of the connector that grants resource, such
+// as "github" for "github:acme/platform" or "snowflake" for
+// "snowflake_prod".
+func ResourceName(resource string) string {
+	name, _, _ := strings.Cut(resource, ":")
+	return strings.TrimSuffix(name, "_prod")
+}
+
+// resourceScope is what resource covers on its connector's system: what
+// follows the colon, as in "acme/platform" for "github:acme/platform". It
+// is empty for a resource that only names the system, such as "github" or
+// "github_prod".
+func resourceScope(resource string) string {
+	if _, scope, ok := strings.Cut(resource, ":"); ok {
+		return strings.TrimSpace(scope)
+	}
+	return ""
+}
+
+// environmentScope is resourceScope for connectors that scope by
+// environment: without a colon, it is the environment the resource names,
+// as in "prod" for "snowflake_prod".
+func environmentScope(resource string) string {
+	if strings.Contains(resource, ":") {
+		return resourceScope(resource)
+	}
+	if _, env, ok := strings.Cut(resource, "_"); ok {
+		return env
+	}
+	return ""
+}
+
+// rejectedError is a grant the connector or its target system refused
+// without changing anything.
+type rejectedError struct{ err error }
+
+func (e rejectedError) Error() string { return e.err.Error() }
+func (e rejectedError) Unwrap() error { return e.err }
+
+// Reject marks err as a refusal that left the target system unchanged,
+// such as a request found invalid before any call was made.
+func Reject(err error) error {
+	return rejectedError{err}
+}
+
+// Rejected reports whether err proves a grant had no effect: it was
+// marked by Reject, or the target system answered with a 4xx error. Any
+// other error, such as a timeout or a 5xx, leaves open whether access was
+// granted.
+func Rejected(err error) bool {
+	var api *apiError
+	if errors.As(err, &api) {
+		return api.StatusCode >= 400 && api.StatusCode < 500
+	}
+	return errors.As(err, new(rejectedError))
+}
+
+// Connector grants access on one target system.
+type Connector interface {
+	// Name is the resource name the connector handles, e.g. "github".
+	Name() string
+	Grant(ctx context.Context, req Request) (Result, error)
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("go-tracing-demo/connector")
+}
+
+// Expiry parses a ticket duration such as "24h" or "7d". ok is false for
+// durations that do not expire or cannot be parsed (e.g. "unknown").
+func Expiry(duration string) (d time.Duration, ok bool) {
+	if days, found := strings.CutSuffix(duration, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// rolesFromEnv reads an access level to role mapping such as
+// "read=VIEWER,admin=OWNER" from the named variable, or returns defaults
+// when it is not set.
+func rolesFromEnv(name string, defaults map[string]string) (map[string]string, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaults, nil
+	}
+	roles := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		level, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || level == "" || role == "" {
+			return nil, fmt.Errorf("%s: invalid entry %q (want level=role)", name, pair)
+		}
+		roles[level] = strings.TrimSpace(role)
+	}
+	return roles, nil
+}
+
+type dryRunKey struct{}
+
+// WithDryRun marks ctx so connectors log and trace the changes they would
+// make instead of making them. Read-only API calls still run, so lookups
+// behave as they would for real.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether ctx was marked with WithDryRun.
+func IsDryRun(ctx context.Context) bool {
+	v, _ := ctx.Value(dryRunKey{}).(bool)
+	return v
+}
@@ -0,0 +1,208 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultDatadogRoles maps access levels to Datadog's managed roles when
+// DATADOG_ROLES is not set.
+var defaultDatadogRoles = map[string]string{
+	"read":  "Datadog Read Only Role",
+	"write": "Datadog Standard Role",
+	"admin": "Datadog Admin Role",
+}
+
+// Datadog adds the requester's Datadog user to a role through the Roles API.
+// Time-bound grants are removed again at expiry by a timer in this process,
+// so they only expire while the bot keeps running.
+type Datadog struct {
+	APIKey string
+	AppKey string
+	// BaseURL is the API host for the account's site, e.g.
+	// https://api.datadoghq.eu.
+	BaseURL string
+	// Roles maps an access level to the name of the role granted for it.
+	Roles map[string]string
+	HTTP  *http.Client
+}
+
+// DatadogFromEnv configures the connector from DD_API_KEY, DD_APP_KEY,
+// DD_SITE (default datadoghq.com) and DATADOG_ROLES. It returns nil when
+// DD_API_KEY is not set.
+func DatadogFromEnv() (*Datadog, error) {
+	apiKey := os.Getenv("DD_API_KEY")
+	if apiKey == "" {
+		return nil, nil
+	}
+	appKey := os.Getenv("DD_APP_KEY")
+	if appKey == "" {
+		return nil, errors.New("DD_APP_KEY is required when DD_API_KEY is set")
+	}
+	site := os.Getenv("DD_SITE")
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	roles, err := rolesFromEnv("DATADOG_ROLES", defaultDatadogRoles)
+	if err != nil {
+		return nil, err
+	}
+	return &Datadog{
+		APIKey:  apiKey,
+		AppKey:  appKey,
+		BaseURL: "https://api." + site,
+		Roles:   roles,
+		HTTP:    &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// Name implements Connector.
+func (d *Datadog) Name() string { return "datadog" }
+
+// Grant adds the requester to the role for the requested access level and,
+// when the ticket has a duration, schedules its removal.
+func (d *Datadog) Grant(ctx context.Context, req Request) (Result, error) {
+	role := d.Roles[req.AccessLevel]
+
+	ctx, span := tracer().Start(ctx, "datadog.grant",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "tool"),
+			attribute.String("connector.name", d.Name()),
+			attribute.String("itsm.ticket.id", req.TicketID),
+			attribute.String("datadog.role", role),
+		),
+	)
+	defer span.End()
+
+	a, err := d.assign(ctx, req, role)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Result{}, err
+	}
+	span.SetAttributes(
+		attribute.String("datadog.role_id", a.roleID),
+		attribute.String("datadog.user_id", a.userID),
+	)
+
+	result := Result{
+		ExternalID: a.roleID + "/" + a.userID,
+		Message:    fmt.Sprintf("added %s to Datadog role %s without expiry", req.Requester, role),
+	}
+	if ttl, ok := Expiry(req.Duration); ok {
+		expiresAt := time.Now().Add(ttl)
+		span.SetAttributes(attribute.String("datadog.expires_at", expiresAt.UTC().Format(time.RFC3339)))
+		result.Message = fmt.Sprintf("added %s to Datadog role %s until %s", req.Requester, role, expiresAt.UTC().Format(time.RFC3339))
+
+		// Removal runs in its own trace, linked back to this grant
+		if !IsDryRun(ctx) {
+			link := trace.LinkFromContext(ctx, attribute.String("itsm.ticket.id", req.TicketID))
+			time.AfterFunc(ttl, func() { d.revoke(req, role, a, link) })
+		}
+	}
+	return result, nil
+}
+
+// assignment identifies a user's membership in a role.
+type assignment struct {
+	roleID string
+	userID string
+}
+
+func (d *Datadog) assign(ctx context.Context, req Request, role string) (assignment, error) {
+	if role == "" {
+		return assignment{}, Reject(fmt.Errorf("datadog: no role configured for access level %q", req.AccessLevel))
+	}
+	if req.Requester == "" {
+		return assignment{}, Reject(errors.New("datadog: requester email is required to assign a role"))
+	}
+
+	roleID, err := d.lookup(ctx, "datadog.find_role", "/api/v2/roles?filter="+url.QueryEscape(role))
+	if err != nil {
+		return assignment{}, fmt.Errorf("datadog: looking up role %s: %w", role, err)
+	}
+	userID, err := d.lookup(ctx, "datadog.find_user", "/api/v2/users?filter="+url.QueryEscape(req.Requester))
+	if err != nil {
+		return assignment{}, fmt.Errorf("datadog: looking up user %s: %w", req.Requester, err)
+	}
+
+	a := assignment{roleID: roleID, userID: userID}
+	if err := doJSON(ctx, d.HTTP, "datadog.add_role_user", http.MethodPost, d.roleUsersURL(a), d.headers(), a.body(), nil); err != nil {
+		return assignment{}, fmt.Errorf("datadog: adding %s to %s: %w", req.Requester, role, err)
+	}
+	return a, nil
+}
+
+// revoke removes an expired assignment in a new trace linked to the grant
+// and to the conversation that requested it.
+func (d *Datadog) revoke(req Request, role string, a assignment, link trace.Link) {
+	links := []trace.Link{link}
+	if req.Origin.IsValid() {
+		links = append(links, trace.Link{SpanContext: req.Origin, Attributes: []attribute.KeyValue{
+			attribute.String("itsm.ticket.id", req.TicketID),
+		}})
+	}
+	ctx, span := tracer().Start(context.Background(), "datadog.revoke",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "tool"),
+			attribute.String("connector.name", d.Name()),
+			attribute.String("itsm.ticket.id", req.TicketID),
+			attribute.String("datadog.role", role),
+			attribute.String("datadog.role_id", a.roleID),
+			attribute.String("datadog.user_id", a.userID),
+		),
+	)
+	defer span.End()
+
+	if err := doJSON(ctx, d.HTTP, "datadog.remove_role_user", http.MethodDelete, d.roleUsersURL(a), d.headers(), a.body(), nil); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Printf("Datadog: removing %s from %s for ticket %s failed: %v", req.Requester, role, req.TicketID, err)
+	}
+}
+
+// lookup returns the ID of the only result of a filtered list request.
+func (d *Datadog) lookup(ctx context.Context, spanName, path string) (string, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, d.HTTP, spanName, http.MethodGet, d.BaseURL+path, d.headers(), nil, &list); err != nil {
+		return "", err
+	}
+	switch len(list.Data) {
+	case 0:
+		return "", Reject(errors.New("not found"))
+	case 1:
+		return list.Data[0].ID, nil
+	default:
+		return "", Reject(fmt.Errorf("%d matches", len(list.Data)))
+	}
+}
+
+func (d *Datadog) roleUsersURL(a assignment) string {
+	return fmt.Sprintf("%s/api/v2/roles/%s/users", d.BaseURL, url.PathEscape(a.roleID))
+}
+
+func (a assignment) body() map[string]any {
+	return map[string]any{"data": map[string]string{"type": "users", "id": a.userID}}
+}
+
+func (d *Datadog) headers() map[string]string {
+	return map[string]string{
+		"DD-API-KEY":         d.APIKey,
+		"DD-APPLICATION-KEY": d.AppKey,
+	}
+}
@@ -0,0 +1,195 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GitHub invites requesters to a GitHub organization (and optionally a team)
+// with a role derived from the requested access level.
+type GitHub struct {
+	Token string
+	// Org and Team are where tickets for a plain "github" resource are
+	// granted; Team empty means org only.
+	Org     string
+	Team    string
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// GitHubFromEnv configures the connector from GITHUB_TOKEN, GITHUB_ORG and
+// GITHUB_TEAM. It returns nil when GITHUB_TOKEN is not set.
+func GitHubFromEnv() (*GitHub, error) {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	org := os.Getenv("GITHUB_ORG")
+	if org == "" {
+		return nil, errors.New("GITHUB_ORG is required when GITHUB_TOKEN is set")
+	}
+	return &GitHub{
+		Token:   token,
+		Org:     org,
+		Team:    os.Getenv("GITHUB_TEAM"),
+		BaseURL: "https://api.github.com",
+		HTTP:    &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// Name implements Connector.
+func (g *GitHub) Name() string { return "github" }
+
+// githubSlug is an organization, team or user name as GitHub allows them.
+var githubSlug = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9._-]{0,98})$`)
+
+// githubTarget is the organization, and optionally the team, a grant is
+// for.
+type githubTarget struct {
+	Org  string
+	Team string
+}
+
+func (t githubTarget) String() string {
+	if t.Team == "" {
+		return t.Org
+	}
+	return t.Org + "/" + t.Team
+}
+
+// target is the org and team req's resource names, as in
+// "github:acme/platform" or "github:acme", or GITHUB_ORG and GITHUB_TEAM
+// for a plain "github" or "github_prod". Only the colon names an org.
+func (g *GitHub) target(req Request) (githubTarget, error) {
+	scope := resourceScope(req.Resource)
+	if scope == "" {
+		return githubTarget{Org: g.Org, Team: g.Team}, nil
+	}
+	org, team, _ := strings.Cut(scope, "/")
+	if !githubSlug.MatchString(org) || (team != "" && !githubSlug.MatchString(team)) {
+		return githubTarget{}, Reject(fmt.Errorf("github: resource %q does not name an org or org/team", req.Resource))
+	}
+	return githubTarget{Org: org, Team: team}, nil
+}
+
+// Grant gives req.Requester the access level they asked for on the org or
+// team the ticket names. Read and write access is an invitation to join as
+// a member (of the team, if one is named); admin access makes the
+// requester a maintainer of the team, never an org owner.
+func (g *GitHub) Grant(ctx context.Context, req Request) (Result, error) {
+	role := "direct_member"
+	if req.AccessLevel == "admin" {
+		role = "maintainer"
+	}
+
+	ctx, span := tracer().Start(ctx, "github.grant",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "tool"),
+			attribute.String("connector.name", g.Name()),
+			attribute.String("itsm.ticket.id", req.TicketID),
+			attribute.String("github.role", role),
+		),
+	)
+	defer span.End()
+
+	result, err := g.grant(ctx, span, req, role)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Result{}, err
+	}
+	span.SetAttributes(attribute.String("github.invitation_id", result.ExternalID))
+	return result, nil
+}
+
+func (g *GitHub) grant(ctx context.Context, span trace.Span, req Request, role string) (Result, error) {
+	target, err := g.target(req)
+	if err != nil {
+		return Result{}, err
+	}
+	span.SetAttributes(
+		attribute.String("github.org", target.Org),
+		attribute.String("github.team", target.Team),
+	)
+	if role == "maintainer" {
+		return g.addMaintainer(ctx, req, target)
+	}
+	return g.invite(ctx, req, target)
+}
+
+// invite sends an invitation to join target as a member to req.Requester's
+// email.
+func (g *GitHub) invite(ctx context.Context, req Request, target githubTarget) (Result, error) {
+	if req.Requester == "" {
+		return Result{}, Reject(errors.New("github: requester email is required to send an invitation"))
+	}
+
+	body := map[string]any{"email": req.Requester, "role": "direct_member"}
+	if target.Team != "" {
+		var team struct {
+			ID int64 `json:"id"`
+		}
+		url := fmt.Sprintf("%s/orgs/%s/teams/%s", g.BaseURL, target.Org, target.Team)
+		if err := doJSON(ctx, g.HTTP, "github.get_team", http.MethodGet, url, g.headers(), nil, &team); err != nil {
+			return Result{}, fmt.Errorf("github: looking up team %s: %w", target, err)
+		}
+		body["team_ids"] = []int64{team.ID}
+	}
+
+	var invitation struct {
+		ID int64 `json:"id"`
+	}
+	url := fmt.Sprintf("%s/orgs/%s/invitations", g.BaseURL, target.Org)
+	if err := doJSON(ctx, g.HTTP, "github.create_invitation", http.MethodPost, url, g.headers(), body, &invitation); err != nil {
+		return Result{}, fmt.Errorf("github: inviting %s: %w", req.Requester, err)
+	}
+
+	result := Result{Message: fmt.Sprintf("invited %s to %s as a member", req.Requester, target)}
+	// A dry run sends no invitation, so there is no ID
+	if invitation.ID != 0 {
+		result.ExternalID = strconv.FormatInt(invitation.ID, 10)
+	}
+	return result, nil
+}
+
+// addMaintainer makes req.Requester a maintainer of target's team, which
+// also invites them to the org if they are not a member. Team roles are
+// set by GitHub username, so the requester must be one.
+func (g *GitHub) addMaintainer(ctx context.Context, req Request, target githubTarget) (Result, error) {
+	if target.Team == "" {
+		return Result{}, Reject(fmt.Errorf("github: admin access is granted as maintainer of a team; name one, as in github:%s/<team>", target.Org))
+	}
+	if !githubSlug.MatchString(req.Requester) || strings.Contains(req.Requester, "@") {
+		return Result{}, Reject(fmt.Errorf("github: admin access on %s needs the requester's GitHub username, not %q", target, req.Requester))
+	}
+	var membership struct {
+		State string `json:"state"`
+	}
+	url := fmt.Sprintf("%s/orgs/%s/teams/%s/memberships/%s", g.BaseURL, target.Org, target.Team, req.Requester)
+	if err := doJSON(ctx, g.HTTP, "github.set_team_membership", http.MethodPut, url, g.headers(), map[string]any{"role": "maintainer"}, &membership); err != nil {
+		return Result{}, fmt.Errorf("github: adding %s to %s as maintainer: %w", req.Requester, target, err)
+	}
+	message := fmt.Sprintf("made %s a maintainer of %s", req.Requester, target)
+	if membership.State == "pending" {
+		message += ", pending their acceptance of the org invitation"
+	}
+	return Result{Message: message}, nil
+}
+
+func (g *GitHub) headers() map[string]string {
+	return map[string]string{
+		"Authorization":        "Bearer " + g.Token,
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+}
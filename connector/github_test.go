@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubGrant(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		req       Request
+		wantCalls []string
+		wantBody  string
+		wantErr   string
+	}{
+		{
+			name:      "member of the configured org",
+			req:       Request{Requester: "jane@example.com", Resource: "github", AccessLevel: "read"},
+			wantCalls: []string{"POST /orgs/acme/invitations"},
+			wantBody:  `{"email":"jane@example.com","role":"direct_member"}`,
+		},
+		{
+			name:      "prod is not an org",
+			req:       Request{Requester: "jane@example.com", Resource: "github_prod", AccessLevel: "read"},
+			wantCalls: []string{"POST /orgs/acme/invitations"},
+			wantBody:  `{"email":"jane@example.com","role":"direct_member"}`,
+		},
+		{
+			name:      "member of the named team",
+			req:       Request{Requester: "jane@example.com", Resource: "github:other/platform", AccessLevel: "write"},
+			wantCalls: []string{"GET /orgs/other/teams/platform", "POST /orgs/other/invitations"},
+			wantBody:  `{"email":"jane@example.com","role":"direct_member","team_ids":[7]}`,
+		},
+		{
+			name:      "admin is team maintainer",
+			req:       Request{Requester: "janedoe", Resource: "github:acme/platform", AccessLevel: "admin"},
+			wantCalls: []string{"PUT /orgs/acme/teams/platform/memberships/janedoe"},
+			wantBody:  `{"role":"maintainer"}`,
+		},
+		{
+			name:    "admin needs a team",
+			req:     Request{Requester: "janedoe", Resource: "github:acme", AccessLevel: "admin"},
+			wantErr: "maintainer of a team",
+		},
+		{
+			name:    "admin needs a username",
+			req:     Request{Requester: "jane@example.com", Resource: "github:acme/platform", AccessLevel: "admin"},
+			wantErr: "GitHub username",
+		},
+		{
+			name:    "bad resource",
+			req:     Request{Requester: "jane@example.com", Resource: "github:../admin", AccessLevel: "read"},
+			wantErr: "does not name an org",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, r.Method+" "+r.URL.Path)
+				if r.Method != http.MethodGet {
+					var v any
+					json.NewDecoder(r.Body).Decode(&v)
+					data, _ := json.Marshal(v)
+					body = string(data)
+				}
+				w.Write([]byte(`{"id":7,"state":"active"}`))
+			}))
+			defer srv.Close()
+			g := &GitHub{Token: "t", Org: "acme", BaseURL: srv.URL, HTTP: srv.Client()}
+
+			_, err := g.Grant(context.Background(), tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Grant() = %v, want %q", err, tt.wantErr)
+				}
+				if len(calls) != 0 {
+					t.Errorf("calls = %v, want none", calls)
+				}
+				if !Rejected(err) {
+					t.Errorf("Grant() = %v, want a rejection", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(calls, ", ") != strings.Join(tt.wantCalls, ", ") {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestGitHubGrantRejected checks that only an API error proving the
+// invitation wasn't sent counts as a rejection.
+func TestGitHubGrantRejected(t *testing.T) {
+	for _, tt := range []struct {
+		status       int
+		wantRejected bool
+	}{
+		{status: http.StatusUnprocessableEntity, wantRejected: true},
+		{status: http.StatusTooManyRequests, wantRejected: true},
+		{status: http.StatusBadGateway},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		g := &GitHub{Token: "t", Org: "acme", BaseURL: srv.URL, HTTP: srv.Client()}
+		_, err := g.Grant(context.Background(), Request{Requester: "jane@example.com", Resource: "github", AccessLevel: "read"})
+		srv.Close()
+		if err == nil || Rejected(err) != tt.wantRejected {
+			t.Errorf("%d: Grant() = %v, rejected %v, want %v", tt.status, err, Rejected(err), tt.wantRejected)
+		}
+	}
+}
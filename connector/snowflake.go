@@ -0,0 +1,323 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	// Registers the "snowflake" database/sql driver
+	_ "github.com/snowflakedb/gosnowflake"
+)
+
+// defaultSnowflakeRoles maps access levels to the roles granted when
+// SNOWFLAKE_ROLES is not set. {scope} is the database or environment the
+// ticket's resource names, so each grant is limited to it.
+var defaultSnowflakeRoles = map[string]string{
+	"read":  "{scope}_READ",
+	"write": "{scope}_WRITE",
+	"admin": "{scope}_ADMIN",
+}
+
+// systemRoles are Snowflake's account-wide roles, which are never granted
+// from a ticket.
+var systemRoles = map[string]bool{
+	"ACCOUNTADMIN":  true,
+	"ORGADMIN":      true,
+	"SECURITYADMIN": true,
+	"SYSADMIN":      true,
+	"USERADMIN":     true,
+}
+
+var (
+	// snowflakeIdent is an unquoted Snowflake identifier, without the $
+	// Snowflake also allows, for role, warehouse and task names.
+	snowflakeIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,254}$`)
+	// snowflakeUser is a user name, which may be an email address.
+	snowflakeUser = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.@+-]{0,254}$`)
+)
+
+// Snowflake grants a role to the requester's Snowflake user. Time-bound
+// grants also create a task that revokes the role when the ticket expires.
+type Snowflake struct {
+	DB *sql.DB
+	// Warehouse runs the revocation tasks.
+	Warehouse string
+	// Roles maps an access level to the role granted for it.
+	Roles map[string]string
+}
+
+// SnowflakeFromEnv configures the connector from SNOWFLAKE_DSN (a
+// gosnowflake DSN), SNOWFLAKE_WAREHOUSE and SNOWFLAKE_ROLES
+// ("read={scope}_READ,admin=ROLE"). It returns nil when SNOWFLAKE_DSN is
+// not set.
+func SnowflakeFromEnv() (*Snowflake, error) {
+	dsn := os.Getenv("SNOWFLAKE_DSN")
+	if dsn == "" {
+		return nil, nil
+	}
+	warehouse := os.Getenv("SNOWFLAKE_WAREHOUSE")
+	if warehouse == "" {
+		return nil, errors.New("SNOWFLAKE_WAREHOUSE is required when SNOWFLAKE_DSN is set")
+	}
+	if !snowflakeIdent.MatchString(warehouse) {
+		return nil, fmt.Errorf("SNOWFLAKE_WAREHOUSE: %q is not a plain identifier", warehouse)
+	}
+	roles, err := rolesFromEnv("SNOWFLAKE_ROLES", defaultSnowflakeRoles)
+	if err != nil {
+		return nil, err
+	}
+	for level, role := range roles {
+		// Checked for any scope, with a stand-in for it
+		if _, err := checkRole(strings.ReplaceAll(role, "{scope}", "X")); err != nil {
+			return nil, fmt.Errorf("SNOWFLAKE_ROLES: %s: %w", level, err)
+		}
+	}
+	db, err := sql.Open("snowflake", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("SNOWFLAKE_DSN: %w", err)
+	}
+	return &Snowflake{DB: db, Warehouse: warehouse, Roles: roles}, nil
+}
+
+// Name implements Connector.
+func (s *Snowflake) Name() string { return "snowflake" }
+
+// Grant runs GRANT ROLE for the requester and, when the ticket has a
+// duration, schedules the matching REVOKE.
+func (s *Snowflake) Grant(ctx context.Context, req Request) (Result, error) {
+	ctx, span := tracer().Start(ctx, "snowflake.grant",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "tool"),
+			attribute.String("connector.name", s.Name()),
+			attribute.String("itsm.ticket.id", req.TicketID),
+		),
+	)
+	defer span.End()
+
+	result, err := s.grant(ctx, span, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Result{}, err
+	}
+	span.SetAttributes(attribute.String("snowflake.revoke_task", result.ExternalID))
+	return result, nil
+}
+
+func (s *Snowflake) grant(ctx context.Context, span trace.Span, req Request) (Result, error) {
+	role, err := s.role(req)
+	if err != nil {
+		return Result{}, err
+	}
+	span.SetAttributes(attribute.String("snowflake.role", role))
+	if req.Requester == "" {
+		return Result{}, Reject(errors.New("snowflake: requester is required to grant a role"))
+	}
+	user, err := s.user(ctx, req.Requester)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var task string
+	var expiresAt time.Time
+	if ttl, ok := Expiry(req.Duration); ok {
+		if task, err = revokeTask(req.TicketID); err != nil {
+			return Result{}, err
+		}
+		expiresAt = time.Now().UTC().Add(ttl)
+	}
+	for i, stmt := range grantStatements(role, user, s.Warehouse, task, expiresAt) {
+		if err := s.exec(ctx, stmt); err != nil {
+			if i == 0 {
+				return Result{}, fmt.Errorf("snowflake: granting %s: %w", role, err)
+			}
+			return Result{}, fmt.Errorf("snowflake: scheduling revocation: %w", err)
+		}
+	}
+	if task == "" {
+		return Result{Message: fmt.Sprintf("granted role %s to %s without expiry", role, req.Requester)}, nil
+	}
+	return Result{
+		ExternalID: task,
+		Message:    fmt.Sprintf("granted role %s to %s until %s", role, req.Requester, expiresAt.Format(time.RFC3339)),
+	}, nil
+}
+
+// role is the role granted for req: the one configured for its access
+// level, scoped to the database or environment its resource names.
+func (s *Snowflake) role(req Request) (string, error) {
+	role := s.Roles[req.AccessLevel]
+	if role == "" {
+		return "", Reject(fmt.Errorf("snowflake: no role configured for access level %q", req.AccessLevel))
+	}
+	if strings.Contains(role, "{scope}") {
+		scope := environmentScope(req.Resource)
+		if scope == "" {
+			return "", Reject(fmt.Errorf("snowflake: resource %q names no database or environment to scope the role to, such as snowflake:ANALYTICS", req.Resource))
+		}
+		role = strings.ReplaceAll(role, "{scope}", scope)
+	}
+	return checkRole(role)
+}
+
+// checkRole returns role as Snowflake stores it, upper-cased, if it is a
+// plain identifier and not an account-wide system role.
+func checkRole(role string) (string, error) {
+	if !snowflakeIdent.MatchString(role) {
+		return "", Reject(fmt.Errorf("snowflake: role %q is not a plain identifier", role))
+	}
+	role = strings.ToUpper(role)
+	if systemRoles[role] {
+		return "", Reject(fmt.Errorf("snowflake: %s is an account-wide role and is not granted from tickets", role))
+	}
+	return role, nil
+}
+
+// user resolves requester to the name of their Snowflake user, in the
+// casing Snowflake stores it. Names are matched case-insensitively, as
+// Snowflake matches unquoted names. The lookup is read-only, so it also
+// runs in a dry run.
+func (s *Snowflake) user(ctx context.Context, requester string) (string, error) {
+	if !snowflakeUser.MatchString(requester) {
+		return "", Reject(fmt.Errorf("snowflake: %q is not a valid user name", requester))
+	}
+	var name string
+	err := s.query(ctx, fmt.Sprintf("SHOW USERS LIKE '%s'", requester), func(rows *sql.Rows) error {
+		cols, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		values := make([]sql.NullString, len(cols))
+		dest := make([]any, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			for i, col := range cols {
+				if strings.EqualFold(col, "name") && strings.EqualFold(values[i].String, requester) {
+					name = values[i].String
+				}
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return "", fmt.Errorf("snowflake: looking up user %s: %w", requester, err)
+	}
+	if name == "" {
+		return "", Reject(fmt.Errorf("snowflake: no user named %s", requester))
+	}
+	return name, nil
+}
+
+// revokeTask is the name of the task that revokes the grant of ticket id.
+func revokeTask(ticketID string) (string, error) {
+	task := "REVOKE_" + strings.ToUpper(strings.ReplaceAll(ticketID, "-", "_"))
+	if !snowflakeIdent.MatchString(task) {
+		return "", Reject(fmt.Errorf("snowflake: ticket ID %q can't name a revocation task", ticketID))
+	}
+	return task, nil
+}
+
+// grantStatements are the statements that grant role to user and, with a
+// task, create it to revoke the role at expiresAt, once, and then suspend
+// itself. The task body is a Snowflake Scripting block of its own rather
+// than a string, so no name is ever parsed as SQL inside it.
+func grantStatements(role, user, warehouse, task string, expiresAt time.Time) []string {
+	stmts := []string{fmt.Sprintf("GRANT ROLE %s TO USER %s", quoteIdent(role), quoteIdent(user))}
+	if task == "" {
+		return stmts
+	}
+	schedule := fmt.Sprintf("USING CRON %d %d %d %d * UTC", expiresAt.Minute(), expiresAt.Hour(), expiresAt.Day(), int(expiresAt.Month()))
+	return append(stmts,
+		fmt.Sprintf("CREATE OR REPLACE TASK %s WAREHOUSE = %s SCHEDULE = '%s' AS BEGIN REVOKE ROLE %s FROM USER %s; ALTER TASK %s SUSPEND; END",
+			quoteIdent(task), quoteIdent(warehouse), schedule, quoteIdent(role), quoteIdent(user), quoteIdent(task)),
+		fmt.Sprintf("ALTER TASK %s RESUME", quoteIdent(task)),
+	)
+}
+
+// exec runs one statement in a client span. Only the sanitized statement is
+// recorded, since identifiers include user names.
+func (s *Snowflake) exec(ctx context.Context, stmt string) error {
+	ctx, span := s.startStatement(ctx, stmt)
+	defer span.End()
+
+	if IsDryRun(ctx) {
+		span.SetAttributes(attribute.Bool("dry_run", true))
+		log.Printf("Dry run: skipped %s", sanitizeSQL(stmt))
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// query runs a read-only statement in a client span, even in a dry run,
+// and hands its rows to scan.
+func (s *Snowflake) query(ctx context.Context, stmt string, scan func(*sql.Rows) error) error {
+	ctx, span := s.startStatement(ctx, stmt)
+	defer span.End()
+
+	err := func() error {
+		rows, err := s.DB.QueryContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		return scan(rows)
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (s *Snowflake) startStatement(ctx context.Context, stmt string) (context.Context, trace.Span) {
+	operation, _, _ := strings.Cut(stmt, " ")
+	return tracer().Start(ctx, "snowflake.exec",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "snowflake"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", sanitizeSQL(stmt)),
+		),
+	)
+}
+
+// quoteIdent quotes a Snowflake identifier, escaping embedded quotes.
+// Identifiers cannot be bound as parameters, so every name in a statement
+// goes through here, after it has been checked against snowflakeIdent or
+// snowflakeUser.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+var sqlLiterals = regexp.MustCompile(`"(?:[^"]|"")*"|'(?:[^']|'')*'`)
+
+// sanitizeSQL replaces quoted identifiers and string literals with
+// placeholders so statements can be traced without user names.
+func sanitizeSQL(stmt string) string {
+	return sqlLiterals.ReplaceAllStringFunc(stmt, func(m string) string {
+		if m[0] == '"' {
+			return `"?"`
+		}
+		return "'?'"
+	})
+}
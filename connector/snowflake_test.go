@@ -0,0 +1,153 @@
+package connector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	for _, tt := range []struct{ name, want string }{
+		{"ANALYST_READ", `"ANALYST_READ"`},
+		{"jane@example.com", `"jane@example.com"`},
+		{`a"b`, `"a""b"`},
+		{`""`, `""""""`},
+	} {
+		if got := quoteIdent(tt.name); got != tt.want {
+			t.Errorf("quoteIdent(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnowflakeRole(t *testing.T) {
+	s := &Snowflake{Roles: map[string]string{
+		"read":  "{scope}_READ",
+		"write": "analyst_write",
+		"admin": "SYSADMIN",
+		"owner": "{scope}_OWNER",
+	}}
+	for _, tt := range []struct {
+		resource, level string
+		want            string
+		wantErr         string
+	}{
+		{resource: "snowflake:analytics", level: "read", want: "ANALYTICS_READ"},
+		{resource: "snowflake_prod", level: "read", want: "PROD_READ"},
+		{resource: "snowflake prod", level: "write", want: "ANALYST_WRITE"},
+		{resource: "snowflake", level: "read", wantErr: "names no database"},
+		{resource: "snowflake:ANALYTICS", level: "admin", wantErr: "account-wide"},
+		{resource: "snowflake:ACCOUNT", level: "owner", want: "ACCOUNT_OWNER"},
+		{resource: "snowflake:x$y", level: "read", wantErr: "not a plain identifier"},
+		{resource: "snowflake:a; DROP USER b", level: "read", wantErr: "not a plain identifier"},
+		{resource: "snowflake:a$$b", level: "read", wantErr: "not a plain identifier"},
+		{resource: "snowflake:ANALYTICS", level: "none", wantErr: "no role configured"},
+	} {
+		got, err := s.role(Request{Resource: tt.resource, AccessLevel: tt.level})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("role(%q, %q) error = %v, want %q", tt.resource, tt.level, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("role(%q, %q) = %q, %v, want %q", tt.resource, tt.level, got, err, tt.want)
+		}
+	}
+}
+
+func TestSnowflakeUserRejectsInjection(t *testing.T) {
+	s := &Snowflake{}
+	for _, requester := range []string{
+		"",
+		"jane'); DROP USER admin; --",
+		"jane$$ END; GRANT ROLE ACCOUNTADMIN TO USER mallory; $$",
+		`jane"@example.com`,
+		"jane doe",
+		".jane",
+	} {
+		if _, err := s.user(context.Background(), requester); err == nil || !strings.Contains(err.Error(), "not a valid user name") {
+			t.Errorf("user(%q) error = %v, want it rejected before any query", requester, err)
+		}
+	}
+}
+
+func TestRevokeTask(t *testing.T) {
+	for _, tt := range []struct {
+		id, want string
+		ok       bool
+	}{
+		{"AR-1A2B3C4D", "REVOKE_AR_1A2B3C4D", true},
+		{"ar-42", "REVOKE_AR_42", true},
+		{"AR-1$$; DROP", "", false},
+		{"AR 1", "", false},
+	} {
+		got, err := revokeTask(tt.id)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("revokeTask(%q) = %q, %v, want %q (ok %v)", tt.id, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGrantStatements(t *testing.T) {
+	at := time.Date(2026, 3, 9, 14, 5, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name string
+		task string
+		want []string
+	}{
+		{
+			name: "no expiry",
+			want: []string{`GRANT ROLE "PROD_READ" TO USER "JANE@EXAMPLE.COM"`},
+		},
+		{
+			name: "expiring",
+			task: "REVOKE_AR_1",
+			want: []string{
+				`GRANT ROLE "PROD_READ" TO USER "JANE@EXAMPLE.COM"`,
+				`CREATE OR REPLACE TASK "REVOKE_AR_1" WAREHOUSE = "ITSM_WH" SCHEDULE = 'USING CRON 5 14 9 3 * UTC' AS BEGIN REVOKE ROLE "PROD_READ" FROM USER "JANE@EXAMPLE.COM"; ALTER TASK "REVOKE_AR_1" SUSPEND; END`,
+				`ALTER TASK "REVOKE_AR_1" RESUME`,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := grantStatements("PROD_READ", "JANE@EXAMPLE.COM", "ITSM_WH", tt.task, at)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("grantStatements() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, stmt := range got {
+				if strings.Contains(stmt, "$$") {
+					t.Errorf("statement %q uses a $$ string", stmt)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	got := sanitizeSQL(`CREATE OR REPLACE TASK "REVOKE_AR_1" SCHEDULE = 'USING CRON 5 14 9 3 * UTC' AS BEGIN REVOKE ROLE "R" FROM USER "a""b@example.com"; END`)
+	want := `CREATE OR REPLACE TASK "?" SCHEDULE = '?' AS BEGIN REVOKE ROLE "?" FROM USER "?"; END`
+	if got != want {
+		t.Errorf("sanitizeSQL() = %s, want %s", got, want)
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	for _, tt := range []struct{ resource, name, scope, env string }{
+		{"github", "github", "", ""},
+		{"github_prod", "github", "", "prod"},
+		{"github:acme/platform", "github", "acme/platform", "acme/platform"},
+		{"snowflake_prod", "snowflake", "", "prod"},
+		{"snowflake:ANALYTICS", "snowflake", "ANALYTICS", "ANALYTICS"},
+	} {
+		if got := ResourceName(tt.resource); got != tt.name {
+			t.Errorf("ResourceName(%q) = %q, want %q", tt.resource, got, tt.name)
+		}
+		if got := resourceScope(tt.resource); got != tt.scope {
+			t.Errorf("resourceScope(%q) = %q, want %q", tt.resource, got, tt.scope)
+		}
+		if got := environmentScope(tt.resource); got != tt.env {
+			t.Errorf("environmentScope(%q) = %q, want %q", tt.resource, got, tt.env)
+		}
+	}
+}
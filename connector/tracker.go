@@ -0,0 +1,217 @@
+package connector
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Attachment is a document to attach to a ticket's record in a ticketing
+// system, such as the conversation that requested the access.
+type Attachment struct {
+	TicketID string
+	// Summary titles the record when the tracker has to create one.
+	Summary string
+	// ExternalID is the tracker's record for the ticket, from an earlier
+	// Attach; empty creates one.
+	ExternalID string
+	// Fields are set on a record the tracker creates, by the tracker's
+	// field names.
+	Fields map[string]string
+	Name   string
+	// Markdown is the document, posted as a note on the record.
+	Markdown string
+}
+
+// Tracker mirrors tickets into a ticketing system that approvers work in.
+type Tracker interface {
+	// Name identifies the tracker, e.g. "servicenow".
+	Name() string
+	// Attach posts a.Markdown on the ticket's record, creating the record
+	// first if a.ExternalID is empty, and returns the record's ID. In a dry
+	// run nothing is created, so the ID may be empty.
+	Attach(ctx context.Context, a Attachment) (externalID string, err error)
+}
+
+// TrackersFromEnv returns the trackers that are configured: ServiceNow
+// and Jira.
+func TrackersFromEnv() ([]Tracker, error) {
+	var trackers []Tracker
+	servicenow, err := ServiceNowFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if servicenow != nil {
+		trackers = append(trackers, servicenow)
+	}
+	jira, err := JiraFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if jira != nil {
+		trackers = append(trackers, jira)
+	}
+	return trackers, nil
+}
+
+// ServiceNow keeps a record per ticket in a ServiceNow table and adds
+// attachments to it as work notes, which only fulfillers and approvers see.
+type ServiceNow struct {
+	// Instance is the instance URL, e.g. https://acme.service-now.com.
+	Instance string
+	User     string
+	Password string
+	// Table is the table records are created in, sc_request by default.
+	Table string
+	HTTP  *http.Client
+}
+
+// ServiceNowFromEnv configures the tracker from SERVICENOW_INSTANCE,
+// SERVICENOW_USER, SERVICENOW_PASSWORD and SERVICENOW_TABLE. It returns nil
+// when SERVICENOW_INSTANCE is not set.
+func ServiceNowFromEnv() (*ServiceNow, error) {
+	instance := os.Getenv("SERVICENOW_INSTANCE")
+	if instance == "" {
+		return nil, nil
+	}
+	s := &ServiceNow{
+		Instance: instance,
+		User:     os.Getenv("SERVICENOW_USER"),
+		Password: os.Getenv("SERVICENOW_PASSWORD"),
+		Table:    os.Getenv("SERVICENOW_TABLE"),
+		HTTP:     &http.Client{Timeout: 15 * time.Second},
+	}
+	if s.User == "" || s.Password == "" {
+		return nil, errors.New("SERVICENOW_USER and SERVICENOW_PASSWORD are required when SERVICENOW_INSTANCE is set")
+	}
+	if s.Table == "" {
+		s.Table = "sc_request"
+	}
+	return s, nil
+}
+
+// Name implements Tracker.
+func (s *ServiceNow) Name() string { return "servicenow" }
+
+// Attach implements Tracker, creating the record with the attachment as
+// its first work note or adding one to the existing record.
+func (s *ServiceNow) Attach(ctx context.Context, a Attachment) (string, error) {
+	note := a.Name + "\n\n" + a.Markdown
+	var record struct {
+		Result struct {
+			SysID string `json:"sys_id"`
+		} `json:"result"`
+	}
+	if a.ExternalID == "" {
+		url := fmt.Sprintf("%s/api/now/table/%s", s.Instance, s.Table)
+		body := map[string]any{
+			"short_description": a.Summary,
+			"correlation_id":    a.TicketID,
+			"work_notes":        note,
+		}
+		for name, v := range a.Fields {
+			body[name] = v
+		}
+		if err := doJSON(ctx, s.HTTP, "servicenow.create_record", http.MethodPost, url, s.headers(), body, &record); err != nil {
+			return "", fmt.Errorf("servicenow: creating a record for %s: %w", a.TicketID, err)
+		}
+		return record.Result.SysID, nil
+	}
+	url := fmt.Sprintf("%s/api/now/table/%s/%s", s.Instance, s.Table, a.ExternalID)
+	if err := doJSON(ctx, s.HTTP, "servicenow.add_work_note", http.MethodPatch, url, s.headers(), map[string]any{"work_notes": note}, nil); err != nil {
+		return "", fmt.Errorf("servicenow: adding a work note to %s: %w", a.ExternalID, err)
+	}
+	return a.ExternalID, nil
+}
+
+func (s *ServiceNow) headers() map[string]string {
+	return map[string]string{"Authorization": basicAuth(s.User, s.Password)}
+}
+
+// Jira keeps an issue per ticket in a Jira project and adds attachments to
+// it as comments.
+type Jira struct {
+	// BaseURL is the site URL, e.g. https://acme.atlassian.net.
+	BaseURL  string
+	Email    string
+	APIToken string
+	Project  string
+	// IssueType is the type of the issues created, Task by default.
+	IssueType string
+	HTTP      *http.Client
+}
+
+// JiraFromEnv configures the tracker from JIRA_URL, JIRA_EMAIL,
+// JIRA_API_TOKEN, JIRA_PROJECT and JIRA_ISSUE_TYPE. It returns nil when
+// JIRA_URL is not set.
+func JiraFromEnv() (*Jira, error) {
+	baseURL := os.Getenv("JIRA_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	j := &Jira{
+		BaseURL:   baseURL,
+		Email:     os.Getenv("JIRA_EMAIL"),
+		APIToken:  os.Getenv("JIRA_API_TOKEN"),
+		Project:   os.Getenv("JIRA_PROJECT"),
+		IssueType: os.Getenv("JIRA_ISSUE_TYPE"),
+		HTTP:      &http.Client{Timeout: 15 * time.Second},
+	}
+	if j.Email == "" || j.APIToken == "" || j.Project == "" {
+		return nil, errors.New("JIRA_EMAIL, JIRA_API_TOKEN and JIRA_PROJECT are required when JIRA_URL is set")
+	}
+	if j.IssueType == "" {
+		j.IssueType = "Task"
+	}
+	return j, nil
+}
+
+// Name implements Tracker.
+func (j *Jira) Name() string { return "jira" }
+
+// Attach implements Tracker, creating the issue if needed and adding the
+// attachment as a comment.
+func (j *Jira) Attach(ctx context.Context, a Attachment) (string, error) {
+	key := a.ExternalID
+	if key == "" {
+		var issue struct {
+			Key string `json:"key"`
+		}
+		fields := map[string]any{
+			"project":   map[string]string{"key": j.Project},
+			"issuetype": map[string]string{"name": j.IssueType},
+			"summary":   a.Summary,
+			"labels":    []string{a.TicketID},
+		}
+		for name, v := range a.Fields {
+			fields[name] = v
+		}
+		body := map[string]any{"fields": fields}
+		url := j.BaseURL + "/rest/api/2/issue"
+		if err := doJSON(ctx, j.HTTP, "jira.create_issue", http.MethodPost, url, j.headers(), body, &issue); err != nil {
+			return "", fmt.Errorf("jira: creating an issue for %s: %w", a.TicketID, err)
+		}
+		// A dry run creates no issue to comment on
+		if key = issue.Key; key == "" {
+			return "", nil
+		}
+	}
+	url := fmt.Sprintf("%s/rest/api/2/issue/%s/comment", j.BaseURL, key)
+	body := map[string]any{"body": a.Name + "\n\n" + a.Markdown}
+	if err := doJSON(ctx, j.HTTP, "jira.add_comment", http.MethodPost, url, j.headers(), body, nil); err != nil {
+		return key, fmt.Errorf("jira: commenting on %s: %w", key, err)
+	}
+	return key, nil
+}
+
+func (j *Jira) headers() map[string]string {
+	return map[string]string{"Authorization": basicAuth(j.Email, j.APIToken)}
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
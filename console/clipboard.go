@@ -0,0 +1,43 @@
+package console
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands are tried in order to set the system clipboard.
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip.exe"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	},
+}
+
+// Copy puts text on the system clipboard with the platform's clipboard
+// tool. Without one, a terminal is sent an OSC 52 sequence instead, which
+// most terminal emulators honor, also over SSH.
+func (c *Console) Copy(text string) error {
+	for _, args := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return nil
+	}
+	if !c.terminal {
+		return errors.New("no clipboard tool found")
+	}
+	fmt.Fprintf(c.w, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return nil
+}
@@ -0,0 +1,191 @@
+// Package console formats the interactive chats' terminal output: prompts
+// and replies in a color theme, a spinner while the model answers, and a
+// plain mode for screen readers.
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Theme holds the ANSI SGR parameters (such as "1;36" for bold cyan) used
+// for each kind of output. Empty parameters leave that output uncolored.
+type Theme struct {
+	User   string
+	Bot    string
+	Notice string
+	Dim    string
+}
+
+// Themes are the themes CLI_THEME can name.
+var Themes = map[string]Theme{
+	"default":       {User: "1;36", Bot: "1;32", Notice: "33", Dim: "2"},
+	"light":         {User: "1;34", Bot: "1;35", Notice: "31", Dim: "90"},
+	"high-contrast": {User: "1;97", Bot: "1;93", Notice: "1;97;41", Dim: "97"},
+	"none":          {},
+}
+
+// Console writes chat output to a terminal.
+type Console struct {
+	Theme Theme
+	// Color is set when the theme's colors are written; without it output
+	// is plain text.
+	Color bool
+	// ScreenReader writes output a screen reader can follow: no colors or
+	// spinner, and every message prefixed with who wrote it.
+	ScreenReader bool
+	// NotifyAfter is how long a generation must take before its end is
+	// announced (see NotifyIfSlow); zero never announces it.
+	NotifyAfter time.Duration
+
+	w        io.Writer
+	terminal bool
+	// preview is set for the split layout (see SetPreview), which redraws
+	// transcript on every message.
+	preview    Preview
+	transcript []entry
+}
+
+// FromEnv returns a Console writing to f in the CLI_THEME theme (default,
+// light, high-contrast or none). Colors and the spinner are only used when
+// f is a terminal. NO_COLOR turns colors off, CLI_SCREEN_READER=1 turns
+// on screen-reader output, and NOTIFY_AFTER sets NotifyAfter.
+func FromEnv(f *os.File) (*Console, error) {
+	name := os.Getenv("CLI_THEME")
+	if name == "" {
+		name = "default"
+	}
+	theme, ok := Themes[name]
+	if !ok {
+		names := make([]string, 0, len(Themes))
+		for n := range Themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("CLI_THEME must be one of %s, got %q", strings.Join(names, ", "), name)
+	}
+	c := &Console{Theme: theme, w: f, terminal: isTerminal(f)}
+	if v := os.Getenv("CLI_SCREEN_READER"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("CLI_SCREEN_READER must be a boolean, got %q", v)
+		}
+		c.ScreenReader = on
+	}
+	var err error
+	if c.NotifyAfter, err = notifyAfterFromEnv(); err != nil {
+		return nil, err
+	}
+	c.Color = c.terminal && !c.ScreenReader && os.Getenv("NO_COLOR") == ""
+	return c, nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the SGR parameters sgr when colors are on.
+func (c *Console) paint(sgr, s string) string {
+	if !c.Color || sgr == "" {
+		return s
+	}
+	return "\033[" + sgr + "m" + s + "\033[0m"
+}
+
+// Prompt asks for the user's next message.
+func (c *Console) Prompt() {
+	if c.ScreenReader {
+		fmt.Fprint(c.w, "User: ")
+		return
+	}
+	fmt.Fprint(c.w, c.paint(c.Theme.User, "You:")+" ")
+}
+
+// Echo shows a message sent on the user's behalf, such as a canned prompt,
+// with a note on where it came from.
+func (c *Console) Echo(note, message string) {
+	if c.preview != nil {
+		c.record("You ("+note+"):", c.Theme.User, message)
+		return
+	}
+	if c.ScreenReader {
+		fmt.Fprintf(c.w, "User (%s): %s\n", note, message)
+		return
+	}
+	fmt.Fprintf(c.w, "%s %s\n", c.paint(c.Theme.User, "You ("+note+"):"), message)
+}
+
+// Reply shows the bot's answer under its display name.
+func (c *Console) Reply(name, text string) {
+	if c.preview != nil {
+		c.record(name+":", c.Theme.Bot, text)
+		return
+	}
+	if c.ScreenReader {
+		fmt.Fprintf(c.w, "\nAssistant (%s): %s\n\n", name, text)
+		return
+	}
+	fmt.Fprintf(c.w, "\n%s %s\n\n", c.paint(c.Theme.Bot, name+":"), text)
+}
+
+// Warn shows a warning about the turn, such as a budget running low.
+func (c *Console) Warn(text string) {
+	if c.preview != nil {
+		c.record("Warning:", c.Theme.Notice, text)
+		return
+	}
+	if c.ScreenReader {
+		fmt.Fprintf(c.w, "\nWarning: %s\n", text)
+		return
+	}
+	fmt.Fprintf(c.w, "\n%s\n", c.paint(c.Theme.Notice, "[Warning: "+text+"]"))
+}
+
+// Notice shows a bracketed status line, such as an escalation.
+func (c *Console) Notice(text string) {
+	if c.preview != nil {
+		c.record("Notice:", c.Theme.Notice, text)
+		return
+	}
+	if c.ScreenReader {
+		fmt.Fprintf(c.w, "\nNotice: %s\n", text)
+		return
+	}
+	fmt.Fprintf(c.w, "\n%s\n", c.paint(c.Theme.Notice, "["+text+"]"))
+}
+
+// spinnerFrames are drawn in turn while the model answers.
+var spinnerFrames = []string{"|", "/", "-", `\`}
+
+// Wait shows a spinner until the returned stop is called, which clears
+// it. Off a terminal and for screen readers it shows nothing.
+func (c *Console) Wait() (stop func()) {
+	if !c.terminal || c.ScreenReader {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprint(c.w, "\r"+c.paint(c.Theme.Dim, spinnerFrames[i%len(spinnerFrames)]+" thinking"))
+			select {
+			case <-done:
+				fmt.Fprint(c.w, "\r\033[K")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
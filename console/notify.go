@@ -0,0 +1,60 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxNotifyBody caps the part of a reply shown in a notification.
+const maxNotifyBody = 120
+
+// notifyAfterFromEnv reads NOTIFY_AFTER, a duration or a number of
+// seconds; zero (the default) turns notifications off.
+func notifyAfterFromEnv() (time.Duration, error) {
+	v := os.Getenv("NOTIFY_AFTER")
+	if v == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("NOTIFY_AFTER must be a duration or a number of seconds, got %q", v)
+	}
+	return d, nil
+}
+
+// NotifyIfSlow rings the terminal bell and shows a desktop notification
+// with title and the start of body when a generation took at least
+// NotifyAfter, so a user who switched windows knows the answer is in.
+// Desktop notifications use notify-send on Linux and osascript on macOS;
+// elsewhere, or when those are missing, only the bell rings.
+func (c *Console) NotifyIfSlow(took time.Duration, title, body string) {
+	if c.NotifyAfter <= 0 || took < c.NotifyAfter {
+		return
+	}
+	fmt.Fprint(c.w, "\a")
+	body = strings.Join(strings.Fields(body), " ")
+	if r := []rune(body); len(r) > maxNotifyBody {
+		body = string(r[:maxNotifyBody-1]) + "…"
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("notify-send", title, body)
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", "display notification "+strconv.Quote(body)+" with title "+strconv.Quote(title))
+	default:
+		return
+	}
+	// The notification is best effort; a missing notifier leaves the bell
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
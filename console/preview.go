@@ -0,0 +1,172 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Field is one line of the preview panel. An empty Value is shown as
+// missing.
+type Field struct {
+	Label string
+	Value string
+}
+
+// Preview returns the panel shown beside the chat: a title and its fields,
+// read again each time the screen is drawn.
+type Preview func() (title string, fields []Field)
+
+// Default terminal size when it can't be read.
+const (
+	defaultWidth  = 100
+	defaultHeight = 30
+)
+
+// maxPanelWidth caps the preview panel's share of a wide terminal.
+const maxPanelWidth = 44
+
+// entry is one message of the transcript kept for redrawing.
+type entry struct {
+	label string
+	sgr   string
+	text  string
+}
+
+// SetPreview switches to a split layout: the chat on the left and the
+// panel preview returns on the right, redrawn after every message. It is
+// ignored off a terminal and for screen readers, which keep the plain
+// layout.
+func (c *Console) SetPreview(preview Preview) {
+	if c.terminal && !c.ScreenReader {
+		c.preview = preview
+	}
+}
+
+// Sent records a message the user typed, for the split layout's
+// transcript; with the plain layout the terminal already shows it.
+func (c *Console) Sent(message string) {
+	if c.preview != nil {
+		c.record(c.userLabel(), c.Theme.User, message)
+	}
+}
+
+func (c *Console) userLabel() string {
+	if c.ScreenReader {
+		return "User:"
+	}
+	return "You:"
+}
+
+// record adds a message to the transcript and redraws the screen.
+func (c *Console) record(label, sgr, text string) {
+	c.transcript = append(c.transcript, entry{label: label, sgr: sgr, text: text})
+	c.redraw()
+}
+
+func (c *Console) size() (width, height int) {
+	if f, ok := c.w.(*os.File); ok {
+		if w, h, err := term.GetSize(int(f.Fd())); err == nil && w > 0 && h > 0 {
+			return w, h
+		}
+	}
+	return defaultWidth, defaultHeight
+}
+
+// line is a drawn line: its plain text, whose length pads the columns,
+// and the text to draw, with colors.
+type line struct{ plain, drawn string }
+
+func plainLine(s string) line { return line{s, s} }
+
+// redraw clears the screen and draws the transcript's latest lines beside
+// the preview panel, leaving the last line for the prompt.
+func (c *Console) redraw() {
+	width, height := c.size()
+	panelWidth := min(maxPanelWidth, width/3)
+	chatWidth := width - panelWidth - 3
+
+	var chat []line
+	for _, e := range c.transcript {
+		for i, l := range wrap(e.label+" "+e.text, chatWidth) {
+			if rest, ok := strings.CutPrefix(l, e.label); i == 0 && ok {
+				chat = append(chat, line{l, c.paint(e.sgr, e.label) + rest})
+				continue
+			}
+			chat = append(chat, plainLine(l))
+		}
+		chat = append(chat, line{})
+	}
+	rows := max(height-1, 1)
+	if len(chat) > rows {
+		chat = chat[len(chat)-rows:]
+	}
+
+	title, fields := c.preview()
+	panel := []line{{title, c.paint(c.Theme.Bot, title)}, {}}
+	for _, f := range fields {
+		value, sgr := f.Value, ""
+		if value == "" {
+			value, sgr = "(missing)", c.Theme.Notice
+		}
+		label := f.Label + ": "
+		for i, l := range wrap(label+value, panelWidth) {
+			if rest, ok := strings.CutPrefix(l, label); i == 0 && ok {
+				panel = append(panel, line{l, label + c.paint(sgr, rest)})
+				continue
+			}
+			panel = append(panel, line{l, c.paint(sgr, l)})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	for i := range rows {
+		var left, right line
+		if i < len(chat) {
+			left = chat[i]
+		}
+		if i < len(panel) {
+			right = panel[i]
+		}
+		b.WriteString(left.drawn)
+		b.WriteString(strings.Repeat(" ", max(chatWidth-utf8.RuneCountInString(left.plain), 0)))
+		b.WriteString(" " + c.paint(c.Theme.Dim, "│") + " ")
+		b.WriteString(right.drawn)
+		b.WriteString("\n")
+	}
+	fmt.Fprint(c.w, b.String())
+}
+
+// wrap breaks text into lines of at most width runes, at spaces where it
+// can. Newlines in text start new lines.
+func wrap(text string, width int) []string {
+	width = max(width, 1)
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		var cur []rune
+		for _, word := range strings.Fields(para) {
+			w := []rune(word)
+			for len(w) > width {
+				// A word longer than a line is split
+				if len(cur) > 0 {
+					lines, cur = append(lines, string(cur)), nil
+				}
+				lines, w = append(lines, string(w[:width])), w[width:]
+			}
+			switch {
+			case len(cur) == 0:
+				cur = w
+			case len(cur)+1+len(w) <= width:
+				cur = append(append(cur, ' '), w...)
+			default:
+				lines, cur = append(lines, string(cur)), w
+			}
+		}
+		lines = append(lines, string(cur))
+	}
+	return lines
+}
@@ -0,0 +1,93 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+)
+
+// ErrInjected is the error of an injected connector failure.
+var ErrInjected = errors.New("injected fault")
+
+// anyConnector in FAULT_CONNECTOR_RATES applies to connectors not listed.
+const anyConnector = "*"
+
+// connectorRatesFromEnv reads FAULT_CONNECTOR_RATES: comma-separated
+// name=share pairs such as "github=0.5,webhook=0.2,*=0.1".
+func connectorRatesFromEnv() (map[string]float64, error) {
+	v := os.Getenv("FAULT_CONNECTOR_RATES")
+	if v == "" {
+		return nil, nil
+	}
+	rates := map[string]float64{}
+	for _, pair := range strings.Split(v, ",") {
+		name, share, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(share), 64)
+		if !ok || name == "" || err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("FAULT_CONNECTOR_RATES: invalid entry %q (want name=share between 0 and 1)", pair)
+		}
+		rates[strings.TrimSpace(name)] = rate
+	}
+	return rates, nil
+}
+
+// connectorRate is the failure share for the named connector.
+func (i *Injector) connectorRate(name string) float64 {
+	if i == nil {
+		return 0
+	}
+	if rate, ok := i.ConnectorRates[name]; ok {
+		return rate
+	}
+	return i.ConnectorRates[anyConnector]
+}
+
+// Fail returns ErrInjected, wrapped with name, for the named connector's
+// share of calls, and records it on the span of ctx: as a fault.injected
+// event and as the span's error. Dry runs fail too, so failures can be
+// rehearsed without touching real systems.
+func (i *Injector) Fail(ctx context.Context, name string) error {
+	rate := i.connectorRate(name)
+	if rate == 0 || rand.Float64() >= rate {
+		return nil
+	}
+	err := fmt.Errorf("%s: %w", name, ErrInjected)
+	span := trace.SpanFromContext(ctx)
+	event(ctx, "connector", attribute.String("fault.connector", name))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// Connector wraps c so its grants fail at the rate FAULT_CONNECTOR_RATES
+// sets for it. It returns c unchanged when there is nothing to inject.
+func (i *Injector) Connector(c connector.Connector) connector.Connector {
+	if i.connectorRate(c.Name()) == 0 {
+		return c
+	}
+	return &chaosConnector{Connector: c, injector: i}
+}
+
+type chaosConnector struct {
+	connector.Connector
+	injector *Injector
+}
+
+// Grant fails before the target system is called, so an injected fault is
+// a rejection that granted nothing.
+func (c *chaosConnector) Grant(ctx context.Context, req connector.Request) (connector.Result, error) {
+	if err := c.injector.Fail(ctx, c.Name()); err != nil {
+		return connector.Result{}, connector.Reject(err)
+	}
+	return c.Connector.Grant(ctx, req)
+}
@@ -0,0 +1,213 @@
+// Package faults injects failures on purpose: slow and failing model calls,
+// failing span exports and failing connectors. Turning them on before
+// production shows whether retries, rate limiting, sampling, the export
+// queue and the outbox behave as configured.
+package faults
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Injector decides which requests fail. A nil Injector injects nothing.
+type Injector struct {
+	// ModelLatency is added before every model call.
+	ModelLatency time.Duration
+	// RateLimitRate and ServerErrorRate are the shares of model calls
+	// answered with a fake 429 or 500 instead of reaching the API.
+	RateLimitRate   float64
+	ServerErrorRate float64
+	// ExportFailureRate is the share of OTLP export requests answered with
+	// a fake 503, which the exporter retries.
+	ExportFailureRate float64
+	// ConnectorRates are the shares of connector calls that fail, by
+	// connector name; "*" covers the connectors not named.
+	ConnectorRates map[string]float64
+}
+
+// flags maps each --fault-* flag to the variable it sets.
+var flags = []struct{ flag, env, usage string }{
+	{"fault-model-latency", "FAULT_MODEL_LATENCY", "add this `duration` before every model call"},
+	{"fault-429-rate", "FAULT_MODEL_429_RATE", "answer this `share` (0-1) of model calls with a fake 429"},
+	{"fault-500-rate", "FAULT_MODEL_500_RATE", "answer this `share` (0-1) of model calls with a fake 500"},
+	{"fault-export-failure-rate", "FAULT_EXPORT_FAILURE_RATE", "fail this `share` (0-1) of span export requests with a 503"},
+	{"fault-connector-rates", "FAULT_CONNECTOR_RATES", "fail connector calls at these `rates`, such as github=0.5,webhook=0.2,*=0.1"},
+}
+
+// RegisterFlags adds the --fault-* flags to fs. Each sets its FAULT_*
+// variable, so the faults also reach subcommands and FromEnv.
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, f := range flags {
+		fs.Func(f.flag, f.usage+" (sets "+f.env+")", func(v string) error {
+			return os.Setenv(f.env, v)
+		})
+	}
+}
+
+// FromEnv returns the Injector set by FAULT_MODEL_LATENCY (a duration),
+// FAULT_MODEL_429_RATE, FAULT_MODEL_500_RATE and FAULT_EXPORT_FAILURE_RATE
+// (shares between 0 and 1) and FAULT_CONNECTOR_RATES (name=share pairs),
+// or nil when none of them is set.
+func FromEnv() (*Injector, error) {
+	var i Injector
+	if v := os.Getenv("FAULT_MODEL_LATENCY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("FAULT_MODEL_LATENCY must be a duration, got %q", v)
+		}
+		i.ModelLatency = d
+	}
+	for _, r := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"FAULT_MODEL_429_RATE", &i.RateLimitRate},
+		{"FAULT_MODEL_500_RATE", &i.ServerErrorRate},
+		{"FAULT_EXPORT_FAILURE_RATE", &i.ExportFailureRate},
+	} {
+		v := os.Getenv(r.name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1, got %q", r.name, v)
+		}
+		*r.dst = f
+	}
+	if i.RateLimitRate+i.ServerErrorRate > 1 {
+		return nil, errors.New("FAULT_MODEL_429_RATE and FAULT_MODEL_500_RATE add up to more than 1")
+	}
+	var err error
+	if i.ConnectorRates, err = connectorRatesFromEnv(); err != nil {
+		return nil, err
+	}
+	if i.ModelLatency == 0 && i.RateLimitRate == 0 && i.ServerErrorRate == 0 && i.ExportFailureRate == 0 && len(i.ConnectorRates) == 0 {
+		return nil, nil
+	}
+	return &i, nil
+}
+
+// Describe lists the faults i injects, for a startup line.
+func (i *Injector) Describe() string {
+	if i == nil {
+		return "none"
+	}
+	var parts []string
+	if i.ModelLatency > 0 {
+		parts = append(parts, fmt.Sprintf("+%s per model call", i.ModelLatency))
+	}
+	if i.RateLimitRate > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% model 429s", 100*i.RateLimitRate))
+	}
+	if i.ServerErrorRate > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% model 500s", 100*i.ServerErrorRate))
+	}
+	if i.ExportFailureRate > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% failed span exports", 100*i.ExportFailureRate))
+	}
+	names := make([]string, 0, len(i.ConnectorRates))
+	for name := range i.ConnectorRates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		label := name
+		if name == anyConnector {
+			label = "other connector"
+		}
+		parts = append(parts, fmt.Sprintf("%g%% failed %s calls", 100*i.ConnectorRates[name], label))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Option returns client middleware injecting the model faults. Put it
+// last, so the scheduler and the SDK's retries see the fake responses as
+// they would real ones. Each one is recorded as a fault.injected event on
+// the span of the request's context.
+func (i *Injector) Option() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		if i == nil || !strings.HasSuffix(req.URL.Path, "/v1/messages") {
+			return next(req)
+		}
+		if i.ModelLatency > 0 {
+			event(req.Context(), "latency", attribute.Int64("fault.latency_ms", i.ModelLatency.Milliseconds()))
+			select {
+			case <-time.After(i.ModelLatency):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+		switch r := rand.Float64(); {
+		case r < i.RateLimitRate:
+			event(req.Context(), "http_429")
+			return fakeResponse(req, http.StatusTooManyRequests, "rate_limit_error"), nil
+		case r < i.RateLimitRate+i.ServerErrorRate:
+			event(req.Context(), "http_500")
+			return fakeResponse(req, http.StatusInternalServerError, "api_error"), nil
+		}
+		return next(req)
+	})
+}
+
+// Transport wraps base, http.DefaultTransport when nil, to fail export
+// requests. It returns base unchanged when there is nothing to inject.
+func (i *Injector) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if i == nil || i.ExportFailureRate == 0 {
+		return base
+	}
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if rand.Float64() < i.ExportFailureRate {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return fakeResponse(req, http.StatusServiceUnavailable, "injected_fault"), nil
+		}
+		return base.RoundTrip(req)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func event(ctx context.Context, kind string, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).AddEvent("fault.injected", trace.WithAttributes(append(attrs, attribute.String("fault.kind", kind))...))
+}
+
+// fakeResponse is an error response shaped like the Anthropic API's, with a
+// short retry-after.
+func fakeResponse(req *http.Request, status int, errorType string) *http.Response {
+	body := fmt.Sprintf(`{"type":"error","error":{"type":%q,"message":"injected fault"}}`, errorType)
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("Retry-After", "1")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,128 @@
+// Package feedback posts scores to LangSmith as feedback on traced runs.
+package feedback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client creates LangSmith feedback.
+type Client struct {
+	APIKey  string
+	BaseURL string
+	HTTP    *http.Client
+
+	pending sync.WaitGroup
+}
+
+// FromEnv uses LANGSMITH_API_KEY and LANGSMITH_ENDPOINT (default
+// https://api.smith.langchain.com).
+func FromEnv() *Client {
+	base := os.Getenv("LANGSMITH_ENDPOINT")
+	if base == "" {
+		base = "https://api.smith.langchain.com"
+	}
+	return &Client{
+		APIKey:  os.Getenv("LANGSMITH_API_KEY"),
+		BaseURL: base,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Score is one piece of feedback on a run.
+type Score struct {
+	Key     string
+	Score   float64
+	Comment string
+	// Source is the LangSmith feedback source type; it defaults to "model"
+	// for automatic scores, and "app" marks feedback from end users.
+	Source string
+}
+
+// Post attaches s to the run LangSmith created for span.
+func (c *Client) Post(ctx context.Context, span trace.SpanContext, s Score) error {
+	traceID := span.TraceID()
+	return c.PostRun(ctx, RunID(span).String(), uuid.UUID(traceID).String(), s)
+}
+
+// PostRun attaches s to a run by its LangSmith IDs, e.g. one returned by
+// the runs API.
+func (c *Client) PostRun(ctx context.Context, runID, traceID string, s Score) error {
+	source := s.Source
+	if source == "" {
+		source = "model"
+	}
+	body, err := json.Marshal(map[string]any{
+		"id":              uuid.New().String(),
+		"run_id":          runID,
+		"trace_id":        traceID,
+		"key":             s.Key,
+		"score":           s.Score,
+		"comment":         s.Comment,
+		"feedback_source": map[string]string{"type": source},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/feedback", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.APIKey)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("LangSmith feedback returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// PostAsync posts scores in the background so the turn isn't held up;
+// failures are logged. Call Wait before exiting.
+func (c *Client) PostAsync(span trace.SpanContext, scores ...Score) {
+	// Without a traced run there is nothing to attach feedback to
+	if !span.IsValid() {
+		return
+	}
+	for _, s := range scores {
+		c.pending.Add(1)
+		go func() {
+			defer c.pending.Done()
+			if err := c.Post(context.Background(), span, s); err != nil {
+				log.Printf("Posting %s feedback: %v", s.Key, err)
+			}
+		}()
+	}
+}
+
+// Wait blocks until all PostAsync calls have finished.
+func (c *Client) Wait() {
+	c.pending.Wait()
+}
+
+// RunID is the LangSmith run ID of an exported OTel span: the first 8
+// bytes of the trace ID followed by the 8-byte span ID.
+func RunID(span trace.SpanContext) uuid.UUID {
+	traceID, spanID := span.TraceID(), span.SpanID()
+	var id uuid.UUID
+	copy(id[:8], traceID[:8])
+	copy(id[8:], spanID[:])
+	return id
+}
@@ -3,29 +3,49 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
-	"github.com/google/uuid"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
 	"github.com/joho/godotenv"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
-	"go.opentelemetry.io/otel/trace"
 
 	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+	"go-tracing-demo/console"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/feedback"
+	"go-tracing-demo/guardrail"
+	"go-tracing-demo/historytrim"
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+	"go-tracing-demo/persona"
+	"go-tracing-demo/ratelimit"
+	"go-tracing-demo/respcache"
+	"go-tracing-demo/survey"
+	"go-tracing-demo/tools"
+	"go-tracing-demo/tracehooks"
 )
 
 func main() {
+	importPath := flag.String("import", "", "continue the conversation in this OpenAI- or Anthropic-style message JSON file")
+	user := flag.String("user", "", "identity recorded on every span as langsmith.metadata.user_id (default USER_ID)")
+	cache := flag.Bool("cache", false, "replay responses to requests seen before from disk instead of calling the API")
+	faults.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
@@ -33,7 +53,7 @@ func main() {
 
 	// Validate keys
 	langsmithKey := os.Getenv("LANGSMITH_API_KEY")
-	if langsmithKey == "" {
+	if langsmithKey == "" && !otlpexport.TracingDisabled() && otlpexport.ToLangSmith() {
 		log.Fatal("LANGSMITH_API_KEY is required")
 	}
 
@@ -48,34 +68,140 @@ func main() {
 	}
 
 	// Initialize OpenTelemetry tracing to LangSmith
-	shutdown, err := initTracer(langsmithKey, projectName)
+	userID, err := otlpexport.UserIDFromEnv(*user)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdown, err := otlpexport.Init(otlpexport.Setup{
+		ServiceName: "go-chat-demo",
+		APIKey:      langsmithKey,
+		Project:     projectName,
+		UserID:      userID,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
 	defer shutdown()
 
+	// Large prompts and completions may go out as events or compressed
+	payloads, err := payload.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inputPolicy, err := guardrail.Load(os.Getenv("INPUT_POLICY_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load input policy: %v", err)
+	}
+
 	// Create Anthropic client with automatic tracing
+	scheduler, err := ratelimit.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	responses, err := respcache.FromEnv(*cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	injector, err := faults.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	client := anthropic.NewClient(
 		option.WithAPIKey(anthropicKey),
 		option.WithHTTPClient(traceanthropic.Client()),
+		tracehooks.Option(),
+		// Cache hits are answered before the scheduler holds anything back
+		responses.Option(),
+		scheduler.Option(),
+		injector.Option(),
 	)
 
 	ctx := context.Background()
 	reader := bufio.NewReader(os.Stdin)
+	con, err := console.FromEnv(os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
 	tracer := otel.Tracer("go-chat-demo")
+	models, err := chat.ModelsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid model: %v", err)
+	}
 
-	// Generate a unique thread ID per session
-	threadID := uuid.New().String()
+	personas, err := persona.Load(os.Getenv("PERSONAS_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load personas: %v", err)
+	}
+	personaName := os.Getenv("PERSONA")
+	if personaName == "" {
+		personaName = "chat"
+	}
+	bot, err := personas.Get(personaName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Tools the persona may call, run concurrently under the turn span
+	var executor *tools.Executor
+	if len(bot.Tools) > 0 {
+		toolConfig, err := tools.ConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if summarize, _ := strconv.ParseBool(os.Getenv("TOOL_RESULT_SUMMARIZE")); summarize {
+			toolConfig.Summarize = chat.ToolResultSummarizer(&client, models.Summary)
+		}
+		toolset, err := tools.Lookup(bot.Tools...)
+		if err != nil {
+			log.Fatalf("Persona %s: %v", bot.Name, err)
+		}
+		executor = tools.NewExecutor(tracer, toolConfig, toolset)
+	}
+
+	// Reply in the user's language, defaulting to the configured locale
+	locale := resolveLocale()
+	systemPrompt := localizedSystemPrompt(bot.SystemPrompt(locale), locale)
+
+	contextGuard, err := historytrim.GuardFromEnv(models.Chat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	session := chat.NewSession(&client, tracer, chat.SessionConfig{
+		Persona:      bot,
+		SystemPrompt: systemPrompt,
+		Locale:       locale,
+		Model:        models.Chat,
+		Executor:     executor,
+		InputPolicy:  inputPolicy,
+		ContextGuard: contextGuard,
+		Payloads:     payloads,
+	})
+
+	// A conversation started elsewhere continues under its own session_id
+	if *importPath != "" {
+		imported, err := chat.ImportConversation(*importPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		session.Import(imported)
+		fmt.Printf("Imported %d messages from %s\n", len(imported.Messages), *importPath)
+	}
 
-	// Maintain conversation history 
-	var conversationHistory []anthropic.MessageParam
+	fmt.Printf("Chat with %s (tracing to LangSmith project: %s)\n", bot.DisplayName, projectName)
+	fmt.Printf("Persona: %s\n", bot.Name)
+	fmt.Printf("Thread ID: %s\n", session.ID())
+	if injector != nil {
+		fmt.Printf("Fault injection: %s\n", injector.Describe())
+	}
+	fmt.Printf("Locale: %s\n", locale)
+	fmt.Print("Commands: /fork, /undo, /retry [temperature], /copy, /compact. Type 'quit' to exit.\n\n")
 
-	fmt.Printf("Chat with Claude (tracing to LangSmith project: %s)\n", projectName)
-	fmt.Printf("Thread ID: %s\n", threadID)
-	fmt.Println("Type 'quit' to exit.\n")
+	// The last answer shown, for /copy
+	var lastReply string
 
 	for {
-		fmt.Print("You: ")
+		con.Prompt()
 		userMessage, err := reader.ReadString('\n')
 		if err != nil {
 			log.Printf("Error reading input: %v", err)
@@ -87,6 +213,9 @@ func main() {
 			continue
 		}
 		if strings.ToLower(userMessage) == "quit" {
+			if spans := session.TurnSpans(); survey.Enabled() && len(spans) > 0 {
+				survey.Run(ctx, reader, os.Stdout, feedback.FromEnv(), spans[0], survey.Response{SessionID: session.ID(), Persona: bot.Name, Turns: len(spans)})
+			}
 			fmt.Println("\nFlushing traces to LangSmith...")
 			if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
 				if err := tp.ForceFlush(ctx); err != nil {
@@ -97,101 +226,90 @@ func main() {
 			return
 		}
 
-		// Add user message to history
-		conversationHistory = append(conversationHistory,
-			anthropic.NewUserMessage(anthropic.NewTextBlock(userMessage)),
-		)
-
-		// Create a parent span for this conversation turn with thread metadata
-		// This groups all turns with the same session_id into a thread in LangSmith
-		turnCtx, turnSpan := tracer.Start(ctx, "chat_turn",
-			trace.WithAttributes(
-				attribute.String("langsmith.trace.name", "go-bot"),
-				attribute.String("langsmith.metadata.session_id", threadID),
-				attribute.String("langsmith.span.kind", "chain"),
-				// Set input on the parent span for Thread view
-				attribute.String("gen_ai.prompt", userMessage),
-			),
-		)
-
-		resp, err := client.Messages.New(turnCtx, anthropic.MessageNewParams{
-			Model:     anthropic.Model("claude-sonnet-4-20250514"),
-			MaxTokens: 1024,
-			Messages:  conversationHistory,
-		})
-
-		if err != nil {
-			log.Printf("Error: %v\n", err)
-			turnSpan.End()
+		var turn func() (chat.TurnResult, error)
+		switch {
+		case userMessage == "/fork":
+			forkedFrom := session.Fork()
+			fmt.Printf("\nForked thread %s -> %s (%d messages copied)\n\n", forkedFrom, session.ID(), len(session.History()))
 			continue
-		}
 
-		// Extract and display response (concat all text blocks)
-		var textParts []string
-		for _, block := range resp.Content {
-			if block.Type == "text" {
-				textParts = append(textParts, block.Text)
+		case userMessage == "/compact":
+			if len(session.History()) <= 2 {
+				fmt.Print("\nNothing to compact.\n\n")
+				continue
 			}
-		}
-		responseText := strings.Join(textParts, "\n")
-
-		turnSpan.SetAttributes(
-			attribute.String("gen_ai.completion", responseText),
-			attribute.Int64("gen_ai.usage.input_tokens", resp.Usage.InputTokens),
-			attribute.Int64("gen_ai.usage.output_tokens", resp.Usage.OutputTokens),
-		)
+			stopWait := con.Wait()
+			compacted, c, err := historytrim.Compact(ctx, tracer, historytrim.ModelSummarizer(&client, models.Summary), session.History(),
+				attribute.String("langsmith.trace.name", "history_compact"),
+				attribute.String("langsmith.metadata.session_id", session.ID()),
+			)
+			stopWait()
+			if err != nil {
+				fmt.Printf("\nCannot compact: %v\n\n", err)
+				continue
+			}
+			session.ReplaceHistory(compacted)
+			fmt.Printf("\nCompacted %d messages (~%d tokens) into %d (~%d tokens)\n\n", c.MessagesBefore, c.TokensBefore, c.MessagesAfter, c.TokensAfter)
+			continue
 
-		// Add assistant response to history
-		conversationHistory = append(conversationHistory,
-			anthropic.NewAssistantMessage(anthropic.NewTextBlock(responseText)),
-		)
+		case userMessage == "/copy":
+			if lastReply == "" {
+				fmt.Print("\nNothing to copy yet.\n\n")
+				continue
+			}
+			if err := con.Copy(lastReply); err != nil {
+				fmt.Printf("\nCannot copy the last reply: %v\n\n", err)
+				continue
+			}
+			fmt.Print("\nCopied the last reply to the clipboard.\n\n")
+			continue
 
-		turnSpan.End()
+		case userMessage == "/undo":
+			if err := session.Undo(); err != nil {
+				fmt.Print("\nNothing to undo.\n\n")
+				continue
+			}
+			fmt.Printf("\nRemoved the last exchange (%d messages left)\n\n", len(session.History()))
+			continue
 
-		fmt.Printf("\nClaude: %s\n\n", responseText)
-	}
-}
+		case userMessage == "/retry" || strings.HasPrefix(userMessage, "/retry "):
+			if !session.CanRewind() {
+				fmt.Print("\nNothing to retry.\n\n")
+				continue
+			}
+			var temperature param.Opt[float64]
+			if arg := strings.TrimSpace(strings.TrimPrefix(userMessage, "/retry")); arg != "" {
+				t, err := strconv.ParseFloat(arg, 64)
+				if err != nil || t < 0 || t > 1 {
+					fmt.Print("\nUsage: /retry [temperature between 0 and 1]\n\n")
+					continue
+				}
+				temperature = anthropic.Float(t)
+			}
+			turn = func() (chat.TurnResult, error) { return session.Retry(ctx, temperature) }
 
-func initTracer(apiKey, projectName string) (func(), error) {
-	ctx := context.Background()
+		default:
+			turn = func() (chat.TurnResult, error) { return session.RunTurn(ctx, userMessage) }
+		}
 
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceName("go-chat-demo"),
-		),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("creating resource: %w", err)
-	}
+		stopWait, started := con.Wait(), time.Now()
+		result, err := turn()
+		stopWait()
+		if errors.Is(err, boterr.ErrGuardrailBlocked) {
+			con.Reply(bot.DisplayName, boterr.UserMessage(err))
+			continue
+		}
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			con.Warn(boterr.UserMessage(err))
+			continue
+		}
+		if result.ContextWarning != "" {
+			con.Warn(result.ContextWarning)
+		}
 
-	exporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint("api.smith.langchain.com"),
-		otlptracehttp.WithURLPath("/otel/v1/traces"),
-		otlptracehttp.WithHeaders(map[string]string{
-			"x-api-key":         apiKey,
-			"Langsmith-Project": projectName,
-		}),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("creating exporter: %w", err)
+		con.Reply(bot.DisplayName, result.Reply)
+		lastReply = result.Reply
+		con.NotifyIfSlow(time.Since(started), bot.DisplayName, result.Reply)
 	}
-
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(time.Second)),
-		sdktrace.WithResource(res),
-	)
-
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
-
-	return func() {
-		shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
-		if err := tp.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Error shutting down tracer: %v", err)
-		}
-	}, nil
 }
@@ -0,0 +1,159 @@
+// Command go-bot-eval turns the traces the bots produce into an evaluation
+// loop: it reads runs back from LangSmith, scores them and writes feedback.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/otlpexport"
+)
+
+const usage = `usage: go-bot-eval <command> [flags]
+
+Commands:
+  judge    score recent runs with an LLM judge and write the scores back as feedback
+  regress  re-run a dataset and fail on regressions against a baseline experiment
+  queue    push escalated or low-scoring runs into an annotation queue for human review`
+
+func main() {
+	// Load .env file
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	langsmithKey := os.Getenv("LANGSMITH_API_KEY")
+	if langsmithKey == "" {
+		log.Fatal("LANGSMITH_API_KEY is required")
+	}
+
+	// The evaluator's own LLM calls are traced to a separate project
+	projectName := os.Getenv("LANGSMITH_EVAL_PROJECT")
+	if projectName == "" {
+		projectName = "go-bot-eval"
+	}
+	shutdown, err := initTracer(langsmithKey, projectName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracer: %v", err)
+	}
+	defer shutdown()
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "judge":
+		var client *anthropic.Client
+		if client, err = newAnthropicClient(); err == nil {
+			err = runJudge(ctx, client, os.Args[2:])
+		}
+	case "regress":
+		var client *anthropic.Client
+		if client, err = newAnthropicClient(); err == nil {
+			err = runRegress(ctx, client, os.Args[2:])
+		}
+	case "queue":
+		err = runQueue(ctx, os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		// Deferred functions don't run on log.Fatal; flush what was traced
+		shutdown()
+		log.Fatal(err)
+	}
+}
+
+// newAnthropicClient returns a traced client for commands that call a model.
+func newAnthropicClient() (*anthropic.Client, error) {
+	anthropicKey := os.Getenv("ANTHROPIC_API_KEY")
+	if anthropicKey == "" {
+		return nil, errors.New("ANTHROPIC_API_KEY is required")
+	}
+	client := anthropic.NewClient(
+		option.WithAPIKey(anthropicKey),
+		option.WithHTTPClient(traceanthropic.Client()),
+	)
+	return &client, nil
+}
+
+func initTracer(apiKey, projectName string) (func(), error) {
+	ctx := context.Background()
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("go-bot-eval"),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+
+	exportOpts, err := otlpexport.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	propagator, err := otlpexport.PropagatorFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, append([]otlptracehttp.Option{
+		otlptracehttp.WithEndpoint("api.smith.langchain.com"),
+		otlptracehttp.WithURLPath("/otel/v1/traces"),
+		otlptracehttp.WithHeaders(map[string]string{
+			"x-api-key":         apiKey,
+			"Langsmith-Project": projectName,
+		}),
+	}, exportOpts.HTTPOptions()...)...)
+	if err != nil {
+		return nil, fmt.Errorf("creating exporter: %w", err)
+	}
+
+	meter := otel.Meter("go-bot-eval")
+	budget, err := otlpexport.NewSizeBudget(exportOpts.SpanBudget, meter)
+	if err != nil {
+		return nil, fmt.Errorf("registering span size metrics: %w", err)
+	}
+	health := otlpexport.NewHealth()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(budget),
+		sdktrace.WithSpanProcessor(health.Batcher(exportOpts.Skew(ctx, exporter), exportOpts.BatcherOptions()...)),
+		sdktrace.WithResource(res),
+	)
+	if err := health.RegisterMetrics(meter); err != nil {
+		return nil, fmt.Errorf("registering export metrics: %w", err)
+	}
+	stopHealthLog := health.LogEvery(exportOpts.HealthLogInterval)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, exportOpts.ShutdownTimeout)
+		defer cancel()
+		if err := tp.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down tracer: %v", err)
+		}
+		stopHealthLog()
+		health.Log()
+	}, nil
+}
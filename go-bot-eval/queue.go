@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/runs"
+)
+
+// runQueue implements "queue": select runs that need a human look and add
+// them to a LangSmith annotation queue.
+func runQueue(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("queue", flag.ContinueOnError)
+	queueName := fs.String("queue", "go-bot-review", "annotation queue to add runs to (created if missing)")
+	project := fs.String("project", defaultProject(), "LangSmith project to select runs from")
+	name := fs.String("name", "", "only consider root runs with this name (e.g. itsm_turn)")
+	since := fs.Duration("since", 24*time.Hour, "how far back to look")
+	limit := fs.Int("limit", 100, "maximum number of runs to examine")
+	escalated := fs.Bool("escalated", true, "select runs escalated to a human")
+	below := fs.Float64("below", 0.5, "select runs with any feedback score below this average (0 disables)")
+	keys := fs.String("keys", "", "comma-separated feedback keys --below applies to (default: all)")
+	dryRun := fs.Bool("dry-run", false, "list the selected runs without adding them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var scoreKeys []string
+	if *keys != "" {
+		scoreKeys = strings.Split(*keys, ",")
+	}
+
+	tracer := otel.Tracer("go-bot-eval")
+	ctx, span := tracer.Start(ctx, "eval_queue", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("eval.project", *project),
+		attribute.String("eval.run_name", *name),
+		attribute.String("eval.queue", *queueName),
+	))
+	defer span.End()
+
+	err := func() error {
+		api := runs.FromEnv()
+		list, err := api.List(ctx, runs.Query{Project: *project, Name: *name, Since: time.Now().Add(-*since), Limit: *limit})
+		if err != nil {
+			return err
+		}
+
+		var selected []string
+		for _, run := range list {
+			reasons := selectReasons(run, *escalated, *below, scoreKeys)
+			if len(reasons) == 0 {
+				continue
+			}
+			selected = append(selected, run.ID)
+			fmt.Printf("%s  %-12s %s\n", run.ID, run.Name, strings.Join(reasons, ", "))
+		}
+		span.SetAttributes(
+			attribute.Int("eval.runs", len(list)),
+			attribute.Int("eval.selected", len(selected)),
+		)
+
+		if *dryRun || len(selected) == 0 {
+			fmt.Printf("\n%d of %d runs selected; none added\n", len(selected), len(list))
+			return nil
+		}
+		queue, err := api.EnsureQueue(ctx, *queueName, "Runs flagged for human review by go-bot-eval")
+		if err != nil {
+			return err
+		}
+		span.SetAttributes(attribute.String("eval.queue_id", queue.ID))
+		if err := api.AddToQueue(ctx, queue.ID, selected); err != nil {
+			return err
+		}
+		fmt.Printf("\nAdded %d of %d runs to annotation queue %s\n", len(selected), len(list), queue.Name)
+		return nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// selectReasons explains why run needs review; an empty result means it
+// doesn't.
+func selectReasons(run runs.Run, escalated bool, below float64, keys []string) []string {
+	var reasons []string
+	if escalated && isTrue(run.Extra.Metadata["escalated"]) {
+		reasons = append(reasons, "escalated")
+	}
+	if below > 0 {
+		if len(keys) == 0 {
+			for key := range run.FeedbackStats {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		}
+		for _, key := range keys {
+			if stat, ok := run.FeedbackStats[key]; ok && stat.Avg < below {
+				reasons = append(reasons, fmt.Sprintf("%s=%.2f", key, stat.Avg))
+			}
+		}
+	}
+	return reasons
+}
+
+// isTrue accepts metadata booleans whether the exporter kept them as JSON
+// booleans or turned them into strings.
+func isTrue(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
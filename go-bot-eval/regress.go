@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/chat"
+	"go-tracing-demo/feedback"
+	"go-tracing-demo/persona"
+	"go-tracing-demo/runs"
+)
+
+// metrics are what regress compares for one example, or their mean over a
+// whole experiment.
+type metrics struct {
+	Latency time.Duration
+	Tokens  float64
+	Scores  map[string]float64
+}
+
+// regressOptions are the thresholds past which a change counts as a
+// regression.
+type regressOptions struct {
+	LatencyTolerance float64
+	TokenTolerance   float64
+	ScoreDrop        float64
+}
+
+// runRegress implements "regress": answer every example in the baseline
+// experiment's dataset again, record the answers as a new experiment, and
+// fail if latency, tokens or judge scores got worse.
+func runRegress(ctx context.Context, client *anthropic.Client, args []string) error {
+	fs := flag.NewFlagSet("regress", flag.ContinueOnError)
+	baseline := fs.String("baseline", "", "experiment to compare against; without it the run only records a new experiment")
+	dataset := fs.String("dataset", "", "dataset to run (default: the baseline's dataset)")
+	personaName := fs.String("persona", "itsm", "persona whose system prompt answers the examples")
+	experiment := fs.String("experiment", "", "name of the new experiment (default: <persona>-regress-<timestamp>)")
+	var opts regressOptions
+	fs.Float64Var(&opts.LatencyTolerance, "latency-tolerance", 0.2, "allowed mean latency increase as a fraction")
+	fs.Float64Var(&opts.TokenTolerance, "token-tolerance", 0.2, "allowed mean token increase as a fraction")
+	fs.Float64Var(&opts.ScoreDrop, "score-drop", 0.1, "allowed drop in a mean judge score")
+	verbose := fs.Bool("v", false, "print both outputs for every changed example")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *baseline == "" && *dataset == "" {
+		return errors.New("regress: --baseline or --dataset is required")
+	}
+	if *experiment == "" {
+		*experiment = fmt.Sprintf("%s-regress-%s", *personaName, time.Now().UTC().Format("20060102-150405"))
+	}
+
+	personas, err := persona.Load(os.Getenv("PERSONAS_FILE"))
+	if err != nil {
+		return fmt.Errorf("loading personas: %w", err)
+	}
+	bot, err := personas.Get(*personaName)
+	if err != nil {
+		return err
+	}
+
+	tracer := otel.Tracer("go-bot-eval")
+	ctx, span := tracer.Start(ctx, "eval_regress", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("eval.baseline", *baseline),
+		attribute.String("eval.experiment", *experiment),
+		attribute.String("langsmith.metadata.persona", bot.Name),
+	))
+	defer span.End()
+
+	regressions, err := func() ([]string, error) {
+		api := runs.FromEnv()
+		var datasetID string
+		if *baseline != "" {
+			base, err := api.Project(ctx, *baseline)
+			if err != nil {
+				return nil, err
+			}
+			datasetID = base.ReferenceDatasetID
+		}
+		if *dataset != "" {
+			if datasetID, err = api.DatasetID(ctx, *dataset); err != nil {
+				return nil, err
+			}
+		}
+		if datasetID == "" {
+			return nil, fmt.Errorf("%s is not an experiment; pass --dataset", *baseline)
+		}
+		examples, err := api.Examples(ctx, datasetID)
+		if err != nil {
+			return nil, err
+		}
+		if len(examples) == 0 {
+			return nil, errors.New("dataset has no examples")
+		}
+		byExample := map[string]runs.Run{}
+		if *baseline != "" {
+			baseRuns, err := api.List(ctx, runs.Query{Project: *baseline, Limit: len(examples)})
+			if err != nil {
+				return nil, err
+			}
+			for _, run := range baseRuns {
+				byExample[run.ReferenceExampleID] = run
+			}
+		}
+
+		exp, err := api.CreateExperiment(ctx, *experiment, datasetID, map[string]any{
+			"baseline": *baseline,
+			"persona":  bot.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		span.SetAttributes(
+			attribute.String("eval.dataset_id", datasetID),
+			attribute.Int("eval.examples", len(examples)),
+		)
+		fmt.Printf("Running %d examples as experiment %s\n\n", len(examples), *experiment)
+
+		fb := feedback.FromEnv()
+		var regressions []string
+		var baseMetrics, curMetrics []metrics
+		var changed int
+		for _, ex := range examples {
+			prior, hasBaseline := byExample[ex.ID]
+			answer, cur, err := answerExample(ctx, client, tracer, api, fb, bot, exp.ID, ex)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", ex.ID, err)
+				if hasBaseline && prior.Error == "" {
+					regressions = append(regressions, fmt.Sprintf("example %s failed: %v", ex.ID, err))
+				}
+				continue
+			}
+			if !hasBaseline {
+				fmt.Printf("%s  new example, no baseline\n", ex.ID)
+				continue
+			}
+			old := baselineMetrics(prior)
+			baseMetrics = append(baseMetrics, old)
+			curMetrics = append(curMetrics, cur)
+
+			status := "same output"
+			if was := outputText(prior.Outputs); strings.TrimSpace(was) != strings.TrimSpace(answer) {
+				changed++
+				status = "output changed"
+				if *verbose {
+					status += fmt.Sprintf("\n  baseline: %s\n  current:  %s", was, answer)
+				}
+			}
+			fmt.Printf("%s  latency %s -> %s  tokens %.0f -> %.0f  %s\n", ex.ID,
+				old.Latency.Round(time.Millisecond), cur.Latency.Round(time.Millisecond), old.Tokens, cur.Tokens, status)
+		}
+
+		before, after := mean(baseMetrics), mean(curMetrics)
+		regressions = append(regressions, compareMetrics(before, after, opts)...)
+		span.SetAttributes(
+			attribute.Int("eval.compared", len(curMetrics)),
+			attribute.Int("eval.changed_outputs", changed),
+			attribute.Int("eval.regressions", len(regressions)),
+		)
+
+		fmt.Printf("\nCompared %d examples, %d outputs changed\n", len(curMetrics), changed)
+		fmt.Printf("  latency  %s -> %s\n", before.Latency.Round(time.Millisecond), after.Latency.Round(time.Millisecond))
+		fmt.Printf("  tokens   %.0f -> %.0f\n", before.Tokens, after.Tokens)
+		for _, key := range judgeKeys {
+			if b, ok := before.Scores[key]; ok {
+				fmt.Printf("  %s  %.2f -> %.2f\n", key, b, after.Scores[key])
+			}
+		}
+		return regressions, nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if len(regressions) > 0 {
+		fmt.Println("\nRegressions:")
+		for _, r := range regressions {
+			fmt.Println("  " + r)
+		}
+		err := fmt.Errorf("%d regressions against %s", len(regressions), *baseline)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	fmt.Println("\nNo regressions")
+	return nil
+}
+
+// answerExample answers ex with bot's system prompt, records the answer as a
+// run in the experiment and judges it.
+func answerExample(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, api *runs.Client, fb *feedback.Client, bot *persona.Persona, experimentID string, ex runs.Example) (string, metrics, error) {
+	ctx, span := tracer.Start(ctx, "regress_example", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("eval.example_id", ex.ID),
+	))
+	defer span.End()
+
+	answer, m, err := func() (string, metrics, error) {
+		messages, err := exampleMessages(ex.Inputs)
+		if err != nil {
+			return "", metrics{}, err
+		}
+
+		// Tools run inside the bots, so examples are answered without them
+		start := time.Now()
+		resp, genErr := chat.Generate(ctx, client, anthropic.MessageNewParams{
+			Model:     anthropic.Model("claude-sonnet-4-20250514"),
+			MaxTokens: 1024,
+			System: []anthropic.TextBlockParam{
+				{Text: bot.SystemPrompt("en")},
+			},
+			Messages: messages,
+		}, nil)
+		// Monotonic, so a clock step mid-call can't make the run end first
+		end := start.Add(time.Since(start))
+
+		// Examples have no session or turn, so only {persona} means much here
+		_, runName := bot.TurnNames(persona.NameVars{SessionID: experimentID})
+		run := runs.NewRun{
+			ID:                 uuid.New().String(),
+			Name:               runName,
+			RunType:            "chain",
+			ProjectID:          experimentID,
+			ReferenceExampleID: ex.ID,
+			Start:              start,
+			End:                end,
+			Inputs:             ex.Inputs,
+			Outputs:            map[string]any{"output": resp.Text},
+			Metadata: map[string]any{
+				"persona":      bot.Name,
+				"total_tokens": resp.InputTokens + resp.OutputTokens,
+			},
+		}
+		if genErr != nil {
+			run.Error = genErr.Error()
+		}
+		if err := api.CreateRun(ctx, run); err != nil {
+			return "", metrics{}, err
+		}
+		if genErr != nil {
+			return "", metrics{}, genErr
+		}
+
+		verdicts, err := judge(ctx, client, tracer, runs.Run{ID: run.ID, Name: run.Name, Inputs: run.Inputs, Outputs: run.Outputs})
+		if err != nil {
+			return "", metrics{}, fmt.Errorf("judging: %w", err)
+		}
+		m := metrics{
+			Latency: end.Sub(start),
+			Tokens:  float64(resp.InputTokens + resp.OutputTokens),
+			Scores:  map[string]float64{},
+		}
+		for _, key := range judgeKeys {
+			v := verdicts[key]
+			m.Scores[key] = v.Score
+			if err := fb.PostRun(ctx, run.ID, run.ID, feedback.Score{Key: key, Score: v.Score, Comment: v.Reason}); err != nil {
+				return "", metrics{}, err
+			}
+		}
+		return resp.Text, m, nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", metrics{}, err
+	}
+	span.SetAttributes(
+		attribute.Int64("eval.latency_ms", m.Latency.Milliseconds()),
+		attribute.Float64("eval.tokens", m.Tokens),
+	)
+	return answer, m, nil
+}
+
+// exampleMessages turns example inputs into a conversation. Inputs are either
+// {"messages": [{"role": ..., "content": ...}]} or a single string under
+// input, question, prompt or message.
+func exampleMessages(inputs map[string]any) ([]anthropic.MessageParam, error) {
+	if raw, ok := inputs["messages"]; ok {
+		data, _ := json.Marshal(raw)
+		var turns []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(data, &turns); err != nil {
+			return nil, fmt.Errorf("example messages: %w", err)
+		}
+		var messages []anthropic.MessageParam
+		for _, t := range turns {
+			switch t.Role {
+			case "user", "human":
+				messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(t.Content)))
+			case "assistant", "ai":
+				messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(t.Content)))
+			}
+		}
+		if len(messages) == 0 {
+			return nil, errors.New("example has no user or assistant messages")
+		}
+		return messages, nil
+	}
+	for _, key := range []string{"input", "question", "prompt", "message"} {
+		if text, ok := inputs[key].(string); ok && text != "" {
+			return []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(text))}, nil
+		}
+	}
+	return nil, errors.New("example inputs have no messages or input text")
+}
+
+// outputText is the answer stored in a run's outputs, or the outputs as
+// JSON when there is no single text field.
+func outputText(outputs map[string]any) string {
+	for _, key := range []string{"output", "completion", "text"} {
+		if text, ok := outputs[key].(string); ok {
+			return text
+		}
+	}
+	data, _ := json.Marshal(outputs)
+	return string(data)
+}
+
+// baselineMetrics reads the metrics of a stored run.
+func baselineMetrics(run runs.Run) metrics {
+	m := metrics{Latency: run.Latency(), Tokens: float64(run.TotalTokens), Scores: map[string]float64{}}
+	// Runs recorded by regress carry their token count in metadata
+	if tokens, ok := run.Extra.Metadata["total_tokens"].(float64); ok && m.Tokens == 0 {
+		m.Tokens = tokens
+	}
+	for key, stat := range run.FeedbackStats {
+		m.Scores[key] = stat.Avg
+	}
+	return m
+}
+
+// mean averages ms; a score is averaged over the examples that have it.
+func mean(ms []metrics) metrics {
+	out := metrics{Scores: map[string]float64{}}
+	if len(ms) == 0 {
+		return out
+	}
+	counts := map[string]int{}
+	for _, m := range ms {
+		out.Latency += m.Latency
+		out.Tokens += m.Tokens
+		for key, score := range m.Scores {
+			out.Scores[key] += score
+			counts[key]++
+		}
+	}
+	out.Latency /= time.Duration(len(ms))
+	out.Tokens /= float64(len(ms))
+	for key := range out.Scores {
+		out.Scores[key] /= float64(counts[key])
+	}
+	return out
+}
+
+// compareMetrics describes every way after is worse than before beyond opts.
+func compareMetrics(before, after metrics, opts regressOptions) []string {
+	var regressions []string
+	if before.Latency > 0 && float64(after.Latency) > float64(before.Latency)*(1+opts.LatencyTolerance) {
+		regressions = append(regressions, fmt.Sprintf("mean latency rose from %s to %s", before.Latency.Round(time.Millisecond), after.Latency.Round(time.Millisecond)))
+	}
+	if before.Tokens > 0 && after.Tokens > before.Tokens*(1+opts.TokenTolerance) {
+		regressions = append(regressions, fmt.Sprintf("mean tokens rose from %.0f to %.0f", before.Tokens, after.Tokens))
+	}
+	for _, key := range judgeKeys {
+		b, ok := before.Scores[key]
+		if !ok {
+			continue
+		}
+		if a := after.Scores[key]; b-a > opts.ScoreDrop {
+			regressions = append(regressions, fmt.Sprintf("mean %s fell from %.2f to %.2f", key, b, a))
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,111 @@
+// Package guardrail screens user input before it is sent to the model.
+package guardrail
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+//go:embed default_policy.json
+var defaultPolicy []byte
+
+// Mode controls what happens when input matches a rule.
+type Mode string
+
+const (
+	// ModeBlock answers with the policy response and skips the model call.
+	ModeBlock Mode = "block"
+	// ModeFlag lets the turn through but records the match on the span.
+	ModeFlag Mode = "flag"
+	// ModeOff disables the policy.
+	ModeOff Mode = "off"
+)
+
+// Outcome is the guardrail decision recorded on the turn span.
+type Outcome string
+
+const (
+	OutcomeAllowed Outcome = "allowed"
+	OutcomeFlagged Outcome = "flagged"
+	OutcomeBlocked Outcome = "blocked"
+)
+
+// Rule is a named group of case-insensitive regular expressions.
+type Rule struct {
+	Name     string   `json:"name"`
+	Patterns []string `json:"patterns"`
+
+	compiled []*regexp.Regexp
+}
+
+// Policy is the configurable input policy.
+type Policy struct {
+	Mode     Mode   `json:"mode"`
+	Response string `json:"response"`
+	Rules    []Rule `json:"rules"`
+}
+
+// Decision is the result of checking one message.
+type Decision struct {
+	Outcome Outcome
+	Rule    string
+}
+
+// Load reads a policy from path, or the built-in default policy when path is empty.
+func Load(path string) (*Policy, error) {
+	data := defaultPolicy
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading input policy: %w", err)
+		}
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing input policy: %w", err)
+	}
+
+	switch p.Mode {
+	case "":
+		p.Mode = ModeBlock
+	case ModeBlock, ModeFlag, ModeOff:
+	default:
+		return nil, fmt.Errorf("input policy: unknown mode %q", p.Mode)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		for _, pattern := range rule.Patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("input policy rule %q: %w", rule.Name, err)
+			}
+			rule.compiled = append(rule.compiled, re)
+		}
+	}
+	return &p, nil
+}
+
+// Check returns the decision for input. The first matching rule wins.
+func (p *Policy) Check(input string) Decision {
+	if p.Mode == ModeOff {
+		return Decision{Outcome: OutcomeAllowed}
+	}
+	for _, rule := range p.Rules {
+		for _, re := range rule.compiled {
+			if !re.MatchString(input) {
+				continue
+			}
+			if p.Mode == ModeFlag {
+				return Decision{Outcome: OutcomeFlagged, Rule: rule.Name}
+			}
+			return Decision{Outcome: OutcomeBlocked, Rule: rule.Name}
+		}
+	}
+	return Decision{Outcome: OutcomeAllowed}
+}
@@ -0,0 +1,61 @@
+package historytrim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/chat"
+)
+
+// compactedReply answers the summary, so the compacted history still
+// alternates and a new user turn can follow it.
+const compactedReply = "Understood. I'll continue from this summary."
+
+// Compaction describes what Compact did, with tokens estimated by
+// chat.HistoryTokens.
+type Compaction struct {
+	TokensBefore, TokensAfter     int
+	MessagesBefore, MessagesAfter int
+}
+
+// Compact collapses the whole of messages into a summary written by
+// summarize (see ModelSummarizer), for compacting a conversation on demand
+// rather than when it is over a budget. The result is the summary as a user
+// message with a short assistant reply. It is traced as a history_compact
+// span carrying attrs. On error messages come back unchanged.
+func Compact(ctx context.Context, tracer trace.Tracer, summarize func(context.Context, []anthropic.MessageParam) (string, error), messages []anthropic.MessageParam, attrs ...attribute.KeyValue) ([]anthropic.MessageParam, Compaction, error) {
+	c := Compaction{TokensBefore: chat.HistoryTokens(messages), MessagesBefore: len(messages)}
+	if tracer == nil {
+		tracer = otel.Tracer("go-tracing-demo/historytrim")
+	}
+	ctx, span := tracer.Start(ctx, "history_compact", trace.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.Int("historytrim.tokens_before", c.TokensBefore),
+		attribute.Int("historytrim.messages_before", c.MessagesBefore),
+	}, attrs...)...))
+	defer span.End()
+
+	summary, err := summarize(ctx, messages)
+	if err != nil {
+		err = fmt.Errorf("summarizing history: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return messages, c, err
+	}
+	compacted := []anthropic.MessageParam{
+		anthropic.NewUserMessage(anthropic.NewTextBlock("Summary of the earlier conversation:\n" + summary)),
+		anthropic.NewAssistantMessage(anthropic.NewTextBlock(compactedReply)),
+	}
+	c.TokensAfter, c.MessagesAfter = chat.HistoryTokens(compacted), len(compacted)
+	span.SetAttributes(
+		attribute.Int("historytrim.tokens_after", c.TokensAfter),
+		attribute.Int("historytrim.messages_after", c.MessagesAfter),
+	)
+	return compacted, c, nil
+}
@@ -0,0 +1,100 @@
+package historytrim
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/chat"
+	"go-tracing-demo/tools"
+)
+
+// Context guard modes.
+const (
+	GuardOff  = "off"
+	GuardWarn = "warn"
+	GuardTrim = "trim"
+)
+
+// Context guard decisions, recorded as context.decision.
+const (
+	decisionOK      = "ok"
+	decisionWarn    = "warn"
+	decisionTrimmed = "trimmed"
+	decisionOver    = "over"
+)
+
+// guardShare is the share of the context window past which a request is
+// too close to the limit.
+const guardShare = 0.9
+
+// Guard checks requests against the model's context window before they are
+// sent, so a long conversation is warned about, or trimmed, before the API
+// rejects it with a 400.
+type Guard struct {
+	Mode string
+	// Window is the model's context window in tokens.
+	Window int
+}
+
+// GuardFromEnv returns a Guard for model in CONTEXT_GUARD mode: warn
+// (the default), trim or off, which returns nil.
+func GuardFromEnv(model string) (*Guard, error) {
+	mode := os.Getenv("CONTEXT_GUARD")
+	switch mode {
+	case "":
+		mode = GuardWarn
+	case GuardWarn, GuardTrim:
+	case GuardOff:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("CONTEXT_GUARD must be warn, trim or off, got %q", mode)
+	}
+	return &Guard{Mode: mode, Window: chat.ContextWindow(model)}, nil
+}
+
+// Check estimates the tokens a request with system, messages and up to
+// maxTokens of output would use. Within 10% of the window it returns a
+// warning for the user, and in trim mode it drops the oldest turns until
+// the request is clear again. The estimate and decision are recorded on
+// span as context.* attributes. A nil Guard returns messages unchanged.
+func (g *Guard) Check(ctx context.Context, span trace.Span, system string, messages []anthropic.MessageParam, maxTokens int) ([]anthropic.MessageParam, string, error) {
+	if g == nil {
+		return messages, "", nil
+	}
+	limit := int(float64(g.Window) * guardShare)
+	fixed := tools.EstimateTokens(system) + maxTokens
+	tokens := fixed + chat.HistoryTokens(messages)
+	span.SetAttributes(
+		attribute.Int("context.tokens_estimated", tokens),
+		attribute.Int("context.window", g.Window),
+	)
+	if tokens < limit {
+		span.SetAttributes(attribute.String("context.decision", decisionOK))
+		return messages, "", nil
+	}
+
+	share := float64(tokens) / float64(g.Window) * 100
+	if g.Mode != GuardTrim {
+		span.SetAttributes(attribute.String("context.decision", decisionWarn))
+		return messages, fmt.Sprintf("this conversation uses about %.0f%% of the model's context window; start a new session soon", share), nil
+	}
+
+	trimmer := &Trimmer{Strategy: DropOldest{}, MaxTokens: max(limit-fixed, 0)}
+	trimmed, err := trimmer.Trim(ctx, messages)
+	if err != nil {
+		return messages, "", err
+	}
+	after := fixed + chat.HistoryTokens(trimmed)
+	span.SetAttributes(attribute.Int("context.tokens_after_trim", after))
+	if after >= limit {
+		span.SetAttributes(attribute.String("context.decision", decisionOver))
+		return trimmed, fmt.Sprintf("this conversation is too long for the model's context window even after dropping older turns (about %.0f%%)", share), nil
+	}
+	span.SetAttributes(attribute.String("context.decision", decisionTrimmed))
+	return trimmed, fmt.Sprintf("this conversation reached about %.0f%% of the model's context window, so the oldest turns were left out of this request", share), nil
+}
@@ -0,0 +1,307 @@
+// Package historytrim counts the tokens of a conversation history and trims
+// it to a budget before it is sent to the model. The system prompt is a
+// separate request parameter, so it is never trimmed. Histories are only cut
+// where a user turn starts, so tool_use blocks stay with their results.
+package historytrim
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/chat"
+)
+
+// Counter counts the tokens messages add to a request.
+type Counter func(ctx context.Context, messages []anthropic.MessageParam) (int, error)
+
+// Estimate is a Counter that estimates tokens locally with
+// chat.HistoryTokens, without calling the API.
+func Estimate(_ context.Context, messages []anthropic.MessageParam) (int, error) {
+	return chat.HistoryTokens(messages), nil
+}
+
+// CountWithAPI is a Counter that asks the count_tokens endpoint for model.
+// Counts include system, so a budget should leave room for it.
+func CountWithAPI(client *anthropic.Client, model anthropic.Model, system []anthropic.TextBlockParam) Counter {
+	return func(ctx context.Context, messages []anthropic.MessageParam) (int, error) {
+		params := anthropic.MessageCountTokensParams{Model: model, Messages: messages}
+		if len(system) > 0 {
+			params.System.OfTextBlockArray = system
+		}
+		res, err := client.Messages.CountTokens(ctx, params)
+		if err != nil {
+			return 0, err
+		}
+		return int(res.InputTokens), nil
+	}
+}
+
+// Strategy shortens a history that is over budget. fits reports whether a
+// candidate history is within the budget.
+type Strategy interface {
+	Name() string
+	Trim(ctx context.Context, messages []anthropic.MessageParam, fits func([]anthropic.MessageParam) (bool, error)) ([]anthropic.MessageParam, error)
+}
+
+// Trimmer trims histories over MaxTokens with Strategy.
+type Trimmer struct {
+	Strategy  Strategy
+	MaxTokens int
+	// Count defaults to Estimate.
+	Count Counter
+	// Tracer defaults to the global provider's.
+	Tracer trace.Tracer
+}
+
+// Trim returns messages unchanged if t is nil or they fit in MaxTokens.
+// Otherwise it returns what Strategy cuts them down to, traced as a
+// history_trim span. A history that can't be cut far enough comes back as
+// short as the strategy could make it and is marked
+// historytrim.over_budget.
+func (t *Trimmer) Trim(ctx context.Context, messages []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+	if t == nil {
+		return messages, nil
+	}
+	count := t.Count
+	if count == nil {
+		count = Estimate
+	}
+	before, err := count(ctx, messages)
+	if err != nil {
+		return messages, fmt.Errorf("counting history tokens: %w", err)
+	}
+	if t.MaxTokens <= 0 || before <= t.MaxTokens {
+		return messages, nil
+	}
+
+	tracer := t.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer("go-tracing-demo/historytrim")
+	}
+	ctx, span := tracer.Start(ctx, "history_trim", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("historytrim.strategy", t.Strategy.Name()),
+		attribute.Int("historytrim.max_tokens", t.MaxTokens),
+		attribute.Int("historytrim.tokens_before", before),
+		attribute.Int("historytrim.messages_before", len(messages)),
+	))
+	defer span.End()
+
+	fits := func(candidate []anthropic.MessageParam) (bool, error) {
+		n, err := count(ctx, candidate)
+		return n <= t.MaxTokens, err
+	}
+	trimmed, err := t.Strategy.Trim(ctx, messages, fits)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return messages, err
+	}
+	after, err := count(ctx, trimmed)
+	if err != nil {
+		return trimmed, err
+	}
+	span.SetAttributes(
+		attribute.Int("historytrim.tokens_after", after),
+		attribute.Int("historytrim.messages_after", len(trimmed)),
+		attribute.Bool("historytrim.over_budget", after > t.MaxTokens),
+	)
+	return trimmed, nil
+}
+
+// turnStarts are the indexes where a history can be cut: user messages
+// that aren't tool results.
+func turnStarts(messages []anthropic.MessageParam) []int {
+	var starts []int
+	for i, m := range messages {
+		if m.Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		toolResult := false
+		for _, b := range m.Content {
+			if b.OfToolResult != nil {
+				toolResult = true
+				break
+			}
+		}
+		if !toolResult {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// firstFittingCut returns the earliest cut among starts for which
+// build(cut) fits, searching in halves since later cuts are shorter. If
+// none fits it returns the last cut.
+func firstFittingCut(starts []int, build func(cut int) []anthropic.MessageParam, fits func([]anthropic.MessageParam) (bool, error)) (int, error) {
+	lo, hi := 0, len(starts)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		ok, err := fits(build(starts[mid]))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return starts[lo], nil
+}
+
+// DropOldest drops the oldest turns until the history fits, always keeping
+// the latest turn.
+type DropOldest struct{}
+
+func (DropOldest) Name() string { return "drop_oldest" }
+
+func (DropOldest) Trim(ctx context.Context, messages []anthropic.MessageParam, fits func([]anthropic.MessageParam) (bool, error)) ([]anthropic.MessageParam, error) {
+	starts := turnStarts(messages)
+	if len(starts) == 0 {
+		return messages, nil
+	}
+	cut, err := firstFittingCut(starts, func(cut int) []anthropic.MessageParam { return messages[cut:] }, fits)
+	if err != nil {
+		return messages, err
+	}
+	return messages[cut:], nil
+}
+
+// SlidingWindow keeps the first turn, which usually states what the
+// conversation is about, and as many of the latest turns as fit.
+type SlidingWindow struct{}
+
+func (SlidingWindow) Name() string { return "sliding_window" }
+
+func (SlidingWindow) Trim(ctx context.Context, messages []anthropic.MessageParam, fits func([]anthropic.MessageParam) (bool, error)) ([]anthropic.MessageParam, error) {
+	starts := turnStarts(messages)
+	if len(starts) < 3 {
+		return DropOldest{}.Trim(ctx, messages, fits)
+	}
+	pinned := messages[:starts[1]]
+	window := func(cut int) []anthropic.MessageParam {
+		return append(pinned[:len(pinned):len(pinned)], messages[cut:]...)
+	}
+	cut, err := firstFittingCut(starts[2:], window, fits)
+	if err != nil {
+		return messages, err
+	}
+	return window(cut), nil
+}
+
+// Summarize replaces the oldest turns with a summary of them, written by
+// Summarizer, at the start of the first turn kept.
+type Summarize struct {
+	Summarizer func(ctx context.Context, dropped []anthropic.MessageParam) (string, error)
+}
+
+func (Summarize) Name() string { return "summarize" }
+
+func (s Summarize) Trim(ctx context.Context, messages []anthropic.MessageParam, fits func([]anthropic.MessageParam) (bool, error)) ([]anthropic.MessageParam, error) {
+	starts := turnStarts(messages)
+	if len(starts) < 2 {
+		return messages, nil
+	}
+	// The cut is picked before the summary is written, so a placeholder of
+	// about its size stands in for it
+	const placeholder = "Summary of the earlier conversation, about this long: the user asked for access and gave the details discussed."
+	withSummary := func(cut int, summary string) []anthropic.MessageParam {
+		first := messages[cut]
+		content := append([]anthropic.ContentBlockParamUnion{
+			anthropic.NewTextBlock("Summary of the earlier conversation:\n" + summary),
+		}, first.Content...)
+		return append([]anthropic.MessageParam{{Role: first.Role, Content: content}}, messages[cut+1:]...)
+	}
+	cut, err := firstFittingCut(starts[1:], func(cut int) []anthropic.MessageParam { return withSummary(cut, placeholder) }, fits)
+	if err != nil {
+		return messages, err
+	}
+	summary, err := s.Summarizer(ctx, messages[:cut])
+	if err != nil {
+		return messages, fmt.Errorf("summarizing history: %w", err)
+	}
+	return withSummary(cut, summary), nil
+}
+
+// ModelSummarizer returns a Summarize.Summarizer that asks model for the
+// summary; use a small one such as chat.SummaryModel.
+func ModelSummarizer(client *anthropic.Client, model string) func(context.Context, []anthropic.MessageParam) (string, error) {
+	return func(ctx context.Context, dropped []anthropic.MessageParam) (string, error) {
+		var transcript strings.Builder
+		for _, m := range dropped {
+			for _, b := range m.Content {
+				if b.OfText != nil {
+					fmt.Fprintf(&transcript, "%s: %s\n\n", m.Role, b.OfText.Text)
+				}
+			}
+		}
+		resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+			Model:     anthropic.Model(model),
+			MaxTokens: 512,
+			System: []anthropic.TextBlockParam{
+				{Text: "Summarize this conversation for the assistant continuing it. Keep every name, resource, access level, duration, ticket ID and decision. Be brief."},
+			},
+			Messages: []anthropic.MessageParam{
+				anthropic.NewUserMessage(anthropic.NewTextBlock(transcript.String())),
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		var parts []string
+		for _, block := range resp.Content {
+			if block.Type == "text" {
+				parts = append(parts, block.Text)
+			}
+		}
+		return strings.Join(parts, "\n"), nil
+	}
+}
+
+// FromEnv returns a Trimmer for HISTORY_MAX_TOKENS, the estimated token
+// budget for the history sent with each request, trimming with
+// HISTORY_TRIM_STRATEGY (default drop_oldest). Summaries are written by
+// summaryModel. Without a budget it returns nil.
+func FromEnv(client *anthropic.Client, summaryModel string) (*Trimmer, error) {
+	v := os.Getenv("HISTORY_MAX_TOKENS")
+	if v == "" {
+		return nil, nil
+	}
+	max, err := strconv.Atoi(v)
+	if err != nil || max < 0 {
+		return nil, fmt.Errorf("HISTORY_MAX_TOKENS must be a token count, got %q", v)
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	strategy, err := FromName(os.Getenv("HISTORY_TRIM_STRATEGY"), client, summaryModel)
+	if err != nil {
+		return nil, err
+	}
+	return &Trimmer{Strategy: strategy, MaxTokens: max}, nil
+}
+
+// FromName returns the strategy called name: drop_oldest, sliding_window or
+// summarize, which summarizes with client and model.
+func FromName(name string, client *anthropic.Client, model string) (Strategy, error) {
+	switch name {
+	case "", "drop_oldest":
+		return DropOldest{}, nil
+	case "sliding_window":
+		return SlidingWindow{}, nil
+	case "summarize":
+		return Summarize{Summarizer: ModelSummarizer(client, model)}, nil
+	}
+	return nil, fmt.Errorf("unknown history trim strategy %q (want drop_oldest, sliding_window or summarize)", name)
+}
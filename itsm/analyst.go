@@ -0,0 +1,324 @@
+package itsm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-tracing-demo/runs"
+)
+
+// analystPromptChars is how much of a session's first message its summary
+// line shows.
+const analystPromptChars = 60
+
+// analystSession is one conversation, put back together from the root runs
+// of its turns.
+type analystSession struct {
+	ID string
+	// Turns are oldest first.
+	Turns     []runs.Run
+	Tokens    int64
+	Errors    int
+	Escalated bool
+}
+
+func (s analystSession) last() runs.Run { return s.Turns[len(s.Turns)-1] }
+
+// analystCommand implements "analyst": a read-only browser of the sessions
+// recently traced to a LangSmith project, for support engineers without
+// the LangSmith UI. It lists one summary line per session after aggregate
+// stats, then opens the sessions picked by number. It only reads from
+// LangSmith and records no spans of its own.
+func analystCommand(args []string) error {
+	fs := flag.NewFlagSet("analyst", flag.ContinueOnError)
+	project := fs.String("project", os.Getenv("LANGSMITH_PROJECT"), "LangSmith project to read (default LANGSMITH_PROJECT, then go-bot-itsm)")
+	since := fs.Duration("since", 24*time.Hour, "how far back to look")
+	limit := fs.Int("limit", 200, "maximum number of turns to read")
+	name := fs.String("name", "", "only read root runs with this name (e.g. itsm_turn)")
+	session := fs.String("session", "", "print this session's conversation and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: analyst [--project name] [--since 24h] [--limit n] [--name run] [--session id]")
+	}
+	if *project == "" {
+		*project = "go-bot-itsm"
+	}
+	api := runs.FromEnv()
+	if api.APIKey == "" {
+		return errors.New("LANGSMITH_API_KEY is required")
+	}
+	q := runs.Query{Project: *project, Name: *name, Since: time.Now().Add(-*since), Limit: *limit}
+	if *session != "" {
+		q.Filter = metadataFilter("session_id", *session)
+	}
+	list, err := api.List(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	sessions := groupSessions(list)
+
+	if *session != "" {
+		if len(sessions) == 0 {
+			return fmt.Errorf("no turns of session %s in %s in the last %s", *session, *project, *since)
+		}
+		printSession(os.Stdout, sessions[0])
+		return nil
+	}
+	fmt.Printf("Project %s, last %s\n\n", *project, *since)
+	if len(sessions) == 0 {
+		fmt.Println("No turns traced in this period.")
+		return nil
+	}
+	printAnalystStats(os.Stdout, list, sessions)
+	if len(list) == *limit {
+		fmt.Printf("Only the newest %d turns were read; raise --limit to see more.\n", *limit)
+	}
+	fmt.Println()
+	printSessionList(os.Stdout, sessions)
+	return browseSessions(os.Stdin, os.Stdout, sessions)
+}
+
+// groupSessions groups turn runs by their session_id metadata, newest
+// session first. Runs without a session are sessions of their own.
+func groupSessions(list []runs.Run) []analystSession {
+	byID := map[string]*analystSession{}
+	var order []string
+	for _, r := range list {
+		id, _ := r.Extra.Metadata["session_id"].(string)
+		if id == "" {
+			id = r.TraceID
+		}
+		s, ok := byID[id]
+		if !ok {
+			s = &analystSession{ID: id}
+			byID[id] = s
+			order = append(order, id)
+		}
+		s.Turns = append(s.Turns, r)
+		s.Tokens += r.TotalTokens
+		if r.Error != "" {
+			s.Errors++
+		}
+		if escalated, _ := r.Extra.Metadata["escalated"].(bool); escalated {
+			s.Escalated = true
+		}
+	}
+	sessions := make([]analystSession, 0, len(order))
+	for _, id := range order {
+		s := byID[id]
+		sort.Slice(s.Turns, func(i, j int) bool { return s.Turns[i].StartTime < s.Turns[j].StartTime })
+		sessions = append(sessions, *s)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].last().StartTime > sessions[j].last().StartTime })
+	return sessions
+}
+
+// printAnalystStats prints the aggregate stats of the turns read.
+func printAnalystStats(w io.Writer, list []runs.Run, sessions []analystSession) {
+	var errs, escalated int
+	var tokens int64
+	var latencies []time.Duration
+	locales := map[string]int{}
+	feedback := map[string]struct {
+		n   int
+		sum float64
+	}{}
+	for _, r := range list {
+		if r.Error != "" {
+			errs++
+		}
+		tokens += r.TotalTokens
+		if d := r.Latency(); d > 0 {
+			latencies = append(latencies, d)
+		}
+		if locale, _ := r.Extra.Metadata["user.locale"].(string); locale != "" {
+			locales[locale]++
+		}
+		for key, stat := range r.FeedbackStats {
+			f := feedback[key]
+			f.n += stat.N
+			f.sum += stat.Avg * float64(stat.N)
+			feedback[key] = f
+		}
+	}
+	for _, s := range sessions {
+		if s.Escalated {
+			escalated++
+		}
+	}
+
+	fmt.Fprintf(w, "Sessions:   %d (%.1f turns each), %d escalated (%.1f%%)\n",
+		len(sessions), float64(len(list))/float64(len(sessions)), escalated, 100*float64(escalated)/float64(len(sessions)))
+	fmt.Fprintf(w, "Turns:      %d, %d errors (%.1f%%)\n", len(list), errs, 100*float64(errs)/float64(len(list)))
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(w, "Latency:    p50 %s  p95 %s  max %s\n",
+			percentile(latencies, 0.50).Round(time.Millisecond), percentile(latencies, 0.95).Round(time.Millisecond), latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "Tokens:     %d (%d per turn)\n", tokens, tokens/int64(len(list)))
+	if len(locales) > 0 {
+		fmt.Fprintf(w, "Locales:    %s\n", countList(locales))
+	}
+	keys := make([]string, 0, len(feedback))
+	for key := range feedback {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		label := ""
+		if i == 0 {
+			label = "Feedback:"
+		}
+		f := feedback[key]
+		fmt.Fprintf(w, "%-11s %s %.2f (n=%d)\n", label, key, f.sum/float64(f.n), f.n)
+	}
+}
+
+// countList formats counts as "en 12, de 3", largest first.
+func countList(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printSessionList prints one numbered summary line per session.
+func printSessionList(w io.Writer, sessions []analystSession) {
+	fmt.Fprintf(w, "%4s  %-20s  %-12s  %5s  %7s  %-9s  %s\n", "#", "LAST TURN", "SESSION", "TURNS", "TOKENS", "FLAGS", "FIRST MESSAGE")
+	for i, s := range sessions {
+		var flags []string
+		if s.Escalated {
+			flags = append(flags, "escalated")
+		}
+		if s.Errors > 0 {
+			flags = append(flags, "error")
+		}
+		id := s.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Fprintf(w, "%4d  %-20s  %-12s  %5d  %7d  %-9s  %s\n", i+1, shortTime(s.last().StartTime), id, len(s.Turns), s.Tokens,
+			strings.Join(flags, ","), oneLine(runInput(s.Turns[0]), analystPromptChars))
+	}
+}
+
+// browseSessions opens the sessions picked by their number until the
+// input ends or is empty.
+func browseSessions(r io.Reader, w io.Writer, sessions []analystSession) error {
+	in := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, "\nSession # to open (Enter to quit): ")
+		if !in.Scan() {
+			fmt.Fprintln(w)
+			return in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+		if line == "" || line == "q" {
+			return nil
+		}
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 1 || n > len(sessions) {
+			fmt.Fprintf(w, "Pick a number from 1 to %d.\n", len(sessions))
+			continue
+		}
+		fmt.Fprintln(w)
+		printSession(w, sessions[n-1])
+	}
+}
+
+// printSession prints a session's conversation turn by turn, with each
+// turn's run for finding it in LangSmith.
+func printSession(w io.Writer, s analystSession) {
+	fmt.Fprintf(w, "Session %s: %d turns, %d tokens\n", s.ID, len(s.Turns), s.Tokens)
+	for i, r := range s.Turns {
+		fmt.Fprintf(w, "\n--- Turn %d  %s  %s  %d tokens  run %s\n", i+1, shortTime(r.StartTime), r.Latency().Round(time.Millisecond), r.TotalTokens, r.ID)
+		fmt.Fprintf(w, "Requester: %s\n", runInput(r))
+		if r.Error != "" {
+			fmt.Fprintf(w, "Error:     %s\n", r.Error)
+		} else {
+			fmt.Fprintf(w, "Bot:       %s\n", runOutput(r))
+		}
+		var notes []string
+		if escalated, _ := r.Extra.Metadata["escalated"].(bool); escalated {
+			notes = append(notes, "escalated")
+		}
+		keys := make([]string, 0, len(r.FeedbackStats))
+		for key := range r.FeedbackStats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			notes = append(notes, fmt.Sprintf("%s=%.2f", key, r.FeedbackStats[key].Avg))
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(w, "           [%s]\n", strings.Join(notes, " "))
+		}
+	}
+}
+
+// runInput is the user's message of a turn run.
+func runInput(r runs.Run) string {
+	return runText(r.Inputs, "prompt", "input", "question", "text")
+}
+
+// runOutput is the bot's reply of a turn run.
+func runOutput(r runs.Run) string {
+	return runText(r.Outputs, "completion", "output", "text")
+}
+
+// runText is the first of keys in a run's inputs or outputs that holds
+// text, or all of them as JSON.
+func runText(values map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if text, ok := values[key].(string); ok {
+			return text
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// oneLine is s on one line, cut to n characters.
+func oneLine(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > n {
+		s = string([]rune(s)[:n]) + "…"
+	}
+	return s
+}
+
+// shortTime formats a run's time to the second.
+func shortTime(ts string) string {
+	t, err := runs.ParseTime(ts)
+	if err != nil {
+		return ts
+	}
+	return t.UTC().Format(time.DateTime)
+}
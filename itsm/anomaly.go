@@ -0,0 +1,108 @@
+package itsm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// accessRank orders access levels for spotting escalation.
+var accessRank = map[string]int{"read": 1, "write": 2, "admin": 3}
+
+// anomaly is one signal that a request is unusual for its requester. Any
+// anomaly raises the ticket to high risk.
+type anomaly struct {
+	Signal string `json:"signal"`
+	Detail string `json:"detail"`
+}
+
+// requesterHistory returns the requester's earlier tickets, oldest first.
+func requesterHistory(store *TicketStore, requester, excludeID string) ([]AccessRequest, error) {
+	rows, err := store.db.Query(`SELECT data FROM tickets WHERE id != ? ORDER BY created_at`, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []AccessRequest
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		t, err := store.decodeTicket(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding ticket: %w", err)
+		}
+		if t.RequesterEmail == requester {
+			history = append(history, t)
+		}
+	}
+	return history, rows.Err()
+}
+
+// detectAnomalies compares t with the requester's history:
+//   - unusual_resource: a resource they have never requested before
+//   - privilege_escalation: a higher level than they ever had on the
+//     resource, or repeated admin requests within a week
+//   - off_hours: requested outside business hours in ITSM_BUSINESS_TZ
+//
+// A requester with no history has no baseline, so only off_hours applies.
+func detectAnomalies(t AccessRequest, history []AccessRequest) []anomaly {
+	var found []anomaly
+	resource := strings.TrimSuffix(t.Resource, "_prod")
+	created, _ := time.Parse(time.RFC3339, t.CreatedAt)
+
+	if len(history) > 0 && t.Resource != "unknown" {
+		seen := false
+		highest := 0
+		for _, h := range history {
+			if strings.TrimSuffix(h.Resource, "_prod") == resource {
+				seen = true
+				highest = max(highest, accessRank[h.AccessLevel])
+			}
+		}
+		if !seen {
+			found = append(found, anomaly{"unusual_resource", fmt.Sprintf("first request for %s after %d earlier requests", resource, len(history))})
+		} else if rank := accessRank[t.AccessLevel]; rank > highest && highest > 0 {
+			found = append(found, anomaly{"privilege_escalation", fmt.Sprintf("%s exceeds the highest level previously requested on %s", t.AccessLevel, resource)})
+		}
+	}
+
+	if t.AccessLevel == "admin" {
+		recent := 0
+		for _, h := range history {
+			hc, err := time.Parse(time.RFC3339, h.CreatedAt)
+			if err == nil && h.AccessLevel == "admin" && created.Sub(hc) < 7*24*time.Hour {
+				recent++
+			}
+		}
+		if recent > 0 {
+			found = append(found, anomaly{"privilege_escalation", fmt.Sprintf("%d other admin requests in the past 7 days", recent)})
+		}
+	}
+
+	if !created.IsZero() && offHours(created.In(businessLocation())) {
+		found = append(found, anomaly{"off_hours", "requested at " + created.In(businessLocation()).Format("Mon 15:04 MST")})
+	}
+	return found
+}
+
+// offHours reports whether t falls outside Monday-Friday 07:00-19:00.
+func offHours(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return true
+	}
+	return t.Hour() < 7 || t.Hour() >= 19
+}
+
+// businessLocation is the timezone of ITSM_BUSINESS_TZ, or local time.
+func businessLocation() *time.Location {
+	if name := os.Getenv("ITSM_BUSINESS_TZ"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
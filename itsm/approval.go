@@ -0,0 +1,389 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Decisions on an approval step.
+const (
+	decisionApproved = "approved"
+	decisionDenied   = "denied"
+)
+
+// errApprovalPolicy is returned for decisions the approval chain doesn't
+// allow the approver to make.
+var errApprovalPolicy = errors.New("not allowed by the approval policy")
+
+// approvalDecision is one approver's decision on a step of a ticket's
+// approval chain.
+type approvalDecision struct {
+	Step     string `json:"step"`
+	Decision string `json:"decision"`
+	Approver string `json:"approver"`
+	// OnBehalfOf is the step's assigned approver when Approver decided as
+	// their delegate under the Delegation rules.
+	OnBehalfOf string `json:"on_behalf_of,omitempty"`
+	Delegation string `json:"delegation,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	DecidedAt  string `json:"decided_at"`
+}
+
+// decisionRequest is an approver's decision on the next step of a ticket's
+// approval chain.
+type decisionRequest struct {
+	TicketID string
+	Approver string
+	Comment  string
+	Deny     bool
+	// SessionID is the conversation the decision is made in, if any.
+	SessionID string
+}
+
+// approvalChain is the steps that must approve t, in order, as listed in
+// its approvals_required, such as "manager + system_owner". A ticket that
+// lists none needs a single approver.
+func approvalChain(t AccessRequest) []string {
+	var steps []string
+	for _, step := range strings.Split(t.ApprovalsRequired, "+") {
+		if step = strings.TrimSpace(step); step != "" && step != "none" {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == 0 {
+		return []string{"approver"}
+	}
+	return steps
+}
+
+// approvalPolicy decides who may decide each step: the system_owner step
+// is the resource owner's, or their delegate's while a delegation rule is in
+// force, and an overdue step also whoever it was escalated to.
+type approvalPolicy struct {
+	owners      resourceOwners
+	delegations delegations
+	// escalateAfter is how long a step waits before it is escalated to the
+	// next of escalateTo; 0 never escalates.
+	escalateAfter time.Duration
+	escalateTo    []string
+}
+
+// loadApprovalPolicy reads the resource owners, delegation rules and
+// approval timeout.
+func loadApprovalPolicy() (approvalPolicy, error) {
+	owners, err := loadResourceOwners()
+	if err != nil {
+		return approvalPolicy{}, err
+	}
+	delegations, err := loadDelegations()
+	if err != nil {
+		return approvalPolicy{}, err
+	}
+	after, to, err := approvalTimeoutFromEnv()
+	if err != nil {
+		return approvalPolicy{}, err
+	}
+	return approvalPolicy{owners: owners, delegations: delegations, escalateAfter: after, escalateTo: to}, nil
+}
+
+// stepRoute is who a step of a ticket's chain is routed to.
+type stepRoute struct {
+	// Assignee is the step's own approver, empty for steps anyone but the
+	// requester may decide.
+	Assignee string
+	// Hops lead from the assignee through their delegations, then to whom
+	// the step was escalated; the last is who the step waits on.
+	Hops []delegationHop
+}
+
+// route is who decides step of t at at.
+func (p approvalPolicy) route(t AccessRequest, step string, at time.Time) stepRoute {
+	var r stepRoute
+	if step == "system_owner" {
+		if owner := p.owners.ownerOf(t.Resource); owner != unassignedOwner {
+			r = stepRoute{Assignee: owner, Hops: p.delegations.resolve(owner, at)}
+		}
+	}
+	for _, e := range t.Escalations {
+		if e.Step == step {
+			r.Hops = append(r.Hops, delegationHop{Approver: e.To, Rule: escalationRule})
+		}
+	}
+	return r
+}
+
+// waitingOn is who the step waits on, empty when anyone may decide it.
+func (r stepRoute) waitingOn() delegationHop {
+	if len(r.Hops) == 0 {
+		return delegationHop{}
+	}
+	return r.Hops[len(r.Hops)-1]
+}
+
+// checkDecision applies the approval-chain policy to approver deciding
+// step of t, routed by route: requesters can't decide their own tickets,
+// each step needs a different approver, and an assigned step is the
+// assignee's or a delegate's along the route. It returns the hop the
+// approver decides as.
+func checkDecision(t AccessRequest, route stepRoute, step, approver string) (delegationHop, error) {
+	if approver == "" {
+		return delegationHop{}, fmt.Errorf("%w: the approver is unknown", errApprovalPolicy)
+	}
+	if t.RequesterEmail != "" && strings.EqualFold(approver, t.RequesterEmail) {
+		return delegationHop{}, fmt.Errorf("%w: %s can't decide their own request", errApprovalPolicy, approver)
+	}
+	for _, d := range t.Approvals {
+		if strings.EqualFold(d.Approver, approver) {
+			return delegationHop{}, fmt.Errorf("%w: %s already approved the %s step, so %s needs someone else", errApprovalPolicy, approver, d.Step, step)
+		}
+	}
+	if route.Assignee == "" {
+		return delegationHop{Approver: approver}, nil
+	}
+	for _, h := range route.Hops {
+		if strings.EqualFold(h.Approver, approver) {
+			return h, nil
+		}
+	}
+	return delegationHop{}, fmt.Errorf("%w: the %s step on %s is for %s", errApprovalPolicy, step, t.Resource, describeRoute(route))
+}
+
+// describeRoute names who a step waits on, and on whose behalf.
+func describeRoute(r stepRoute) string {
+	to := r.waitingOn()
+	switch to.Rule {
+	case "":
+		return to.Approver
+	case escalationRule:
+		return to.Approver + ", after escalation"
+	}
+	return fmt.Sprintf("%s, standing in for %s under delegation %s", to.Approver, r.Assignee, to.Rule)
+}
+
+// pendingStep is the next step of t's approval chain still to decide, and
+// how many follow it.
+func pendingStep(t AccessRequest) (string, int) {
+	chain := approvalChain(t)
+	if len(t.Approvals) >= len(chain) {
+		return "", 0
+	}
+	return chain[len(t.Approvals)], len(chain) - len(t.Approvals) - 1
+}
+
+// decideTicket records req on the next step of its ticket's approval chain
+// in a ticket_decision span linked to the turn that created the ticket.
+// Approving the last step approves the ticket; denying any step denies it.
+// Drafts, failed and escalated tickets can be decided, and a failed
+// ticket's chain starts over. The step's assignee may be stood in for by
+// their delegate, or whoever the step was escalated to, which the decision
+// and span record. Each decision is also
+// written to the approval_decisions audit log. attrs are added to the span.
+func decideTicket(ctx context.Context, tracer trace.Tracer, store *TicketStore, policy approvalPolicy, req decisionRequest, attrs ...attribute.KeyValue) (AccessRequest, error) {
+	decision := decisionApproved
+	if req.Deny {
+		decision = decisionDenied
+	}
+	ctx, span := tracer.Start(ctx, "ticket_decision", trace.WithAttributes(
+		attribute.String("langsmith.metadata.session_id", req.SessionID),
+		attribute.String("itsm.ticket.id", req.TicketID),
+		attribute.String("itsm.approval.decision", decision),
+		attribute.String("itsm.approval.approver", req.Approver),
+	), trace.WithAttributes(attrs...))
+	defer span.End()
+	fail := func(t AccessRequest, err error) (AccessRequest, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return t, err
+	}
+
+	if t, ok := store.get(req.TicketID); ok {
+		linkToOrigin(span, t)
+		if origin := store.originSession(t.ID); origin != "" {
+			span.SetAttributes(attribute.String("itsm.ticket.session_id", origin))
+		}
+	}
+
+	ctx = withChange(ctx, change{Actor: req.Approver, SessionID: req.SessionID, TurnIndex: -1})
+	var step string
+	var route stepRoute
+	var hop delegationHop
+	ticket, err := store.updateTx(ctx, req.TicketID, func(tx queryExecer, t *AccessRequest) error {
+		switch t.Status {
+		case statusFailed:
+			t.Approvals, t.Escalations = nil, nil
+		case statusDraft, statusEscalated:
+		default:
+			return fmt.Errorf("ticket %s is %s", t.ID, t.Status)
+		}
+		var remaining int
+		step, remaining = pendingStep(*t)
+		if step == "" {
+			return fmt.Errorf("ticket %s has no approval step left to decide", t.ID)
+		}
+		route = policy.route(*t, step, time.Now())
+		var err error
+		if hop, err = checkDecision(*t, route, step, req.Approver); err != nil {
+			return err
+		}
+		d := approvalDecision{Step: step, Decision: decision, Approver: req.Approver, Comment: req.Comment, DecidedAt: now()}
+		if hop.Rule != "" {
+			d.OnBehalfOf, d.Delegation = route.Assignee, hop.Rule
+		}
+		t.Approvals = append(t.Approvals, d)
+		switch {
+		case req.Deny:
+			t.Status = statusDenied
+		case remaining == 0:
+			t.Status = statusApproved
+			approvers := make([]string, len(t.Approvals))
+			for i, d := range t.Approvals {
+				approvers[i] = d.Approver
+			}
+			t.ApprovedBy = strings.Join(approvers, ", ")
+		}
+		return addDecision(ctx, tx, t.ID, t.Approvals[len(t.Approvals)-1], req.SessionID)
+	})
+	if step != "" {
+		span.SetAttributes(attribute.String("itsm.approval.step", step))
+	}
+	if route.Assignee != "" {
+		span.SetAttributes(attribute.String("itsm.approval.assignee", route.Assignee))
+	}
+	if hop.Rule != "" {
+		span.SetAttributes(
+			attribute.String("itsm.approval.on_behalf_of", route.Assignee),
+			attribute.String("itsm.approval.delegation_rule", hop.Rule),
+		)
+	}
+	if err != nil {
+		return fail(ticket, err)
+	}
+	next, remaining := pendingStep(ticket)
+	span.SetAttributes(
+		attribute.String("itsm.ticket.status", ticket.Status),
+		attribute.Int("itsm.approval.steps_remaining", remaining),
+	)
+	if next != "" && ticket.Status != statusDenied {
+		span.SetAttributes(attribute.String("itsm.approval.next_step", next))
+		if to := policy.route(ticket, next, time.Now()).waitingOn(); to.Approver != "" {
+			span.SetAttributes(attribute.String("itsm.approval.next_approver", to.Approver))
+		}
+	}
+	// A human may approve despite SoD conflicts, but the trace shows it
+	span.SetAttributes(attribute.Int("itsm.sod.conflict_count", len(ticket.SoDConflicts)))
+	return ticket, nil
+}
+
+// addDecision writes d on ticket id to the audit log, with the trace and
+// span it was made in.
+func addDecision(ctx context.Context, tx queryExecer, id string, d approvalDecision, sessionID string) error {
+	var traceID, spanID string
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	_, err := tx.Exec(`
+		INSERT INTO approval_decisions (ticket_id, step, decision, approver, on_behalf_of, delegation, comment, session_id, trace_id, span_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, d.Step, d.Decision, d.Approver, d.OnBehalfOf, d.Delegation, d.Comment, sessionID, traceID, spanID, d.DecidedAt)
+	return err
+}
+
+// originSession is the session that first wrote the ticket, empty for
+// tickets created outside a conversation.
+func (s *TicketStore) originSession(id string) string {
+	var sessionID string
+	s.db.QueryRow(`SELECT session_id FROM ticket_revisions
+		WHERE ticket_id = ? AND session_id != '' ORDER BY revision LIMIT 1`, id).Scan(&sessionID)
+	return sessionID
+}
+
+// describeDecision says what a decision left the ticket waiting on, and
+// who that is under policy.
+func describeDecision(t AccessRequest, policy approvalPolicy) string {
+	last := t.Approvals[len(t.Approvals)-1]
+	switch t.Status {
+	case statusDenied:
+		return fmt.Sprintf("Denied %s at the %s step", t.ID, last.Step)
+	case statusApproved:
+		return fmt.Sprintf("Approved %s for %s on %s (by %s)", t.ID, t.AccessLevel, t.Resource, t.ApprovedBy)
+	}
+	next, remaining := pendingStep(t)
+	waiting := next
+	if route := policy.route(t, next, time.Now()); route.waitingOn().Approver != "" {
+		waiting += " (" + describeRoute(route) + ")"
+	}
+	if remaining > 0 {
+		return fmt.Sprintf("Approved the %s step of %s; waiting on %s, then %d more", last.Step, t.ID, waiting, remaining)
+	}
+	return fmt.Sprintf("Approved the %s step of %s; waiting on %s", last.Step, t.ID, waiting)
+}
+
+// defaultApprover is who approves from the command line: ITSM_APPROVER, or
+// the login user.
+func defaultApprover() string {
+	if approver := os.Getenv("ITSM_APPROVER"); approver != "" {
+		return approver
+	}
+	return os.Getenv("USER")
+}
+
+// decideFromCommand implements "tickets approve" and "tickets deny".
+func decideFromCommand(args []string, deny bool) error {
+	name := "tickets approve"
+	if deny {
+		name = "tickets deny"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	as := fs.String("as", defaultApprover(), "approver recorded for the decision (default ITSM_APPROVER, then $USER)")
+	comment := fs.String("comment", "", "reason for the decision, kept in the audit log (required to deny)")
+	// The ticket ID may come before the flags
+	var id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if id == "" && fs.NArg() == 1 {
+		id = fs.Arg(0)
+	} else if id == "" || fs.NArg() != 0 {
+		return fmt.Errorf("usage: %s <ticket id> [--as approver] [--comment text]", name)
+	}
+	if deny && strings.TrimSpace(*comment) == "" {
+		return errors.New("a denial needs a --comment giving the reason")
+	}
+
+	policy, err := loadApprovalPolicy()
+	if err != nil {
+		return err
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	ticket, err := decideTicket(context.Background(), otel.Tracer("go-bot-itsm"), store, policy, decisionRequest{
+		TicketID: id, Approver: *as, Comment: *comment, Deny: deny,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(describeDecision(ticket, policy))
+	return nil
+}
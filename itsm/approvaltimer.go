@@ -0,0 +1,264 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// escalationRule is the rule recorded for decisions made by whom a step
+// was escalated to.
+const escalationRule = "escalation"
+
+// approvalEscalation records that a step waited too long for a decision
+// and was routed on.
+type approvalEscalation struct {
+	Step        string `json:"step"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	After       string `json:"after"`
+	EscalatedAt string `json:"escalated_at"`
+}
+
+// approvalTimeoutFromEnv reads how long a step may wait for a decision,
+// ITSM_APPROVAL_TIMEOUT, and who it is escalated to after each wait,
+// ITSM_APPROVAL_ESCALATION: approvers in order, the last usually a fallback
+// group. Without a timeout, steps wait indefinitely.
+func approvalTimeoutFromEnv() (time.Duration, []string, error) {
+	v := os.Getenv("ITSM_APPROVAL_TIMEOUT")
+	if v == "" {
+		return 0, nil, nil
+	}
+	after, err := time.ParseDuration(v)
+	if err != nil || after <= 0 {
+		return 0, nil, fmt.Errorf("ITSM_APPROVAL_TIMEOUT must be a positive duration, got %q", v)
+	}
+	var to []string
+	for _, approver := range strings.Split(os.Getenv("ITSM_APPROVAL_ESCALATION"), ",") {
+		if approver = strings.TrimSpace(approver); approver != "" {
+			to = append(to, approver)
+		}
+	}
+	if len(to) == 0 {
+		return 0, nil, errors.New("ITSM_APPROVAL_TIMEOUT needs ITSM_APPROVAL_ESCALATION to name who overdue approvals go to")
+	}
+	return after, to, nil
+}
+
+// awaitingApproval reports whether t is a complete draft with a step of
+// its approval chain still to decide.
+func awaitingApproval(t AccessRequest) bool {
+	step, _ := pendingStep(t)
+	return t.Status == statusDraft && step != "" && len(missingFields(t)) == 0
+}
+
+// escalationLevel is how often step of t has been escalated.
+func escalationLevel(t AccessRequest, step string) int {
+	level := 0
+	for _, e := range t.Escalations {
+		if e.Step == step {
+			level++
+		}
+	}
+	return level
+}
+
+// escalateOverdue escalates every ticket whose pending step has waited
+// longer than the policy allows since the ticket last changed. Each
+// escalation is traced as its own approval_escalation trace, linked to the
+// turn that created the ticket, and announced with a
+// ticket.approval_escalated event. It returns how many tickets were
+// escalated.
+func escalateOverdue(ctx context.Context, tracer trace.Tracer, store *TicketStore, policy approvalPolicy) (int, error) {
+	if policy.escalateAfter == 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-policy.escalateAfter).UTC().Format(time.RFC3339)
+	rows, err := store.db.Query(`SELECT id FROM tickets WHERE status = ? AND updated_at <= ? ORDER BY updated_at`, statusDraft, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	escalated := 0
+	for _, id := range ids {
+		t, ok := store.get(id)
+		if !ok || !awaitingApproval(t) {
+			continue
+		}
+		step, _ := pendingStep(t)
+		// Once the last escalation target has the step, it stays there
+		if escalationLevel(t, step) >= len(policy.escalateTo) {
+			continue
+		}
+		if err := escalateStep(ctx, tracer, store, policy, t, step); err != nil {
+			log.Printf("Escalating approval of %s: %v", id, err)
+			continue
+		}
+		escalated++
+	}
+	return escalated, nil
+}
+
+// escalateStep routes step of t to the next escalation target.
+func escalateStep(ctx context.Context, tracer trace.Tracer, store *TicketStore, policy approvalPolicy, t AccessRequest, step string) error {
+	level := escalationLevel(t, step)
+	from := policy.route(t, step, time.Now()).waitingOn().Approver
+	to := policy.escalateTo[level]
+	ctx, span := tracer.Start(ctx, "approval_escalation", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("langsmith.trace.name", "approval_escalation"),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("itsm.ticket.id", t.ID),
+		attribute.String("itsm.approval.step", step),
+		attribute.String("itsm.approval.escalated_from", from),
+		attribute.String("itsm.approval.escalated_to", to),
+		attribute.Int("itsm.approval.escalation_level", level+1),
+		attribute.String("itsm.approval.timeout", policy.escalateAfter.String()),
+	))
+	defer span.End()
+	linkToOrigin(span, t)
+	if origin := store.originSession(t.ID); origin != "" {
+		span.SetAttributes(attribute.String("itsm.ticket.session_id", origin))
+	}
+
+	ctx = withChange(ctx, change{Actor: "system", TurnIndex: -1})
+	_, err := store.updateTx(ctx, t.ID, func(tx queryExecer, t *AccessRequest) error {
+		// The ticket may have been decided or changed since it was read
+		if current, _ := pendingStep(*t); !awaitingApproval(*t) || current != step || escalationLevel(*t, step) != level {
+			return errEscalationStale
+		}
+		t.Escalations = append(t.Escalations, approvalEscalation{
+			Step: step, From: from, To: to, After: policy.escalateAfter.String(), EscalatedAt: now(),
+		})
+		sealed := store.seal(*t)
+		return enqueue(ctx, tx, outboxEvent{Type: "ticket.approval_escalated", Ticket: &sealed})
+	})
+	if errors.Is(err, errEscalationStale) {
+		span.SetAttributes(attribute.Bool("itsm.approval.escalation_skipped", true))
+		return nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	log.Printf("Escalated the %s step of %s from %s to %s after %s", step, t.ID, orAnyone(from), to, policy.escalateAfter)
+	return nil
+}
+
+var errEscalationStale = errors.New("ticket changed before it was escalated")
+
+func orAnyone(approver string) string {
+	if approver == "" {
+		return "any approver"
+	}
+	return approver
+}
+
+// approvalTimer escalates overdue approvals in the background.
+type approvalTimer struct {
+	store    *TicketStore
+	tracer   trace.Tracer
+	policy   approvalPolicy
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startApprovalTimer checks for overdue approvals while the bot runs. It
+// returns nil when approvals have no timeout.
+func startApprovalTimer(ctx context.Context, store *TicketStore, tracer trace.Tracer, policy approvalPolicy) *approvalTimer {
+	if policy.escalateAfter == 0 {
+		return nil
+	}
+	// Check often enough that a step is escalated soon after it is due
+	interval := min(max(policy.escalateAfter/10, time.Second), time.Minute)
+	a := &approvalTimer{
+		store:    store,
+		tracer:   tracer,
+		policy:   policy,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.loop(ctx)
+	return a
+}
+
+func (a *approvalTimer) loop(ctx context.Context) {
+	defer close(a.done)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := escalateOverdue(ctx, a.tracer, a.store, a.policy); err != nil {
+				log.Printf("Checking overdue approvals: %v", err)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Stop stops checking for overdue approvals.
+func (a *approvalTimer) Stop() {
+	if a == nil {
+		return
+	}
+	close(a.stop)
+	<-a.done
+}
+
+// escalateCommand implements "tickets escalate": one pass over the
+// overdue approvals, for running from cron when no bot is up.
+func escalateCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: tickets escalate")
+	}
+	policy, err := loadApprovalPolicy()
+	if err != nil {
+		return err
+	}
+	if policy.escalateAfter == 0 {
+		return errors.New("ITSM_APPROVAL_TIMEOUT is not set, so no approval is ever overdue")
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	n, err := escalateOverdue(context.Background(), otel.Tracer("go-bot-itsm"), store, policy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Escalated %d overdue approvals\n", n)
+	return nil
+}
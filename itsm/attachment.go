@@ -0,0 +1,275 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+)
+
+// transcriptAttachment is the name of the conversation transcript attached
+// to a submitted ticket.
+const transcriptAttachment = "transcript.md"
+
+// submitted reports whether t has left the conversation for approvers:
+// complete and justified, or handed to a human.
+func submitted(t AccessRequest) bool {
+	if t.Status == statusEscalated {
+		return true
+	}
+	return t.Status == statusDraft && len(missingFields(t)) == 0 && !t.NeedsJustification
+}
+
+// transcriptMarkdown renders the conversation that requested t for
+// approvers, after a summary of the ticket.
+func transcriptMarkdown(t AccessRequest, sessionID string, messages []conversationMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Conversation for %s\n\n", t.ID)
+	fmt.Fprintf(&b, "- **Requester:** %s\n", orUnknown(t.RequesterEmail))
+	fmt.Fprintf(&b, "- **Resource:** %s\n", t.Resource)
+	fmt.Fprintf(&b, "- **Access level:** %s\n", t.AccessLevel)
+	fmt.Fprintf(&b, "- **Duration:** %s\n", t.Duration)
+	fmt.Fprintf(&b, "- **Business justification:** %s\n", orUnknown(t.BusinessJustif))
+	for _, name := range sortedKeys(t.Fields) {
+		fmt.Fprintf(&b, "- **%s:** %s\n", name, t.Fields[name])
+	}
+	fmt.Fprintf(&b, "- **Session:** %s\n", sessionID)
+	if t.HandoffSummary != "" {
+		fmt.Fprintf(&b, "\n## Handoff summary\n\n%s\n", t.HandoffSummary)
+	}
+	b.WriteString("\n## Transcript\n")
+	for _, m := range messages {
+		speaker := "Requester"
+		if m.Role == "assistant" {
+			speaker = "Bot"
+		}
+		fmt.Fprintf(&b, "\n**%s:**\n\n%s\n", speaker, strings.TrimSpace(m.Text))
+	}
+	return b.String()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// claimAttachment stores a ticket attachment with the trace and span of
+// ctx, unless the ticket already has one of that name. It reports whether
+// it stored it, so an attachment is uploaded once.
+func (s *TicketStore) claimAttachment(ctx context.Context, ticketID, sessionID, name, content string) (bool, error) {
+	var traceID, spanID string
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	res, err := s.db.Exec(`
+		INSERT INTO ticket_attachments (ticket_id, name, content_type, content, session_id, trace_id, span_id, created_at)
+		VALUES (?, ?, 'text/markdown', ?, ?, ?, ?, ?)
+		ON CONFLICT (ticket_id, name) DO NOTHING`,
+		ticketID, name, content, sessionID, traceID, spanID, now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// attachment returns the named attachment of ticket id, if it has one.
+func (s *TicketStore) attachment(id, name string) (string, bool, error) {
+	var content string
+	err := s.db.QueryRow(`SELECT content FROM ticket_attachments WHERE ticket_id = ? AND name = ?`, id, name).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return content, err == nil, err
+}
+
+// externalRecord is tracker's record of ticket id; empty when it has none.
+func (s *TicketStore) externalRecord(id, tracker string) (string, error) {
+	var externalID string
+	err := s.db.QueryRow(`SELECT external_id FROM ticket_external_records WHERE ticket_id = ? AND tracker = ?`, id, tracker).Scan(&externalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return externalID, err
+}
+
+func (s *TicketStore) setExternalRecord(id, tracker, externalID string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ticket_external_records (ticket_id, tracker, external_id, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (ticket_id, tracker) DO UPDATE SET external_id = excluded.external_id, updated_at = excluded.updated_at`,
+		id, tracker, externalID, now())
+	return err
+}
+
+// attachTranscript attaches the conversation in session sessionID to
+// ticket id: once to the ticket record, then as a work note or comment on
+// the ticket's record in each tracker. Each tracker's upload is guarded by
+// its own idempotency key, so one that failed is retried on a later call
+// and one that succeeded is never repeated. The upload is a
+// transcript_attachment span, with a tracker_attachment span per tracker
+// and the trackers' API calls below those. A tracker that fails does not
+// stop the others.
+func attachTranscript(ctx context.Context, tracer trace.Tracer, store *TicketStore, trackers []connector.Tracker, tmpl *ticketTemplate, id, sessionID string) error {
+	ctx, span := tracer.Start(ctx, "transcript_attachment", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("itsm.ticket.id", id),
+		attribute.String("itsm.attachment.name", transcriptAttachment),
+	))
+	defer span.End()
+	fail := func(err error) error {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	t, ok := store.get(id)
+	if !ok {
+		return fail(fmt.Errorf("unknown ticket %q", id))
+	}
+	linkToOrigin(span, t)
+	messages, err := store.conversation(sessionID)
+	if err != nil {
+		return fail(err)
+	}
+	// Trackers are outside the store, so confidential values are masked
+	t = store.classes.mask(t)
+	markdown := confidentialValues.Replace(transcriptMarkdown(t, sessionID, messages))
+	claimed, err := store.claimAttachment(ctx, id, sessionID, transcriptAttachment, markdown)
+	if err != nil {
+		return fail(fmt.Errorf("storing the transcript: %w", err))
+	}
+	if !claimed {
+		// Trackers still to be sent it get the transcript as first stored
+		span.SetAttributes(attribute.Bool("itsm.attachment.already_attached", true))
+		if markdown, _, err = store.attachment(id, transcriptAttachment); err != nil {
+			return fail(fmt.Errorf("reading the transcript: %w", err))
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("itsm.attachment.bytes", len(markdown)),
+		attribute.Int("itsm.attachment.messages", len(messages)),
+	)
+
+	var names []string
+	var errs []error
+	for _, tracker := range trackers {
+		names = append(names, tracker.Name())
+		if err := attachToTracker(ctx, tracer, store, tracker, connector.Attachment{
+			TicketID: id,
+			Summary:  fmt.Sprintf("%s: %s access to %s for %s", id, t.AccessLevel, t.Resource, orUnknown(t.RequesterEmail)),
+			Fields:   tmpl.mapped(tracker.Name(), t),
+			Name:     transcriptAttachment,
+			Markdown: markdown,
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	span.SetAttributes(attribute.StringSlice("itsm.attachment.trackers", names))
+	if err := errors.Join(errs...); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// attachToTracker posts a to the ticket's record in tracker, creating the
+// record if there is none yet, under an idempotency key per ticket, tracker
+// and attachment.
+func attachToTracker(ctx context.Context, tracer trace.Tracer, store *TicketStore, tracker connector.Tracker, a connector.Attachment) error {
+	key := attachmentKey(tracker.Name(), a.TicketID, a.Name)
+	ctx, span := tracer.Start(ctx, "tracker_attachment", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "tool"),
+		attribute.String("itsm.tracker", tracker.Name()),
+		attribute.String("idempotency.key", key),
+	))
+	defer span.End()
+	fail := func(err error) error {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	// Dry runs change nothing, so they neither claim nor replay keys
+	if !connector.IsDryRun(ctx) {
+		decision, _, err := store.claim(key, "attach")
+		if err != nil {
+			return fail(fmt.Errorf("claiming idempotency key: %w", err))
+		}
+		span.SetAttributes(attribute.String("idempotency.decision", decision))
+		switch decision {
+		case idempotencyReplayed:
+			return nil
+		case idempotencyInDoubt:
+			return fail(fmt.Errorf("an earlier upload of %s to %s never finished and may have created a record; check %s before retrying",
+				a.TicketID, tracker.Name(), tracker.Name()))
+		}
+	}
+
+	externalID, err := store.externalRecord(a.TicketID, tracker.Name())
+	if err != nil {
+		store.release(key)
+		return fail(err)
+	}
+	a.ExternalID = externalID
+	created, err := tracker.Attach(ctx, a)
+	// Keep a record the tracker created even if attaching to it failed, so
+	// the retry adds to it rather than creating another
+	if created != "" && created != externalID {
+		if err := store.setExternalRecord(a.TicketID, tracker.Name(), created); err != nil {
+			// The key stays claimed: the record exists but the store
+			// doesn't know it
+			return fail(fmt.Errorf("storing %s record %s: %w", tracker.Name(), created, err))
+		}
+	}
+	if created != "" {
+		span.SetAttributes(attribute.String("itsm.tracker.external_id", created))
+	}
+	if connector.IsDryRun(ctx) {
+		if err != nil {
+			return fail(err)
+		}
+		return nil
+	}
+	if err != nil {
+		store.release(key)
+		return fail(err)
+	}
+	store.complete(key, []byte(created))
+	return nil
+}
+
+// attachmentKey derives the idempotency key for posting attachment name of
+// a ticket to a tracker.
+func attachmentKey(tracker, ticketID, name string) string {
+	return fmt.Sprintf("attach:%s:%s:%s", tracker, ticketID, name)
+}
+
+// transcriptCommand implements "tickets transcript": it prints the
+// transcript attached to a ticket.
+func transcriptCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tickets transcript <ticket id>")
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	markdown, ok, err := store.attachment(args[0], transcriptAttachment)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("ticket %q has no transcript attached", args[0])
+	}
+	fmt.Print(markdown)
+	return nil
+}
@@ -0,0 +1,77 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go-tracing-demo/connector"
+)
+
+// fakeTracker records the record each Attach posts to. It creates record
+// "R-1" when there is none and fails the calls in fail, by call number.
+type fakeTracker struct {
+	name  string
+	fail  map[int]bool
+	calls []string
+}
+
+func (f *fakeTracker) Name() string { return f.name }
+
+func (f *fakeTracker) Attach(ctx context.Context, a connector.Attachment) (string, error) {
+	f.calls = append(f.calls, a.ExternalID)
+	if f.fail[len(f.calls)] {
+		// The record was created but posting to it failed
+		return "R-1", errors.New(f.name + " is down")
+	}
+	return "R-1", nil
+}
+
+func TestAttachTranscriptPerTracker(t *testing.T) {
+	store := testStore(t)
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusDraft})
+	servicenow := &fakeTracker{name: "servicenow", fail: map[int]bool{1: true}}
+	jira := &fakeTracker{name: "jira"}
+	trackers := []connector.Tracker{servicenow, jira}
+	tracer := noop.NewTracerProvider().Tracer("")
+	attach := func() error {
+		return attachTranscript(context.Background(), tracer, store, trackers, nil, "AR-1", "session-1")
+	}
+
+	if err := attach(); err == nil {
+		t.Fatal("attachTranscript() = nil, want the servicenow failure")
+	}
+	// The failed tracker is retried on its existing record; the other one
+	// is not sent it again
+	for range 2 {
+		if err := attach(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := servicenow.calls; len(got) != 2 || got[0] != "" || got[1] != "R-1" {
+		t.Errorf("servicenow calls = %q, want a create and then a post to R-1", got)
+	}
+	if got := jira.calls; len(got) != 1 {
+		t.Errorf("jira calls = %q, want one", got)
+	}
+}
+
+func TestAttachTranscriptInDoubt(t *testing.T) {
+	store := testStore(t)
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusDraft})
+	// A claim without an outcome, as a crash during Attach leaves it
+	if _, _, err := store.claim(attachmentKey("servicenow", "AR-1", transcriptAttachment), "attach"); err != nil {
+		t.Fatal(err)
+	}
+	servicenow := &fakeTracker{name: "servicenow"}
+
+	err := attachTranscript(context.Background(), noop.NewTracerProvider().Tracer(""), store, []connector.Tracker{servicenow}, nil, "AR-1", "session-1")
+	if err == nil {
+		t.Fatal("attachTranscript() = nil, want an in-doubt error")
+	}
+	if len(servicenow.calls) != 0 {
+		t.Errorf("servicenow calls = %q, want none", servicenow.calls)
+	}
+}
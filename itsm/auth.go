@@ -0,0 +1,156 @@
+package itsm
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Server roles. Requesters run their own sessions and read their own
+// tickets, approvers read and approve anyone's tickets, and admins can do
+// everything, including opening sessions on someone else's behalf.
+const (
+	roleRequester = "requester"
+	roleApprover  = "approver"
+	roleAdmin     = "admin"
+)
+
+// apiKey authenticates one principal of a tenant.
+type apiKey struct {
+	Key string `json:"key"`
+	// Principal identifies the caller, usually by email; it becomes the
+	// requester of the sessions they open.
+	Principal string   `json:"principal"`
+	Roles     []string `json:"roles"`
+}
+
+func validateAPIKeys(tenant string, keys []apiKey, seen map[string]bool) error {
+	for _, k := range keys {
+		switch {
+		case k.Key == "":
+			return fmt.Errorf("tenant %s: every API key needs a key", tenant)
+		case seen[k.Key]:
+			return fmt.Errorf("tenant %s: API key for %s is already in use", tenant, k.Principal)
+		case k.Principal == "":
+			return fmt.Errorf("tenant %s: every API key needs a principal", tenant)
+		case len(k.Roles) == 0:
+			return fmt.Errorf("tenant %s: API key for %s has no roles", tenant, k.Principal)
+		}
+		for _, r := range k.Roles {
+			if r != roleRequester && r != roleApprover && r != roleAdmin {
+				return fmt.Errorf("tenant %s: unknown role %q for %s (want requester, approver or admin)", tenant, r, k.Principal)
+			}
+		}
+		seen[k.Key] = true
+	}
+	return nil
+}
+
+// loadAPIKeys reads ITSM_API_KEYS_FILE for the single-tenant server.
+func loadAPIKeys(path string) ([]apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		APIKeys []apiKey `json:"api_keys"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.APIKeys) == 0 {
+		return nil, fmt.Errorf("%s defines no API keys", path)
+	}
+	return file.APIKeys, validateAPIKeys("default", file.APIKeys, map[string]bool{})
+}
+
+// principal is an authenticated caller.
+type principal struct {
+	tenant *tenant
+	ID     string
+	Roles  []string
+}
+
+func (p *principal) has(role string) bool {
+	return slices.Contains(p.Roles, roleAdmin) || slices.Contains(p.Roles, role)
+}
+
+// attributes record the principal on the spans the server starts for it.
+func (p *principal) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("enduser.id", p.ID),
+		attribute.String("enduser.role", strings.Join(p.Roles, ",")),
+	}
+}
+
+var (
+	errUnauthenticated = errors.New("missing or unknown API key")
+	errForbidden       = errors.New("forbidden")
+)
+
+// authenticate finds the principal for the request's bearer token. A
+// tenant token authenticates as the tenant itself, with every role. When
+// no tenant has keys, as for a single-tenant server on a loopback address,
+// every request is an anonymous admin.
+func (s *server) authenticate(r *http.Request) (*principal, error) {
+	if !s.auth {
+		return &principal{tenant: s.tenants[0], ID: "anonymous", Roles: []string{roleAdmin}}, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errUnauthenticated
+	}
+	// Every key is compared, so the time taken says nothing about which matched
+	var found *principal
+	for _, t := range s.tenants {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			found = &principal{tenant: t, ID: "tenant:" + t.Name, Roles: []string{roleAdmin}}
+		}
+		for _, k := range t.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(k.Key), []byte(token)) == 1 {
+				found = &principal{tenant: t, ID: k.Principal, Roles: k.Roles}
+			}
+		}
+	}
+	if found == nil {
+		return nil, errUnauthenticated
+	}
+	return found, nil
+}
+
+// loopback reports whether addr only listens on this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize wraps h so it only runs for principals with role.
+func (s *server) authorize(role string, h func(http.ResponseWriter, *http.Request, *principal)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.authenticate(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if !p.has(role) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%w: %s needs the %s role", errForbidden, p.ID, role))
+			return
+		}
+		h(w, r, p)
+	}
+}
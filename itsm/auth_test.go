@@ -0,0 +1,142 @@
+package itsm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func authServer(auth bool) *server {
+	return &server{auth: auth, tenants: []*tenant{
+		{tenantConfig: tenantConfig{Name: "acme", Token: "acme-token", APIKeys: []apiKey{
+			{Key: "ada-key", Principal: "ada@example.com", Roles: []string{roleRequester}},
+			{Key: "grace-key", Principal: "grace@example.com", Roles: []string{roleApprover}},
+		}}},
+		{tenantConfig: tenantConfig{Name: "globex", APIKeys: []apiKey{
+			{Key: "hank-key", Principal: "hank@globex.example", Roles: []string{roleAdmin}},
+		}}},
+	}}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		auth       bool
+		header     string
+		wantID     string
+		wantTenant string
+		wantErr    error
+	}{
+		{name: "api key", auth: true, header: "Bearer ada-key", wantID: "ada@example.com", wantTenant: "acme"},
+		{name: "other tenant's key", auth: true, header: "Bearer hank-key", wantID: "hank@globex.example", wantTenant: "globex"},
+		{name: "tenant token", auth: true, header: "Bearer acme-token", wantID: "tenant:acme", wantTenant: "acme"},
+		{name: "unknown key", auth: true, header: "Bearer nope", wantErr: errUnauthenticated},
+		{name: "prefix of a key", auth: true, header: "Bearer ada", wantErr: errUnauthenticated},
+		{name: "no header", auth: true, wantErr: errUnauthenticated},
+		{name: "empty token", auth: true, header: "Bearer ", wantErr: errUnauthenticated},
+		{name: "not bearer", auth: true, header: "Basic ada-key", wantErr: errUnauthenticated},
+		{name: "keyless server", auth: false, wantID: "anonymous", wantTenant: "acme"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/tickets", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			p, err := authServer(tt.auth).authenticate(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("authenticate() = %v, %v, want %v", p, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.ID != tt.wantID || p.tenant.Name != tt.wantTenant {
+				t.Errorf("authenticate() = %s of %s, want %s of %s", p.ID, p.tenant.Name, tt.wantID, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		key    string
+		role   string
+		status int
+	}{
+		{name: "requester as requester", key: "ada-key", role: roleRequester, status: http.StatusOK},
+		{name: "requester as approver", key: "ada-key", role: roleApprover, status: http.StatusForbidden},
+		{name: "approver as approver", key: "grace-key", role: roleApprover, status: http.StatusOK},
+		{name: "approver as admin", key: "grace-key", role: roleAdmin, status: http.StatusForbidden},
+		{name: "admin as approver", key: "hank-key", role: roleApprover, status: http.StatusOK},
+		{name: "tenant token as admin", key: "acme-token", role: roleAdmin, status: http.StatusOK},
+		{name: "no key", role: roleRequester, status: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			h := authServer(true).authorize(tt.role, func(w http.ResponseWriter, r *http.Request, p *principal) {
+				called = true
+			})
+			r := httptest.NewRequest(http.MethodPost, "/v1/tickets/AR-1/approve", nil)
+			if tt.key != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Errorf("handler called = %v, want %v", called, !called)
+			}
+		})
+	}
+}
+
+func TestValidateAPIKeys(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		keys    []apiKey
+		wantErr string
+	}{
+		{name: "valid", keys: []apiKey{{Key: "k", Principal: "p", Roles: []string{roleAdmin}}}},
+		{name: "no key", keys: []apiKey{{Principal: "p", Roles: []string{roleAdmin}}}, wantErr: "needs a key"},
+		{name: "no principal", keys: []apiKey{{Key: "k", Roles: []string{roleAdmin}}}, wantErr: "needs a principal"},
+		{name: "no roles", keys: []apiKey{{Key: "k", Principal: "p"}}, wantErr: "has no roles"},
+		{name: "unknown role", keys: []apiKey{{Key: "k", Principal: "p", Roles: []string{"root"}}}, wantErr: "unknown role"},
+		{name: "duplicate", keys: []apiKey{
+			{Key: "k", Principal: "p", Roles: []string{roleAdmin}},
+			{Key: "k", Principal: "q", Roles: []string{roleAdmin}},
+		}, wantErr: "already in use"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAPIKeys("acme", tt.keys, map[string]bool{})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateAPIKeys() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		"127.3.2.1:80":   true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.5:8080":  false,
+		"[::]:8080":      false,
+		"example.com:80": false,
+		"8080":           false,
+	} {
+		if got := loopback(addr); got != want {
+			t.Errorf("loopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+	"go-tracing-demo/persona"
+)
+
+// turnSpanAllocBudget caps allocations for starting and ending one turn
+// span with its usual attributes. Raise it only with a reason.
+const turnSpanAllocBudget = 25
+
+const benchPrompt = "I need read access to snowflake prod for 7 days to debug the revenue dashboard, ticket INC-4412"
+
+func benchSession(tb testing.TB, tp trace.TracerProvider) *Session {
+	tb.Helper()
+	personas, err := persona.Load("")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	bot, err := personas.Get("itsm")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &Session{
+		Bot:      &Bot{bot: bot, tracer: tp.Tracer("bench"), tracing: true, locale: "en"},
+		threadID: "3f1c2a9e-5b7d-4c1e-9a2f-6d8e0b4c7a15",
+	}
+}
+
+func benchTicket() AccessRequest {
+	t := inferAccessRequestDraft(benchPrompt)
+	t.BusinessJustif = strings.Repeat("Debugging the revenue dashboard for finance. ", 4)
+	return t
+}
+
+// turnSpan is the telemetry one turn records, without the model call.
+func turnSpan(s *Session, ticket AccessRequest, resp chat.Completion) {
+	_, span := s.startTurnSpan(context.Background(), benchPrompt, nil, TurnOptions{}, nil)
+	s.recordCompletion(span, resp)
+	if s.tracing {
+		span.SetAttributes(
+			attribute.String("itsm.category", "access_request_demo"),
+			attribute.String("itsm.ticket.id", ticket.ID),
+			attribute.String("itsm.ticket.status", ticket.Status),
+		)
+	}
+	span.End()
+}
+
+func benchCompletion() chat.Completion {
+	return chat.Completion{
+		Text:          strings.Repeat("Here is your ticket draft. ", 40),
+		FinishReasons: []string{"end_turn"},
+		InputTokens:   1200,
+		OutputTokens:  300,
+	}
+}
+
+// BenchmarkTurnSpan measures creating and ending a turn span with its
+// attributes, exported to a no-op exporter.
+func BenchmarkTurnSpan(b *testing.B) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	s := benchSession(b, tp)
+	ticket := benchTicket()
+	resp := benchCompletion()
+	b.ReportAllocs()
+	for range b.N {
+		turnSpan(s, ticket, resp)
+	}
+}
+
+// BenchmarkCompletionExport measures serializing a turn span with a large
+// completion and the ticket JSON to OTLP and posting it to a local server.
+func BenchmarkCompletionExport(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(srv.URL+"/otel/v1/traces"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(ctx)
+	s := benchSession(b, tp)
+	ticket := benchTicket()
+
+	for _, size := range []int{1 << 10, 32 << 10, 256 << 10} {
+		completion := strings.Repeat("x", size)
+		b.Run(fmt.Sprintf("completion=%dKB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for range b.N {
+				_, span := s.tracer.Start(ctx, s.bot.SpanName)
+				s.payloads.String(span, "gen_ai.completion", completion)
+				s.payloads.JSON(span, "itsm.ticket_draft_json", ticket)
+				span.End()
+			}
+		})
+	}
+}
+
+// TestTurnSpanAllocBudget fails when turn telemetry starts allocating more
+// than turnSpanAllocBudget times per turn.
+func TestTurnSpanAllocBudget(t *testing.T) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	s := benchSession(t, tp)
+	ticket := benchTicket()
+	resp := benchCompletion()
+
+	allocs := minAllocs(func() { turnSpan(s, ticket, resp) })
+	if allocs > turnSpanAllocBudget {
+		t.Errorf("turn span allocates %.0f times, budget is %d", allocs, turnSpanAllocBudget)
+	}
+	t.Logf("turn span: %.0f allocations (budget %d)", allocs, turnSpanAllocBudget)
+}
+
+// TestTurnSpanDisabledAllocs checks that with TRACING_DISABLED turn
+// telemetry allocates nothing.
+func TestTurnSpanDisabledAllocs(t *testing.T) {
+	s := benchSession(t, noop.NewTracerProvider())
+	s.tracing = false
+	ticket := benchTicket()
+	resp := benchCompletion()
+
+	if allocs := minAllocs(func() { turnSpan(s, ticket, resp) }); allocs != 0 {
+		t.Errorf("disabled turn span allocates %.0f times, want 0", allocs)
+	}
+}
+
+// minAllocs is the fewest allocations f makes per run over a few rounds of
+// testing.AllocsPerRun. AllocsPerRun counts every goroutine's allocations,
+// and gosnowflake probes for cloud platforms in the background at init.
+func minAllocs(f func()) float64 {
+	least := testing.AllocsPerRun(100, f)
+	for range 4 {
+		least = min(least, testing.AllocsPerRun(100, f))
+	}
+	return least
+}
+
+// TestTurnSpanDisabledLeavesCaller checks that with TRACING_DISABLED the
+// turn doesn't end or fail the caller's own span.
+func TestTurnSpanDisabledLeavesCaller(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	ctx, caller := tp.Tracer("caller").Start(context.Background(), "request")
+	s := benchSession(t, noop.NewTracerProvider())
+	s.tracing = false
+
+	_, span := s.startTurnSpan(ctx, "hi", nil, TurnOptions{}, nil)
+	if span.IsRecording() || span.SpanContext().IsValid() {
+		t.Errorf("disabled turn span records as %v", span.SpanContext())
+	}
+	boterr.Record(span, errors.New("boom"))
+	span.End()
+	if ended := rec.Ended(); len(ended) != 0 || !caller.IsRecording() {
+		t.Errorf("the turn ended the caller's span: %d ended", len(ended))
+	}
+}
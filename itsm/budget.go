@@ -0,0 +1,275 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+)
+
+// costUSD is what a completion from model cost. Models without registry
+// pricing cost nothing.
+func costUSD(model string, resp chat.Completion) float64 {
+	info, _ := chat.LookupModel(model)
+	return info.CostUSD(resp.InputTokens, resp.OutputTokens)
+}
+
+// budgetConfig caps what a tenant, and each of its users, may spend on
+// turns. Zero limits are unlimited.
+type budgetConfig struct {
+	DailyUSD       float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD     float64 `json:"monthly_usd,omitempty"`
+	UserDailyUSD   float64 `json:"user_daily_usd,omitempty"`
+	UserMonthlyUSD float64 `json:"user_monthly_usd,omitempty"`
+	// WarnAt is the share of a limit past which turns are warned about
+	// (default 0.8).
+	WarnAt float64 `json:"warn_at,omitempty"`
+}
+
+// budgetsFromEnv reads ITSM_BUDGET_DAILY_USD, ITSM_BUDGET_MONTHLY_USD,
+// ITSM_USER_BUDGET_DAILY_USD, ITSM_USER_BUDGET_MONTHLY_USD and
+// ITSM_BUDGET_WARN_AT.
+func budgetsFromEnv() (budgetConfig, error) {
+	var c budgetConfig
+	for _, v := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"ITSM_BUDGET_DAILY_USD", &c.DailyUSD},
+		{"ITSM_BUDGET_MONTHLY_USD", &c.MonthlyUSD},
+		{"ITSM_USER_BUDGET_DAILY_USD", &c.UserDailyUSD},
+		{"ITSM_USER_BUDGET_MONTHLY_USD", &c.UserMonthlyUSD},
+		{"ITSM_BUDGET_WARN_AT", &c.WarnAt},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 {
+			return c, fmt.Errorf("%s must be a non-negative number, got %q", v.name, s)
+		}
+		*v.dst = f
+	}
+	return c, c.validate()
+}
+
+func (c budgetConfig) validate() error {
+	if c.DailyUSD < 0 || c.MonthlyUSD < 0 || c.UserDailyUSD < 0 || c.UserMonthlyUSD < 0 {
+		return errors.New("budgets can't be negative")
+	}
+	if c.WarnAt < 0 || c.WarnAt > 1 {
+		return fmt.Errorf("budget warn_at must be between 0 and 1, got %g", c.WarnAt)
+	}
+	return nil
+}
+
+func (c budgetConfig) warnAt() float64 {
+	if c.WarnAt == 0 {
+		return 0.8
+	}
+	return c.WarnAt
+}
+
+// Budget decisions, recorded on turn spans as itsm.budget.decision.
+const (
+	budgetAllow = "allow"
+	budgetWarn  = "warn"
+	budgetBlock = "block"
+)
+
+// errBudget is returned for turns over a spend budget.
+var errBudget = errors.New("spend budget exceeded")
+
+// budgetState is one budget and what has been spent against it.
+type budgetState struct {
+	Scope    string  `json:"scope"`
+	Period   string  `json:"period"`
+	SpentUSD float64 `json:"spent_usd"`
+	LimitUSD float64 `json:"limit_usd"`
+	Status   string  `json:"status"`
+}
+
+// spendScopes are the spend table keys for a tenant and one of its users.
+func spendScopes(tenant, user string) (tenantScope, userScope string) {
+	if user == "" {
+		user = "anonymous"
+	}
+	return "tenant:" + tenant, "user:" + tenant + ":" + user
+}
+
+// periodStarts are the first days of today's daily and monthly periods,
+// in UTC.
+func periodStarts(now time.Time) (day, month string) {
+	now = now.UTC()
+	return now.Format(time.DateOnly), now.Format("2006-01") + "-01"
+}
+
+// budgetStates reports every budget that applies to user of tenant.
+func (s *TicketStore) budgetStates(c budgetConfig, tenant, user string) ([]budgetState, error) {
+	tenantScope, userScope := spendScopes(tenant, user)
+	day, month := periodStarts(time.Now())
+	var states []budgetState
+	for _, b := range []struct {
+		scope, period, from string
+		limit               float64
+	}{
+		{tenantScope, "daily", day, c.DailyUSD},
+		{tenantScope, "monthly", month, c.MonthlyUSD},
+		{userScope, "daily", day, c.UserDailyUSD},
+		{userScope, "monthly", month, c.UserMonthlyUSD},
+	} {
+		if b.limit == 0 {
+			continue
+		}
+		spent, err := s.spent(b.scope, b.from)
+		if err != nil {
+			return nil, err
+		}
+		state := budgetState{Scope: b.scope, Period: b.period, SpentUSD: spent, LimitUSD: b.limit, Status: budgetAllow}
+		switch {
+		case spent >= b.limit:
+			state.Status = budgetBlock
+		case spent >= b.limit*c.warnAt():
+			state.Status = budgetWarn
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// checkBudget decides whether requester may run a turn, or another model
+// call, and records the decision on span. The tightest budget decides: a
+// call over any budget is blocked with errBudget, and one past the warning
+// threshold of any budget runs with a warning.
+func (b *Bot) checkBudget(ctx context.Context, span trace.Span, requester string) (warning string, err error) {
+	states, err := b.tickets.budgetStates(b.budget, tenantOf(ctx), requester)
+	if err != nil {
+		// Budgets fail open: a broken store shouldn't stop support
+		log.Printf("Checking spend budgets: %v", err)
+		return "", nil
+	}
+	if len(states) == 0 {
+		return "", nil
+	}
+	tightest := states[0]
+	for _, st := range states[1:] {
+		if st.SpentUSD/st.LimitUSD > tightest.SpentUSD/tightest.LimitUSD {
+			tightest = st
+		}
+	}
+	span.SetAttributes(
+		attribute.String("itsm.budget.decision", tightest.Status),
+		attribute.String("itsm.budget.scope", tightest.Scope),
+		attribute.String("itsm.budget.period", tightest.Period),
+		attribute.Float64("itsm.budget.spent_usd", tightest.SpentUSD),
+		attribute.Float64("itsm.budget.limit_usd", tightest.LimitUSD),
+	)
+	summary := fmt.Sprintf("%s %s budget: $%.2f of $%.2f spent", strings.SplitN(tightest.Scope, ":", 2)[0], tightest.Period, tightest.SpentUSD, tightest.LimitUSD)
+	switch tightest.Status {
+	case budgetBlock:
+		return "", boterr.New(boterr.ErrRateLimited, "This conversation has reached its spend budget. Try again once the budget resets.", fmt.Errorf("%w (%s)", errBudget, summary))
+	case budgetWarn:
+		log.Printf("Spend budget warning for %s: %s", tightest.Scope, summary)
+		return summary, nil
+	}
+	return "", nil
+}
+
+// recordSpend adds the cost of a completion from model to the tenant's
+// and requester's spend. The spend is written by a job once the call is
+// answered, so a turn started right behind this one may not count it yet.
+func (b *Bot) recordSpend(ctx context.Context, span trace.Span, requester, model string, resp chat.Completion) {
+	cost := costUSD(model, resp)
+	span.SetAttributes(attribute.Float64("itsm.cost_usd", cost))
+	tenantScope, userScope := spendScopes(tenantOf(ctx), requester)
+	day, _ := periodStarts(time.Now())
+	b.jobs.Go(ctx, "spend.record", func(context.Context) error {
+		var errs []error
+		for _, scope := range []string{tenantScope, userScope} {
+			if err := b.tickets.addSpend(scope, day, resp.InputTokens, resp.OutputTokens, cost); err != nil {
+				errs = append(errs, fmt.Errorf("recording spend for %s: %w", scope, err))
+			}
+		}
+		return errors.Join(errs...)
+	}, attribute.Float64("itsm.cost_usd", cost))
+}
+
+// printSpend shows what user and the whole deployment spent today and
+// this month, and where that leaves each budget.
+func printSpend(store *TicketStore, c budgetConfig, user string) {
+	tenantScope, userScope := spendScopes("default", user)
+	day, month := periodStarts(time.Now())
+	fmt.Println()
+	for _, scope := range []string{userScope, tenantScope} {
+		today, err := store.spent(scope, day)
+		if err != nil {
+			fmt.Printf("Cannot read spend: %v\n\n", err)
+			return
+		}
+		thisMonth, _ := store.spent(scope, month)
+		fmt.Printf("%-40s today $%.4f, this month $%.4f\n", scope, today, thisMonth)
+	}
+	states, err := store.budgetStates(c, "default", user)
+	if err != nil {
+		fmt.Printf("Cannot read budgets: %v\n\n", err)
+		return
+	}
+	if len(states) == 0 {
+		fmt.Println("No spend budgets set.")
+	}
+	for _, st := range states {
+		fmt.Printf("%s %s budget: $%.2f of $%.2f (%s)\n", st.Scope, st.Period, st.SpentUSD, st.LimitUSD, st.Status)
+	}
+	fmt.Println()
+}
+
+// addSpend adds tokens and cost to scope's spend on day.
+func (s *TicketStore) addSpend(scope, day string, inputTokens, outputTokens int64, cost float64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO spend (scope, day, input_tokens, output_tokens, cost_usd)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (scope, day) DO UPDATE SET
+			input_tokens = input_tokens + excluded.input_tokens,
+			output_tokens = output_tokens + excluded.output_tokens,
+			cost_usd = cost_usd + excluded.cost_usd`,
+		scope, day, inputTokens, outputTokens, cost)
+	return err
+}
+
+// spent is scope's spend since the day from.
+func (s *TicketStore) spent(scope, from string) (float64, error) {
+	var cost float64
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(cost_usd), 0) FROM spend WHERE scope = ? AND day >= ?`, scope, from).Scan(&cost)
+	return cost, err
+}
+
+// spenders lists the users of tenant with spend since the day from.
+func (s *TicketStore) spenders(tenant, from string) ([]string, error) {
+	prefix := "user:" + tenant + ":"
+	rows, err := s.db.Query(`SELECT DISTINCT scope FROM spend WHERE scope LIKE ? ESCAPE '\' AND day >= ? ORDER BY scope`,
+		strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)+"%", from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, err
+		}
+		users = append(users, strings.TrimPrefix(scope, prefix))
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,46 @@
+package itsm
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//go:embed canned_prompts.json
+var defaultCannedPrompts []byte
+
+// CannedPrompt is a named, reusable request for demos and repeatable tests.
+type CannedPrompt struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+}
+
+// loadCannedPrompts reads the library from ITSM_CANNED_PROMPTS if set,
+// otherwise it falls back to the built-in canned_prompts.json.
+func loadCannedPrompts() ([]CannedPrompt, error) {
+	data := defaultCannedPrompts
+	if path := os.Getenv("ITSM_CANNED_PROMPTS"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading canned prompts: %w", err)
+		}
+	}
+
+	var prompts []CannedPrompt
+	if err := json.Unmarshal(data, &prompts); err != nil {
+		return nil, fmt.Errorf("parsing canned prompts: %w", err)
+	}
+	return prompts, nil
+}
+
+// findCannedPrompt looks up a canned prompt by name.
+func findCannedPrompt(prompts []CannedPrompt, name string) (CannedPrompt, bool) {
+	for _, p := range prompts {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return CannedPrompt{}, false
+}
@@ -0,0 +1,128 @@
+package itsm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-tracing-demo/connector"
+)
+
+// requiredSections must appear in a reply once the ticket is complete.
+var requiredSections = []string{"Ticket Draft", "Approvals", "Next Steps"}
+
+var (
+	ticketIDPattern = regexp.MustCompile(`\bAR-[0-9A-F]{8}\b`)
+	validTicketID   = regexp.MustCompile(`^AR-[0-9A-F]{8}$`)
+	// grantedClaim matches replies telling the user their access is live.
+	grantedClaim = regexp.MustCompile(`(?i)\b(access (has been|is now|was) (granted|provisioned)|successfully provisioned|you now have)\b`)
+)
+
+// checkResult is the outcome of one automatic turn check.
+type checkResult struct {
+	Key    string
+	Pass   bool
+	Detail string
+}
+
+// checkTurn runs cheap format and hallucination checks on an ITSM reply
+// against the ticket as it stands after the turn. Checks that don't apply
+// to the turn (e.g. sections while still clarifying) are left out.
+func checkTurn(reply string, t AccessRequest, tmpl *ticketTemplate) []checkResult {
+	var results []checkResult
+
+	results = append(results, checkResult{
+		Key:    "format.request_type",
+		Pass:   strings.Contains(reply, "Request Type: Access Request"),
+		Detail: `reply must classify the request as "Request Type: Access Request"`,
+	})
+
+	if len(missingFields(t)) == 0 && t.Status != statusEscalated {
+		var absent []string
+		for _, section := range requiredSections {
+			if !strings.Contains(reply, section) {
+				absent = append(absent, section)
+			}
+		}
+		results = append(results, checkResult{
+			Key:    "format.sections",
+			Pass:   len(absent) == 0,
+			Detail: "missing sections: " + strings.Join(absent, ", "),
+		})
+	}
+
+	problems := validateTicket(t, tmpl)
+	results = append(results, checkResult{
+		Key:    "ticket.valid",
+		Pass:   len(problems) == 0,
+		Detail: strings.Join(problems, "; "),
+	})
+
+	var unknownIDs []string
+	for _, id := range ticketIDPattern.FindAllString(reply, -1) {
+		if id != t.ID {
+			unknownIDs = append(unknownIDs, id)
+		}
+	}
+	results = append(results, checkResult{
+		Key:    "hallucination.ticket_id",
+		Pass:   len(unknownIDs) == 0,
+		Detail: "reply mentions ticket IDs that do not exist: " + strings.Join(unknownIDs, ", "),
+	})
+
+	if grantedClaim.MatchString(reply) {
+		results = append(results, checkResult{
+			Key:    "hallucination.status_claim",
+			Pass:   t.Status == statusProvisioned,
+			Detail: fmt.Sprintf("reply says access is granted but the ticket is %s", t.Status),
+		})
+	}
+
+	// Details only explain failures
+	for i := range results {
+		if results[i].Pass {
+			results[i].Detail = ""
+		}
+	}
+	return results
+}
+
+// validateTicket checks the draft against the ticket schema, extended by
+// the organization's ticket template.
+func validateTicket(t AccessRequest, tmpl *ticketTemplate) []string {
+	var problems []string
+	if !validTicketID.MatchString(t.ID) {
+		problems = append(problems, fmt.Sprintf("id %q is not AR-XXXXXXXX", t.ID))
+	}
+	if t.Type != "access_request" {
+		problems = append(problems, fmt.Sprintf("type %q is not access_request", t.Type))
+	}
+	switch t.AccessLevel {
+	case "read", "write", "admin", "unknown":
+	default:
+		problems = append(problems, fmt.Sprintf("access_level %q is not read, write, admin or unknown", t.AccessLevel))
+	}
+	switch t.RiskLevel {
+	case "low", "medium", "high":
+	default:
+		problems = append(problems, fmt.Sprintf("risk_level %q is not low, medium or high", t.RiskLevel))
+	}
+	switch t.Status {
+	case statusDraft, statusApproved, statusProvisioning, statusProvisioned, statusFailed, statusEscalated, statusDenied:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown status %q", t.Status))
+	}
+	if t.Duration != "unknown" {
+		if _, ok := connector.Expiry(t.Duration); !ok {
+			problems = append(problems, fmt.Sprintf("duration %q is not like 24h or 7d", t.Duration))
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, t.CreatedAt); err != nil {
+		problems = append(problems, fmt.Sprintf("created_at %q is not RFC 3339", t.CreatedAt))
+	}
+	if t.Connector != "" && t.ApprovedBy == "" {
+		problems = append(problems, "granted through a connector without approved_by")
+	}
+	return append(problems, tmpl.problems(t)...)
+}
@@ -0,0 +1,227 @@
+package itsm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+)
+
+// Classification levels of ticket fields. Fields not listed in the
+// classification are internal.
+const (
+	classPublic       = "public"
+	classInternal     = "internal"
+	classConfidential = "confidential"
+)
+
+// maskedValue replaces confidential values in traces and exports.
+const maskedValue = "[CONFIDENTIAL]"
+
+// maskedValueLimit caps the confidential values kept for masking spans.
+// Sweeps and exports read every ticket; only the most recent values are
+// kept, which covers the tickets being worked on.
+const maskedValueLimit = 10000
+
+// confidentialValues are the confidential values of the tickets this
+// process has stored or read most recently, masked in every exported span.
+var confidentialValues = otlpexport.NewMasker(maskedValue, maskedValueLimit)
+
+// fieldClasses classifies ticket fields by their JSON name, such as
+// "requester_email", or "fields.<name>" for ticket template fields.
+type fieldClasses map[string]string
+
+// loadFieldClasses reads the classification from the JSON file named by
+// ITSM_FIELD_CLASSIFICATION. Without it every field is internal and
+// nothing is masked.
+func loadFieldClasses() (fieldClasses, error) {
+	path := os.Getenv("ITSM_FIELD_CLASSIFICATION")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading field classification: %w", err)
+	}
+	var classes fieldClasses
+	if err := json.Unmarshal(data, &classes); err != nil {
+		return nil, fmt.Errorf("parsing field classification %s: %w", path, err)
+	}
+	fields := stringFields()
+	for name, class := range classes {
+		switch class {
+		case classPublic, classInternal, classConfidential:
+		default:
+			return nil, fmt.Errorf("field classification: %s is %q, not public, internal or confidential", name, class)
+		}
+		if _, ok := fields[name]; !ok && !strings.HasPrefix(name, "fields.") {
+			return nil, fmt.Errorf("field classification: %q is not a text field of the ticket", name)
+		}
+	}
+	return classes, nil
+}
+
+// stringFields are the indexes of AccessRequest's text fields, by JSON
+// name; only these can be classified.
+func stringFields() map[string]int {
+	fields := map[string]int{}
+	typ := reflect.TypeOf(AccessRequest{})
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Type.Kind() == reflect.String && name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+// confidential calls fn with a pointer to each confidential value of t
+// that is set.
+func (c fieldClasses) confidential(t *AccessRequest, fn func(v *string)) {
+	if len(c) == 0 {
+		return
+	}
+	fields := stringFields()
+	v := reflect.ValueOf(t).Elem()
+	for name, class := range c {
+		if class != classConfidential {
+			continue
+		}
+		if custom, ok := strings.CutPrefix(name, "fields."); ok {
+			if value, ok := t.Fields[custom]; ok && value != "" && value != "unknown" {
+				fn(&value)
+				t.Fields[custom] = value
+			}
+			continue
+		}
+		if field := v.Field(fields[name]).Addr().Interface().(*string); *field != "" && *field != "unknown" {
+			fn(field)
+		}
+	}
+}
+
+// mask returns t with its confidential values replaced by maskedValue, for
+// span attributes and exports.
+func (c fieldClasses) mask(t AccessRequest) AccessRequest {
+	t.Fields = cloneFields(t.Fields)
+	c.confidential(&t, func(v *string) { *v = maskedValue })
+	return t
+}
+
+// maskText returns s with the confidential values of t masked, as when a
+// comment quotes them.
+func (c fieldClasses) maskText(t AccessRequest, s string) string {
+	m := otlpexport.NewMasker(maskedValue, 0)
+	c.confidential(&t, func(v *string) { m.Add(*v) })
+	return m.Replace(s)
+}
+
+func cloneFields(fields map[string]string) map[string]string {
+	if fields == nil {
+		return nil
+	}
+	out := make(map[string]string, len(fields))
+	for k, v := range fields {
+		out[k] = v
+	}
+	return out
+}
+
+// fieldSealerFromEnv returns the encryptor for confidential values at
+// rest, from ITSM_DB_KEY: 32 bytes in base64 (openssl rand -base64 32).
+// It returns nil without the key, and confidential values are then stored
+// in the clear.
+func fieldSealerFromEnv() (*payload.Encryptor, error) {
+	v := os.Getenv("ITSM_DB_KEY")
+	if v == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.New("ITSM_DB_KEY must be base64")
+	}
+	e, err := payload.NewEncryptor(key)
+	if err != nil {
+		return nil, fmt.Errorf("ITSM_DB_KEY: %w", err)
+	}
+	return e, nil
+}
+
+// seal returns t as it is stored: confidential values encrypted when the
+// store has a key. Encryption is stable, so an unchanged ticket is stored
+// unchanged and revisions still show which fields changed. It also
+// registers the values to be masked.
+func (s *TicketStore) seal(t AccessRequest) AccessRequest {
+	t.Fields = cloneFields(t.Fields)
+	s.classes.confidential(&t, func(v *string) {
+		confidentialValues.Add(*v)
+		if s.sealer != nil {
+			*v = s.sealer.EncryptStable(*v)
+		}
+	})
+	return t
+}
+
+// encodeTicket is the JSON t is stored as.
+func (s *TicketStore) encodeTicket(t AccessRequest) (string, error) {
+	data, err := json.Marshal(s.seal(t))
+	return string(data), err
+}
+
+// decodeTicket reads a ticket as stored, opening its confidential values.
+func (s *TicketStore) decodeTicket(data string) (AccessRequest, error) {
+	var t AccessRequest
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return t, err
+	}
+	return t, s.open(&t)
+}
+
+// open decrypts the sealed values of t in place and registers them to be
+// masked.
+func (s *TicketStore) open(t *AccessRequest) error {
+	var errs []error
+	s.classes.confidential(t, func(v *string) {
+		if payload.IsEncrypted(*v) {
+			if s.sealer == nil {
+				errs = append(errs, errors.New("ticket has encrypted fields but ITSM_DB_KEY is not set"))
+				return
+			}
+			plaintext, err := s.sealer.Decrypt(*v)
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			*v = plaintext
+		}
+		confidentialValues.Add(*v)
+	})
+	return errors.Join(errs...)
+}
+
+// openEvent returns an outbox payload with the ticket's confidential
+// values opened, as webhook receivers get it.
+func (s *TicketStore) openEvent(data string) (string, error) {
+	if s.sealer == nil {
+		return data, nil
+	}
+	var e outboxEvent
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return "", err
+	}
+	if e.Ticket == nil {
+		return data, nil
+	}
+	if err := s.open(e.Ticket); err != nil {
+		return "", err
+	}
+	opened, err := json.Marshal(e)
+	return string(opened), err
+}
@@ -0,0 +1,159 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+)
+
+// RunCommand runs a go-bot-itsm subcommand such as "tickets export"; args
+// are the command line after the flags.
+func RunCommand(args []string) error {
+	switch {
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "export":
+		return exportTickets(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "import":
+		return importTickets(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "approve":
+		return decideFromCommand(args[2:], false)
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "deny":
+		return decideFromCommand(args[2:], true)
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "escalate":
+		return escalateCommand(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "comment":
+		return commentCommand(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "comments":
+		return commentsCommand(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "transcript":
+		return transcriptCommand(args[2:])
+	case len(args) >= 2 && args[0] == "tickets" && args[1] == "diff":
+		return ticketDiff(args[2:])
+	case len(args) >= 2 && args[0] == "reviews" && args[1] == "create":
+		return createReviewCampaign(args[2:])
+	case len(args) >= 2 && args[0] == "admin" && args[1] == "purge-user":
+		return purgeUserCommand(args[2:])
+	case len(args) >= 2 && args[0] == "admin" && args[1] == "in-doubt":
+		return inDoubtCommand(args[2:])
+	case len(args) >= 1 && args[0] == "analyst":
+		return analystCommand(args[1:])
+	case len(args) >= 1 && args[0] == "simulate":
+		return runSimulation(args[1:])
+	case len(args) >= 1 && args[0] == "loadtest":
+		return runLoadTest(args[1:])
+	case len(args) >= 1 && args[0] == "serve":
+		return runServer(args[1:])
+	case len(args) >= 1 && args[0] == "replay":
+		return replayTranscript(args[1:])
+	case len(args) >= 1 && args[0] == "upload":
+		return uploadTraces(args[1:])
+	case len(args) >= 1 && args[0] == "decrypt":
+		return decryptPayloads(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: tickets export, tickets import, tickets approve, tickets deny, tickets escalate, tickets comment, tickets comments, tickets transcript, tickets diff, reviews create, admin purge-user, admin in-doubt, analyst, simulate, loadtest, serve, replay, upload, decrypt)", strings.Join(args, " "))
+	}
+}
+
+// openPersistedStore opens ITSM_DB for subcommands, which have nothing to
+// work on without a persisted database.
+func openPersistedStore() (*TicketStore, error) {
+	path := os.Getenv("ITSM_DB")
+	if path == "" {
+		return nil, errors.New("ITSM_DB is not set, so there are no persisted tickets")
+	}
+	return OpenTicketStore(path)
+}
+
+// initTracer sets up the global tracer provider for go-bot-itsm.
+// Confidential ticket values are masked before any exporter sees a span.
+func initTracer(apiKey, projectName, userID string, wrap ...otlpexport.ExporterWrapper) (func(), error) {
+	return otlpexport.Init(otlpexport.Setup{
+		ServiceName: "go-bot-itsm",
+		APIKey:      apiKey,
+		Project:     projectName,
+		UserID:      userID,
+		Wrap:        wrap,
+		Redact:      confidentialValues.Wrap,
+	})
+}
+
+// initCommandTracer sets up tracing for subcommands that record spans.
+func initCommandTracer(wrap ...otlpexport.ExporterWrapper) (func(), error) {
+	apiKey := os.Getenv("LANGSMITH_API_KEY")
+	if apiKey == "" && !otlpexport.TracingDisabled() && otlpexport.ToLangSmith() {
+		return nil, errors.New("LANGSMITH_API_KEY is required")
+	}
+	projectName := os.Getenv("LANGSMITH_PROJECT")
+	if projectName == "" {
+		projectName = "go-bot-itsm"
+	}
+	// Subcommands act for many users, or none, so no identity is recorded
+	return initTracer(apiKey, projectName, "", wrap...)
+}
+
+// uploadTraces implements "upload": spans written to an OTLP_FILE while
+// offline are sent to LangSmith, batch by batch as they were exported.
+func uploadTraces(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	project := fs.String("project", os.Getenv("LANGSMITH_PROJECT"), "LangSmith project to send the spans to (default LANGSMITH_PROJECT, then go-bot-itsm)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: upload [--project name] <traces.jsonl>")
+	}
+	exportOpts, err := otlpexport.FromEnv()
+	if err != nil {
+		return err
+	}
+	apiKey := os.Getenv("LANGSMITH_API_KEY")
+	if apiKey == "" && exportOpts.Endpoint == "" {
+		return errors.New("LANGSMITH_API_KEY is required")
+	}
+	if *project == "" {
+		*project = "go-bot-itsm"
+	}
+	client := otlptracehttp.NewClient(exportOpts.EndpointOptions(apiKey, *project)...)
+	sent, err := otlpexport.Upload(context.Background(), fs.Arg(0), client)
+	fmt.Fprintf(os.Stderr, "Uploaded %d spans from %s to %s\n", sent, fs.Arg(0), *project)
+	return err
+}
+
+// decryptPayloads implements "decrypt": every encrypted payload in the
+// file, or stdin, is replaced by its plaintext and the result printed. The
+// input can be a single value copied from LangSmith or a whole OTLP_FILE.
+func decryptPayloads(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: decrypt [file]")
+	}
+	encryptor, err := payload.EncryptorFromEnv()
+	if err != nil {
+		return err
+	}
+	if encryptor == nil {
+		return errors.New("TRACE_PAYLOAD_KEY is required")
+	}
+	var data []byte
+	if len(args) == 1 {
+		data, err = os.ReadFile(args[0])
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	out, failed := encryptor.DecryptAll(string(data))
+	fmt.Print(out)
+	if failed > 0 {
+		return fmt.Errorf("%d payloads could not be decrypted with this key", failed)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/chat"
+	"go-tracing-demo/tracehooks"
+)
+
+// replyPrompt has the model answer an approver's question on a ticket for
+// the requester, from what the conversation established.
+const replyPrompt = `You help an employee whose IT access request is being reviewed. An approver has asked a question on the ticket.
+Draft a short, factual reply in the approver's language, using only what the conversation and ticket below establish.
+If they do not answer the question, say what is unknown and that the requester will follow up. Do not invent details.`
+
+// commentAuthorBot is the author of replies the model drafted.
+const commentAuthorBot = "go-bot-itsm"
+
+// ticketComment is one comment in a ticket's thread.
+type ticketComment struct {
+	ID       int64  `json:"id"`
+	TicketID string `json:"ticket_id"`
+	Author   string `json:"author"`
+	Body     string `json:"body"`
+	// Drafted replies were written by the model, in reply to the comment
+	// InReplyTo.
+	Drafted   bool   `json:"drafted,omitempty"`
+	InReplyTo int64  `json:"in_reply_to,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// conversationMessage is one text message of a stored conversation.
+type conversationMessage struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// saveConversation stores the text of the conversation in session
+// sessionID against its ticket, replacing what was stored before, so
+// later work on the ticket can refer back to it.
+func (s *TicketStore) saveConversation(sessionID, ticketID, requester string, history []anthropic.MessageParam) error {
+	data, err := json.Marshal(conversationText(history))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO conversations (session_id, ticket_id, requester, messages, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (session_id) DO UPDATE SET
+			ticket_id = excluded.ticket_id, requester = excluded.requester,
+			messages = excluded.messages, updated_at = excluded.updated_at`,
+		sessionID, ticketID, requester, string(data), now())
+	return err
+}
+
+// conversation returns the stored conversation of session sessionID.
+func (s *TicketStore) conversation(sessionID string) ([]conversationMessage, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT messages FROM conversations WHERE session_id = ?`, sessionID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var messages []conversationMessage
+	if err := json.Unmarshal([]byte(data), &messages); err != nil {
+		return nil, fmt.Errorf("decoding conversation %s: %w", sessionID, err)
+	}
+	return messages, nil
+}
+
+// conversationText keeps the text blocks of history, one message each.
+func conversationText(history []anthropic.MessageParam) []conversationMessage {
+	var messages []conversationMessage
+	for _, m := range history {
+		var text strings.Builder
+		for _, block := range m.Content {
+			if block.OfText != nil {
+				text.WriteString(block.OfText.Text)
+			}
+		}
+		if text.Len() > 0 {
+			messages = append(messages, conversationMessage{Role: string(m.Role), Text: text.String()})
+		}
+	}
+	return messages
+}
+
+// addComment appends c to its ticket's thread and returns it as stored,
+// with the trace and span of ctx.
+func (s *TicketStore) addComment(ctx context.Context, c ticketComment) (ticketComment, error) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		c.TraceID, c.SpanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	c.CreatedAt = now()
+	var inReplyTo any
+	if c.InReplyTo != 0 {
+		inReplyTo = c.InReplyTo
+	}
+	res, err := s.db.Exec(`
+		INSERT INTO ticket_comments (ticket_id, author, body, drafted, in_reply_to, trace_id, span_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.TicketID, c.Author, c.Body, c.Drafted, inReplyTo, c.TraceID, c.SpanID, c.CreatedAt)
+	if err != nil {
+		return c, err
+	}
+	c.ID, err = res.LastInsertId()
+	return c, err
+}
+
+// comments returns the thread of ticket id, oldest first.
+func (s *TicketStore) comments(id string) ([]ticketComment, error) {
+	rows, err := s.db.Query(`SELECT id, author, body, drafted, COALESCE(in_reply_to, 0), trace_id, span_id, created_at
+		FROM ticket_comments WHERE ticket_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []ticketComment{}
+	for rows.Next() {
+		c := ticketComment{TicketID: id}
+		if err := rows.Scan(&c.ID, &c.Author, &c.Body, &c.Drafted, &c.InReplyTo, &c.TraceID, &c.SpanID, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// replyDrafter drafts replies to ticket comments with the model.
+type replyDrafter struct {
+	client *anthropic.Client
+	model  string
+}
+
+// commentOnTicket adds author's comment to ticket id in a ticket_comment
+// span linked to the turn that created the ticket. With a drafter, the
+// model also drafts a reply from the ticket's original conversation, which
+// is added to the thread as a drafted reply. It returns the comments
+// added, which is the comment alone when drafting fails.
+func commentOnTicket(ctx context.Context, tracer trace.Tracer, store *TicketStore, drafter *replyDrafter, id, author, body string, attrs ...attribute.KeyValue) ([]ticketComment, error) {
+	ctx, span := tracer.Start(ctx, "ticket_comment", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("itsm.ticket.id", id),
+		attribute.String("itsm.comment.author", author),
+		attribute.Bool("itsm.comment.draft_reply", drafter != nil),
+	), trace.WithAttributes(attrs...))
+	defer span.End()
+	fail := func(err error) ([]ticketComment, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	ticket, ok := store.get(id)
+	if !ok {
+		return fail(fmt.Errorf("unknown ticket %q", id))
+	}
+	if strings.TrimSpace(body) == "" {
+		return fail(errors.New("the comment is empty"))
+	}
+	if author == "" {
+		return fail(errors.New("the comment has no author"))
+	}
+	linkToOrigin(span, ticket)
+	origin := store.originSession(id)
+	if origin != "" {
+		span.SetAttributes(attribute.String("itsm.ticket.session_id", origin))
+	}
+
+	comment, err := store.addComment(ctx, ticketComment{TicketID: id, Author: author, Body: body})
+	if err != nil {
+		return fail(err)
+	}
+	span.SetAttributes(attribute.Int64("itsm.comment.id", comment.ID))
+	added := []ticketComment{comment}
+	if drafter == nil {
+		return added, nil
+	}
+
+	reply, err := drafter.draft(ctx, tracer, store, ticket, origin, comment)
+	if err != nil {
+		// The comment itself is kept
+		_, err = fail(fmt.Errorf("drafting a reply: %w", err))
+		return added, err
+	}
+	return append(added, reply), nil
+}
+
+// draft writes the bot's reply to question in a comment_reply span, from
+// the conversation of session origin and the thread so far, and adds it
+// to the thread.
+func (d *replyDrafter) draft(ctx context.Context, tracer trace.Tracer, store *TicketStore, t AccessRequest, origin string, question ticketComment) (ticketComment, error) {
+	ctx, span := tracer.Start(ctx, "comment_reply", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("gen_ai.request.model", d.model),
+		attribute.String("itsm.ticket.id", t.ID),
+		attribute.Int64("itsm.comment.in_reply_to", question.ID),
+		attribute.String("gen_ai.prompt", question.Body),
+	))
+	defer span.End()
+	fail := func(err error) (ticketComment, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return ticketComment{}, err
+	}
+
+	var conversation []conversationMessage
+	if origin != "" {
+		var err error
+		if conversation, err = store.conversation(origin); err != nil {
+			return fail(err)
+		}
+	}
+	thread, err := store.comments(t.ID)
+	if err != nil {
+		return fail(err)
+	}
+	span.SetAttributes(
+		attribute.Int("itsm.comment.conversation_messages", len(conversation)),
+		attribute.Int("itsm.comment.thread_length", len(thread)),
+	)
+
+	var prompt strings.Builder
+	if len(conversation) == 0 {
+		prompt.WriteString("The original conversation is not available.\n\n")
+	}
+	for _, m := range conversation {
+		fmt.Fprintf(&prompt, "%s: %s\n\n", m.Role, m.Text)
+	}
+	t.TraceParent, t.TraceState = "", ""
+	ticketJSON, _ := json.MarshalIndent(t, "", "  ")
+	fmt.Fprintf(&prompt, "Ticket:\n%s\n\nComments:\n", ticketJSON)
+	for _, c := range thread {
+		fmt.Fprintf(&prompt, "%s: %s\n", c.Author, c.Body)
+	}
+	fmt.Fprintf(&prompt, "\nQuestion to answer, from %s:\n%s", question.Author, question.Body)
+
+	resp, err := d.client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(d.model),
+		MaxTokens: 400,
+		System: []anthropic.TextBlockParam{
+			{Text: replyPrompt},
+		},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(prompt.String())),
+		},
+	})
+	if err != nil {
+		return fail(err)
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	span.SetAttributes(attribute.String("gen_ai.completion", text.String()))
+
+	reply, err := store.addComment(ctx, ticketComment{
+		TicketID: t.ID, Author: commentAuthorBot, Body: text.String(), Drafted: true, InReplyTo: question.ID,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	span.SetAttributes(attribute.Int64("itsm.comment.id", reply.ID))
+	return reply, nil
+}
+
+// writeComments prints a ticket's thread.
+func writeComments(w io.Writer, comments []ticketComment) {
+	for _, c := range comments {
+		fmt.Fprintf(w, "#%d  %s  %s", c.ID, c.CreatedAt, c.Author)
+		if c.Drafted {
+			fmt.Fprintf(w, "  (drafted reply to #%d)", c.InReplyTo)
+		}
+		fmt.Fprintf(w, "\n  %s\n", strings.ReplaceAll(c.Body, "\n", "\n  "))
+	}
+}
+
+// commentCommand implements "tickets comment": adds a comment to a
+// ticket's thread, and with --draft-reply has the bot draft a reply.
+func commentCommand(args []string) error {
+	fs := flag.NewFlagSet("tickets comment", flag.ContinueOnError)
+	as := fs.String("as", defaultApprover(), "author of the comment (default ITSM_APPROVER, then $USER)")
+	draftReply := fs.Bool("draft-reply", false, "have the bot draft a reply from the ticket's original conversation")
+	var id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if id == "" && len(rest) > 0 {
+		id, rest = rest[0], rest[1:]
+	}
+	if id == "" || len(rest) == 0 {
+		return errors.New("usage: tickets comment <ticket id> [--as author] [--draft-reply] <text>")
+	}
+
+	var drafter *replyDrafter
+	if *draftReply {
+		apiKey := os.Getenv("ANTHROPIC_API_KEY")
+		if apiKey == "" {
+			return errors.New("ANTHROPIC_API_KEY is required to draft a reply")
+		}
+		client := anthropic.NewClient(
+			option.WithAPIKey(apiKey),
+			option.WithHTTPClient(traceanthropic.Client()),
+			tracehooks.Option(),
+		)
+		models, err := chat.ModelsFromEnv()
+		if err != nil {
+			return err
+		}
+		drafter = &replyDrafter{client: &client, model: models.Chat}
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	added, err := commentOnTicket(context.Background(), otel.Tracer("go-bot-itsm"), store, drafter, id, *as, strings.Join(rest, " "))
+	writeComments(os.Stdout, added)
+	return err
+}
+
+// commentsCommand implements "tickets comments": a ticket's thread.
+func commentsCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tickets comments <ticket id>")
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if _, ok := store.get(args[0]); !ok {
+		return fmt.Errorf("unknown ticket %q", args[0])
+	}
+	comments, err := store.comments(args[0])
+	if err != nil {
+		return err
+	}
+	if len(comments) == 0 {
+		fmt.Printf("Ticket %s has no comments\n", args[0])
+	}
+	writeComments(os.Stdout, comments)
+	return nil
+}
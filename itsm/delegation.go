@@ -0,0 +1,126 @@
+package itsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// delegation hands an approver's decisions to a delegate for a window,
+// such as while they are out of office.
+type delegation struct {
+	Name     string `json:"name"`
+	Approver string `json:"approver"`
+	Delegate string `json:"delegate"`
+	// From and Until bound the window as YYYY-MM-DD or RFC 3339; a bare
+	// Until date includes that whole day. An empty bound leaves that end
+	// open.
+	From   string `json:"from,omitempty"`
+	Until  string `json:"until,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// The window in RFC 3339, for comparing
+	from, until string
+}
+
+// delegations are the delegation rules in force.
+type delegations []delegation
+
+// maxDelegationHops bounds how far a chain of delegations is followed.
+const maxDelegationHops = 5
+
+// loadDelegations reads the rules from ITSM_DELEGATIONS, a JSON list.
+// Without it nobody delegates.
+func loadDelegations() (delegations, error) {
+	path := os.Getenv("ITSM_DELEGATIONS")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading delegations: %w", err)
+	}
+	var rules delegations
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing delegations: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		d := &rules[i]
+		switch {
+		case d.Name == "":
+			return nil, fmt.Errorf("delegation %d has no name", i+1)
+		case seen[d.Name]:
+			return nil, fmt.Errorf("delegation %q is defined twice", d.Name)
+		case d.Approver == "" || d.Delegate == "":
+			return nil, fmt.Errorf("delegation %q needs an approver and a delegate", d.Name)
+		case strings.EqualFold(d.Approver, d.Delegate):
+			return nil, fmt.Errorf("delegation %q delegates %s to themselves", d.Name, d.Approver)
+		}
+		seen[d.Name] = true
+		if d.from, err = parseBound(d.From, false); err != nil {
+			return nil, fmt.Errorf("delegation %q: from: %w", d.Name, err)
+		}
+		if d.Until != "" {
+			if d.until, err = parseBound(d.Until, true); err != nil {
+				return nil, fmt.Errorf("delegation %q: until: %w", d.Name, err)
+			}
+		}
+		if d.until != "" && d.until < d.from {
+			return nil, fmt.Errorf("delegation %q ends before it starts", d.Name)
+		}
+	}
+	return rules, nil
+}
+
+// active is the rule delegating approver's decisions at at, if any. When
+// windows overlap, the first rule listed wins.
+func (ds delegations) active(approver string, at time.Time) (delegation, bool) {
+	now := at.UTC().Format(time.RFC3339)
+	for _, d := range ds {
+		if strings.EqualFold(d.Approver, approver) && d.from <= now && (d.until == "" || now <= d.until) {
+			return d, true
+		}
+	}
+	return delegation{}, false
+}
+
+// delegationHop is one step from an approver to who decides for them.
+type delegationHop struct {
+	Approver string
+	// Rule is the delegation that led here, after those before it as in
+	// "ooo-alice > ooo-bob"; it is empty for the assigned approver.
+	Rule string
+}
+
+// resolve follows approver's delegations at at: the assigned approver,
+// then each delegate in turn. The last hop is who the decision is routed
+// to. A chain stops at someone who is in, after maxDelegationHops, or where
+// it would come back round.
+func (ds delegations) resolve(approver string, at time.Time) []delegationHop {
+	hops := []delegationHop{{Approver: approver}}
+	for len(hops) <= maxDelegationHops {
+		last := hops[len(hops)-1]
+		d, ok := ds.active(last.Approver, at)
+		if !ok || hopsInclude(hops, d.Delegate) {
+			break
+		}
+		rule := d.Name
+		if last.Rule != "" {
+			rule = last.Rule + " > " + d.Name
+		}
+		hops = append(hops, delegationHop{Approver: d.Delegate, Rule: rule})
+	}
+	return hops
+}
+
+func hopsInclude(hops []delegationHop, approver string) bool {
+	for _, h := range hops {
+		if strings.EqualFold(h.Approver, approver) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package itsm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+)
+
+// Demo pacing when the scenario leaves it out.
+const (
+	defaultDemoTypingDelay = 45 * time.Millisecond
+	defaultDemoPause       = 2 * time.Second
+)
+
+// lineReader is where the chat reads the user's messages: the terminal, or
+// a demo typing them.
+type lineReader interface {
+	ReadString(delim byte) (string, error)
+}
+
+// demoScenario is a scripted conversation for --demo.
+type demoScenario struct {
+	Name string `yaml:"name"`
+	// TypingDelay is the time to type one character, varied a little per
+	// keystroke; Pause is the time before typing each step.
+	TypingDelay time.Duration `yaml:"typing_delay"`
+	Pause       time.Duration `yaml:"pause"`
+	// TimeLimit ends the demo after this long, skipping the steps left; zero
+	// plays every step.
+	TimeLimit time.Duration `yaml:"time_limit"`
+	Steps     []demoStep    `yaml:"steps"`
+}
+
+// demoStep is one message typed in a demo: chat text, or a command such as
+// /approve.
+type demoStep struct {
+	Say string `yaml:"say"`
+	// Pause replaces the scenario's pause before this step.
+	Pause time.Duration `yaml:"pause"`
+}
+
+func loadDemoScenario(path string) (demoScenario, error) {
+	var sc demoScenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, fmt.Errorf("reading demo scenario: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("parsing demo scenario %s: %w", path, err)
+	}
+	if len(sc.Steps) == 0 {
+		return sc, errors.New("demo scenario has no steps")
+	}
+	for i, st := range sc.Steps {
+		if strings.TrimSpace(st.Say) == "" {
+			return sc, fmt.Errorf("demo scenario step %d has nothing to say", i+1)
+		}
+	}
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if sc.TypingDelay == 0 {
+		sc.TypingDelay = defaultDemoTypingDelay
+	}
+	if sc.Pause == 0 {
+		sc.Pause = defaultDemoPause
+	}
+	return sc, nil
+}
+
+// demoPlayer types a scenario's steps as if the user were typing them, then
+// quits.
+type demoPlayer struct {
+	scenario demoScenario
+	w        io.Writer
+	deadline time.Time
+	next     int
+}
+
+func newDemoPlayer(sc demoScenario, w io.Writer) *demoPlayer {
+	return &demoPlayer{scenario: sc, w: w}
+}
+
+// ReadString types the next step after its pause and returns it. Once the
+// steps are played, or the time limit is up, it returns quit.
+func (p *demoPlayer) ReadString(byte) (string, error) {
+	if p.deadline.IsZero() && p.scenario.TimeLimit > 0 {
+		p.deadline = time.Now().Add(p.scenario.TimeLimit)
+	}
+	if p.next > 0 && !p.deadline.IsZero() && time.Now().After(p.deadline) {
+		fmt.Fprintf(p.w, "quit\n\nDemo time limit of %s reached, skipping %d step(s)\n", p.scenario.TimeLimit, len(p.scenario.Steps)-p.next)
+		p.next = len(p.scenario.Steps)
+		return "quit\n", nil
+	}
+	if p.next == len(p.scenario.Steps) {
+		fmt.Fprintln(p.w, "quit")
+		return "quit\n", nil
+	}
+	st := p.scenario.Steps[p.next]
+	p.next++
+
+	pause := p.scenario.Pause
+	if st.Pause > 0 {
+		pause = st.Pause
+	}
+	time.Sleep(pause)
+	for _, r := range st.Say {
+		fmt.Fprint(p.w, string(r))
+		// Keystrokes are uneven, and a little slower after words
+		delay := time.Duration(float64(p.scenario.TypingDelay) * (0.5 + rand.Float64()))
+		if r == ' ' || r == ',' || r == '.' {
+			delay *= 2
+		}
+		time.Sleep(delay)
+	}
+	fmt.Fprintln(p.w)
+	return st.Say + "\n", nil
+}
+
+// attributes tag a demo's turns, so its traces are easy to find.
+func (p *demoPlayer) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("langsmith.metadata.demo_scenario", p.scenario.Name),
+		attribute.Int("langsmith.metadata.demo_step", p.next),
+	}
+}
@@ -0,0 +1,334 @@
+package itsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+)
+
+// digestMaxLinks caps the ticket traces a digest span links to, at the
+// SDK's default link limit.
+const digestMaxLinks = 128
+
+// activity counts a tenant's sessions and turns since the last digest.
+type activity struct {
+	sessions    atomic.Int64
+	turns       atomic.Int64
+	failedTurns atomic.Int64
+}
+
+// digestConfig says where digests go and when. Digests are off with no
+// destination.
+type digestConfig struct {
+	slackURL string
+	// emailTo, with smtpAddr and emailFrom, sends the digest by email
+	emailTo      []string
+	emailFrom    string
+	smtpAddr     string
+	smtpUser     string
+	smtpPassword string
+	// at is the time of day, in UTC, the digest is sent
+	at time.Duration
+}
+
+// digestFromEnv reads DIGEST_SLACK_WEBHOOK_URL, DIGEST_EMAIL_TO (comma-
+// separated), DIGEST_EMAIL_FROM, DIGEST_SMTP_ADDR (host:port),
+// DIGEST_SMTP_USERNAME, DIGEST_SMTP_PASSWORD and DIGEST_AT (HH:MM in UTC,
+// default 09:00).
+func digestFromEnv() (digestConfig, error) {
+	c := digestConfig{
+		slackURL:     os.Getenv("DIGEST_SLACK_WEBHOOK_URL"),
+		emailFrom:    os.Getenv("DIGEST_EMAIL_FROM"),
+		smtpAddr:     os.Getenv("DIGEST_SMTP_ADDR"),
+		smtpUser:     os.Getenv("DIGEST_SMTP_USERNAME"),
+		smtpPassword: os.Getenv("DIGEST_SMTP_PASSWORD"),
+		at:           9 * time.Hour,
+	}
+	for _, to := range strings.Split(os.Getenv("DIGEST_EMAIL_TO"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			c.emailTo = append(c.emailTo, to)
+		}
+	}
+	if len(c.emailTo) > 0 && (c.smtpAddr == "" || c.emailFrom == "") {
+		return c, fmt.Errorf("DIGEST_EMAIL_TO needs DIGEST_SMTP_ADDR and DIGEST_EMAIL_FROM")
+	}
+	if v := os.Getenv("DIGEST_AT"); v != "" {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return c, fmt.Errorf("DIGEST_AT must be HH:MM, got %q", v)
+		}
+		c.at = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return c, nil
+}
+
+func (c digestConfig) enabled() bool { return c.slackURL != "" || len(c.emailTo) > 0 }
+
+// next is the first time at or after now to send a digest.
+func (c digestConfig) next(now time.Time) time.Time {
+	now = now.UTC()
+	t := now.Truncate(24 * time.Hour).Add(c.at)
+	if t.Before(now) {
+		t = t.Add(24 * time.Hour)
+	}
+	return t
+}
+
+// tenantDigest is one tenant's activity in a digest.
+type tenantDigest struct {
+	Name                      string
+	Sessions, Turns, Failed   int64
+	InputTokens, OutputTokens int64
+	CostUSD                   float64
+}
+
+// digest summarizes server activity between From and To.
+type digest struct {
+	From, To time.Time
+	Tenants  []tenantDigest
+	// TicketsCreated counts tickets drafted in the window, and Outcomes
+	// the tickets by the status they were left in, for those changed in
+	// it.
+	TicketsCreated int
+	Outcomes       map[string]int
+	// links are the traces of the tickets drafted in the window
+	links []trace.Link
+}
+
+// digester sends the daily digest in the background.
+type digester struct {
+	srv    *server
+	config digestConfig
+	http   *http.Client
+	faults *faults.Injector
+	since  time.Time
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startDigests starts sending srv's daily digest. It returns nil when no
+// destination is configured.
+func startDigests(ctx context.Context, srv *server, config digestConfig) *digester {
+	if !config.enabled() {
+		return nil
+	}
+	// Bad FAULT_* values are reported at startup; here they inject nothing
+	injector, _ := faults.FromEnv()
+	d := &digester{
+		srv:    srv,
+		config: config,
+		http:   &http.Client{Timeout: 10 * time.Second},
+		faults: injector,
+		since:  time.Now().UTC(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go d.loop(ctx)
+	return d
+}
+
+func (d *digester) loop(ctx context.Context) {
+	defer close(d.done)
+	for {
+		t := time.NewTimer(time.Until(d.config.next(time.Now())))
+		select {
+		case <-t.C:
+			d.run(ctx)
+		case <-d.stop:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Stop stops the digester without sending a digest.
+func (d *digester) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+}
+
+// run builds the digest of everything since the last one and sends it,
+// traced as a digest span linked to the traces of the tickets it counts.
+func (d *digester) run(ctx context.Context) {
+	to := time.Now().UTC()
+	dg, err := d.srv.collectDigest(d.since, to)
+	if err != nil {
+		log.Printf("Digest: %v", err)
+		return
+	}
+	d.since = to
+
+	var sessions, turns, failed int64
+	var cost float64
+	for _, t := range dg.Tenants {
+		sessions, turns, failed, cost = sessions+t.Sessions, turns+t.Turns, failed+t.Failed, cost+t.CostUSD
+	}
+	ctx, span := d.srv.tracer.Start(ctx, "digest",
+		trace.WithLinks(dg.links...),
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("digest.from", dg.From.Format(time.RFC3339)),
+			attribute.String("digest.to", dg.To.Format(time.RFC3339)),
+			attribute.Int64("digest.sessions", sessions),
+			attribute.Int64("digest.turns", turns),
+			attribute.Int64("digest.failed_turns", failed),
+			attribute.Int("digest.tickets_created", dg.TicketsCreated),
+			attribute.Float64("digest.cost_usd", cost),
+		),
+	)
+	defer span.End()
+
+	text := dg.text()
+	if d.config.slackURL != "" {
+		d.send(ctx, "slack", func() error { return d.postSlack(ctx, text) })
+	}
+	if len(d.config.emailTo) > 0 {
+		d.send(ctx, "email", func() error { return d.sendEmail(dg, text) })
+	}
+}
+
+// send runs one delivery in a digest.send span.
+func (d *digester) send(ctx context.Context, destination string, deliver func() error) {
+	ctx, span := d.srv.tracer.Start(ctx, "digest.send", trace.WithAttributes(attribute.String("digest.destination", destination)))
+	defer span.End()
+	if err := d.faults.Fail(ctx, destination); err != nil {
+		log.Printf("Digest: sending to %s: %v", destination, err)
+		return
+	}
+	if connector.IsDryRun(ctx) {
+		span.SetAttributes(attribute.Bool("dry_run", true))
+		log.Printf("Dry run: skipped digest to %s", destination)
+		return
+	}
+	if err := deliver(); err != nil {
+		log.Printf("Digest: sending to %s: %v", destination, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (d *digester) postSlack(ctx context.Context, text string) error {
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.slackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *digester) sendEmail(dg digest, text string) error {
+	c := d.config
+	var auth smtp.Auth
+	if c.smtpUser != "" {
+		host, _, _ := strings.Cut(c.smtpAddr, ":")
+		auth = smtp.PlainAuth("", c.smtpUser, c.smtpPassword, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: ITSM digest for %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		c.emailFrom, strings.Join(c.emailTo, ", "), dg.To.Format(time.DateOnly), strings.ReplaceAll(text, "\n", "\r\n"))
+	return smtp.SendMail(c.smtpAddr, auth, c.emailFrom, c.emailTo, []byte(msg))
+}
+
+// collectDigest gathers the activity between from and to, and resets the
+// tenants' activity counters.
+func (s *server) collectDigest(from, to time.Time) (digest, error) {
+	dg := digest{From: from, To: to, Outcomes: map[string]int{}}
+	day := from.Format(time.DateOnly)
+	for _, t := range s.tenants {
+		td := tenantDigest{
+			Name:     t.Name,
+			Sessions: t.activity.sessions.Swap(0),
+			Turns:    t.activity.turns.Swap(0),
+			Failed:   t.activity.failedTurns.Swap(0),
+		}
+		tenantScope, _ := spendScopes(t.Name, "")
+		err := s.tickets.db.QueryRow(`SELECT COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0), COALESCE(SUM(cost_usd), 0) FROM spend WHERE scope = ? AND day >= ?`,
+			tenantScope, day).Scan(&td.InputTokens, &td.OutputTokens, &td.CostUSD)
+		if err != nil {
+			return dg, fmt.Errorf("reading spend: %w", err)
+		}
+		dg.Tenants = append(dg.Tenants, td)
+	}
+
+	since := from.Format(time.RFC3339)
+	rows, err := s.tickets.db.Query(`SELECT created_at, data FROM tickets WHERE updated_at >= ? ORDER BY created_at`, since)
+	if err != nil {
+		return dg, fmt.Errorf("reading tickets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var created, data string
+		if err := rows.Scan(&created, &data); err != nil {
+			return dg, err
+		}
+		t, err := s.tickets.decodeTicket(data)
+		if err != nil {
+			continue
+		}
+		dg.Outcomes[t.Status]++
+		if created < since {
+			continue
+		}
+		dg.TicketsCreated++
+		if sc := spanContextOf(t.TraceParent, t.TraceState); sc.IsValid() && len(dg.links) < digestMaxLinks {
+			dg.links = append(dg.links, trace.Link{SpanContext: sc, Attributes: []attribute.KeyValue{attribute.String("itsm.ticket.id", t.ID)}})
+		}
+	}
+	return dg, rows.Err()
+}
+
+// text renders the digest for Slack and email.
+func (dg digest) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ITSM digest, %s to %s UTC\n", dg.From.Format("2006-01-02 15:04"), dg.To.Format("2006-01-02 15:04"))
+	statuses := make([]string, 0, len(dg.Outcomes))
+	for st := range dg.Outcomes {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	outcomes := make([]string, len(statuses))
+	for i, st := range statuses {
+		outcomes[i] = fmt.Sprintf("%d %s", dg.Outcomes[st], st)
+	}
+	fmt.Fprintf(&b, "Tickets: %d created", dg.TicketsCreated)
+	if len(outcomes) > 0 {
+		fmt.Fprintf(&b, "; now %s", strings.Join(outcomes, ", "))
+	}
+	b.WriteString("\n")
+	for _, t := range dg.Tenants {
+		errorRate := 0.0
+		if t.Turns > 0 {
+			errorRate = 100 * float64(t.Failed) / float64(t.Turns)
+		}
+		fmt.Fprintf(&b, "%s: %d sessions, %d turns (%.1f%% failed), %d input and %d output tokens, $%.2f\n",
+			t.Name, t.Sessions, t.Turns, errorRate, t.InputTokens, t.OutputTokens, t.CostUSD)
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const handoffPrompt = `You hand over an IT access request from an assistant to a human service desk agent.
+Write a short handoff note in English with these sections:
+Request: what the user wants, in one sentence
+Known: the details that are settled
+Missing: what the assistant could not get from the user
+Risks: SoD conflicts, anomalies or high-risk aspects on the ticket, or "none"
+Next step: what the agent should do first`
+
+// escalationInstruction replaces further clarifying questions once the
+// budget is spent.
+const escalationInstruction = `This request has been handed over to a human service desk agent because it is still incomplete.
+Do not ask any more questions. Tell the user briefly that a human agent will follow up on ticket %s, and summarize what the agent already knows.`
+
+// clarificationBudgetFromEnv reads ITSM_MAX_CLARIFICATIONS (default 3).
+func clarificationBudgetFromEnv() (int, error) {
+	v := os.Getenv("ITSM_MAX_CLARIFICATIONS")
+	if v == "" {
+		return 3, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ITSM_MAX_CLARIFICATIONS must be a non-negative integer, got %q", v)
+	}
+	return n, nil
+}
+
+// missingFields lists what the ticket still needs before it can be
+// submitted.
+func missingFields(t AccessRequest) []string {
+	var missing []string
+	if t.Resource == "unknown" {
+		missing = append(missing, "resource")
+	}
+	if t.AccessLevel == "unknown" {
+		missing = append(missing, "access level")
+	}
+	if t.Duration == "unknown" {
+		missing = append(missing, "duration")
+	}
+	if t.NeedsJustification {
+		missing = append(missing, "business justification")
+	}
+	return append(missing, missingTemplateFields(t)...)
+}
+
+// writeHandoff summarizes the conversation and ticket for the human agent
+// taking over.
+func writeHandoff(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model string, messages []anthropic.MessageParam, t AccessRequest) (string, error) {
+	ctx, span := tracer.Start(ctx, "escalation_handoff",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("gen_ai.request.model", model),
+			attribute.String("itsm.ticket.id", t.ID),
+		),
+	)
+	defer span.End()
+
+	var transcript strings.Builder
+	for _, m := range messages {
+		for _, block := range m.Content {
+			if block.OfText != nil {
+				fmt.Fprintf(&transcript, "%s: %s\n\n", m.Role, block.OfText.Text)
+			}
+		}
+	}
+	t.TraceParent, t.TraceState = "", ""
+	ticketJSON, _ := json.MarshalIndent(t, "", "  ")
+	fmt.Fprintf(&transcript, "Ticket:\n%s", ticketJSON)
+
+	resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: 400,
+		System: []anthropic.TextBlockParam{
+			{Text: handoffPrompt},
+		},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(transcript.String())),
+		},
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	var summary strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			summary.WriteString(block.Text)
+		}
+	}
+	span.SetAttributes(attribute.String("gen_ai.completion", summary.String()))
+	return summary.String(), nil
+}
@@ -0,0 +1,247 @@
+package itsm
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportColumns are the report columns, one row per ticket event.
+var exportColumns = []string{
+	"occurred_at", "event", "actor", "ticket_id", "requested_for", "requester_email",
+	"resource", "access_level", "duration", "risk_level", "business_justification",
+	"status", "approved_by", "connector", "external_id", "provisioned_at", "failure_reason",
+	"approval_step", "on_behalf_of", "comment",
+}
+
+// exportTickets implements "tickets export": every request, approval and
+// provisioning action in a date range, for access-review audits. The rows
+// come from the ticket revisions and approval decisions, which are kept for
+// as long as the ticket is.
+func exportTickets(args []string) error {
+	fs := flag.NewFlagSet("tickets export", flag.ContinueOnError)
+	from := fs.String("from", "", "first day to include (YYYY-MM-DD or RFC 3339); default: all history")
+	to := fs.String("to", "", "last day to include (YYYY-MM-DD or RFC 3339); default: now")
+	format := fs.String("format", "csv", "report format: csv or xlsx")
+	out := fs.String("out", "", "file to write (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "xlsx" {
+		return fmt.Errorf("--format must be csv or xlsx, got %q", *format)
+	}
+
+	start, err := parseBound(*from, false)
+	if err != nil {
+		return fmt.Errorf("--from: %w", err)
+	}
+	end, err := parseBound(*to, true)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rows, err := exportRows(store, start, end)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "xlsx" {
+		err = writeXLSX(w, "Access requests", append([][]string{exportColumns}, rows...))
+	} else {
+		cw := csv.NewWriter(w)
+		if err = cw.Write(exportColumns); err == nil {
+			err = cw.WriteAll(csvSafe(rows))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d events to %s\n", len(rows), *out)
+	}
+	return nil
+}
+
+// parseBound parses a --from or --to value into the RFC 3339 form the
+// store uses. A bare date as upper bound includes that whole day.
+func parseBound(v string, upper bool) (string, error) {
+	switch {
+	case v == "" && upper:
+		return now(), nil
+	case v == "":
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return "", fmt.Errorf("want YYYY-MM-DD or RFC 3339, got %q", v)
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t.Format(time.RFC3339), nil
+}
+
+// exportRows returns the report rows between start and end, oldest first:
+// one for each revision that created a ticket, changed its status or
+// escalated its approval, and one for each approval decision.
+func exportRows(store *TicketStore, start, end string) ([][]string, error) {
+	decisions, err := exportDecisions(store, start, end)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := exportRevisions(store, start, end, decisions)
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range decisions {
+		for _, d := range ds {
+			rows = append(rows, exportRow(store, d.DecidedAt, "approval."+d.Decision, d.Approver, d.ticket, d.approvalDecision))
+		}
+	}
+	// A stable sort keeps a ticket's events in the order they were written
+	// within a second
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows, nil
+}
+
+// exportDecision is an approval decision with the ticket as it left it.
+type exportDecision struct {
+	approvalDecision
+	ticket  AccessRequest
+	matched bool
+}
+
+// exportDecisions returns the approval decisions between start and end by
+// ticket, oldest first. Each has only the ticket's ID until exportRevisions
+// finds the revision that recorded it.
+func exportDecisions(store *TicketStore, start, end string) (map[string][]*exportDecision, error) {
+	rs, err := store.db.Query(`SELECT ticket_id, step, decision, approver, on_behalf_of, comment, created_at
+		FROM approval_decisions WHERE created_at >= ? AND created_at <= ? ORDER BY id`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	decisions := map[string][]*exportDecision{}
+	for rs.Next() {
+		var d exportDecision
+		if err := rs.Scan(&d.ticket.ID, &d.Step, &d.Decision, &d.Approver, &d.OnBehalfOf, &d.Comment, &d.DecidedAt); err != nil {
+			return nil, err
+		}
+		decisions[d.ticket.ID] = append(decisions[d.ticket.ID], &d)
+	}
+	return decisions, rs.Err()
+}
+
+// exportRevisions returns a row for each revision between start and end
+// that is a ticket event, and gives each of decisions the revision that
+// added it to the ticket's approvals.
+func exportRevisions(store *TicketStore, start, end string, decisions map[string][]*exportDecision) ([][]string, error) {
+	// Earlier revisions are read too, to tell what each one changed
+	rs, err := store.db.Query(`SELECT ticket_id, revision, actor, created_at, data FROM ticket_revisions
+		WHERE created_at <= ? ORDER BY ticket_id, revision`, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var rows [][]string
+	var previous AccessRequest
+	for rs.Next() {
+		var id, actor, createdAt, data string
+		var number int
+		if err := rs.Scan(&id, &number, &actor, &createdAt, &data); err != nil {
+			return nil, err
+		}
+		t, err := store.decodeTicket(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding revision %d of %s: %w", number, id, err)
+		}
+		if previous.ID != id {
+			previous = AccessRequest{}
+		}
+		for _, a := range t.Approvals {
+			if slices.Contains(previous.Approvals, a) {
+				continue
+			}
+			for _, d := range decisions[id] {
+				if !d.matched && d.Step == a.Step && d.Approver == a.Approver && d.DecidedAt == a.DecidedAt {
+					d.ticket, d.matched = t, true
+					break
+				}
+			}
+		}
+
+		var event string
+		switch {
+		case previous.ID == "":
+			event = "ticket.created"
+		case t.Status != previous.Status:
+			event = "ticket." + t.Status
+		case len(t.Escalations) > len(previous.Escalations):
+			event = "ticket.approval_escalated"
+		}
+		previous = t
+		if event == "" || createdAt < start {
+			continue
+		}
+		rows = append(rows, exportRow(store, createdAt, event, actor, t, approvalDecision{}))
+	}
+	return rows, rs.Err()
+}
+
+func exportRow(store *TicketStore, occurredAt, event, actor string, t AccessRequest, d approvalDecision) []string {
+	// Confidential values stay out of reports, whether sealed or not, also
+	// where a comment quotes them
+	comment := store.classes.maskText(t, d.Comment)
+	t = store.classes.mask(t)
+	return []string{
+		occurredAt, event, actor, t.ID, t.RequestedFor, t.RequesterEmail,
+		t.Resource, t.AccessLevel, t.Duration, t.RiskLevel, t.BusinessJustif,
+		t.Status, t.ApprovedBy, t.Connector, t.ExternalID, t.ProvisionedAt, t.FailureReason,
+		d.Step, d.OnBehalfOf, comment,
+	}
+}
+
+// csvSafe returns rows with each cell that a spreadsheet would run as a
+// formula prefixed with a single quote, so it opens as text. Ticket values
+// come from requesters, so "=HYPERLINK(...)" in a justification stays
+// inert for the auditor who opens the report. XLSX cells are always text.
+func csvSafe(rows [][]string) [][]string {
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		out[i] = make([]string, len(row))
+		for j, v := range row {
+			if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+				v = "'" + v
+			}
+			out[i][j] = v
+		}
+	}
+	return out
+}
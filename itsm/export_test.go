@@ -0,0 +1,109 @@
+package itsm
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+)
+
+func TestExportRows(t *testing.T) {
+	store := testStore(t)
+	ctx := context.Background()
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusDraft})
+	store.update(ctx, "AR-1", func(t *AccessRequest) error {
+		t.BusinessJustif = "on-call rotation"
+		return nil
+	})
+	store.updateTx(ctx, "AR-1", func(tx queryExecer, t *AccessRequest) error {
+		d := approvalDecision{Step: "manager", Decision: decisionApproved, Approver: "grace@example.com", DecidedAt: now()}
+		t.Status, t.ApprovedBy, t.Approvals = statusApproved, "grace@example.com", append(t.Approvals, d)
+		return addDecision(ctx, tx, t.ID, d, "")
+	})
+	store.update(ctx, "AR-1", func(t *AccessRequest) error {
+		t.Status = statusProvisioned
+		return nil
+	})
+	// The report doesn't depend on the outbox
+	if _, err := store.db.Exec(`DELETE FROM outbox`); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := exportRows(store, "", now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, row := range rows {
+		if len(row) != len(exportColumns) {
+			t.Fatalf("row has %d cells for %d columns: %q", len(row), len(exportColumns), row)
+		}
+		events = append(events, row[1])
+	}
+	slices.Sort(events)
+	want := []string{"approval.approved", "ticket.approved", "ticket.created", "ticket.provisioned"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	for _, row := range rows {
+		if row[1] == "approval.approved" && (row[2] != "grace@example.com" || row[17] != "manager" || row[11] != statusApproved) {
+			t.Errorf("decision row = %q, want grace's manager approval of the approved ticket", row)
+		}
+	}
+
+	if rows, err := exportRows(store, "2000-01-01T00:00:00Z", "2000-12-31T23:59:59Z"); err != nil || len(rows) != 0 {
+		t.Errorf("exportRows() outside the range = %q, %v, want none", rows, err)
+	}
+}
+
+// TestExportRowsMasksComments checks that a comment quoting a confidential
+// value is masked even in a fresh process, which has read no tickets yet.
+func TestExportRowsMasksComments(t *testing.T) {
+	store := testStore(t)
+	store.classes = fieldClasses{"business_justification": classConfidential}
+	store.sealer, _ = payload.NewEncryptor(bytes.Repeat([]byte{1}, 32))
+	ctx := context.Background()
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusDraft, BusinessJustif: "project falcon"})
+	store.updateTx(ctx, "AR-1", func(tx queryExecer, t *AccessRequest) error {
+		d := approvalDecision{Step: "manager", Decision: decisionApproved, Approver: "grace@example.com", Comment: "fine for project falcon", DecidedAt: now()}
+		t.Status, t.ApprovedBy, t.Approvals = statusApproved, "grace@example.com", append(t.Approvals, d)
+		return addDecision(ctx, tx, t.ID, d, "")
+	})
+
+	saved := confidentialValues
+	confidentialValues = otlpexport.NewMasker(maskedValue, maskedValueLimit)
+	t.Cleanup(func() { confidentialValues = saved })
+	rows, err := exportRows(store, "", now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if strings.Contains(strings.Join(row, ","), "falcon") {
+			t.Errorf("row %q shows the confidential justification", row)
+		}
+		if row[1] == "approval.approved" && row[19] != "fine for "+maskedValue {
+			t.Errorf("comment = %q, want the justification masked", row[19])
+		}
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"on-call rotation", "on-call rotation"},
+		{`=HYPERLINK("https://evil.example","x")`, `'=HYPERLINK("https://evil.example","x")`},
+		{"+1 555 0100", "'+1 555 0100"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"", ""},
+		{"2026-03-09T14:05:00Z", "2026-03-09T14:05:00Z"},
+	} {
+		if got := csvSafe([][]string{{tt.in}})[0][0]; got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package itsm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLoggedBody caps how much of a request or response body is logged.
+const maxLoggedBody = 4 << 10
+
+// httpLogConfig controls the server's request log.
+type httpLogConfig struct {
+	// SampleRate is the share of requests logged; 5xx responses are always
+	// logged.
+	SampleRate float64
+	// Bodies are the routes, such as /v1/sessions/{id}/turns, whose request
+	// and response bodies are logged; "*" means every route.
+	Bodies []string
+}
+
+// httpLogFromEnv reads HTTP_LOG_SAMPLE_RATE (default 1) and HTTP_LOG_BODIES
+// (default none).
+func httpLogFromEnv() (httpLogConfig, error) {
+	c := httpLogConfig{SampleRate: 1}
+	if v := os.Getenv("HTTP_LOG_SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return c, fmt.Errorf("HTTP_LOG_SAMPLE_RATE must be between 0 and 1, got %q", v)
+		}
+		c.SampleRate = rate
+	}
+	for _, route := range strings.Split(os.Getenv("HTTP_LOG_BODIES"), ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			c.Bodies = append(c.Bodies, route)
+		}
+	}
+	return c, nil
+}
+
+func (c httpLogConfig) logsBodies(pattern string) bool {
+	// Mux patterns carry the method ("POST /v1/sessions"); routes don't
+	_, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		path = pattern
+	}
+	for _, route := range c.Bodies {
+		if route == "*" || route == path {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLog collects what handlers know about a request for its log line.
+type requestLog struct {
+	sessionID string
+	traceID   string
+}
+
+type requestLogKey struct{}
+
+// noteRequest records the session and trace a request touched on its log
+// line; empty values are ignored.
+func noteRequest(ctx context.Context, sessionID, traceID string) {
+	l, ok := ctx.Value(requestLogKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	if sessionID != "" {
+		l.sessionID = sessionID
+	}
+	if traceID != "" {
+		l.traceID = traceID
+	}
+}
+
+// logRequests wraps next so a sample of requests is logged with their
+// status, latency, session and trace.
+func logRequests(c httpLogConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		note := &requestLog{}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, note))
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		// Bodies are captured for every request, since the route is only
+		// known once the mux has matched it
+		var reqBody *cappedBuffer
+		if len(c.Bodies) > 0 && r.Body != nil {
+			reqBody = &cappedBuffer{}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+			rec.body = &cappedBuffer{}
+		}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status < 500 && rand.Float64() >= c.SampleRate {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if note.sessionID != "" {
+			attrs = append(attrs, slog.String("session_id", note.sessionID))
+		}
+		if note.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", note.traceID))
+		}
+		if reqBody != nil && c.logsBodies(r.Pattern) {
+			attrs = append(attrs,
+				slog.String("request_body", reqBody.String()),
+				slog.String("response_body", rec.body.String()),
+			)
+		}
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "http request", attrs...)
+	})
+}
+
+// responseRecorder remembers the status and, when body is set, the start
+// of the body written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.body != nil {
+		r.body.Write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// cappedBuffer keeps the first maxLoggedBody bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := max(maxLoggedBody-b.Len(), 0); len(p) > room {
+		b.Buffer.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.Buffer.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "…"
+	}
+	return b.Buffer.String()
+}
@@ -0,0 +1,103 @@
+package itsm
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"go-tracing-demo/connector"
+)
+
+// inDoubtKey is an idempotency key whose operation started but never
+// recorded an outcome.
+type inDoubtKey struct {
+	Key       string
+	Operation string
+	Since     string
+}
+
+// inDoubtKeys lists the keys still pending, oldest first. A key claimed
+// moments ago may belong to an operation that is still running.
+func (s *TicketStore) inDoubtKeys() ([]inDoubtKey, error) {
+	rows, err := s.db.Query(`SELECT key, operation, updated_at FROM idempotency_keys WHERE state = 'pending' ORDER BY updated_at, key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []inDoubtKey
+	for rows.Next() {
+		var k inDoubtKey
+		if err := rows.Scan(&k.Key, &k.Operation, &k.Since); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// resolveInDoubt settles a pending key once its target system has been
+// checked. An operation that took effect is completed with externalID, the
+// grant or record it made, so a retry replays it; one that didn't has its
+// key released, so a retry runs it again.
+func (s *TicketStore) resolveInDoubt(key string, applied bool, externalID string) error {
+	var operation string
+	err := s.db.QueryRow(`SELECT operation FROM idempotency_keys WHERE key = ? AND state = 'pending'`, key).Scan(&operation)
+	if err != nil {
+		return fmt.Errorf("key %s is not in doubt: %w", key, err)
+	}
+	if !applied {
+		return s.release(key)
+	}
+	result := []byte(externalID)
+	if operation == "grant" {
+		result, _ = json.Marshal(connector.Result{ExternalID: externalID, Message: "granted, as resolved by an admin"})
+	}
+	return s.complete(key, result)
+}
+
+// inDoubtCommand implements "admin in-doubt": without a key it lists the
+// operations that never recorded an outcome, such as a grant that timed
+// out; with one it resolves that key after the target system was checked.
+func inDoubtCommand(args []string) error {
+	fs := flag.NewFlagSet("admin in-doubt", flag.ContinueOnError)
+	applied := fs.Bool("applied", false, "the operation took effect: a retry replays it instead of running it again")
+	notApplied := fs.Bool("not-applied", false, "the operation had no effect: a retry runs it again")
+	externalID := fs.String("external-id", "", "with --applied, the target system's ID for the grant or record made")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	usage := errors.New("usage: admin in-doubt [--applied [--external-id id] | --not-applied] [key]")
+	if fs.NArg() > 1 || (fs.NArg() == 1) != (*applied != *notApplied) || (*externalID != "" && !*applied) {
+		return usage
+	}
+
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if fs.NArg() == 1 {
+		if err := store.resolveInDoubt(fs.Arg(0), *applied, *externalID); err != nil {
+			return err
+		}
+		if *applied {
+			fmt.Printf("Resolved %s as applied: a retry returns its result\n", fs.Arg(0))
+		} else {
+			fmt.Printf("Released %s: a retry runs it again\n", fs.Arg(0))
+		}
+		return nil
+	}
+
+	keys, err := store.inDoubtKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Println("No idempotency keys are in doubt")
+	}
+	for _, k := range keys {
+		fmt.Printf("%s %s, pending since %s\n", k.Key, k.Operation, k.Since)
+	}
+	return nil
+}
@@ -0,0 +1,558 @@
+package itsm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+	"go-tracing-demo/connector"
+	"go-tracing-demo/console"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/ratelimit"
+	"go-tracing-demo/respcache"
+	"go-tracing-demo/survey"
+	"go-tracing-demo/tracehooks"
+)
+
+// AccessRequest is a minimal ticket object for an ITSM access request.
+type AccessRequest struct {
+	ID                 string  `json:"id"`
+	Type               string  `json:"type"` // "access_request"
+	RequestedFor       string  `json:"requested_for"`
+	RequesterEmail     string  `json:"requester_email,omitempty"`
+	Resource           string  `json:"resource"`
+	AccessLevel        string  `json:"access_level"`
+	Duration           string  `json:"duration"`
+	BusinessJustif     string  `json:"business_justification"`
+	JustificationScore float64 `json:"justification_score,omitempty"`
+	NeedsJustification bool    `json:"needs_justification,omitempty"`
+	ApprovalsRequired  string  `json:"approvals_required"`
+	RiskLevel          string  `json:"risk_level"`
+	Status             string  `json:"status"`
+	ApprovedBy         string  `json:"approved_by,omitempty"`
+	// Fields are the organization's own fields, from the ticket template.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Approvals are the decisions on the approval chain so far.
+	Approvals []approvalDecision `json:"approvals,omitempty"`
+	// Escalations are the steps that waited too long and were routed on.
+	Escalations        []approvalEscalation `json:"approval_escalations,omitempty"`
+	Connector          string               `json:"connector,omitempty"`
+	ExternalID         string               `json:"external_id,omitempty"`
+	ProvisionedAt      string               `json:"provisioned_at,omitempty"`
+	SoDConflicts       []sodConflict        `json:"sod_conflicts,omitempty"`
+	Anomalies          []anomaly            `json:"anomalies,omitempty"`
+	HandoffSummary     string               `json:"handoff_summary,omitempty"`
+	FailureReason      string               `json:"failure_reason,omitempty"`
+	CreatedAt          string               `json:"created_at"`
+	RecommendedActions string               `json:"recommended_actions"`
+	// TraceParent and TraceState carry the W3C trace context of the turn
+	// that created the ticket, so fulfillment systems can continue that
+	// trace and later actions can link back to it.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+	// Tenant is the server tenant the ticket belongs to, the tenant of the
+	// context it was created in.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ChatOptions configure RunChat. They are the go-bot-itsm command's flags.
+type ChatOptions struct {
+	// DryRun logs and traces external side effects (connector grants,
+	// webhooks) without executing them.
+	DryRun bool
+	// TranscriptPath, when set, is where the session's spans are saved on
+	// exit, for replay.
+	TranscriptPath string
+	// ImportPath continues the conversation in this OpenAI- or
+	// Anthropic-style message JSON file.
+	ImportPath string
+	// User is recorded on every span as langsmith.metadata.user_id; empty
+	// uses USER_ID.
+	User string
+	// Cache replays responses to requests seen before from disk instead of
+	// calling the API.
+	Cache bool
+	// Preview shows the ticket draft beside the chat, updated after every
+	// turn.
+	Preview bool
+	// DemoPath auto-plays the scripted scenario in this YAML file instead
+	// of reading input, and implies DryRun.
+	DemoPath string
+}
+
+// RunChat runs the interactive chat on stdin and stdout until the user
+// quits, with tracing set up from the environment.
+func RunChat(opts ChatOptions) error {
+	// A demo must never grant real access
+	if opts.DemoPath != "" {
+		opts.DryRun = true
+	}
+
+	langsmithKey := os.Getenv("LANGSMITH_API_KEY")
+	if langsmithKey == "" && !otlpexport.TracingDisabled() && otlpexport.ToLangSmith() {
+		return errors.New("LANGSMITH_API_KEY is required")
+	}
+
+	anthropicKey := os.Getenv("ANTHROPIC_API_KEY")
+	if anthropicKey == "" {
+		return errors.New("ANTHROPIC_API_KEY is required")
+	}
+
+	projectName := os.Getenv("LANGSMITH_PROJECT")
+	if projectName == "" {
+		projectName = "go-bot-itsm"
+	}
+
+	userID, err := otlpexport.UserIDFromEnv(opts.User)
+	if err != nil {
+		return err
+	}
+
+	// Initialize OTEL tracing to LangSmith
+	var wrap []otlpexport.ExporterWrapper
+	if opts.TranscriptPath != "" {
+		wrap = append(wrap, newTranscriptRecorder(opts.TranscriptPath))
+	}
+	shutdown, err := initTracer(langsmithKey, projectName, userID, wrap...)
+	if err != nil {
+		return fmt.Errorf("initializing tracer: %w", err)
+	}
+	defer shutdown()
+
+	// Turns, judges and classifiers share the key's rate limits
+	scheduler, err := ratelimit.FromEnv()
+	if err != nil {
+		return err
+	}
+	responses, err := respcache.FromEnv(opts.Cache)
+	if err != nil {
+		return err
+	}
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return err
+	}
+	client := anthropic.NewClient(
+		option.WithAPIKey(anthropicKey),
+		option.WithHTTPClient(traceanthropic.Client()),
+		tracehooks.Option(),
+		// Cache hits are answered before the scheduler holds anything back
+		responses.Option(),
+		scheduler.Option(),
+		injector.Option(),
+	)
+
+	ctx := context.Background()
+	if opts.DryRun {
+		ctx = connector.WithDryRun(ctx)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	var input lineReader = reader
+	var demo *demoPlayer
+	if opts.DemoPath != "" {
+		scenario, err := loadDemoScenario(opts.DemoPath)
+		if err != nil {
+			return err
+		}
+		demo = newDemoPlayer(scenario, os.Stdout)
+		input = demo
+	}
+	con, err := console.FromEnv(os.Stdout)
+	if err != nil {
+		return err
+	}
+	tracer := otel.Tracer("go-bot-itsm")
+
+	cannedPrompts, err := loadCannedPrompts()
+	if err != nil {
+		return fmt.Errorf("loading canned prompts: %w", err)
+	}
+
+	// Tickets (persisted when ITSM_DB is set); provision_access moves them through their lifecycle
+	tickets, err := OpenTicketStore(os.Getenv("ITSM_DB"))
+	if err != nil {
+		return fmt.Errorf("opening ticket store: %w", err)
+	}
+	defer tickets.Close()
+
+	bot, err := NewBot(&client, tracer, tickets, opts.DryRun)
+	if err != nil {
+		return err
+	}
+	s := bot.NewSession()
+	if opts.ImportPath != "" {
+		// A conversation started elsewhere continues under its own session_id
+		imported, err := chat.ImportConversation(opts.ImportPath)
+		if err != nil {
+			return err
+		}
+		s.Import(imported)
+		fmt.Printf("Imported %d messages from %s\n", len(imported.Messages), opts.ImportPath)
+	}
+
+	// Deliver ticket events from the outbox while the session runs
+	outbox := startDispatcher(ctx, tickets, tracer)
+	// and escalate approvals left waiting too long
+	approvals := startApprovalTimer(ctx, tickets, tracer, bot.approvals)
+
+	fmt.Printf("go-bot-itsm (tracing to LangSmith project: %s)\n", projectName)
+	fmt.Printf("Thread ID: %s\n", s.ID())
+	fmt.Printf("Locale: %s\n", bot.locale)
+	if opts.DryRun {
+		fmt.Println("Dry run: connector grants and webhooks are logged and traced, not executed")
+	}
+	if injector != nil {
+		fmt.Printf("Fault injection: %s\n", injector.Describe())
+	}
+	if demo != nil {
+		fmt.Printf("Demo: playing %q (%d steps)\n", demo.scenario.Name, len(demo.scenario.Steps))
+	}
+	fmt.Print("Commands: /fork, /undo, /retry [temperature], /approve, /stats, /copy [ticket], /tickets diff [id], /compact, /search <words>, /canned list, /canned run <name>. Type 'quit' to exit.\n\n")
+
+	// The last answer shown, for /copy
+	var lastReply string
+	if opts.Preview {
+		con.SetPreview(func() (string, []console.Field) {
+			ticket, ok := tickets.get(s.TicketID())
+			if s.TicketID() == "" || !ok {
+				return "Ticket draft", nil
+			}
+			return "Ticket " + ticket.ID, ticketPreview(ticket)
+		})
+	}
+
+	for {
+		con.Prompt()
+		userMessage, err := input.ReadString('\n')
+		if err != nil {
+			log.Printf("Error reading input: %v", err)
+			continue
+		}
+
+		userMessage = strings.TrimSpace(userMessage)
+		if userMessage == "" {
+			continue
+		}
+
+		if strings.ToLower(userMessage) == "quit" {
+			if spans := s.TurnSpans(); survey.Enabled() && len(spans) > 0 && demo == nil {
+				survey.Run(ctx, reader, os.Stdout, bot.feedback, spans[0], survey.Response{SessionID: s.ID(), Persona: bot.bot.Name, Turns: len(spans)})
+			}
+			approvals.Stop()
+			outbox.Stop()
+			bot.Wait()
+			fmt.Println("\nFlushing traces to LangSmith...")
+			if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+				if err := tp.ForceFlush(ctx); err != nil {
+					log.Printf("Error flushing traces: %v", err)
+				}
+			}
+			fmt.Println("Goodbye!")
+			return nil
+		}
+
+		var opts TurnOptions
+		retry := false
+		if demo != nil {
+			opts.Attributes = demo.attributes()
+		}
+
+		switch {
+		case userMessage == "/fork":
+			old := s.Fork()
+			fmt.Printf("\nForked thread %s -> %s (%d messages copied)\n\n", old, s.ID(), len(s.History()))
+			continue
+
+		case userMessage == "/undo":
+			if err := s.Undo(); err != nil {
+				fmt.Print("\nNothing to undo.\n\n")
+				continue
+			}
+			fmt.Printf("\nRemoved the last exchange (%d messages left)\n\n", len(s.History()))
+			continue
+
+		case userMessage == "/retry" || strings.HasPrefix(userMessage, "/retry "):
+			if !s.CanRewind() {
+				fmt.Print("\nNothing to retry.\n\n")
+				continue
+			}
+			if arg := strings.TrimSpace(strings.TrimPrefix(userMessage, "/retry")); arg != "" {
+				t, err := strconv.ParseFloat(arg, 64)
+				if err != nil || t < 0 || t > 1 {
+					fmt.Print("\nUsage: /retry [temperature between 0 and 1]\n\n")
+					continue
+				}
+				opts.Temperature = anthropic.Float(t)
+			}
+			retry = true
+
+		case userMessage == "/approve":
+			if s.TicketID() == "" {
+				fmt.Print("\nNo ticket to approve yet.\n\n")
+				continue
+			}
+			ticket, err := decideTicket(ctx, tracer, tickets, bot.approvals, decisionRequest{
+				TicketID: s.TicketID(), Approver: defaultApprover(), SessionID: s.ID(),
+			})
+			if err != nil {
+				fmt.Printf("\nCannot approve: %v\n\n", err)
+				continue
+			}
+			fmt.Printf("\n%s\n", describeDecision(ticket, bot.approvals))
+			for _, c := range ticket.SoDConflicts {
+				fmt.Printf("Warning: %s\n", c.Warning)
+			}
+			fmt.Println()
+			continue
+
+		case userMessage == "/compact":
+			if len(s.History()) <= 2 {
+				fmt.Print("\nNothing to compact.\n\n")
+				continue
+			}
+			stopWait := con.Wait()
+			c, err := s.compact(ctx)
+			stopWait()
+			if err != nil {
+				fmt.Printf("\nCannot compact: %v\n\n", err)
+				continue
+			}
+			fmt.Printf("\nCompacted %d messages (~%d tokens) into %d (~%d tokens)\n\n", c.MessagesBefore, c.TokensBefore, c.MessagesAfter, c.TokensAfter)
+			continue
+
+		case userMessage == "/copy":
+			if lastReply == "" {
+				fmt.Print("\nNothing to copy yet.\n\n")
+				continue
+			}
+			copyToClipboard(con, "the last reply", lastReply)
+			continue
+
+		case userMessage == "/copy ticket":
+			ticket, ok := tickets.get(s.TicketID())
+			if s.TicketID() == "" || !ok {
+				fmt.Print("\nNo ticket drafted yet.\n\n")
+				continue
+			}
+			data, _ := json.MarshalIndent(ticket, "", "  ")
+			copyToClipboard(con, "ticket "+ticket.ID, string(data))
+			continue
+
+		case userMessage == "/tickets diff" || strings.HasPrefix(userMessage, "/tickets diff "):
+			id := strings.TrimSpace(strings.TrimPrefix(userMessage, "/tickets diff"))
+			if id == "" {
+				id = s.TicketID()
+			}
+			if id == "" {
+				fmt.Print("\nNo ticket drafted yet.\n\n")
+				continue
+			}
+			fmt.Println()
+			if err := printTicketDiff(os.Stdout, tickets, id, 0, 0); err != nil {
+				fmt.Printf("Cannot diff: %v\n", err)
+			}
+			fmt.Println()
+			continue
+
+		case userMessage == "/stats":
+			printSpend(tickets, bot.budget, s.requester)
+			continue
+
+		case userMessage == "/search" || strings.HasPrefix(userMessage, "/search "):
+			query := strings.TrimSpace(strings.TrimPrefix(userMessage, "/search"))
+			if query == "" {
+				fmt.Print("\nUsage: /search <words>\n\n")
+				continue
+			}
+			hits, err := searchConversations(ctx, tracer, tickets, bot.embedder, query, searchLimit)
+			if err != nil {
+				fmt.Printf("\nSearch failed: %v\n\n", err)
+				continue
+			}
+			printSearch(os.Stdout, query, hits)
+			continue
+
+		case userMessage == "/canned list":
+			fmt.Println()
+			for _, p := range cannedPrompts {
+				fmt.Printf("  %-26s %s\n", p.Name, p.Prompt)
+			}
+			fmt.Println()
+			continue
+
+		case strings.HasPrefix(userMessage, "/canned run "):
+			name := strings.TrimSpace(strings.TrimPrefix(userMessage, "/canned run "))
+			p, ok := findCannedPrompt(cannedPrompts, name)
+			if !ok {
+				fmt.Printf("\nUnknown canned prompt %q (see /canned list)\n\n", name)
+				continue
+			}
+			opts.Canned = p.Name
+			userMessage = p.Prompt
+			con.Echo("canned", userMessage)
+
+		case strings.HasPrefix(userMessage, "/canned"):
+			fmt.Print("\nUsage: /canned list | /canned run <name>\n\n")
+			continue
+
+		default:
+			con.Sent(userMessage)
+		}
+
+		stopWait, started := con.Wait(), time.Now()
+		var result TurnResult
+		if retry {
+			result, err = s.Retry(ctx, opts)
+		} else {
+			result, err = s.Turn(ctx, userMessage, opts)
+		}
+		stopWait()
+		if errors.Is(err, boterr.ErrGuardrailBlocked) {
+			con.Reply(bot.bot.DisplayName, boterr.UserMessage(err))
+			continue
+		}
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			con.Warn(boterr.UserMessage(err))
+			continue
+		}
+		if result.BudgetWarning != "" {
+			con.Warn(result.BudgetWarning)
+		}
+		if result.ContextWarning != "" {
+			con.Warn(result.ContextWarning)
+		}
+		if result.Escalated {
+			con.Notice("Escalated " + s.TicketID() + " to a human agent")
+			fmt.Println(result.Handoff)
+		}
+		con.Reply(bot.bot.DisplayName, result.Reply)
+		lastReply = result.Reply
+		con.NotifyIfSlow(time.Since(started), bot.bot.DisplayName, result.Reply)
+	}
+}
+
+// ticketPreview is the ticket as --preview shows it, with fields the
+// bot still has to ask for left empty.
+func ticketPreview(t AccessRequest) []console.Field {
+	known := func(v string) string {
+		if v == "unknown" {
+			return ""
+		}
+		return v
+	}
+	justification := t.BusinessJustif
+	if t.NeedsJustification {
+		justification = ""
+	}
+	fields := []console.Field{
+		{Label: "Status", Value: t.Status},
+		{Label: "Requested for", Value: known(t.RequestedFor)},
+		{Label: "Resource", Value: known(t.Resource)},
+		{Label: "Access level", Value: known(t.AccessLevel)},
+		{Label: "Duration", Value: known(t.Duration)},
+		{Label: "Justification", Value: known(justification)},
+		{Label: "Risk", Value: t.RiskLevel},
+		{Label: "Approvals", Value: t.ApprovalsRequired},
+	}
+	for _, c := range t.SoDConflicts {
+		fields = append(fields, console.Field{Label: "Conflict", Value: c.Warning})
+	}
+	return fields
+}
+
+// copyToClipboard copies text and says what was copied.
+func copyToClipboard(con *console.Console, what, text string) {
+	if err := con.Copy(text); err != nil {
+		fmt.Printf("\nCannot copy %s: %v\n\n", what, err)
+		return
+	}
+	fmt.Printf("\nCopied %s to the clipboard.\n\n", what)
+}
+
+// inferAccessRequestDraft creates a small, local ticket draft object
+// This is intentionally simple and does not need perfect extraction.
+func inferAccessRequestDraft(userMessage string) AccessRequest {
+	now := time.Now().UTC().Format(time.RFC3339)
+	id := "AR-" + strings.ToUpper(uuid.New().String()[:8])
+
+	resource := "unknown"
+	accessLevel := "unknown"
+	duration := "unknown"
+
+	lower := strings.ToLower(userMessage)
+
+	// extremely lightweight heuristics
+	if strings.Contains(lower, "snowflake") {
+		resource = "snowflake"
+	}
+	if strings.Contains(lower, "datadog") {
+		resource = "datadog"
+	}
+	if strings.Contains(lower, "github") {
+		resource = "github"
+	}
+	if strings.Contains(lower, "prod") || strings.Contains(lower, "production") {
+		resource = resource + "_prod"
+	}
+
+	if strings.Contains(lower, "admin") {
+		accessLevel = "admin"
+	} else if strings.Contains(lower, "read") {
+		accessLevel = "read"
+	} else if strings.Contains(lower, "write") {
+		accessLevel = "write"
+	}
+
+	if strings.Contains(lower, "24") && strings.Contains(lower, "hour") {
+		duration = "24h"
+	} else if strings.Contains(lower, "7") && strings.Contains(lower, "day") {
+		duration = "7d"
+	}
+
+	risk := "medium"
+	if strings.Contains(lower, "prod") || strings.Contains(lower, "admin") {
+		risk = "high"
+	}
+
+	return AccessRequest{
+		ID:                 id,
+		Type:               "access_request",
+		RequestedFor:       "self",
+		Resource:           resource,
+		AccessLevel:        accessLevel,
+		Duration:           duration,
+		BusinessJustif:     "provided_in_chat",
+		ApprovalsRequired:  "manager + system_owner",
+		RiskLevel:          risk,
+		Status:             statusDraft,
+		CreatedAt:          now,
+		RecommendedActions: "collect justification; confirm duration; route for approval; provision access; log audit",
+	}
+}
+
+// turnRecord remembers an answered turn so it can be undone or regenerated.
+type turnRecord struct {
+	Prompt string
+	Canned string
+	Span   trace.SpanContext
+}
@@ -0,0 +1,134 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// justificationRubric grades what the user has said so far. The weights
+// add up to 1 so the score reads as a completeness fraction.
+const justificationRubric = `You grade the business justification in an IT access request conversation.
+Score how complete it is from 0 to 1 using this rubric:
+- purpose (0.4): the concrete task, incident or project that needs the access
+- scope (0.3): why this resource and access level, rather than something narrower
+- timeframe (0.2): why the requested duration is needed
+- reference (0.1): a ticket, incident, change or project reference
+
+Only count what the user actually said. Reply with JSON only:
+{"score": <0-1>, "justification": "<the user's justification in one or two sentences, or empty>", "missing": ["purpose" | "scope" | "timeframe" | "reference", ...], "rationale": "<one sentence>"}`
+
+// askForJustification is appended to the system prompt while the
+// justification scores below the threshold.
+const askForJustification = `The business justification is not complete enough to submit (missing: %s).
+Before anything is provisioned, ask the user for exactly these details in one short question. Do not call provision_access yet.`
+
+// justificationScore is the judge's verdict on the conversation so far.
+type justificationScore struct {
+	Score         float64  `json:"score"`
+	Justification string   `json:"justification"`
+	Missing       []string `json:"missing"`
+	Rationale     string   `json:"rationale"`
+}
+
+// justificationThresholdFromEnv reads ITSM_JUSTIFICATION_MIN (default 0.6).
+func justificationThresholdFromEnv() (float64, error) {
+	v := os.Getenv("ITSM_JUSTIFICATION_MIN")
+	if v == "" {
+		return 0.6, nil
+	}
+	min, err := strconv.ParseFloat(v, 64)
+	if err != nil || min < 0 || min > 1 {
+		return 0, fmt.Errorf("ITSM_JUSTIFICATION_MIN must be between 0 and 1, got %q", v)
+	}
+	return min, nil
+}
+
+// scoreJustification asks the judge model to rate the justification given
+// in the user's messages.
+func scoreJustification(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model string, userMessages []string) (justificationScore, error) {
+	ctx, span := tracer.Start(ctx, "justification_score",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("gen_ai.request.model", model),
+		),
+	)
+	defer span.End()
+
+	var transcript strings.Builder
+	for _, m := range userMessages {
+		fmt.Fprintf(&transcript, "User: %s\n\n", m)
+	}
+
+	score, err := func() (justificationScore, error) {
+		resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+			Model:     anthropic.Model(model),
+			MaxTokens: 300,
+			System: []anthropic.TextBlockParam{
+				{Text: justificationRubric},
+			},
+			Messages: []anthropic.MessageParam{
+				anthropic.NewUserMessage(anthropic.NewTextBlock(transcript.String())),
+			},
+		})
+		if err != nil {
+			return justificationScore{}, err
+		}
+		var text strings.Builder
+		for _, block := range resp.Content {
+			if block.Type == "text" {
+				text.WriteString(block.Text)
+			}
+		}
+
+		// Tolerate prose or code fences around the JSON object
+		raw := text.String()
+		start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}")
+		if start < 0 || end < start {
+			return justificationScore{}, errors.New("judge returned no JSON score")
+		}
+		var s justificationScore
+		if err := json.Unmarshal([]byte(raw[start:end+1]), &s); err != nil {
+			return justificationScore{}, fmt.Errorf("parsing score: %w", err)
+		}
+		s.Score = min(max(s.Score, 0), 1)
+		return s, nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return justificationScore{}, err
+	}
+
+	span.SetAttributes(
+		attribute.Float64("justification.score", score.Score),
+		attribute.StringSlice("justification.missing", score.Missing),
+		attribute.String("justification.rationale", score.Rationale),
+	)
+	return score, nil
+}
+
+// userTexts returns the text of every user message in history.
+func userTexts(history []anthropic.MessageParam) []string {
+	var texts []string
+	for _, m := range history {
+		if m.Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		for _, block := range m.Content {
+			if block.OfText != nil {
+				texts = append(texts, block.OfText.Text)
+			}
+		}
+	}
+	return texts
+}
@@ -0,0 +1,396 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/ratelimit"
+	"go-tracing-demo/tools"
+	"go-tracing-demo/tracehooks"
+)
+
+// mockReply is the mock provider's answer to a chat turn.
+const mockReply = `Request type: access request
+
+Here is your ticket draft. I have recorded the resource, access level and duration you asked for.
+
+Next steps: confirm the draft and I will route it for approval.`
+
+// runLoadTest implements "loadtest": concurrent sessions each send a number
+// of turns through the full turn pipeline, and the report covers turn
+// latency, errors and how the span exporter kept up.
+func runLoadTest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", 10, "sessions run at once")
+	turns := fs.Int("turns", 5, "turns each session sends")
+	mock := fs.Bool("mock", true, "answer model calls from a local mock instead of the Anthropic API")
+	mockLatency := fs.Duration("mock-latency", 800*time.Millisecond, "mean mock response time, varied by ±50%")
+	mockErrorRate := fs.Float64("mock-error-rate", 0, "probability between 0 and 1 that a mock call returns a 500")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 || *turns < 1 {
+		return errors.New("loadtest: --concurrency and --turns must be at least 1")
+	}
+	if *mockErrorRate < 0 || *mockErrorRate > 1 {
+		return errors.New("loadtest: --mock-error-rate must be between 0 and 1")
+	}
+
+	clientOpts := []option.RequestOption{option.WithHTTPClient(traceanthropic.Client()), tracehooks.Option()}
+	if *mock {
+		provider := httptest.NewServer(mockProvider(*mockLatency, *mockErrorRate))
+		defer provider.Close()
+		clientOpts = append(clientOpts, option.WithBaseURL(provider.URL), option.WithAPIKey("mock"))
+	} else {
+		anthropicKey := os.Getenv("ANTHROPIC_API_KEY")
+		if anthropicKey == "" {
+			return errors.New("ANTHROPIC_API_KEY is required without --mock")
+		}
+		clientOpts = append(clientOpts, option.WithAPIKey(anthropicKey))
+	}
+
+	stats := &exportStats{}
+	shutdown, err := initCommandTracer(stats.wrap)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	if !ok {
+		return errors.New("loadtest needs the SDK tracer provider")
+	}
+	tp.RegisterSpanProcessor(stats)
+
+	// Like simulate, nothing leaves the process except traces and feedback
+	tickets, err := OpenTicketStore("")
+	if err != nil {
+		return err
+	}
+	defer tickets.Close()
+	scheduler, err := ratelimit.FromEnv()
+	if err != nil {
+		return err
+	}
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return err
+	}
+	client := anthropic.NewClient(append(clientOpts, scheduler.Option(), injector.Option())...)
+	tracer := otel.Tracer("go-bot-itsm")
+	bot, err := NewBot(&client, tracer, tickets, true)
+	if err != nil {
+		return err
+	}
+	prompts, err := loadCannedPrompts()
+	if err != nil {
+		return err
+	}
+	if len(prompts) == 0 {
+		return errors.New("loadtest: the canned prompt library is empty")
+	}
+	ctx := connector.WithDryRun(context.Background())
+
+	loadtestID := uuid.New().String()
+	fmt.Printf("Load test %s: %d sessions x %d turns (mock provider: %t)\n", loadtestID, *concurrency, *turns, *mock)
+
+	// Sample the exporter backlog while the load runs
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats.sample()
+			case <-stopSampling:
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var failures int
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := bot.NewSession()
+			for t := range *turns {
+				p := prompts[(i+t)%len(prompts)]
+				turnStart := time.Now()
+				_, err := s.Turn(ctx, p.Prompt, TurnOptions{
+					Canned: p.Name,
+					Attributes: []attribute.KeyValue{
+						attribute.String("langsmith.metadata.loadtest_id", loadtestID),
+						attribute.Bool("langsmith.metadata.mock_provider", *mock),
+					},
+				})
+				elapsed := time.Since(turnStart)
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					failures++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+	backlog := stats.backlog()
+
+	bot.Wait()
+	flushStart := time.Now()
+	if err := tp.ForceFlush(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Flushing traces: %v\n", err)
+	}
+	flushTime := time.Since(flushStart)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	total := len(latencies)
+	report := loadReport{
+		Turns:      total,
+		Errors:     failures,
+		ErrorRate:  float64(failures) / float64(total),
+		Elapsed:    elapsed,
+		Throughput: float64(total) / elapsed.Seconds(),
+		P50:        percentile(latencies, 0.50),
+		P95:        percentile(latencies, 0.95),
+		P99:        percentile(latencies, 0.99),
+		Max:        latencies[total-1],
+		Ended:      stats.ended.Load(),
+		Exported:   stats.exported.Load(),
+		Failed:     stats.failed.Load(),
+		Batches:    stats.batches.Load(),
+		MaxBacklog: stats.maxBacklog.Load(),
+		Backlog:    backlog,
+		FlushTime:  flushTime,
+		LastError:  stats.lastError(),
+	}
+	// Spans the batcher never handed to the exporter were dropped from its queue
+	report.Dropped = max(report.Ended-report.Exported-report.Failed, 0)
+	report.print(os.Stdout)
+
+	// The summary goes out as its own trace once the load has been exported
+	_, span := tracer.Start(ctx, "loadtest_summary")
+	span.SetAttributes(append(report.attributes(),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("langsmith.metadata.loadtest_id", loadtestID),
+		attribute.Int("loadtest.concurrency", *concurrency),
+		attribute.Int("loadtest.turns_per_session", *turns),
+		attribute.Bool("loadtest.mock_provider", *mock),
+	)...)
+	span.End()
+	if err := tp.ForceFlush(ctx); err != nil {
+		return fmt.Errorf("flushing traces: %w", err)
+	}
+	return nil
+}
+
+// loadReport is the outcome of a load test.
+type loadReport struct {
+	Turns, Errors      int
+	ErrorRate          float64
+	Elapsed            time.Duration
+	Throughput         float64
+	P50, P95, P99, Max time.Duration
+
+	// Exporter behavior, in spans
+	Ended, Exported, Failed, Dropped, Batches int64
+	MaxBacklog, Backlog                       int64
+	FlushTime                                 time.Duration
+	LastError                                 string
+}
+
+func (r loadReport) print(w io.Writer) {
+	fmt.Fprintf(w, "\nTurns:      %d in %s (%.1f/s)\n", r.Turns, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	fmt.Fprintf(w, "Errors:     %d (%.1f%%)\n", r.Errors, 100*r.ErrorRate)
+	fmt.Fprintf(w, "Latency:    p50 %s  p95 %s  p99 %s  max %s\n",
+		r.P50.Round(time.Millisecond), r.P95.Round(time.Millisecond), r.P99.Round(time.Millisecond), r.Max.Round(time.Millisecond))
+	fmt.Fprintf(w, "Spans:      %d ended, %d exported in %d batches, %d failed, %d dropped\n", r.Ended, r.Exported, r.Batches, r.Failed, r.Dropped)
+	fmt.Fprintf(w, "Backlog:    max %d spans, %d when the load stopped; final flush took %s\n", r.MaxBacklog, r.Backlog, r.FlushTime.Round(time.Millisecond))
+	if r.LastError != "" {
+		fmt.Fprintf(w, "Last export error: %s\n", r.LastError)
+	}
+}
+
+func (r loadReport) attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("loadtest.turns", r.Turns),
+		attribute.Int("loadtest.errors", r.Errors),
+		attribute.Float64("loadtest.error_rate", r.ErrorRate),
+		attribute.Float64("loadtest.throughput_per_s", r.Throughput),
+		attribute.Int64("loadtest.latency.p50_ms", r.P50.Milliseconds()),
+		attribute.Int64("loadtest.latency.p95_ms", r.P95.Milliseconds()),
+		attribute.Int64("loadtest.latency.p99_ms", r.P99.Milliseconds()),
+		attribute.Int64("loadtest.latency.max_ms", r.Max.Milliseconds()),
+		attribute.Int64("loadtest.spans.ended", r.Ended),
+		attribute.Int64("loadtest.spans.exported", r.Exported),
+		attribute.Int64("loadtest.spans.failed", r.Failed),
+		attribute.Int64("loadtest.spans.dropped", r.Dropped),
+		attribute.Int64("loadtest.export.batches", r.Batches),
+		attribute.Int64("loadtest.export.max_backlog", r.MaxBacklog),
+		attribute.Int64("loadtest.export.flush_ms", r.FlushTime.Milliseconds()),
+	}
+	if r.LastError != "" {
+		attrs = append(attrs, attribute.String("loadtest.export.last_error", r.LastError))
+	}
+	return attrs
+}
+
+// percentile returns the p-th percentile of sorted (nearest rank).
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// exportStats counts spans as they end and as the exporter ships them; the
+// difference is what is waiting in (or was dropped from) the batch queue.
+type exportStats struct {
+	ended, exported, failed, batches atomic.Int64
+	maxBacklog                       atomic.Int64
+
+	mu      sync.Mutex
+	lastErr string
+}
+
+func (s *exportStats) wrap(exp sdktrace.SpanExporter) sdktrace.SpanExporter {
+	return &countingExporter{SpanExporter: exp, stats: s}
+}
+
+func (s *exportStats) backlog() int64 {
+	return s.ended.Load() - s.exported.Load() - s.failed.Load()
+}
+
+func (s *exportStats) sample() {
+	b := s.backlog()
+	for {
+		cur := s.maxBacklog.Load()
+		if b <= cur || s.maxBacklog.CompareAndSwap(cur, b) {
+			return
+		}
+	}
+}
+
+func (s *exportStats) lastError() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// OnEnd implements sdktrace.SpanProcessor; the other methods are no-ops.
+func (s *exportStats) OnEnd(sdktrace.ReadOnlySpan)                     { s.ended.Add(1) }
+func (s *exportStats) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+func (s *exportStats) Shutdown(context.Context) error                  { return nil }
+func (s *exportStats) ForceFlush(context.Context) error                { return nil }
+
+// countingExporter records every export batch in stats.
+type countingExporter struct {
+	sdktrace.SpanExporter
+	stats *exportStats
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	e.stats.batches.Add(1)
+	if err != nil {
+		e.stats.failed.Add(int64(len(spans)))
+		e.stats.mu.Lock()
+		e.stats.lastErr = err.Error()
+		e.stats.mu.Unlock()
+		return err
+	}
+	e.stats.exported.Add(int64(len(spans)))
+	return nil
+}
+
+// mockProvider answers Anthropic Messages API calls after a random delay,
+// picking a reply that parses for each of the bot's prompts.
+func mockProvider(latency time.Duration, errorRate float64) http.Handler {
+	plannerPrefix, _, _ := strings.Cut(plannerInstruction, "\n")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model  string `json:"model"`
+			System []struct {
+				Text string `json:"text"`
+			} `json:"system"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var system strings.Builder
+		for _, block := range req.System {
+			system.WriteString(block.Text)
+		}
+
+		select {
+		case <-time.After(time.Duration(float64(latency) * (0.5 + rand.Float64()))):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if rand.Float64() < errorRate {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"type": "error", "error": {"type": "api_error", "message": "mock provider failure"}}`)
+			return
+		}
+
+		text := mockReply
+		switch prompt := system.String(); {
+		case strings.Contains(prompt, topicClassifierPrompt):
+			text = "on_topic"
+		case strings.Contains(prompt, justificationRubric):
+			text = `{"score": 0.8, "justification": "Needs the access for the quarterly reporting project.", "missing": ["reference"], "rationale": "Mock verdict."}`
+		case strings.Contains(prompt, handoffPrompt):
+			text = "Mock handoff: the requester did not complete the ticket."
+		case strings.Contains(prompt, plannerPrefix):
+			text = `{"steps": [{"kind": "analyze", "description": "Check the request against policy"}]}`
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":            "msg_mock_" + uuid.New().String()[:8],
+			"type":          "message",
+			"role":          "assistant",
+			"model":         req.Model,
+			"content":       []map[string]any{{"type": "text", "text": text}},
+			"stop_reason":   "end_turn",
+			"stop_sequence": nil,
+			"usage": map[string]int{
+				"input_tokens":  tools.EstimateTokens(system.String()) + 200,
+				"output_tokens": tools.EstimateTokens(text),
+			},
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package itsm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// languageNames maps supported locales to the language the model falls back to.
+var languageNames = map[string]string{
+	"en": "English",
+	"de": "German",
+	"fr": "French",
+	"es": "Spanish",
+	"nl": "Dutch",
+}
+
+// resolveLocale returns BOT_LOCALE if set, otherwise the language of the
+// process environment (LC_ALL, LANG), falling back to "en".
+func resolveLocale() string {
+	for _, key := range []string{"BOT_LOCALE", "LC_ALL", "LANG"} {
+		// "de_DE.UTF-8" -> "de"
+		lang, _, _ := strings.Cut(os.Getenv(key), ".")
+		lang, _, _ = strings.Cut(lang, "_")
+		lang, _, _ = strings.Cut(lang, "-")
+		lang = strings.ToLower(lang)
+		if lang != "" && lang != "c" && lang != "posix" {
+			return lang
+		}
+	}
+	return "en"
+}
+
+// localizedSystemPrompt appends an instruction to answer in whatever
+// language the user writes in to a persona's system prompt.
+func localizedSystemPrompt(prompt, locale string) string {
+	if prompt == "" {
+		return languageInstruction(locale)
+	}
+	return prompt + "\n\n" + languageInstruction(locale)
+}
+
+// languageInstruction tells the model to mirror the user's language, using
+// the configured locale only when the language is unclear.
+func languageInstruction(locale string) string {
+	name, ok := languageNames[locale]
+	if !ok {
+		name = languageNames["en"]
+	}
+	return fmt.Sprintf("Always reply in the language the user writes in. If it is unclear, reply in %s.", name)
+}
@@ -0,0 +1,274 @@
+package itsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+)
+
+// defaultCompletionTokens is max_tokens for a chat completion that doesn't
+// set one; the OpenAI API has no required limit, the Messages API does.
+const defaultCompletionTokens = 1024
+
+// completionRequest is the part of an OpenAI chat completion request the
+// facade understands. Other fields, such as n or tools, are ignored.
+type completionRequest struct {
+	Model               string             `json:"model"`
+	Messages            []chat.ChatMessage `json:"messages"`
+	MaxTokens           int64              `json:"max_tokens"`
+	MaxCompletionTokens int64              `json:"max_completion_tokens"`
+	Temperature         *float64           `json:"temperature"`
+	Stop                json.RawMessage    `json:"stop"`
+	Stream              bool               `json:"stream"`
+	StreamOptions       struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type completionMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type completionChoice struct {
+	Index        int                `json:"index"`
+	Message      *completionMessage `json:"message,omitempty"`
+	Delta        *completionMessage `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type completionUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+type completionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   *completionUsage   `json:"usage,omitempty"`
+}
+
+// openAIFinishReasons maps Anthropic stop reasons to OpenAI finish reasons.
+var openAIFinishReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+	"refusal":       "content_filter",
+}
+
+// chatCompletions serves POST /v1/chat/completions, so OpenAI clients and
+// SDKs can use the tenant's model by pointing their base URL at the
+// server. The conversation is sent as it is, with the client's system
+// messages as the system prompt (the bot's when there are none) and
+// without the bot's tools or ticket drafting. A request for a Claude model
+// gets it; any other model name gets the bot's chat model. Completions
+// count against the tenant's and the caller's spend budgets like turns. The
+// call is traced as a chat_completions span above the provider's span.
+func (s *server) chatCompletions(w http.ResponseWriter, r *http.Request, p *principal) {
+	var req completionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	system, messages, err := chat.ConvertMessages(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, errors.New("messages must include a user message"))
+		return
+	}
+	stop, err := stopSequences(req.Stop)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t := p.tenant
+	model := t.bot.models.Chat
+	if resolved, err := chat.ResolveModel(req.Model); err == nil {
+		model = resolved
+	}
+	params := anthropic.MessageNewParams{
+		Model:         anthropic.Model(model),
+		MaxTokens:     defaultCompletionTokens,
+		Messages:      messages,
+		StopSequences: stop,
+		System:        []anthropic.TextBlockParam{{Text: t.bot.systemPrompt}},
+	}
+	if len(system) > 0 {
+		params.System = []anthropic.TextBlockParam{{Text: strings.Join(system, "\n\n")}}
+	}
+	switch {
+	case req.MaxCompletionTokens > 0:
+		params.MaxTokens = req.MaxCompletionTokens
+	case req.MaxTokens > 0:
+		params.MaxTokens = req.MaxTokens
+	}
+	if req.Temperature != nil {
+		// OpenAI's range is 0-2, Anthropic's 0-1
+		params.Temperature = anthropic.Float(min(*req.Temperature/2, 1))
+	}
+
+	release, err := t.quota.acquire()
+	if err != nil {
+		writeOpenAIError(w, boterr.HTTPStatus(err), err)
+		return
+	}
+	defer release()
+
+	ctx, span := s.tracer.Start(withTenant(r.Context(), t.Name), "chat_completions", trace.WithAttributes(
+		attribute.String("langsmith.trace.name", "chat_completions"),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("openai.request.model", req.Model),
+		attribute.String("gen_ai.request.model", model),
+		attribute.Bool("openai.request.stream", req.Stream),
+		attribute.Int("conversation.message_count", len(messages)),
+	))
+	defer span.End()
+	span.SetAttributes(p.attributes()...)
+	t.bot.payloads.JSON(span, "openai.request.messages_json", req.Messages)
+
+	// Over a budget, no model call is made
+	if _, err := t.bot.checkBudget(ctx, span, p.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeOpenAIError(w, boterr.HTTPStatus(err), errors.New(boterr.UserMessage(err)))
+		return
+	}
+
+	id := "chatcmpl-" + uuid.New().String()
+	created := time.Now().Unix()
+	chunk := func(choice completionChoice, usage *completionUsage) completionResponse {
+		resp := completionResponse{ID: id, Object: "chat.completion.chunk", Created: created, Model: model, Usage: usage}
+		if choice.Delta != nil || choice.FinishReason != nil {
+			resp.Choices = []completionChoice{choice}
+		} else {
+			resp.Choices = []completionChoice{}
+		}
+		return resp
+	}
+
+	var onDelta func(string)
+	var send func(data any)
+	if req.Stream {
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		send = func(data any) {
+			body, _ := json.Marshal(data)
+			fmt.Fprintf(w, "data: %s\n\n", body)
+			rc.Flush()
+		}
+		send(chunk(completionChoice{Delta: &completionMessage{Role: "assistant"}}, nil))
+		onDelta = func(text string) {
+			send(chunk(completionChoice{Delta: &completionMessage{Content: text}}, nil))
+		}
+	}
+
+	completion, err := chat.GenerateStream(ctx, t.bot.client, params, nil, onDelta)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if req.Stream {
+			send(map[string]any{"error": openAIError{Message: err.Error(), Type: "api_error"}})
+			return
+		}
+		writeOpenAIError(w, boterr.HTTPStatus(err), err)
+		return
+	}
+	t.bot.recordSpend(ctx, span, p.ID, model, completion)
+	finish := "stop"
+	if n := len(completion.FinishReasons); n > 0 {
+		if f, ok := openAIFinishReasons[completion.FinishReasons[n-1]]; ok {
+			finish = f
+		}
+	}
+	usage := &completionUsage{
+		PromptTokens:     completion.InputTokens,
+		CompletionTokens: completion.OutputTokens,
+		TotalTokens:      completion.InputTokens + completion.OutputTokens,
+	}
+	t.bot.payloads.String(span, "gen_ai.completion", completion.Text)
+	span.SetAttributes(
+		attribute.Int64("gen_ai.usage.input_tokens", completion.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", completion.OutputTokens),
+		attribute.StringSlice("gen_ai.response.finish_reasons", completion.FinishReasons),
+	)
+
+	if req.Stream {
+		send(chunk(completionChoice{Delta: &completionMessage{}, FinishReason: &finish}, nil))
+		if req.StreamOptions.IncludeUsage {
+			send(chunk(completionChoice{}, usage))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+	writeJSON(w, http.StatusOK, completionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []completionChoice{{
+			Message:      &completionMessage{Role: "assistant", Content: completion.Text},
+			FinishReason: &finish,
+		}},
+		Usage: usage,
+	})
+}
+
+// stopSequences reads OpenAI's stop, a string or a list of strings.
+func stopSequences(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, errors.New("stop must be a string or a list of strings")
+	}
+	return list, nil
+}
+
+type openAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// writeOpenAIError writes err in the OpenAI API's error shape, which its
+// SDKs parse.
+func writeOpenAIError(w http.ResponseWriter, status int, err error) {
+	kind := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		kind = "invalid_request_error"
+	case http.StatusTooManyRequests:
+		kind = "rate_limit_error"
+	}
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, status, map[string]openAIError{"error": {Message: err.Error(), Type: kind}})
+}
@@ -0,0 +1,74 @@
+package itsm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go-tracing-demo/chat"
+)
+
+func TestChatCompletionsBudget(t *testing.T) {
+	var calls atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"m","content":[{"type":"text","text":"hello"}],"stop_reason":"end_turn","usage":{"input_tokens":1000,"output_tokens":1000}}`))
+	}))
+	defer api.Close()
+	client := anthropic.NewClient(option.WithBaseURL(api.URL), option.WithAPIKey("k"), option.WithMaxRetries(0))
+
+	store := testStore(t)
+	s := authServer(true)
+	s.tracer = noop.NewTracerProvider().Tracer("")
+	acme := s.tenants[0]
+	acme.quota = newQuota(0, 0)
+	acme.bot = &Bot{
+		client:  &client,
+		tickets: store,
+		budget:  budgetConfig{UserDailyUSD: 1},
+		models:  chat.Models{Chat: chat.DefaultChatModel},
+	}
+	day, _ := periodStarts(time.Now())
+	_, overBudget := spendScopes("acme", "ada@example.com")
+	if err := store.addSpend(overBudget, day, 0, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		key       string
+		status    int
+		wantCalls int32
+	}{
+		{key: "ada-key", status: http.StatusTooManyRequests},
+		{key: "acme-token", status: http.StatusOK, wantCalls: 1},
+	} {
+		calls.Store(0)
+		r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		r.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		s.authorize(roleRequester, s.chatCompletions)(w, r)
+		if w.Code != tt.status || calls.Load() != tt.wantCalls {
+			t.Errorf("%s: status %d with %d model calls, want %d with %d (%s)", tt.key, w.Code, calls.Load(), tt.status, tt.wantCalls, w.Body)
+		}
+		if tt.status == http.StatusTooManyRequests && !strings.Contains(w.Body.String(), `"type":"rate_limit_error"`) {
+			t.Errorf("%s: body %s, want an OpenAI rate_limit_error", tt.key, w.Body)
+		}
+	}
+
+	// The tenant token's completion counts against its own budget and the
+	// tenant's
+	tenantScope, callerScope := spendScopes("acme", "tenant:acme")
+	for _, scope := range []string{callerScope, tenantScope} {
+		if spent, err := store.spent(scope, day); err != nil || spent <= 0 {
+			t.Errorf("spent(%s) = %v, %v, want the completion's cost", scope, spent, err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package itsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+)
+
+const (
+	// outboxBatch is how many events one dispatcher run delivers at most.
+	outboxBatch = 20
+	// outboxMaxAttempts is how often an event is tried before it is
+	// marked dead and left for an operator.
+	outboxMaxAttempts = 10
+	// outboxMaxBackoff caps the delay between retries.
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// errDeliverySkipped is returned by deliver in a dry run, which leaves the
+// event pending for a real run.
+var errDeliverySkipped = errors.New("delivery skipped in dry run")
+
+// outboxEvent is the JSON body delivered for a ticket change or review
+// request. Receivers should dedupe on ID; delivery is at least once.
+// TraceParent and TraceState are the W3C trace context of the change, for
+// receivers that continue the trace.
+type outboxEvent struct {
+	ID          string         `json:"id"`
+	Type        string         `json:"type"`
+	OccurredAt  string         `json:"occurred_at"`
+	TraceParent string         `json:"traceparent,omitempty"`
+	TraceState  string         `json:"tracestate,omitempty"`
+	Ticket      *AccessRequest `json:"ticket,omitempty"`
+	Review      *reviewRequest `json:"review,omitempty"`
+}
+
+// enqueue records event inside the caller's transaction, so the event
+// exists if and only if the change it announces is committed.
+func enqueue(ctx context.Context, tx execer, event outboxEvent) error {
+	ts := now()
+	event.ID = uuid.New().String()
+	event.OccurredAt = ts
+	event.TraceParent, event.TraceState = traceContext(ctx)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (event_id, type, payload, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		event.ID, event.Type, string(payload), ts, ts)
+	return err
+}
+
+// dispatcher delivers outbox events to a webhook in the background,
+// retrying failed deliveries with exponential backoff.
+type dispatcher struct {
+	store    *TicketStore
+	tracer   trace.Tracer
+	url      string
+	http     *http.Client
+	interval time.Duration
+	faults   *faults.Injector
+	// skippedUpTo is the last event a dry run skipped; later runs start
+	// after it rather than skip the same events again.
+	skippedUpTo int64
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// startDispatcher starts delivering to ITSM_WEBHOOK_URL. It returns nil
+// when no webhook is configured; events then stay in the outbox.
+func startDispatcher(ctx context.Context, store *TicketStore, tracer trace.Tracer) *dispatcher {
+	url := os.Getenv("ITSM_WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+	// Bad FAULT_* values are reported at startup; here they inject nothing
+	injector, _ := faults.FromEnv()
+	d := &dispatcher{
+		store:    store,
+		tracer:   tracer,
+		url:      url,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		interval: time.Second,
+		faults:   injector,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go d.loop(ctx)
+	return d
+}
+
+func (d *dispatcher) loop(ctx context.Context) {
+	defer close(d.done)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.run(ctx)
+		case <-d.stop:
+			// One last pass so events from the final turn are not left behind
+			d.run(ctx)
+			return
+		}
+	}
+}
+
+// Stop delivers what is due and stops the dispatcher.
+func (d *dispatcher) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+}
+
+// pendingEvent is an outbox row due for delivery.
+type pendingEvent struct {
+	id       int64
+	eventID  string
+	typ      string
+	payload  string
+	attempts int
+}
+
+// run delivers the events that are due. Runs with nothing to deliver are
+// not traced, so an idle dispatcher doesn't flood the project.
+func (d *dispatcher) run(ctx context.Context) {
+	events, err := d.due()
+	if err != nil {
+		log.Printf("Outbox: loading events: %v", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, span := d.tracer.Start(ctx, "outbox.dispatch", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.Int("outbox.batch_size", len(events)),
+	))
+	defer span.End()
+
+	var delivered, skipped, failed int
+	for _, e := range events {
+		err := d.deliver(ctx, e)
+		if connector.IsDryRun(ctx) {
+			// Nothing was sent, so the event stays pending for a real run,
+			// and neither a skip nor an injected fault counts as an attempt
+			d.skippedUpTo = max(d.skippedUpTo, e.id)
+			if errors.Is(err, errDeliverySkipped) {
+				skipped++
+			} else {
+				failed++
+			}
+			continue
+		}
+		if err != nil {
+			failed++
+			d.retryLater(e, err)
+			continue
+		}
+		delivered++
+		if _, err := d.store.db.Exec(`UPDATE outbox SET state = 'delivered', attempts = attempts + 1, delivered_at = ?, last_error = NULL WHERE id = ?`,
+			now(), e.id); err != nil {
+			log.Printf("Outbox: marking %s delivered: %v", e.eventID, err)
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("outbox.delivered", delivered),
+		attribute.Int("outbox.skipped", skipped),
+		attribute.Int("outbox.failed", failed),
+	)
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d deliveries failed", failed, len(events)))
+	}
+}
+
+func (d *dispatcher) due() ([]pendingEvent, error) {
+	rows, err := d.store.db.Query(`SELECT id, event_id, type, payload, attempts FROM outbox
+		WHERE state = 'pending' AND next_attempt_at <= ? AND id > ? ORDER BY id LIMIT ?`, now(), d.skippedUpTo, outboxBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err := rows.Scan(&e.id, &e.eventID, &e.typ, &e.payload, &e.attempts); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// deliver posts one event to the webhook in a client span, linked to the
+// trace that produced the event.
+func (d *dispatcher) deliver(ctx context.Context, e pendingEvent) error {
+	var origin struct {
+		TraceParent string `json:"traceparent"`
+		TraceState  string `json:"tracestate"`
+	}
+	json.Unmarshal([]byte(e.payload), &origin)
+	var links []trace.Link
+	if sc := spanContextOf(origin.TraceParent, origin.TraceState); sc.IsValid() {
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+	ctx, span := d.tracer.Start(ctx, "outbox.deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("outbox.event_id", e.eventID),
+			attribute.String("outbox.event_type", e.typ),
+			attribute.String("idempotency.key", e.eventID),
+			attribute.Int("outbox.attempt", e.attempts+1),
+			attribute.String("http.request.method", http.MethodPost),
+			attribute.String("url.full", d.url),
+		),
+	)
+	defer span.End()
+
+	if err := d.faults.Fail(ctx, "webhook"); err != nil {
+		return err
+	}
+	if connector.IsDryRun(ctx) {
+		span.SetAttributes(attribute.Bool("dry_run", true))
+		log.Printf("Dry run: skipped webhook %s for %s", e.typ, e.eventID)
+		return errDeliverySkipped
+	}
+
+	err := func() error {
+		// Receivers fulfil the request, so they get confidential values
+		body, err := d.store.openEvent(e.payload)
+		if err != nil {
+			return fmt.Errorf("opening event: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader([]byte(body)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", e.eventID)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+		resp, err := d.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook returned %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// retryLater schedules the next attempt, or marks the event dead after
+// outboxMaxAttempts.
+func (d *dispatcher) retryLater(e pendingEvent, cause error) {
+	attempts := e.attempts + 1
+	state := "pending"
+	if attempts >= outboxMaxAttempts {
+		state = "dead"
+		log.Printf("Outbox: giving up on %s %s after %d attempts: %v", e.typ, e.eventID, attempts, cause)
+	}
+	backoff := min(time.Second<<attempts, outboxMaxBackoff)
+	next := time.Now().UTC().Add(backoff).Format(time.RFC3339)
+	if _, err := d.store.db.Exec(`UPDATE outbox SET state = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		state, attempts, next, cause.Error(), e.id); err != nil {
+		log.Printf("Outbox: rescheduling %s: %v", e.eventID, err)
+	}
+}
@@ -0,0 +1,45 @@
+package itsm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go-tracing-demo/connector"
+)
+
+func TestDispatcherDryRunLeavesEventsPending(t *testing.T) {
+	store := testStore(t)
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusDraft})
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { posts.Add(1) }))
+	defer srv.Close()
+	newDispatcher := func() *dispatcher {
+		return &dispatcher{store: store, tracer: noop.NewTracerProvider().Tracer(""), url: srv.URL, http: srv.Client()}
+	}
+	state := func() (string, int) {
+		var state string
+		var attempts int
+		if err := store.db.QueryRow(`SELECT state, attempts FROM outbox ORDER BY id LIMIT 1`).Scan(&state, &attempts); err != nil {
+			t.Fatal(err)
+		}
+		return state, attempts
+	}
+
+	dry := newDispatcher()
+	for range 2 {
+		dry.run(connector.WithDryRun(context.Background()))
+	}
+	if st, attempts := state(); st != "pending" || attempts != 0 || posts.Load() != 0 {
+		t.Fatalf("after a dry run: %s with %d attempts and %d posts, want pending, untouched and unsent", st, attempts, posts.Load())
+	}
+
+	newDispatcher().run(context.Background())
+	if st, attempts := state(); st != "delivered" || attempts != 1 || posts.Load() != 1 {
+		t.Fatalf("after a real run: %s with %d attempts and %d posts, want delivered once", st, attempts, posts.Load())
+	}
+}
@@ -0,0 +1,46 @@
+package itsm
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed resource_owners.json
+var defaultResourceOwners []byte
+
+// unassignedOwner reviews grants on resources with no listed owner.
+const unassignedOwner = "unassigned"
+
+// resourceOwners maps a resource (without the _prod suffix) to the person
+// or team that reviews access to it.
+type resourceOwners map[string]string
+
+// loadResourceOwners reads the owners from ITSM_RESOURCE_OWNERS if set,
+// otherwise it falls back to the built-in resource_owners.json.
+func loadResourceOwners() (resourceOwners, error) {
+	data := defaultResourceOwners
+	if path := os.Getenv("ITSM_RESOURCE_OWNERS"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading resource owners: %w", err)
+		}
+	}
+
+	var owners resourceOwners
+	if err := json.Unmarshal(data, &owners); err != nil {
+		return nil, fmt.Errorf("parsing resource owners: %w", err)
+	}
+	return owners, nil
+}
+
+// ownerOf returns who reviews access to resource.
+func (o resourceOwners) ownerOf(resource string) string {
+	if owner, ok := o[strings.TrimSuffix(resource, "_prod")]; ok {
+		return owner
+	}
+	return unassignedOwner
+}
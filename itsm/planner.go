@@ -0,0 +1,212 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxPlanSteps bounds how many steps of a plan are executed per turn.
+const maxPlanSteps = 6
+
+const plannerInstruction = `Before replying, plan how to handle the user's latest message.
+Respond with only a JSON object, no prose:
+{"steps": [{"kind": "analyze" | "ask" | "tool", "description": "...", "tool": "<tool name, for tool steps>", "input": {<tool input, for tool steps>}}]}
+
+- analyze: work something out (classify the request, check policy, assess risk)
+- ask: a clarifying question to put to the user
+- tool: call one of the available tools
+Use at most %d steps. Available tools: %s.`
+
+// planStep is one step of a plan produced in planner mode.
+type planStep struct {
+	Kind        string          `json:"kind"`
+	Description string          `json:"description"`
+	Tool        string          `json:"tool,omitempty"`
+	Input       json.RawMessage `json:"input,omitempty"`
+}
+
+type plan struct {
+	Steps []planStep `json:"steps"`
+}
+
+// stepResult is what executing a plan step produced.
+type stepResult struct {
+	Step   planStep
+	Output string
+	Err    error
+}
+
+// toolRunner executes a named tool with JSON input and returns its result.
+type toolRunner func(ctx context.Context, name string, input json.RawMessage) (string, error)
+
+// runPlanner asks the model for a plan, executes it step by step and returns
+// the system prompt for the final reply, extended with the step results.
+// Each step is traced as a plan_step span under the turn.
+func runPlanner(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model, system string, messages []anthropic.MessageParam, tools []string, run toolRunner) (string, error) {
+	p, err := makePlan(ctx, client, tracer, model, system, messages, tools)
+	if err != nil {
+		return system, err
+	}
+
+	var results []stepResult
+	for i, step := range p.Steps {
+		results = append(results, executeStep(ctx, client, tracer, model, system, messages, i, step, results, run))
+	}
+	return system + "\n\n" + planSummary(results), nil
+}
+
+func makePlan(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model, system string, messages []anthropic.MessageParam, tools []string) (plan, error) {
+	ctx, span := tracer.Start(ctx, "plan",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("gen_ai.request.model", model),
+		),
+	)
+	defer span.End()
+
+	available := "none"
+	if len(tools) > 0 {
+		available = strings.Join(tools, ", ")
+	}
+
+	resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: 1024,
+		System: []anthropic.TextBlockParam{
+			{Text: system + "\n\n" + fmt.Sprintf(plannerInstruction, maxPlanSteps, available)},
+		},
+		Messages: messages,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return plan{}, err
+	}
+
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	// Tolerate prose or code fences around the JSON object
+	raw := text.String()
+	start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}")
+	if start < 0 || end < start {
+		err := errors.New("planner returned no JSON plan")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return plan{}, err
+	}
+
+	var p plan
+	if err := json.Unmarshal([]byte(raw[start:end+1]), &p); err != nil {
+		err = fmt.Errorf("parsing plan: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return plan{}, err
+	}
+	if len(p.Steps) > maxPlanSteps {
+		p.Steps = p.Steps[:maxPlanSteps]
+	}
+
+	planJSON, _ := json.Marshal(p)
+	span.SetAttributes(
+		attribute.Int("plan.step_count", len(p.Steps)),
+		attribute.String("plan.json", string(planJSON)),
+		attribute.Int64("gen_ai.usage.input_tokens", resp.Usage.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", resp.Usage.OutputTokens),
+	)
+	return p, nil
+}
+
+func executeStep(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model, system string, messages []anthropic.MessageParam, index int, step planStep, previous []stepResult, run toolRunner) stepResult {
+	ctx, span := tracer.Start(ctx, "plan_step",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.Int("plan.step.index", index),
+			attribute.String("plan.step.kind", step.Kind),
+			attribute.String("plan.step.description", step.Description),
+		),
+	)
+	defer span.End()
+
+	result := stepResult{Step: step}
+	switch step.Kind {
+	case "ask":
+		// Questions are answered by the user, so they go straight into the reply
+		result.Output = step.Description
+
+	case "tool":
+		span.SetAttributes(attribute.String("plan.step.tool", step.Tool))
+		if run == nil {
+			result.Err = fmt.Errorf("tool %q is not available", step.Tool)
+			break
+		}
+		result.Output, result.Err = run(ctx, step.Tool, step.Input)
+
+	default:
+		span.SetAttributes(attribute.String("gen_ai.request.model", model))
+		instruction := fmt.Sprintf("You are carrying out step %d of your plan: %s\n\n%s\nReply with only the result of this step.",
+			index+1, step.Description, planSummary(previous))
+		resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+			Model:     anthropic.Model(model),
+			MaxTokens: 512,
+			System: []anthropic.TextBlockParam{
+				{Text: system + "\n\n" + instruction},
+			},
+			Messages: messages,
+		})
+		if err != nil {
+			result.Err = err
+			break
+		}
+		var parts []string
+		for _, block := range resp.Content {
+			if block.Type == "text" {
+				parts = append(parts, block.Text)
+			}
+		}
+		result.Output = strings.Join(parts, "\n")
+	}
+
+	if result.Err != nil {
+		span.RecordError(result.Err)
+		span.SetStatus(codes.Error, result.Err.Error())
+	} else {
+		span.SetAttributes(attribute.String("plan.step.output", result.Output))
+	}
+	return result
+}
+
+// planSummary renders step results for inclusion in a system prompt.
+func planSummary(results []stepResult) string {
+	if len(results) == 0 {
+		return "No plan steps have been carried out yet."
+	}
+
+	var b strings.Builder
+	b.WriteString("Your plan for this reply and the result of each step:\n")
+	for i, r := range results {
+		fmt.Fprintf(&b, "%d. [%s] %s\n", i+1, r.Step.Kind, r.Step.Description)
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(&b, "   failed: %v\n", r.Err)
+		case r.Step.Kind == "ask":
+			b.WriteString("   ask the user this question in your reply\n")
+		default:
+			fmt.Fprintf(&b, "   result: %s\n", r.Output)
+		}
+	}
+	b.WriteString("Use these results to write your reply.")
+	return b.String()
+}
@@ -0,0 +1,286 @@
+package itsm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+	"go-tracing-demo/tools"
+)
+
+// provisionFailures are the simulated errors a failed provisioning reports.
+var provisionFailures = []string{
+	"target system timed out",
+	"role quota exceeded on target system",
+	"requester account not found in directory",
+	"connector credentials expired",
+}
+
+// simulatorConfig controls the provisioning simulator.
+type simulatorConfig struct {
+	// Latency is the mean simulated provisioning time; each call varies by ±50%.
+	Latency time.Duration
+	// FailureRate is the probability (0-1) that provisioning fails.
+	FailureRate float64
+}
+
+// simulatorConfigFromEnv reads PROVISION_LATENCY (default 1.5s) and
+// PROVISION_FAILURE_RATE (default 0.2).
+func simulatorConfigFromEnv() (simulatorConfig, error) {
+	cfg := simulatorConfig{Latency: 1500 * time.Millisecond, FailureRate: 0.2}
+	if v := os.Getenv("PROVISION_LATENCY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("PROVISION_LATENCY: %w", err)
+		}
+		cfg.Latency = d
+	}
+	if v := os.Getenv("PROVISION_FAILURE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return cfg, fmt.Errorf("PROVISION_FAILURE_RATE must be between 0 and 1, got %q", v)
+		}
+		cfg.FailureRate = rate
+	}
+	return cfg, nil
+}
+
+type provisionInput struct {
+	TicketID string `json:"ticket_id" description:"ID of the ticket to provision, e.g. AR-1A2B3C4D"`
+}
+
+type provisionResult struct {
+	TicketID   string `json:"ticket_id"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	ExternalID string `json:"external_id,omitempty"`
+	LatencyMS  int64  `json:"latency_ms"`
+}
+
+// registerProvisioningTool adds provision_access. Tickets for a resource
+// with a configured connector (keyed by connector.ResourceName) are granted
+// for real and must be approved first; everything else goes through the
+// simulator.
+func registerProvisioningTool(store *TicketStore, cfg simulatorConfig, connectors map[string]connector.Connector) {
+	tools.Register("provision_access", func(ctx context.Context, in provisionInput) (provisionResult, error) {
+		return provision(ctx, store, cfg, connectors, in)
+	}, tools.WithDescription("Provision the access requested on a ticket once the user has confirmed the ticket draft. Returns whether provisioning succeeded."))
+}
+
+// provision implements provision_access.
+func provision(ctx context.Context, store *TicketStore, cfg simulatorConfig, connectors map[string]connector.Connector, in provisionInput) (provisionResult, error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("itsm.ticket.id", in.TicketID))
+
+	// Checked and claimed in one update, so of concurrent calls for the
+	// ticket only one provisions it
+	var conn connector.Connector
+	ticket, err := store.update(ctx, in.TicketID, func(t *AccessRequest) error {
+		conn = connectors[connector.ResourceName(t.Resource)]
+		if err := provisionable(*t, conn); err != nil {
+			return err
+		}
+		if conn != nil && connector.IsDryRun(ctx) {
+			return errDryRunGrant
+		}
+		t.Status = statusProvisioning
+		return nil
+	})
+	linkToOrigin(span, ticket)
+	if errors.Is(err, errDryRunGrant) {
+		err = nil
+	}
+	if err != nil {
+		return provisionResult{}, err
+	}
+
+	start := time.Now()
+	var result provisionResult
+	if conn != nil {
+		span.SetAttributes(attribute.String("provision.connector", conn.Name()))
+		result, err = grant(ctx, store, conn, ticket)
+	} else {
+		result, err = simulate(ctx, store, cfg, ticket)
+	}
+	if err != nil {
+		return provisionResult{}, err
+	}
+	result.LatencyMS = time.Since(start).Milliseconds()
+
+	span.SetAttributes(
+		attribute.String("provision.outcome", result.Status),
+		attribute.Int64("provision.latency_ms", result.LatencyMS),
+		attribute.Bool("provision.simulated", conn == nil),
+		attribute.Bool("provision.dry_run", connector.IsDryRun(ctx)),
+	)
+	if result.ExternalID != "" {
+		span.SetAttributes(attribute.String("provision.external_id", result.ExternalID))
+	}
+	if result.Status == statusFailed {
+		span.SetAttributes(attribute.String("provision.failure_reason", result.Message))
+	}
+	return result, nil
+}
+
+// provisionable reports why t can't be provisioned, if it can't: it was
+// handed to a human, denied or needs a better justification, is already
+// being or has been provisioned, or is for a real connector and not
+// approved.
+func provisionable(t AccessRequest, conn connector.Connector) error {
+	switch {
+	case t.Status == statusEscalated:
+		return fmt.Errorf("ticket %s was handed to a human agent and must be approved by them first", t.ID)
+	case t.Status == statusDenied:
+		return fmt.Errorf("ticket %s was denied and can't be provisioned", t.ID)
+	case t.Status == statusProvisioning:
+		return fmt.Errorf("ticket %s is already being provisioned", t.ID)
+	case t.Status == statusProvisioned:
+		return fmt.Errorf("ticket %s is already provisioned", t.ID)
+	case t.NeedsJustification:
+		return fmt.Errorf("ticket %s needs a more complete business justification before it is submitted", t.ID)
+	case conn != nil && t.Status != statusApproved:
+		return fmt.Errorf("ticket %s must be approved before access is granted on %s (status: %s)", t.ID, conn.Name(), t.Status)
+	}
+	return nil
+}
+
+// errDryRunGrant stops provision from claiming a ticket in a dry run,
+// which grants nothing and so leaves the ticket as it is.
+var errDryRunGrant = errors.New("dry run leaves the ticket unchanged")
+
+// grant provisions ticket through a real connector.
+// The grant is guarded by an idempotency key, so a retried call (or one
+// after a crash) never grants the same access twice.
+func grant(ctx context.Context, store *TicketStore, conn connector.Connector, ticket AccessRequest) (provisionResult, error) {
+	req := connector.Request{
+		TicketID:    ticket.ID,
+		Requester:   ticket.RequesterEmail,
+		Resource:    ticket.Resource,
+		AccessLevel: ticket.AccessLevel,
+		Duration:    ticket.Duration,
+		Origin:      originOf(ticket),
+	}
+	key := grantKey(conn.Name(), req)
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("idempotency.key", key))
+
+	// Dry runs change nothing: they neither claim nor replay keys, and the
+	// ticket, its revisions and the outbox are left alone
+	if connector.IsDryRun(ctx) {
+		result := provisionResult{TicketID: ticket.ID, Status: ticket.Status}
+		res, err := conn.Grant(ctx, req)
+		if err != nil {
+			result.Message = "dry run, provisioning would fail: " + err.Error()
+		} else {
+			result.Message, result.ExternalID = "dry run, nothing was changed: "+res.Message, res.ExternalID
+		}
+		return result, nil
+	}
+
+	decision, stored, err := store.claim(key, "grant")
+	if err != nil {
+		return provisionResult{}, fmt.Errorf("claiming idempotency key: %w", err)
+	}
+	span.SetAttributes(attribute.String("idempotency.decision", decision))
+
+	var res connector.Result
+	var grantErr error
+	switch decision {
+	case idempotencyReplayed:
+		if err := json.Unmarshal(stored, &res); err != nil {
+			return provisionResult{}, fmt.Errorf("decoding stored grant result: %w", err)
+		}
+	case idempotencyInDoubt:
+		grantErr = fmt.Errorf("an earlier grant for ticket %s never finished and may have succeeded; check %s and resolve key %s with admin in-doubt before retrying", ticket.ID, conn.Name(), key)
+	default:
+		res, grantErr = conn.Grant(ctx, req)
+		switch {
+		case connector.Rejected(grantErr):
+			store.release(key)
+		case grantErr != nil:
+			// A timeout or server error may have granted the access, so the
+			// key stays in doubt until "admin in-doubt" resolves it
+			grantErr = fmt.Errorf("%w; the grant may have succeeded, so check %s and resolve key %s with admin in-doubt before retrying", grantErr, conn.Name(), key)
+		default:
+			data, _ := json.Marshal(res)
+			store.complete(key, data)
+		}
+	}
+
+	updated, _ := store.update(ctx, ticket.ID, func(t *AccessRequest) error {
+		t.Connector = conn.Name()
+		if grantErr != nil {
+			t.Status = statusFailed
+			t.FailureReason = grantErr.Error()
+			return nil
+		}
+		t.Status = statusProvisioned
+		t.ProvisionedAt = now()
+		t.ExternalID = res.ExternalID
+		t.FailureReason = ""
+		return nil
+	})
+
+	result := provisionResult{TicketID: ticket.ID, Status: updated.Status, Message: res.Message, ExternalID: res.ExternalID}
+	if grantErr != nil {
+		result.Message = "provisioning failed: " + grantErr.Error()
+	}
+	return result, nil
+}
+
+// simulate fakes provisioning with configurable latency and failure rate.
+func simulate(ctx context.Context, store *TicketStore, cfg simulatorConfig, ticket AccessRequest) (provisionResult, error) {
+	latency := time.Duration(float64(cfg.Latency) * (0.5 + rand.Float64()))
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		store.update(ctx, ticket.ID, func(t *AccessRequest) error {
+			t.Status = statusFailed
+			t.FailureReason = "provisioning interrupted: " + ctx.Err().Error()
+			return nil
+		})
+		return provisionResult{}, ctx.Err()
+	}
+
+	failed := rand.Float64() < cfg.FailureRate
+	updated, _ := store.update(ctx, ticket.ID, func(t *AccessRequest) error {
+		if failed {
+			t.Status = statusFailed
+			t.FailureReason = provisionFailures[rand.IntN(len(provisionFailures))]
+		} else {
+			t.Status = statusProvisioned
+			t.ProvisionedAt = now()
+			t.FailureReason = ""
+		}
+		return nil
+	})
+
+	result := provisionResult{
+		TicketID: ticket.ID,
+		Status:   updated.Status,
+		Message:  fmt.Sprintf("granted %s access to %s", updated.AccessLevel, updated.Resource),
+	}
+	if failed {
+		result.Message = "provisioning failed: " + updated.FailureReason
+	}
+	return result, nil
+}
+
+// grantKey derives the idempotency key for granting req via a connector.
+// It covers everything the grant depends on, so editing and re-approving a
+// ticket yields a new key.
+func grantKey(connectorName string, req connector.Request) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{req.Requester, req.Resource, req.AccessLevel, req.Duration}, "\x00")))
+	return fmt.Sprintf("grant:%s:%s:%x", connectorName, req.TicketID, sum[:8])
+}
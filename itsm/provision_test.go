@@ -0,0 +1,282 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go-tracing-demo/connector"
+)
+
+// fakeConnector counts grants and, with release set, holds each one until
+// it is closed.
+type fakeConnector struct {
+	grants  atomic.Int32
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (c *fakeConnector) Name() string { return "github" }
+
+func (c *fakeConnector) Grant(ctx context.Context, req connector.Request) (connector.Result, error) {
+	c.grants.Add(1)
+	if c.started != nil {
+		c.started <- struct{}{}
+	}
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return connector.Result{}, c.err
+	}
+	return connector.Result{ExternalID: "42", Message: "invited"}, nil
+}
+
+func testStore(t *testing.T) *TicketStore {
+	t.Helper()
+	t.Setenv("ITSM_FIELD_CLASSIFICATION", "")
+	t.Setenv("ITSM_DB_KEY", "")
+	store, err := OpenTicketStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func putTicket(t *testing.T, store *TicketStore, ticket AccessRequest) {
+	t.Helper()
+	ticket.Type = "access_request"
+	if ticket.RequesterEmail == "" {
+		ticket.RequesterEmail = "jane@example.com"
+	}
+	if ticket.AccessLevel == "" {
+		ticket.AccessLevel = "read"
+	}
+	if err := store.put(context.Background(), ticket); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProvisionable(t *testing.T) {
+	conn := &fakeConnector{}
+	for _, tt := range []struct {
+		name    string
+		ticket  AccessRequest
+		conn    connector.Connector
+		wantErr string
+	}{
+		{name: "approved on a connector", ticket: AccessRequest{Status: statusApproved}, conn: conn},
+		{name: "draft in the simulator", ticket: AccessRequest{Status: statusDraft}},
+		{name: "failed is retried", ticket: AccessRequest{Status: statusFailed}},
+		{name: "draft on a connector", ticket: AccessRequest{Status: statusDraft}, conn: conn, wantErr: "must be approved"},
+		{name: "escalated", ticket: AccessRequest{Status: statusEscalated}, wantErr: "handed to a human"},
+		{name: "denied", ticket: AccessRequest{Status: statusDenied}, conn: conn, wantErr: "was denied"},
+		{name: "provisioning", ticket: AccessRequest{Status: statusProvisioning}, conn: conn, wantErr: "already being provisioned"},
+		{name: "provisioned", ticket: AccessRequest{Status: statusProvisioned}, wantErr: "already provisioned"},
+		{name: "needs justification", ticket: AccessRequest{Status: statusApproved, NeedsJustification: true}, conn: conn, wantErr: "business justification"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := provisionable(tt.ticket, tt.conn)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("provisionable() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("provisionable() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvisionTransitions(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     string
+		grantErr   error
+		dryRun     bool
+		wantStatus string
+		wantErr    string
+		wantGrants int32
+	}{
+		{name: "granted", status: statusApproved, wantStatus: statusProvisioned, wantGrants: 1},
+		{name: "grant fails", status: statusApproved, grantErr: errors.New("boom"), wantStatus: statusFailed, wantGrants: 1},
+		{name: "dry run", status: statusApproved, dryRun: true, wantStatus: statusApproved, wantGrants: 1},
+		{name: "not approved", status: statusDraft, wantStatus: statusDraft, wantErr: "must be approved"},
+		{name: "already provisioned", status: statusProvisioned, wantStatus: statusProvisioned, wantErr: "already provisioned"},
+		{name: "in flight", status: statusProvisioning, wantStatus: statusProvisioning, wantErr: "already being provisioned"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore(t)
+			putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: tt.status})
+			conn := &fakeConnector{err: tt.grantErr}
+			ctx := context.Background()
+			if tt.dryRun {
+				ctx = connector.WithDryRun(ctx)
+			}
+
+			_, err := provision(ctx, store, simulatorConfig{}, map[string]connector.Connector{"github": conn}, provisionInput{TicketID: "AR-1"})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("provision() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("provision() = %v, want %q", err, tt.wantErr)
+			}
+			got, _ := store.get("AR-1")
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if n := conn.grants.Load(); n != tt.wantGrants {
+				t.Errorf("grants = %d, want %d", n, tt.wantGrants)
+			}
+			// A dry run leaves no trace of the grant it didn't make
+			if tt.dryRun {
+				if got.ProvisionedAt != "" || got.Connector != "" {
+					t.Errorf("dry run recorded a grant: %+v", got)
+				}
+				if n := count(t, store, "ticket_revisions", `ticket_id = 'AR-1'`); n != 1 {
+					t.Errorf("dry run left %d revisions, want the first only", n)
+				}
+				if n := count(t, store, "outbox", `type != 'ticket.created'`); n != 0 {
+					t.Errorf("dry run enqueued %d events", n)
+				}
+				if n := count(t, store, "idempotency_keys", `1`); n != 0 {
+					t.Errorf("dry run claimed %d keys", n)
+				}
+			}
+		})
+	}
+}
+
+// TestProvisionConcurrent runs two provision_access calls for one ticket at
+// once, as the executor may: one grants, the other is refused without
+// touching the ticket.
+func TestProvisionConcurrent(t *testing.T) {
+	store := testStore(t)
+	putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusApproved})
+	conn := &fakeConnector{started: make(chan struct{}, 2), release: make(chan struct{})}
+	connectors := map[string]connector.Connector{"github": conn}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = provision(context.Background(), store, simulatorConfig{}, connectors, provisionInput{TicketID: "AR-1"})
+		}()
+	}
+	// One call is granting; the other has been refused, or is refused once
+	// it gets to the ticket
+	<-conn.started
+	for {
+		if got, _ := store.get("AR-1"); got.Status == statusProvisioning {
+			break
+		}
+	}
+	close(conn.release)
+	wg.Wait()
+
+	var refused int
+	for _, err := range errs {
+		if err != nil {
+			if !strings.Contains(err.Error(), "already") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			refused++
+		}
+	}
+	if refused != 1 {
+		t.Errorf("%d calls refused, want 1 (errors: %v)", refused, errs)
+	}
+	if n := conn.grants.Load(); n != 1 {
+		t.Errorf("grants = %d, want 1", n)
+	}
+	if got, _ := store.get("AR-1"); got.Status != statusProvisioned || got.FailureReason != "" {
+		t.Errorf("ticket is %s (%q), want provisioned", got.Status, got.FailureReason)
+	}
+}
+
+// TestGrantInDoubt checks that only a grant proven to have had no effect
+// frees its key, and that "admin in-doubt" settles one left in doubt.
+func TestGrantInDoubt(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		grantErr   error
+		applied    bool
+		wantStatus string
+		wantGrants int32
+	}{
+		{name: "rejected", grantErr: connector.Reject(errors.New("no team")), wantStatus: statusProvisioned, wantGrants: 2},
+		{name: "timed out, applied", grantErr: context.DeadlineExceeded, applied: true, wantStatus: statusProvisioned, wantGrants: 1},
+		{name: "timed out, not applied", grantErr: context.DeadlineExceeded, wantStatus: statusProvisioned, wantGrants: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore(t)
+			putTicket(t, store, AccessRequest{ID: "AR-1", Resource: "github", Status: statusApproved})
+			conn := &fakeConnector{err: tt.grantErr}
+			connectors := map[string]connector.Connector{"github": conn}
+			reprovision := func() provisionResult {
+				t.Helper()
+				if _, err := store.update(context.Background(), "AR-1", func(t *AccessRequest) error {
+					t.Status = statusApproved
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				result, err := provision(context.Background(), store, simulatorConfig{}, connectors, provisionInput{TicketID: "AR-1"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return result
+			}
+
+			if result := reprovision(); result.Status != statusFailed {
+				t.Fatalf("status = %s, want failed", result.Status)
+			}
+			keys, err := store.inDoubtKeys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.err = nil
+			if connector.Rejected(tt.grantErr) {
+				if len(keys) != 0 {
+					t.Errorf("a rejected grant left %d keys in doubt", len(keys))
+				}
+			} else {
+				if len(keys) != 1 {
+					t.Fatalf("%d keys in doubt, want the timed out grant's", len(keys))
+				}
+				// Retried blindly, the grant stays failed without calling
+				// the connector
+				if result := reprovision(); result.Status != statusFailed || !strings.Contains(result.Message, "admin in-doubt") {
+					t.Errorf("retry before resolving = %s %q, want failed in doubt", result.Status, result.Message)
+				}
+				if err := store.resolveInDoubt(keys[0].Key, tt.applied, "42"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := reprovision()
+			if result.Status != tt.wantStatus || result.ExternalID != "42" {
+				t.Errorf("provision() after resolving = %s %s, want %s 42", result.Status, result.ExternalID, tt.wantStatus)
+			}
+			if n := conn.grants.Load(); n != tt.wantGrants {
+				t.Errorf("grants = %d, want %d", n, tt.wantGrants)
+			}
+			var key string
+			if err := store.db.QueryRow(`SELECT key FROM idempotency_keys`).Scan(&key); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.resolveInDoubt(key, true, ""); err == nil {
+				t.Error("resolveInDoubt() of a settled key succeeded")
+			}
+		})
+	}
+}
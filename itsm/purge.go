@@ -0,0 +1,638 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"go-tracing-demo/runs"
+)
+
+// Purge modes of "admin purge-user".
+const (
+	// purgeAnonymize keeps the user's tickets for access-review audits, with
+	// their identity replaced by a pseudonym and what they wrote erased.
+	purgeAnonymize = "anonymize"
+	// purgeDelete removes the user's tickets and everything recorded
+	// about them.
+	purgeDelete = "delete"
+)
+
+// erasedText replaces the free text a purged user wrote in tickets that
+// are kept.
+const erasedText = "[erased]"
+
+// purgeReport is what "admin purge-user" did, or would do in a dry run.
+type purgeReport struct {
+	Subject string `json:"subject"`
+	Mode    string `json:"mode"`
+	// Pseudonym replaces the subject wherever their records are kept.
+	Pseudonym string `json:"pseudonym"`
+	DryRun    bool   `json:"dry_run,omitempty"`
+	PurgedAt  string `json:"purged_at"`
+	// Tickets are the subject's own tickets; Sessions their conversations,
+	// which are deleted in both modes.
+	Tickets  []string `json:"tickets"`
+	Sessions []string `json:"sessions"`
+	// Deleted and Anonymized count the rows changed, by table. Anonymized
+	// also counts other people's records that named the subject, such as
+	// their decisions as an approver.
+	Deleted    map[string]int  `json:"deleted"`
+	Anonymized map[string]int  `json:"anonymized"`
+	LangSmith  *langSmithPurge `json:"langsmith,omitempty"`
+}
+
+// langSmithPurge is the part of a purge done in LangSmith.
+type langSmithPurge struct {
+	Project  string   `json:"project"`
+	TraceIDs []string `json:"trace_ids"`
+	// More is set when a search hit its limit, so more of the subject's
+	// traces may remain; running the purge again deletes the next ones.
+	More bool `json:"more,omitempty"`
+}
+
+// purgeSearchLimit caps each LangSmith search for the subject's traces.
+const purgeSearchLimit = 100
+
+// purger purges one user from the ticket store, inside one transaction.
+type purger struct {
+	store  *TicketStore
+	tx     *sql.Tx
+	report *purgeReport
+	// subject matches the user's identifier as a whole word, in any case.
+	subject *regexp.Regexp
+	tickets map[string]bool
+	// traceIDs are the traces recorded for the user's tickets, by hex ID.
+	traceIDs map[string]bool
+}
+
+// purgeUserCommand implements "admin purge-user": the right to erasure for
+// one user, identified by the email or principal ID their tickets and
+// sessions were opened with. Their tickets and conversations are
+// anonymized or deleted, and with --langsmith the traces recorded for them
+// are deleted from LangSmith first, so a failure there leaves the local
+// records to find the traces by. The report is printed as JSON.
+func purgeUserCommand(args []string) error {
+	fs := flag.NewFlagSet("admin purge-user", flag.ContinueOnError)
+	mode := fs.String("mode", purgeAnonymize, "anonymize: keep the tickets under a pseudonym; delete: remove them")
+	withLangSmith := fs.Bool("langsmith", false, "also delete the user's traces from LangSmith")
+	project := fs.String("project", os.Getenv("LANGSMITH_PROJECT"), "LangSmith project the traces are in (default LANGSMITH_PROJECT, then go-bot-itsm)")
+	dryRun := fs.Bool("dry-run", false, "report what would be purged without changing anything")
+	out := fs.String("report", "", "file to write the purge report to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: admin purge-user [--mode anonymize|delete] [--langsmith] [--project name] [--dry-run] [--report file] <user id>")
+	}
+	if *mode != purgeAnonymize && *mode != purgeDelete {
+		return fmt.Errorf("--mode must be anonymize or delete, got %q", *mode)
+	}
+	subject := strings.TrimSpace(fs.Arg(0))
+	if len(subject) < 3 {
+		return fmt.Errorf("user id %q is too short to match safely", subject)
+	}
+	var client *runs.Client
+	if *withLangSmith {
+		client = runs.FromEnv()
+		if client.APIKey == "" {
+			return errors.New("LANGSMITH_API_KEY is required with --langsmith")
+		}
+		if *project == "" {
+			*project = "go-bot-itsm"
+		}
+	}
+
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	report, err := store.purgeUser(context.Background(), client, *project, subject, *mode, *dryRun)
+	if report == nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, ferr := os.Create(*out)
+		if ferr != nil {
+			return errors.Join(err, ferr)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if werr := enc.Encode(report); werr != nil {
+		return errors.Join(err, werr)
+	}
+	if err == nil && *out != "" {
+		fmt.Fprintf(os.Stderr, "Purged %s: %d tickets, %d sessions (%s); report in %s\n", subject, len(report.Tickets), len(report.Sessions), *mode, *out)
+	}
+	return err
+}
+
+// purgeUser purges subject in mode, deleting their LangSmith traces first
+// when client is set. The report is returned with any error, describing
+// what was done before it.
+func (s *TicketStore) purgeUser(ctx context.Context, client *runs.Client, project, subject, mode string, dryRun bool) (*purgeReport, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	pattern := regexp.QuoteMeta(subject)
+	if isWordByte(subject[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(subject[len(subject)-1]) {
+		pattern += `\b`
+	}
+	p := &purger{
+		store: s,
+		tx:    tx,
+		report: &purgeReport{
+			Subject:    subject,
+			Mode:       mode,
+			Pseudonym:  "erased-user-" + uuid.New().String()[:8],
+			DryRun:     dryRun,
+			PurgedAt:   now(),
+			Tickets:    []string{},
+			Sessions:   []string{},
+			Deleted:    map[string]int{},
+			Anonymized: map[string]int{},
+		},
+		subject:  regexp.MustCompile(`(?i)` + pattern),
+		tickets:  map[string]bool{},
+		traceIDs: map[string]bool{},
+	}
+	if err := p.find(); err != nil {
+		return nil, err
+	}
+	if client != nil {
+		if err := p.purgeLangSmith(ctx, client, project, dryRun); err != nil {
+			return p.report, err
+		}
+	}
+	if mode == purgeDelete {
+		err = p.deleteTickets()
+	} else {
+		err = p.anonymizeTickets()
+	}
+	if err == nil {
+		err = p.redactRest()
+	}
+	if err != nil {
+		return p.report, fmt.Errorf("purging %s (nothing was changed in the ticket store): %w", subject, err)
+	}
+	if dryRun {
+		return p.report, nil
+	}
+	return p.report, tx.Commit()
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// find collects the subject's tickets, which they requested or which were
+// requested for them, their sessions, and the traces recorded for them.
+func (p *purger) find() error {
+	rows, err := p.tx.Query(`SELECT data FROM tickets ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		t, err := p.store.decodeTicket(data)
+		if err != nil {
+			return fmt.Errorf("decoding ticket: %w", err)
+		}
+		if p.is(t.RequesterEmail) || p.is(t.RequestedFor) {
+			p.tickets[t.ID] = true
+			p.report.Tickets = append(p.report.Tickets, t.ID)
+			if sc := originOf(t); sc.IsValid() {
+				p.traceIDs[sc.TraceID().String()] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	err = p.eachRow("conversations", []string{"session_id", "ticket_id", "requester"}, func(v []string) error {
+		if p.is(v[2]) || p.tickets[v[1]] {
+			p.report.Sessions = append(p.report.Sessions, v[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"ticket_revisions", "approval_decisions", "ticket_comments", "ticket_attachments", "conversation_turns"} {
+		err := p.eachRow(table, []string{"ticket_id", "trace_id"}, func(v []string) error {
+			if p.tickets[v[0]] && v[1] != "" {
+				p.traceIDs[v[1]] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// is reports whether v is the subject's identifier.
+func (p *purger) is(v string) bool {
+	return strings.EqualFold(strings.TrimSpace(v), p.report.Subject)
+}
+
+// purgeLangSmith deletes the subject's traces from project: those of
+// their tickets, and those their sessions or identity are recorded on.
+func (p *purger) purgeLangSmith(ctx context.Context, client *runs.Client, project string, dryRun bool) error {
+	p.report.LangSmith = &langSmithPurge{Project: project}
+	projectID, err := client.ProjectID(ctx, project)
+	if err != nil {
+		return err
+	}
+	ids := map[string]bool{}
+	for hex := range p.traceIDs {
+		if id, err := uuid.Parse(hex); err == nil {
+			ids[id.String()] = true
+		}
+	}
+	filters := []string{metadataFilter("user_id", p.report.Subject)}
+	for _, session := range p.report.Sessions {
+		filters = append(filters, metadataFilter("session_id", session))
+	}
+	for _, filter := range filters {
+		found, err := client.List(ctx, runs.Query{Project: project, Filter: filter, Limit: purgeSearchLimit})
+		if err != nil {
+			return err
+		}
+		if len(found) == purgeSearchLimit {
+			p.report.LangSmith.More = true
+		}
+		for _, r := range found {
+			ids[r.TraceID] = true
+		}
+	}
+	for id := range ids {
+		p.report.LangSmith.TraceIDs = append(p.report.LangSmith.TraceIDs, id)
+	}
+	slices.Sort(p.report.LangSmith.TraceIDs)
+	if dryRun {
+		return nil
+	}
+	for batch := range slices.Chunk(p.report.LangSmith.TraceIDs, purgeSearchLimit) {
+		if err := client.DeleteTraces(ctx, projectID, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func metadataFilter(key, value string) string {
+	return fmt.Sprintf(`and(eq(metadata_key, %q), eq(metadata_value, %q))`, key, value)
+}
+
+// deleteTickets deletes the subject's tickets with everything recorded
+// about them, and their sessions.
+func (p *purger) deleteTickets() error {
+	for _, id := range p.report.Tickets {
+		if err := deleteTicket(p.tx, id, p.report.Deleted); err != nil {
+			return err
+		}
+	}
+	return p.deleteSessions()
+}
+
+// ticketTables are the tables that keep records of a ticket by ticket_id.
+var ticketTables = []string{
+	"ticket_revisions", "approval_decisions", "ticket_comments", "ticket_attachments",
+	"ticket_external_records", "review_tasks", "conversations", "conversation_turns",
+}
+
+// deleteTicket deletes ticket id with everything recorded about it,
+// adding the rows deleted to deleted by table.
+func deleteTicket(tx execer, id string, deleted map[string]int) error {
+	for _, table := range ticketTables {
+		if err := deleteRows(tx, deleted, table, `ticket_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	if err := deleteRows(tx, deleted, "outbox", `json_extract(payload, '$.ticket.id') = ?`, id); err != nil {
+		return err
+	}
+	// The grant and attachment keys of the ticket, which name it, and the
+	// submission key that produced it
+	if err := deleteRows(tx, deleted, "idempotency_keys", `key LIKE 'grant:%:' || ? || ':%' OR key LIKE 'attach:%:' || ? || ':%'`, id, id); err != nil {
+		return err
+	}
+	if err := deleteRows(tx, deleted, "idempotency_keys", `key LIKE 'submit:%' AND result = ?`, id); err != nil {
+		return err
+	}
+	return deleteRows(tx, deleted, "tickets", `id = ?`, id)
+}
+
+// deleteRows deletes the rows of table matching where, adding how many to
+// deleted.
+func deleteRows(tx execer, deleted map[string]int, table, where string, args ...any) error {
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s`, table, where), args...)
+	if err != nil {
+		return fmt.Errorf("deleting from %s: %w", table, err)
+	}
+	n, err := res.RowsAffected()
+	if n > 0 {
+		deleted[table] += int(n)
+	}
+	return err
+}
+
+// anonymizeTickets erases what the subject wrote in their tickets, and
+// deletes their sessions and the transcripts of them. The subject's
+// identifier is replaced later with everyone else's records.
+func (p *purger) anonymizeTickets() error {
+	for _, id := range p.report.Tickets {
+		if err := p.delete("ticket_attachments", `ticket_id = ? AND name = ?`, id, transcriptAttachment); err != nil {
+			return err
+		}
+	}
+	return p.deleteSessions()
+}
+
+func (p *purger) deleteSessions() error {
+	for _, session := range p.report.Sessions {
+		if err := p.delete("conversations", `session_id = ?`, session); err != nil {
+			return err
+		}
+		if err := p.delete("conversation_turns", `session_id = ?`, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactRest replaces the subject's identifier with the pseudonym in the
+// records that are kept, and deletes their spend.
+func (p *purger) redactRest() error {
+	for _, table := range []string{"tickets", "ticket_revisions"} {
+		cols := []string{"data"}
+		if table == "ticket_revisions" {
+			cols = append(cols, "actor")
+		}
+		err := p.rewriteRows(table, cols, func(v []string) (bool, error) {
+			t, err := p.store.decodeTicket(v[0])
+			if err != nil {
+				return false, fmt.Errorf("decoding ticket: %w", err)
+			}
+			t, changed, err := p.redactTicket(t)
+			if err != nil || !changed {
+				return false, err
+			}
+			v[0], err = p.store.encodeTicket(t)
+			if len(v) > 1 {
+				v[1] = p.redact(v[1])
+			}
+			return true, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := p.rewriteRows("outbox", []string{"payload"}, func(v []string) (bool, error) {
+		var e outboxEvent
+		if err := json.Unmarshal([]byte(v[0]), &e); err != nil {
+			return false, err
+		}
+		if e.Ticket == nil {
+			// Review requests name requesters and owners in plain JSON
+			redacted, changed, err := p.redactJSON(v[0])
+			v[0] = redacted
+			return changed, err
+		}
+		if err := p.store.open(e.Ticket); err != nil {
+			return false, err
+		}
+		t, changed, err := p.redactTicket(*e.Ticket)
+		if err != nil || !changed {
+			return false, err
+		}
+		sealed := p.store.seal(t)
+		e.Ticket = &sealed
+		data, err := json.Marshal(e)
+		v[0] = string(data)
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	err = p.rewriteRows("conversations", []string{"requester", "messages"}, func(v []string) (bool, error) {
+		messages, changed, err := p.redactJSON(v[1])
+		requester := p.redact(v[0])
+		changed = changed || requester != v[0]
+		v[0], v[1] = requester, messages
+		return changed, err
+	})
+	if err != nil {
+		return err
+	}
+	// A redacted turn's embedding is of the text before, so it goes too
+	err = p.rewriteRows("conversation_turns", []string{"prompt", "reply", "embedding"}, func(v []string) (bool, error) {
+		prompt, reply := p.redact(v[0]), p.redact(v[1])
+		if prompt == v[0] && reply == v[1] {
+			return false, nil
+		}
+		v[0], v[1], v[2] = prompt, reply, ""
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	for table, cols := range map[string][]string{
+		"approval_decisions": {"approver", "on_behalf_of", "comment"},
+		"ticket_comments":    {"author", "body"},
+		"ticket_attachments": {"content"},
+		"review_tasks":       {"owner"},
+	} {
+		err := p.rewriteRows(table, cols, func(v []string) (bool, error) {
+			changed := false
+			for i := range v {
+				redacted := p.redact(v[i])
+				changed = changed || redacted != v[i]
+				v[i] = redacted
+			}
+			return changed, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return p.delete("spend", `scope LIKE 'user:%:' || ?`, p.report.Subject)
+}
+
+// redact replaces the subject's identifier in s with the pseudonym.
+func (p *purger) redact(s string) string {
+	return p.subject.ReplaceAllLiteralString(s, p.report.Pseudonym)
+}
+
+// redactTicket replaces the subject's identifier in t's text, and for the
+// subject's own tickets also erases their justification and the handoff
+// summary of their conversation. It reports whether anything changed.
+func (p *purger) redactTicket(t AccessRequest) (AccessRequest, bool, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return t, false, err
+	}
+	redacted, changed, err := p.redactJSON(string(data))
+	if err != nil {
+		return t, false, err
+	}
+	var out AccessRequest
+	if err := json.Unmarshal([]byte(redacted), &out); err != nil {
+		return t, false, err
+	}
+	if p.tickets[out.ID] {
+		for _, text := range []*string{&out.BusinessJustif, &out.HandoffSummary} {
+			if *text != "" && *text != erasedText {
+				*text = erasedText
+				changed = true
+			}
+		}
+	}
+	return out, changed, nil
+}
+
+// redactJSON replaces the subject's identifier in the string values of a
+// JSON document, leaving its keys alone.
+func (p *purger) redactJSON(data string) (string, bool, error) {
+	var v any
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return data, false, err
+	}
+	changed := false
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			redacted := p.redact(v)
+			changed = changed || redacted != v
+			return redacted
+		case []any:
+			for i := range v {
+				v[i] = walk(v[i])
+			}
+		case map[string]any:
+			for k := range v {
+				v[k] = walk(v[k])
+			}
+		}
+		return v
+	}
+	v = walk(v)
+	if !changed {
+		return data, false, nil
+	}
+	out, err := json.Marshal(v)
+	return string(out), true, err
+}
+
+// delete deletes the rows of table matching where, counting them.
+func (p *purger) delete(table, where string, args ...any) error {
+	return deleteRows(p.tx, p.report.Deleted, table, where, args...)
+}
+
+// eachRow calls fn with the text columns cols of every row of table.
+func (p *purger) eachRow(table string, cols []string, fn func(v []string) error) error {
+	rows, err := p.tx.Query(fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(cols, ", "), table))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := make([]string, len(cols))
+		dest := make([]any, len(cols))
+		for i := range v {
+			dest[i] = &v[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return rows.Err()
+}
+
+// rewriteRows calls fn with the text columns cols of every row of table,
+// and writes back the rows fn changes, counting them.
+func (p *purger) rewriteRows(table string, cols []string, fn func(v []string) (bool, error)) error {
+	type row struct {
+		rowid  int64
+		values []string
+	}
+	var changed []row
+	rows, err := p.tx.Query(fmt.Sprintf(`SELECT rowid, %s FROM %s`, strings.Join(cols, ", "), table))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", table, err)
+	}
+	for rows.Next() {
+		r := row{values: make([]string, len(cols))}
+		dest := []any{&r.rowid}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		ok, err := fn(r.values)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		if ok {
+			changed = append(changed, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	set := make([]string, len(cols))
+	for i, c := range cols {
+		set[i] = c + " = ?"
+	}
+	update := fmt.Sprintf(`UPDATE %s SET %s WHERE rowid = ?`, table, strings.Join(set, ", "))
+	for _, r := range changed {
+		args := make([]any, 0, len(r.values)+1)
+		for _, v := range r.values {
+			args = append(args, v)
+		}
+		if _, err := p.tx.Exec(update, append(args, r.rowid)...); err != nil {
+			return fmt.Errorf("updating %s: %w", table, err)
+		}
+	}
+	if len(changed) > 0 {
+		p.report.Anonymized[table] += len(changed)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package itsm
+
+import (
+	"maps"
+	"testing"
+)
+
+// addRecords records a comment, a transcript, a conversation of one turn
+// and grant, attachment and submission keys for ticket id.
+func addRecords(t *testing.T, store *TicketStore, id, session string) {
+	t.Helper()
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO ticket_comments (ticket_id, author, body, drafted, trace_id, span_id, created_at) VALUES (?, 'jane@example.com', 'any news?', 0, '', '', ?)`, []any{id, now()}},
+		{`INSERT INTO ticket_attachments (ticket_id, name, content_type, content, session_id, trace_id, span_id, created_at) VALUES (?, ?, 'text/plain', 'hi', ?, '', '', ?)`, []any{id, transcriptAttachment, session, now()}},
+		{`INSERT INTO conversations (session_id, ticket_id, requester, messages, updated_at) VALUES (?, ?, 'jane@example.com', '[]', ?)`, []any{session, id, now()}},
+		{`INSERT INTO conversation_turns (prompt, reply, session_id, ticket_id, turn_index, trace_id, span_id, created_at, embedding) VALUES ('hi', 'hello', ?, ?, 0, '', '', ?, '')`, []any{session, id, now()}},
+		{`INSERT INTO idempotency_keys (key, operation, state, created_at, updated_at) VALUES ('grant:github:' || ? || ':00', 'grant', 'done', ?, ?)`, []any{id, now(), now()}},
+		{`INSERT INTO idempotency_keys (key, operation, state, created_at, updated_at) VALUES ('attach:jira:' || ? || ':transcript', 'attach', 'done', ?, ?)`, []any{id, now(), now()}},
+		{`INSERT INTO idempotency_keys (key, operation, state, result, created_at, updated_at) VALUES (?, 'submit', 'done', ?, ?, ?)`, []any{submissionKey(session), id, now(), now()}},
+	} {
+		if _, err := store.db.Exec(q.query, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// count returns the rows of table matching where.
+func count(t *testing.T, store *TicketStore, table, where string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := store.db.QueryRow(`SELECT count(*) FROM `+table+` WHERE `+where, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeleteTicket(t *testing.T) {
+	store := testStore(t)
+	for _, id := range []string{"AR-1", "AR-11"} {
+		putTicket(t, store, AccessRequest{ID: id, Resource: "github", Status: statusDraft})
+		addRecords(t, store, id, "session-"+id)
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	deleted := map[string]int{}
+	if err := deleteTicket(tx, "AR-1", deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"tickets":            1,
+		"ticket_revisions":   1,
+		"ticket_comments":    1,
+		"ticket_attachments": 1,
+		"conversations":      1,
+		"conversation_turns": 1,
+		"outbox":             1,
+		"idempotency_keys":   3,
+	}
+	if !maps.Equal(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+	// AR-11's keys contain AR-1 but must not match it
+	for table, where := range map[string]string{
+		"tickets":          `id = 'AR-11'`,
+		"ticket_comments":  `ticket_id = 'AR-11'`,
+		"idempotency_keys": `key LIKE '%:AR-11:%' OR result = 'AR-11'`,
+	} {
+		if n := count(t, store, table, where); n == 0 {
+			t.Errorf("AR-11's rows in %s were deleted", table)
+		}
+	}
+	if n := count(t, store, "idempotency_keys", `1`); n != 3 {
+		t.Errorf("%d idempotency keys left, want AR-11's 3", n)
+	}
+}
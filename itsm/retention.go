@@ -0,0 +1,303 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/connector"
+)
+
+// retentionPolicy is how long stored sessions and tickets are kept after
+// they last changed. Zero keeps them indefinitely.
+type retentionPolicy struct {
+	sessions time.Duration
+	tickets  time.Duration
+	// interval is how often the sweeper runs.
+	interval time.Duration
+}
+
+// retentionFromEnv reads ITSM_RETENTION_SESSIONS and
+// ITSM_RETENTION_TICKETS, such as "30d" and "1y", and how often to sweep,
+// ITSM_RETENTION_INTERVAL (default 1h).
+func retentionFromEnv() (retentionPolicy, error) {
+	var p retentionPolicy
+	for _, v := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"ITSM_RETENTION_SESSIONS", &p.sessions},
+		{"ITSM_RETENTION_TICKETS", &p.tickets},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		d, ok := parseRetention(s)
+		if !ok {
+			return p, fmt.Errorf("%s must be a period such as 30d or 1y, got %q", v.name, s)
+		}
+		*v.d = d
+	}
+	p.interval = time.Hour
+	if s := os.Getenv("ITSM_RETENTION_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("ITSM_RETENTION_INTERVAL must be a positive duration, got %q", s)
+		}
+		p.interval = d
+	}
+	return p, nil
+}
+
+// parseRetention parses a period in years ("1y"), weeks ("12w"), days
+// ("30d") or as a Go duration ("720h").
+func parseRetention(s string) (time.Duration, bool) {
+	for suffix, unit := range map[string]time.Duration{"y": 365 * 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, found := strings.CutSuffix(s, suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, false
+			}
+			return time.Duration(count) * unit, true
+		}
+	}
+	return connector.Expiry(s)
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.sessions > 0 || p.tickets > 0
+}
+
+// retentionSweep is what one sweep deleted.
+type retentionSweep struct {
+	Sessions int
+	Tickets  int
+	// Rows counts every row deleted, by table.
+	Rows map[string]int
+	// SessionIDs are the sessions whose conversations were deleted, with
+	// their tickets or on their own.
+	SessionIDs []string
+}
+
+// retained reports whether t is kept past the ticket retention: tickets
+// still in flight, including drafts and escalated tickets with an approval
+// step pending, and grants that are still active, which access reviews
+// need.
+func retained(t AccessRequest, at time.Time) bool {
+	switch t.Status {
+	case statusApproved, statusProvisioning:
+		return true
+	case statusDraft, statusEscalated:
+		step, _ := pendingStep(t)
+		return step != ""
+	case statusProvisioned:
+		expires, ok := grantExpiry(t)
+		return !ok || expires.After(at)
+	}
+	return false
+}
+
+// sweepRetention deletes the conversations and tickets that have not
+// changed for longer than the policy keeps them, in one transaction. A
+// ticket goes with everything recorded about it, as with "admin
+// purge-user --mode delete". The sweep is traced as its own
+// retention_sweep trace.
+func sweepRetention(ctx context.Context, tracer trace.Tracer, store *TicketStore, policy retentionPolicy) (retentionSweep, error) {
+	sweep := retentionSweep{Rows: map[string]int{}}
+	ctx, span := tracer.Start(ctx, "retention_sweep", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("langsmith.trace.name", "retention_sweep"),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("itsm.retention.sessions", policy.sessions.String()),
+		attribute.String("itsm.retention.tickets", policy.tickets.String()),
+	))
+	defer span.End()
+	fail := func(err error) (retentionSweep, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return retentionSweep{}, err
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+	at := time.Now()
+	if policy.tickets > 0 {
+		cutoff := at.Add(-policy.tickets).UTC().Format(time.RFC3339)
+		span.SetAttributes(attribute.String("itsm.retention.tickets_cutoff", cutoff))
+		rows, err := tx.Query(`SELECT data FROM tickets WHERE updated_at < ?`, cutoff)
+		if err != nil {
+			return fail(err)
+		}
+		var expired []string
+		for rows.Next() {
+			var data string
+			if err := rows.Scan(&data); err != nil {
+				rows.Close()
+				return fail(err)
+			}
+			t, err := store.decodeTicket(data)
+			if err != nil {
+				rows.Close()
+				return fail(fmt.Errorf("decoding ticket: %w", err))
+			}
+			if !retained(t, at) {
+				expired = append(expired, t.ID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fail(err)
+		}
+		for _, id := range expired {
+			sessions, err := sessionIDs(tx, `ticket_id = ?`, id)
+			if err != nil {
+				return fail(err)
+			}
+			sweep.SessionIDs = append(sweep.SessionIDs, sessions...)
+			if err := deleteTicket(tx, id, sweep.Rows); err != nil {
+				return fail(err)
+			}
+		}
+		sweep.Tickets = len(expired)
+	}
+	if policy.sessions > 0 {
+		cutoff := at.Add(-policy.sessions).UTC().Format(time.RFC3339)
+		span.SetAttributes(attribute.String("itsm.retention.sessions_cutoff", cutoff))
+		sessions, err := sessionIDs(tx, `updated_at < ?`, cutoff)
+		if err != nil {
+			return fail(err)
+		}
+		sweep.SessionIDs = append(sweep.SessionIDs, sessions...)
+		// Sessions deleted with their tickets count as tickets
+		before := sweep.Rows["conversations"]
+		if err := deleteRows(tx, sweep.Rows, "conversations", `updated_at < ?`, cutoff); err != nil {
+			return fail(err)
+		}
+		sweep.Sessions = sweep.Rows["conversations"] - before
+		// Their turns go from search with them
+		if err := deleteRows(tx, sweep.Rows, "conversation_turns", `session_id NOT IN (SELECT session_id FROM conversations)`); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+
+	span.SetAttributes(
+		attribute.Int("itsm.retention.sessions_deleted", sweep.Sessions),
+		attribute.Int("itsm.retention.tickets_deleted", sweep.Tickets),
+	)
+	for table, n := range sweep.Rows {
+		span.SetAttributes(attribute.Int("itsm.retention.rows_deleted."+table, n))
+	}
+	return sweep, nil
+}
+
+// sessionIDs are the sessions of the conversations matching where.
+func sessionIDs(tx *sql.Tx, where string, args ...any) ([]string, error) {
+	rows, err := tx.Query(`SELECT session_id FROM conversations WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// retentionSweeper enforces the retention policy in the background.
+type retentionSweeper struct {
+	store  *TicketStore
+	tracer trace.Tracer
+	policy retentionPolicy
+	// swept, when set, is called after every sweep that succeeded.
+	swept   func(retentionSweep)
+	deleted metric.Int64Counter
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startRetentionSweeper sweeps at once and then every policy.interval
+// while the server runs, counting what it deletes as
+// itsm.retention.deleted and passing each sweep to swept. It returns nil
+// when nothing expires.
+func startRetentionSweeper(ctx context.Context, store *TicketStore, tracer trace.Tracer, policy retentionPolicy, swept func(retentionSweep)) (*retentionSweeper, error) {
+	if !policy.enabled() {
+		return nil, nil
+	}
+	deleted, err := otel.Meter("go-bot-itsm").Int64Counter("itsm.retention.deleted",
+		metric.WithDescription("Sessions and tickets deleted by the retention policy"))
+	if err != nil {
+		return nil, fmt.Errorf("registering retention metrics: %w", err)
+	}
+	r := &retentionSweeper{
+		store:   store,
+		tracer:  tracer,
+		policy:  policy,
+		swept:   swept,
+		deleted: deleted,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go r.loop(ctx)
+	return r, nil
+}
+
+func (r *retentionSweeper) loop(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.policy.interval)
+	defer ticker.Stop()
+	for {
+		r.sweep(ctx)
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *retentionSweeper) sweep(ctx context.Context) {
+	sweep, err := sweepRetention(ctx, r.tracer, r.store, r.policy)
+	if err != nil {
+		log.Printf("Sweeping expired sessions and tickets: %v", err)
+		return
+	}
+	if r.swept != nil {
+		r.swept(sweep)
+	}
+	r.deleted.Add(ctx, int64(sweep.Sessions), metric.WithAttributes(attribute.String("itsm.retention.kind", "session")))
+	r.deleted.Add(ctx, int64(sweep.Tickets), metric.WithAttributes(attribute.String("itsm.retention.kind", "ticket")))
+	if sweep.Sessions > 0 || sweep.Tickets > 0 {
+		log.Printf("Retention: deleted %d sessions and %d tickets", sweep.Sessions, sweep.Tickets)
+	}
+}
+
+// Stop stops sweeping.
+func (r *retentionSweeper) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
@@ -0,0 +1,76 @@
+package itsm
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSweepRetention(t *testing.T) {
+	store := testStore(t)
+	longAgo := time.Now().AddDate(-2, 0, 0).UTC().Format(time.RFC3339)
+	for _, ticket := range []AccessRequest{
+		{ID: "AR-1", Status: statusDenied},
+		{ID: "AR-2", Status: statusApproved},
+		{ID: "AR-3", Status: statusProvisioned, Duration: "30d", ProvisionedAt: longAgo},
+		{ID: "AR-4", Status: statusProvisioned},
+		{ID: "AR-6", Status: statusDraft},
+		{ID: "AR-7", Status: statusEscalated},
+	} {
+		ticket.Resource = "github"
+		putTicket(t, store, ticket)
+	}
+	addRecords(t, store, "AR-1", "session-AR-1")
+	// A stale conversation that never became a ticket, and a fresh one
+	for _, c := range []struct{ session, updated string }{{"session-old", longAgo}, {"session-new", now()}} {
+		if _, err := store.db.Exec(`INSERT INTO conversations (session_id, ticket_id, requester, messages, updated_at) VALUES (?, '', 'jane@example.com', '[]', ?)`, c.session, c.updated); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.db.Exec(`INSERT INTO conversation_turns (prompt, reply, session_id, ticket_id, turn_index, trace_id, span_id, created_at, embedding) VALUES ('hi', 'hello', ?, '', 0, '', '', ?, '')`, c.session, c.updated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec(`UPDATE tickets SET updated_at = ?`, longAgo); err != nil {
+		t.Fatal(err)
+	}
+	putTicket(t, store, AccessRequest{ID: "AR-5", Resource: "github", Status: statusDenied})
+
+	policy := retentionPolicy{sessions: 30 * 24 * time.Hour, tickets: 365 * 24 * time.Hour}
+	sweep, err := sweepRetention(context.Background(), noop.NewTracerProvider().Tracer(""), store, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AR-1 is closed and AR-3's grant has expired; AR-2 is in flight, AR-4's
+	// grant never expires, AR-6 and AR-7 wait on their approval chains and
+	// AR-5 changed recently
+	if sweep.Tickets != 2 || sweep.Sessions != 1 {
+		t.Errorf("swept %d tickets and %d sessions, want 2 and 1", sweep.Tickets, sweep.Sessions)
+	}
+	want := map[string]int{
+		"tickets":            2,
+		"ticket_revisions":   2,
+		"outbox":             2,
+		"ticket_comments":    1,
+		"ticket_attachments": 1,
+		"conversations":      2,
+		"conversation_turns": 2,
+		"idempotency_keys":   3,
+	}
+	if !maps.Equal(sweep.Rows, want) {
+		t.Errorf("rows deleted = %v, want %v", sweep.Rows, want)
+	}
+	slices.Sort(sweep.SessionIDs)
+	if want := []string{"session-AR-1", "session-old"}; !slices.Equal(sweep.SessionIDs, want) {
+		t.Errorf("sessions deleted = %q, want %q", sweep.SessionIDs, want)
+	}
+	if n := count(t, store, "tickets", `id IN ('AR-2', 'AR-4', 'AR-5', 'AR-6', 'AR-7')`); n != 5 {
+		t.Errorf("%d of the retained tickets are left, want 5", n)
+	}
+	if n := count(t, store, "conversation_turns", `session_id = 'session-new'`); n != 1 {
+		t.Errorf("the fresh conversation has %d turns left, want 1", n)
+	}
+}
@@ -0,0 +1,221 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"go-tracing-demo/connector"
+)
+
+// reviewQuestion is what each owner is asked about every grant.
+const reviewQuestion = "Does this person still need this access?"
+
+// reviewTask asks a resource owner to confirm one active grant.
+type reviewTask struct {
+	ID            string `json:"id"`
+	TicketID      string `json:"ticket_id"`
+	Requester     string `json:"requester"`
+	Resource      string `json:"resource"`
+	AccessLevel   string `json:"access_level"`
+	ProvisionedAt string `json:"provisioned_at"`
+	// ExpiresAt is empty for grants without a duration.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Question  string `json:"question"`
+}
+
+// reviewRequest is the notification sent to one owner for a campaign.
+type reviewRequest struct {
+	CampaignID string       `json:"campaign_id"`
+	Owner      string       `json:"owner"`
+	Tasks      []reviewTask `json:"tasks"`
+}
+
+// createReviewCampaign implements "reviews create": one review task per
+// active grant, grouped by resource owner. With --notify each owner's tasks
+// are sent as a review.requested event through the outbox.
+func createReviewCampaign(args []string) error {
+	fs := flag.NewFlagSet("reviews create", flag.ContinueOnError)
+	resource := fs.String("resource", "", "only review grants on this resource")
+	notify := fs.Bool("notify", false, "send each owner their tasks via ITSM_WEBHOOK_URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	owners, err := loadResourceOwners()
+	if err != nil {
+		return err
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	tracer := otel.Tracer("go-bot-itsm")
+	ctx, span := tracer.Start(context.Background(), "review_campaign")
+	defer span.End()
+
+	campaignID := "RC-" + strings.ToUpper(uuid.New().String()[:8])
+	span.SetAttributes(
+		attribute.String("langsmith.trace.name", "access_review_campaign"),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("review.campaign_id", campaignID),
+		attribute.Bool("review.notify", *notify),
+	)
+	if *resource != "" {
+		span.SetAttributes(attribute.String("review.resource", *resource))
+	}
+
+	grants, err := activeGrants(store, *resource, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	byOwner := map[string][]reviewTask{}
+	for _, t := range grants {
+		task := reviewTask{
+			ID:            "RT-" + strings.ToUpper(uuid.New().String()[:8]),
+			TicketID:      t.ID,
+			Requester:     t.RequesterEmail,
+			Resource:      t.Resource,
+			AccessLevel:   t.AccessLevel,
+			ProvisionedAt: t.ProvisionedAt,
+			Question:      reviewQuestion,
+		}
+		if task.Requester == "" {
+			task.Requester = t.RequestedFor
+		}
+		if expires, ok := grantExpiry(t); ok {
+			task.ExpiresAt = expires.Format(time.RFC3339)
+		}
+		owner := owners.ownerOf(t.Resource)
+		byOwner[owner] = append(byOwner[owner], task)
+	}
+	ownerNames := make([]string, 0, len(byOwner))
+	for owner := range byOwner {
+		ownerNames = append(ownerNames, owner)
+	}
+	sort.Strings(ownerNames)
+
+	// The campaign, its tasks and the notifications commit together
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ts := now()
+	if _, err := tx.Exec(`INSERT INTO review_campaigns (id, created_at) VALUES (?, ?)`, campaignID, ts); err != nil {
+		return err
+	}
+	for _, owner := range ownerNames {
+		ownerCtx, ownerSpan := tracer.Start(ctx, "review_tasks")
+		ownerSpan.SetAttributes(
+			attribute.String("review.owner", owner),
+			attribute.Int("review.task_count", len(byOwner[owner])),
+		)
+		for _, task := range byOwner[owner] {
+			if _, err := tx.Exec(`INSERT INTO review_tasks (id, campaign_id, ticket_id, owner, created_at) VALUES (?, ?, ?, ?, ?)`,
+				task.ID, campaignID, task.TicketID, owner, ts); err != nil {
+				ownerSpan.End()
+				return err
+			}
+		}
+		if *notify {
+			req := &reviewRequest{CampaignID: campaignID, Owner: owner, Tasks: byOwner[owner]}
+			if err := enqueue(ownerCtx, tx, outboxEvent{Type: "review.requested", Review: req}); err != nil {
+				ownerSpan.End()
+				return err
+			}
+		}
+		tasksJSON, _ := json.Marshal(byOwner[owner])
+		ownerSpan.SetAttributes(attribute.String("review.tasks_json", string(tasksJSON)))
+		ownerSpan.End()
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	span.SetAttributes(
+		attribute.Int("review.task_count", len(grants)),
+		attribute.Int("review.owner_count", len(ownerNames)),
+	)
+
+	if *notify {
+		// Deliver now rather than waiting for the next chat session
+		startDispatcher(ctx, store, tracer).Stop()
+	}
+
+	fmt.Printf("Campaign %s: %d review tasks for %d owners\n", campaignID, len(grants), len(ownerNames))
+	for _, owner := range ownerNames {
+		fmt.Printf("\n%s\n", owner)
+		for _, task := range byOwner[owner] {
+			expires := "no expiry"
+			if task.ExpiresAt != "" {
+				expires = "expires " + task.ExpiresAt
+			}
+			fmt.Printf("  %s  %s  %s %s (%s, %s)\n", task.ID, task.TicketID, task.Requester, task.AccessLevel, task.Resource, expires)
+		}
+	}
+	return nil
+}
+
+// activeGrants returns provisioned tickets whose access has not expired,
+// optionally limited to one resource.
+func activeGrants(store *TicketStore, resource string, at time.Time) ([]AccessRequest, error) {
+	rows, err := store.db.Query(`SELECT data FROM tickets WHERE status = ? ORDER BY created_at`, statusProvisioned)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []AccessRequest
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		t, err := store.decodeTicket(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding ticket: %w", err)
+		}
+		if resource != "" && strings.TrimSuffix(t.Resource, "_prod") != strings.TrimSuffix(resource, "_prod") {
+			continue
+		}
+		if expires, ok := grantExpiry(t); ok && !expires.After(at) {
+			continue
+		}
+		grants = append(grants, t)
+	}
+	return grants, rows.Err()
+}
+
+// grantExpiry is when a provisioned ticket's access ends. ok is false for
+// grants without a duration.
+func grantExpiry(t AccessRequest) (time.Time, bool) {
+	ttl, ok := connector.Expiry(t.Duration)
+	if !ok {
+		return time.Time{}, false
+	}
+	provisioned, err := time.Parse(time.RFC3339, t.ProvisionedAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return provisioned.Add(ttl).UTC(), true
+}
@@ -0,0 +1,281 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// change is who a ticket write is made for, and the conversation turn
+// making it.
+type change struct {
+	Actor     string
+	SessionID string
+	// TurnIndex is the turn's index in the session, or -1 for writes made
+	// outside a turn, such as approvals.
+	TurnIndex int
+}
+
+type changeKey struct{}
+
+// withChange attributes the ticket writes made under ctx to c.
+func withChange(ctx context.Context, c change) context.Context {
+	return context.WithValue(ctx, changeKey{}, c)
+}
+
+// changeOf is the change ctx was marked with, or one by "system" outside
+// any turn.
+func changeOf(ctx context.Context) change {
+	if c, ok := ctx.Value(changeKey{}).(change); ok {
+		if c.Actor == "" {
+			c.Actor = "system"
+		}
+		return c
+	}
+	return change{Actor: "system", TurnIndex: -1}
+}
+
+// revision is one stored version of a ticket.
+type revision struct {
+	Number    int
+	Actor     string
+	SessionID string
+	TurnIndex int
+	TraceID   string
+	SpanID    string
+	CreatedAt string
+	Ticket    AccessRequest
+}
+
+// fieldChange is one field that differs between two revisions.
+type fieldChange struct {
+	Field    string
+	Old, New string
+}
+
+// addRevision stores data, t's JSON, as the ticket's next revision unless
+// it matches previous, the revision it replaces. The revision is recorded
+// on the span of ctx as a ticket.revision event with the fields it changed.
+func addRevision(ctx context.Context, tx queryExecer, t AccessRequest, data, previous string) error {
+	if data == previous {
+		return nil
+	}
+	var number int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(revision), 0) + 1 FROM ticket_revisions WHERE ticket_id = ?`, t.ID).Scan(&number); err != nil {
+		return err
+	}
+	c := changeOf(ctx)
+	span := trace.SpanFromContext(ctx)
+	var traceID, spanID string
+	if sc := span.SpanContext(); sc.IsValid() {
+		traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO ticket_revisions (ticket_id, revision, actor, session_id, turn_index, trace_id, span_id, created_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, number, c.Actor, c.SessionID, c.TurnIndex, traceID, spanID, now(), data); err != nil {
+		return err
+	}
+	if span.IsRecording() {
+		changes, _ := diffTicketJSON(previous, data)
+		fields := make([]string, len(changes))
+		for i, fc := range changes {
+			fields[i] = fc.Field
+		}
+		span.AddEvent("ticket.revision", trace.WithAttributes(
+			attribute.String("itsm.ticket.id", t.ID),
+			attribute.Int("itsm.ticket.revision", number),
+			attribute.String("itsm.ticket.changed_by", c.Actor),
+			attribute.StringSlice("itsm.ticket.changed_fields", fields),
+		))
+	}
+	return nil
+}
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx.
+type queryExecer interface {
+	execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// latestRevision is the number of the ticket's newest revision, 0 when it
+// has none.
+func (s *TicketStore) latestRevision(id string) int {
+	var number int
+	s.db.QueryRow(`SELECT COALESCE(MAX(revision), 0) FROM ticket_revisions WHERE ticket_id = ?`, id).Scan(&number)
+	return number
+}
+
+// revisions returns the ticket's revisions, oldest first.
+func (s *TicketStore) revisions(id string) ([]revision, error) {
+	rows, err := s.db.Query(`SELECT revision, actor, session_id, turn_index, trace_id, span_id, created_at, data
+		FROM ticket_revisions WHERE ticket_id = ? ORDER BY revision`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []revision
+	for rows.Next() {
+		var r revision
+		var data string
+		if err := rows.Scan(&r.Number, &r.Actor, &r.SessionID, &r.TurnIndex, &r.TraceID, &r.SpanID, &r.CreatedAt, &data); err != nil {
+			return nil, err
+		}
+		var err error
+		if r.Ticket, err = s.decodeTicket(data); err != nil {
+			return nil, fmt.Errorf("decoding revision %d of %s: %w", r.Number, id, err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// diffTickets lists the fields that differ between old and new, by their
+// JSON names in alphabetical order.
+func diffTickets(old, new AccessRequest) []fieldChange {
+	oldJSON, _ := json.Marshal(old)
+	newJSON, _ := json.Marshal(new)
+	changes, _ := diffTicketJSON(string(oldJSON), string(newJSON))
+	return changes
+}
+
+// diffTicketJSON is diffTickets on ticket JSON. An empty old is a ticket
+// with no fields set.
+func diffTicketJSON(old, new string) ([]fieldChange, error) {
+	var before, after map[string]json.RawMessage
+	if old != "" {
+		if err := json.Unmarshal([]byte(old), &before); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal([]byte(new), &after); err != nil {
+		return nil, err
+	}
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	var changes []fieldChange
+	for field := range fields {
+		o, n := fieldValue(before[field]), fieldValue(after[field])
+		if o != n {
+			changes = append(changes, fieldChange{Field: field, Old: o, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// fieldValue shows a JSON value: strings without quotes, the rest as
+// JSON, and nothing for unset fields.
+func fieldValue(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if v := string(raw); v != "null" && v != "0" && v != "false" {
+		return v
+	}
+	return ""
+}
+
+// ticketDiff implements "tickets diff": the field changes between a
+// ticket's revisions, each with who and which turn made it.
+func ticketDiff(args []string) error {
+	fs := flag.NewFlagSet("tickets diff", flag.ContinueOnError)
+	from := fs.Int("from", 0, "revision to compare from (default: each revision against the one before it)")
+	to := fs.Int("to", 0, "revision to compare to (default: the latest)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: tickets diff [--from N] [--to N] <ticket id>")
+	}
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return printTicketDiff(os.Stdout, store, fs.Arg(0), *from, *to)
+}
+
+// printTicketDiff writes the changes to ticket id between revisions from
+// and to. With from 0, every revision up to to is shown against the one
+// before it; with to 0, the latest revision is the last.
+func printTicketDiff(w io.Writer, store *TicketStore, id string, from, to int) error {
+	revs, err := store.revisions(id)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		return fmt.Errorf("ticket %q has no revisions", id)
+	}
+	if to == 0 {
+		to = len(revs)
+	}
+	if to < 1 || to > len(revs) || from < 0 || from >= to {
+		return fmt.Errorf("ticket %s has revisions 1 to %d; --from must be below --to", id, len(revs))
+	}
+	fmt.Fprintf(w, "Ticket %s: %d revisions\n", id, len(revs))
+	if from > 0 {
+		writeRevisionChanges(w, revs[to-1], diffTickets(revs[from-1].Ticket, revs[to-1].Ticket), "since revision "+strconv.Itoa(from))
+		return nil
+	}
+	for i := 0; i < to; i++ {
+		var previous AccessRequest
+		if i > 0 {
+			previous = revs[i-1].Ticket
+		}
+		writeRevisionChanges(w, revs[i], diffTickets(previous, revs[i].Ticket), "")
+	}
+	return nil
+}
+
+func writeRevisionChanges(w io.Writer, r revision, changes []fieldChange, since string) {
+	fmt.Fprintf(w, "\nRevision %d  %s  by %s", r.Number, r.CreatedAt, r.Actor)
+	if since != "" {
+		fmt.Fprintf(w, "  (%s)", since)
+	}
+	fmt.Fprintln(w)
+	switch {
+	case r.SessionID != "" && r.TurnIndex >= 0:
+		fmt.Fprintf(w, "  session %s, turn %d", r.SessionID, r.TurnIndex)
+	case r.SessionID != "":
+		fmt.Fprintf(w, "  session %s", r.SessionID)
+	}
+	if r.TraceID != "" {
+		fmt.Fprintf(w, "  trace %s", r.TraceID)
+	}
+	if r.SessionID != "" || r.TraceID != "" {
+		fmt.Fprintln(w)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "  no field changes")
+	}
+	for _, c := range changes {
+		switch {
+		case c.Old == "":
+			fmt.Fprintf(w, "  %s: %s\n", c.Field, c.New)
+		case c.New == "":
+			fmt.Fprintf(w, "  %s: removed (was %s)\n", c.Field, c.Old)
+		default:
+			fmt.Fprintf(w, "  %s: %s -> %s\n", c.Field, c.Old, c.New)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package itsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// searchLimit is how many turns /search shows.
+const searchLimit = 5
+
+// rrfK damps the ranks of keyword and embedding matches when they are
+// fused, as reciprocal rank fusion usually does.
+const rrfK = 60
+
+// saveTurn indexes turn index of session sessionID for search, with the
+// trace of its turn span. Turns from index on are replaced, so an undone
+// or regenerated turn is not found any more.
+func (s *TicketStore) saveTurn(sessionID, ticketID string, index int, prompt, reply string, sc trace.SpanContext) error {
+	var traceID, spanID string
+	if sc.IsValid() {
+		traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM conversation_turns WHERE session_id = ? AND turn_index >= ?`, sessionID, index); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO conversation_turns (prompt, reply, session_id, ticket_id, turn_index, trace_id, span_id, created_at, embedding)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, '')`,
+		prompt, reply, sessionID, ticketID, index, traceID, spanID, now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setTurnEmbedding stores the embedding of a turn indexed by saveTurn.
+func (s *TicketStore) setTurnEmbedding(sessionID string, index int, vector []float64) error {
+	data, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE conversation_turns SET embedding = ? WHERE session_id = ? AND turn_index = ?`, string(data), sessionID, index)
+	return err
+}
+
+// searchHit is a stored turn that matches a search.
+type searchHit struct {
+	SessionID string
+	TicketID  string
+	TurnIndex int
+	Prompt    string
+	Reply     string
+	// Snippet is the matching text with the query's words in brackets;
+	// for turns only the embeddings found, the start of the turn.
+	Snippet   string
+	TraceID   string
+	CreatedAt string
+	Score     float64
+
+	rowid int64
+}
+
+// TraceURL links to the trace of the hit's turn: ITSM_TRACE_URL with
+// {trace_id} and {session_id} filled in, or the trace ID as LangSmith
+// shows it without a template.
+func (h searchHit) TraceURL() string {
+	if h.TraceID == "" {
+		return ""
+	}
+	id := h.TraceID
+	if u, err := uuid.Parse(h.TraceID); err == nil {
+		id = u.String()
+	}
+	template := os.Getenv("ITSM_TRACE_URL")
+	if template == "" {
+		return "trace " + id
+	}
+	return strings.NewReplacer("{trace_id}", id, "{session_id}", h.SessionID).Replace(template)
+}
+
+// searchConversations finds the stored turns that best match query, in a
+// conversation_search span: by full text, and also by meaning when an
+// embedder is set, with the two rankings fused.
+func searchConversations(ctx context.Context, tracer trace.Tracer, store *TicketStore, embedder *embedder, query string, limit int) ([]searchHit, error) {
+	mode := "fulltext"
+	if embedder != nil {
+		mode = "hybrid"
+	}
+	ctx, span := tracer.Start(ctx, "conversation_search", trace.WithAttributes(
+		attribute.String("langsmith.trace.name", "conversation_search"),
+		attribute.String("langsmith.span.kind", "retriever"),
+		attribute.String("retrieval.query", query),
+		attribute.Int("retrieval.top_k", limit),
+		attribute.String("search.mode", mode),
+	))
+	defer span.End()
+	fail := func(err error) ([]searchHit, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// Ranked lists to fuse, best first
+	var rankings [][]searchHit
+	keyword, err := store.keywordSearch(query, limit*4)
+	if err != nil {
+		return fail(err)
+	}
+	rankings = append(rankings, keyword)
+	if embedder != nil {
+		vector, err := embedder.embed(ctx, tracer, query)
+		if err != nil {
+			return fail(fmt.Errorf("embedding the query: %w", err))
+		}
+		semantic, err := store.semanticSearch(vector, limit*4)
+		if err != nil {
+			return fail(err)
+		}
+		rankings = append(rankings, semantic)
+	}
+
+	fused := map[int64]*searchHit{}
+	for _, ranking := range rankings {
+		for rank, h := range ranking {
+			if f, ok := fused[h.rowid]; ok {
+				f.Score += 1 / float64(rrfK+rank+1)
+				continue
+			}
+			h.Score = 1 / float64(rrfK+rank+1)
+			fused[h.rowid] = &h
+		}
+	}
+	hits := make([]searchHit, 0, len(fused))
+	for _, h := range fused {
+		hits = append(hits, *h)
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].CreatedAt > hits[j].CreatedAt
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	ids := make([]string, len(hits))
+	scores := make([]float64, len(hits))
+	for i, h := range hits {
+		ids[i], scores[i] = fmt.Sprintf("%s#%d", h.SessionID, h.TurnIndex), h.Score
+	}
+	span.SetAttributes(
+		attribute.Int("retrieval.document_count", len(hits)),
+		attribute.StringSlice("retrieval.document_ids", ids),
+		attribute.Float64Slice("retrieval.scores", scores),
+	)
+	return hits, nil
+}
+
+// ftsQuery turns a search into an FTS5 query matching any of its words,
+// so punctuation in it is never read as query syntax.
+func ftsQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " OR ")
+}
+
+// keywordSearch ranks the turns containing the query's words by BM25.
+func (s *TicketStore) keywordSearch(query string, limit int) ([]searchHit, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`
+		SELECT rowid, session_id, ticket_id, turn_index, prompt, reply, trace_id, created_at,
+			snippet(conversation_turns, -1, '[', ']', '…', 12)
+		FROM conversation_turns WHERE conversation_turns MATCH ?
+		ORDER BY bm25(conversation_turns) LIMIT ?`, match, limit)
+	if err != nil {
+		return nil, fmt.Errorf("searching conversations: %w", err)
+	}
+	defer rows.Close()
+	var hits []searchHit
+	for rows.Next() {
+		var h searchHit
+		if err := rows.Scan(&h.rowid, &h.SessionID, &h.TicketID, &h.TurnIndex, &h.Prompt, &h.Reply, &h.TraceID, &h.CreatedAt, &h.Snippet); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// semanticSearch ranks the turns with embeddings by cosine similarity to
+// vector.
+func (s *TicketStore) semanticSearch(vector []float64, limit int) ([]searchHit, error) {
+	rows, err := s.db.Query(`
+		SELECT rowid, session_id, ticket_id, turn_index, prompt, reply, trace_id, created_at, embedding
+		FROM conversation_turns WHERE embedding != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("searching conversations: %w", err)
+	}
+	defer rows.Close()
+	var hits []searchHit
+	for rows.Next() {
+		var h searchHit
+		var data string
+		if err := rows.Scan(&h.rowid, &h.SessionID, &h.TicketID, &h.TurnIndex, &h.Prompt, &h.Reply, &h.TraceID, &h.CreatedAt, &data); err != nil {
+			return nil, err
+		}
+		var embedding []float64
+		if err := json.Unmarshal([]byte(data), &embedding); err != nil {
+			continue
+		}
+		h.Score = cosine(vector, embedding)
+		h.Snippet = oneLine(h.Prompt, 80)
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// embedder embeds text with an OpenAI-compatible embeddings API, for
+// search by meaning as well as by words.
+type embedder struct {
+	url    string
+	model  string
+	apiKey string
+	http   *http.Client
+}
+
+// embedderFromEnv configures embeddings from ITSM_EMBEDDINGS_URL, such as
+// https://api.openai.com/v1/embeddings, ITSM_EMBEDDINGS_MODEL (default
+// text-embedding-3-small) and ITSM_EMBEDDINGS_API_KEY. It returns nil when
+// the URL is not set, and search is by full text only.
+func embedderFromEnv() *embedder {
+	url := os.Getenv("ITSM_EMBEDDINGS_URL")
+	if url == "" {
+		return nil
+	}
+	e := &embedder{
+		url:    url,
+		model:  os.Getenv("ITSM_EMBEDDINGS_MODEL"),
+		apiKey: os.Getenv("ITSM_EMBEDDINGS_API_KEY"),
+		http:   &http.Client{Timeout: 15 * time.Second},
+	}
+	if e.model == "" {
+		e.model = "text-embedding-3-small"
+	}
+	return e
+}
+
+// embed returns the embedding of text, in an embedding span.
+func (e *embedder) embed(ctx context.Context, tracer trace.Tracer, text string) ([]float64, error) {
+	ctx, span := tracer.Start(ctx, "embedding", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "embedding"),
+		attribute.String("gen_ai.request.model", e.model),
+		attribute.Int("embedding.input_chars", len(text)),
+	))
+	defer span.End()
+	vector, err := func() ([]float64, error) {
+		body, err := json.Marshal(map[string]any{"model": e.model, "input": text})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if e.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+e.apiKey)
+		}
+		resp, err := e.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+		if err != nil {
+			return nil, err
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("embeddings API returned %d: %s", resp.StatusCode, data)
+		}
+		var out struct {
+			Data []struct {
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+			Usage struct {
+				PromptTokens int64 `json:"prompt_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+		if len(out.Data) == 0 || len(out.Data[0].Embedding) == 0 {
+			return nil, errors.New("embeddings API returned no embedding")
+		}
+		span.SetAttributes(
+			attribute.Int64("gen_ai.usage.input_tokens", out.Usage.PromptTokens),
+			attribute.Int("embedding.dimensions", len(out.Data[0].Embedding)),
+		)
+		return out.Data[0].Embedding, nil
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return vector, err
+}
+
+// printSearch shows the hits of /search, each with the link to its trace,
+// so a past resolution can be found and opened.
+func printSearch(w io.Writer, query string, hits []searchHit) {
+	if len(hits) == 0 {
+		fmt.Fprintf(w, "\nNo stored conversations match %q.\n\n", query)
+		return
+	}
+	fmt.Fprintln(w)
+	for i, h := range hits {
+		ticket := ""
+		if h.TicketID != "" {
+			ticket = ", " + h.TicketID
+		}
+		fmt.Fprintf(w, "%d. Session %s, turn %d%s, %s\n", i+1, h.SessionID, h.TurnIndex+1, ticket, shortTime(h.CreatedAt))
+		fmt.Fprintf(w, "   %s\n", oneLine(h.Snippet, 160))
+		fmt.Fprintf(w, "   Reply: %s\n", oneLine(h.Reply, 160))
+		if link := h.TraceURL(); link != "" {
+			fmt.Fprintf(w, "   %s\n", link)
+		}
+	}
+	fmt.Fprintln(w)
+}
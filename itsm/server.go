@@ -0,0 +1,693 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/guardrail"
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+	"go-tracing-demo/ratelimit"
+	"go-tracing-demo/tracehooks"
+)
+
+// tenant is a configured tenant with the bot that serves it and its open
+// sessions. Sessions are only visible to their own tenant.
+type tenant struct {
+	tenantConfig
+	bot   *Bot
+	quota *quota
+
+	mu       sync.Mutex
+	sessions map[string]*serverSession
+	// lastEviction is when idle sessions were last dropped.
+	lastEviction time.Time
+	// activity is counted for the daily digest
+	activity activity
+}
+
+// serverSession serializes the turns of one session; a session is not safe
+// for concurrent use.
+type serverSession struct {
+	mu sync.Mutex
+	*Session
+	// owner is the principal that opened the session.
+	owner string
+	// lastUsed is when the session was opened or last looked up; the
+	// tenant's mu guards it.
+	lastUsed time.Time
+}
+
+// server serves the ITSM bot over HTTP, one bot per tenant.
+type server struct {
+	tenants []*tenant
+	tickets *TicketStore
+	tracer  trace.Tracer
+	// auth is set when requests must carry a tenant token or API key: with
+	// ITSM_TENANTS_FILE, or ITSM_API_KEYS_FILE for a single tenant.
+	auth    bool
+	dryRun  bool
+	httpLog httpLogConfig
+	// sessionIdle is how long a session is kept in memory unused.
+	sessionIdle time.Duration
+	// draining is set once shutdown starts; new sessions and turns are
+	// turned away while open ones finish and their spans export.
+	draining atomic.Bool
+}
+
+// runServer serves the bot over HTTP until interrupted.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on; without API keys, only a loopback address")
+	dryRun := fs.Bool("dry-run", false, "log and trace external side effects (connector grants, webhooks) without executing them")
+	drainTimeout := fs.Duration("drain-timeout", 30*time.Second, "how long open requests may run after SIGINT or SIGTERM")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tenantsFile := os.Getenv("ITSM_TENANTS_FILE")
+	configs, err := loadTenants(tenantsFile)
+	if err != nil {
+		return err
+	}
+	if tenantsFile == "" && configs[0].AnthropicAPIKey == "" {
+		return errors.New("ANTHROPIC_API_KEY is required")
+	}
+	// Without keys every caller is an admin, which is only safe locally
+	auth := tenantsFile != "" || len(configs[0].APIKeys) > 0
+	if !auth && !loopback(*addr) {
+		return fmt.Errorf("refusing to serve %s without API keys: set ITSM_API_KEYS_FILE, or listen on a loopback address such as 127.0.0.1:8080", *addr)
+	}
+
+	// Each tenant's spans go to its own LangSmith project; the server's own
+	// spans (outbox deliveries) use LANGSMITH_API_KEY and LANGSMITH_PROJECT
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wrap []otlpexport.ExporterWrapper
+	if tenantsFile != "" && !otlpexport.TracingDisabled() {
+		router, err := newTenantRouter(ctx, configs)
+		if err != nil {
+			return err
+		}
+		wrap = append(wrap, router.wrap)
+	}
+	shutdown, err := initCommandTracer(wrap...)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok && tenantsFile != "" {
+		tp.RegisterSpanProcessor(tenantTagger{})
+	}
+	tracer := otel.Tracer("go-bot-itsm")
+
+	httpLog, err := httpLogFromEnv()
+	if err != nil {
+		return err
+	}
+	digestConfig, err := digestFromEnv()
+	if err != nil {
+		return err
+	}
+	retention, err := retentionFromEnv()
+	if err != nil {
+		return err
+	}
+	sessionIdle := 24 * time.Hour
+	if v := os.Getenv("ITSM_SESSION_IDLE_TIMEOUT"); v != "" {
+		if sessionIdle, err = time.ParseDuration(v); err != nil || sessionIdle <= 0 {
+			return fmt.Errorf("ITSM_SESSION_IDLE_TIMEOUT must be a positive duration, got %q", v)
+		}
+	}
+
+	// Tenants share the ticket store, and with it provision_access and the outbox
+	tickets, err := OpenTicketStore(os.Getenv("ITSM_DB"))
+	if err != nil {
+		return fmt.Errorf("opening ticket store: %w", err)
+	}
+	defer tickets.Close()
+
+	srv := &server{
+		tickets: tickets,
+		tracer:  tracer,
+		auth:    auth,
+		dryRun:  *dryRun,
+		httpLog: httpLog,
+
+		sessionIdle: sessionIdle,
+	}
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return err
+	}
+	// One pool bounds the model calls of every tenant, since they share
+	// the server's memory
+	pool, err := ratelimit.PoolFromEnv()
+	if err != nil {
+		return err
+	}
+	if pool != nil {
+		if err := pool.RegisterMetrics(otel.Meter("go-bot-itsm")); err != nil {
+			return fmt.Errorf("registering pool metrics: %w", err)
+		}
+	}
+	for _, c := range configs {
+		// Each tenant has its own key, so its own rate limits
+		scheduler, err := ratelimit.FromEnv()
+		if err != nil {
+			return err
+		}
+		client := anthropic.NewClient(
+			option.WithAPIKey(c.AnthropicAPIKey),
+			option.WithHTTPClient(traceanthropic.Client()),
+			tracehooks.Option(),
+			scheduler.Option(),
+			pool.Option(),
+			injector.Option(),
+		)
+		bot, err := NewBot(&client, tracer, tickets, *dryRun)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", c.Name, err)
+		}
+		// Feedback lands next to the tenant's runs
+		bot.feedback.APIKey = c.LangSmithAPIKey
+		if c.Budgets != nil {
+			bot.budget = *c.Budgets
+		}
+		if c.InputPolicyFile != "" {
+			if bot.inputPolicy, err = guardrail.Load(c.InputPolicyFile); err != nil {
+				return fmt.Errorf("tenant %s: loading input policy: %w", c.Name, err)
+			}
+		}
+		if c.TicketTemplateFile != "" {
+			if bot.ticketTemplate, err = loadTicketTemplate(c.TicketTemplateFile); err != nil {
+				return fmt.Errorf("tenant %s: %w", c.Name, err)
+			}
+		}
+		srv.tenants = append(srv.tenants, &tenant{
+			tenantConfig: c,
+			bot:          bot,
+			quota:        newQuota(c.RequestsPerMinute, c.MaxConcurrentTurns),
+			sessions:     map[string]*serverSession{},
+		})
+	}
+
+	sweeper, err := startRetentionSweeper(context.Background(), tickets, tracer, retention, srv.dropSessions)
+	if err != nil {
+		return err
+	}
+	dispatchCtx := context.Background()
+	if *dryRun {
+		dispatchCtx = connector.WithDryRun(dispatchCtx)
+	}
+	outbox := startDispatcher(dispatchCtx, tickets, tracer)
+	digests := startDigests(dispatchCtx, srv, digestConfig)
+	// Every tenant's bot reads the same approval timeout
+	approvals := startApprovalTimer(dispatchCtx, tickets, tracer, srv.tenants[0].bot.approvals)
+
+	httpServer := &http.Server{Addr: *addr, Handler: srv.routes()}
+	errc := make(chan error, 1)
+	go func() { errc <- httpServer.ListenAndServe() }()
+	log.Printf("Serving %d tenant(s) on %s", len(srv.tenants), *addr)
+	if injector != nil {
+		log.Printf("Fault injection: %s", injector.Describe())
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	srv.draining.Store(true)
+	log.Printf("Draining for up to %s", *drainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutting down server: %v", err)
+	}
+	approvals.Stop()
+	sweeper.Stop()
+	outbox.Stop()
+	digests.Stop()
+	for _, t := range srv.tenants {
+		t.bot.Wait()
+	}
+	return nil
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /v1/sessions", s.refuseWhileDraining(s.authorize(roleRequester, s.createSession)))
+	mux.HandleFunc("POST /v1/sessions/{id}/turns", s.refuseWhileDraining(s.authorize(roleRequester, s.runTurn)))
+	mux.HandleFunc("POST /v1/chat/stream", s.refuseWhileDraining(s.authorize(roleRequester, s.streamTurn)))
+	mux.HandleFunc("POST /v1/chat/completions", s.refuseWhileDraining(s.authorize(roleRequester, s.chatCompletions)))
+	mux.HandleFunc("GET /v1/tickets/{id}", s.authorize(roleRequester, s.getTicket))
+	mux.HandleFunc("POST /v1/tickets/{id}/approve", s.authorize(roleApprover, s.approveTicket))
+	mux.HandleFunc("POST /v1/tickets/{id}/deny", s.authorize(roleApprover, s.denyTicket))
+	mux.HandleFunc("GET /v1/tickets/{id}/comments", s.authorize(roleRequester, s.listComments))
+	mux.HandleFunc("POST /v1/tickets/{id}/comments", s.authorize(roleRequester, s.addComment))
+	mux.HandleFunc("GET /v1/tickets/{id}/transcript", s.authorize(roleRequester, s.getTranscript))
+	mux.HandleFunc("GET /v1/admin/budgets", s.authorize(roleAdmin, s.budgets))
+	mux.HandleFunc("POST /v1/admin/tracing", s.authorize(roleAdmin, s.setTracing))
+	return logRequests(s.httpLog, mux)
+}
+
+type createSessionRequest struct {
+	// Requester is the email the session's tickets are for; it defaults
+	// to the caller, and only admins may set someone else.
+	Requester string `json:"requester,omitempty"`
+}
+
+type sessionResponse struct {
+	SessionID string `json:"session_id"`
+}
+
+func (s *server) createSession(w http.ResponseWriter, r *http.Request, p *principal) {
+	var req createSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+			return
+		}
+	}
+	if req.Requester != "" && req.Requester != p.ID && !p.has(roleAdmin) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: only admins can open sessions for someone else", errForbidden))
+		return
+	}
+	sess := s.openSession(p, req.Requester)
+	noteRequest(r.Context(), sess.threadID, "")
+	writeJSON(w, http.StatusCreated, sessionResponse{SessionID: sess.threadID})
+}
+
+// openSession opens a session of p's tenant for requester, or for p when
+// requester is empty.
+func (s *server) openSession(p *principal, requester string) *serverSession {
+	t := p.tenant
+	sess := &serverSession{Session: t.bot.NewSession(), owner: p.ID}
+	switch {
+	case requester != "":
+		sess.requester = requester
+	case s.auth && p.ID != "tenant:"+t.Name:
+		// A tenant token speaks for no one in particular
+		sess.requester = p.ID
+	}
+	t.mu.Lock()
+	sess.lastUsed = time.Now()
+	t.evictIdleSessions(s.sessionIdle, sess.lastUsed)
+	t.sessions[sess.threadID] = sess
+	t.mu.Unlock()
+	t.activity.sessions.Add(1)
+	return sess
+}
+
+// evictIdleSessions drops the sessions unused for longer than idle, at most
+// once a minute, so memory doesn't grow with every session ever opened.
+// Their conversations stay in the store. The caller holds t.mu.
+func (t *tenant) evictIdleSessions(idle time.Duration, at time.Time) {
+	if idle <= 0 || at.Sub(t.lastEviction) < time.Minute {
+		return
+	}
+	t.lastEviction = at
+	for id, sess := range t.sessions {
+		if at.Sub(sess.lastUsed) > idle {
+			delete(t.sessions, id)
+		}
+	}
+}
+
+// dropSessions drops the sessions whose conversations a retention sweep
+// deleted.
+func (s *server) dropSessions(sweep retentionSweep) {
+	for _, t := range s.tenants {
+		t.mu.Lock()
+		for _, id := range sweep.SessionIDs {
+			delete(t.sessions, id)
+		}
+		t.mu.Unlock()
+	}
+}
+
+type turnRequest struct {
+	Message string `json:"message"`
+}
+
+func (s *server) runTurn(w http.ResponseWriter, r *http.Request, p *principal) {
+	sess, ok := s.session(w, p, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	var req turnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("message is required"))
+		return
+	}
+
+	release, err := p.tenant.quota.acquire()
+	if err != nil {
+		writeTurnError(w, err)
+		return
+	}
+	defer release()
+
+	resp, err := s.turn(r, p, sess, req.Message, nil)
+	if err != nil {
+		writeTurnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// session returns the session id of p's tenant if p may use it: its owner
+// and admins may.
+func (s *server) session(w http.ResponseWriter, p *principal, id string) (*serverSession, bool) {
+	t := p.tenant
+	t.mu.Lock()
+	sess := t.sessions[id]
+	if sess != nil {
+		sess.lastUsed = time.Now()
+	}
+	t.mu.Unlock()
+	if sess == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown session %q", id))
+		return nil, false
+	}
+	if sess.owner != p.ID && !p.has(roleAdmin) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: session %s belongs to %s", errForbidden, sess.threadID, sess.owner))
+		return nil, false
+	}
+	return sess, true
+}
+
+// turn runs one turn of sess for p, streaming the reply to onDelta when it
+// is set. The caller holds a quota slot.
+func (s *server) turn(r *http.Request, p *principal, sess *serverSession, message string, onDelta func(string)) (TurnResult, error) {
+	t := p.tenant
+	ctx := ratelimit.WithSession(withTenant(r.Context(), t.Name), sess.threadID)
+	if s.dryRun {
+		ctx = connector.WithDryRun(ctx)
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	result, err := sess.Turn(ctx, strings.TrimSpace(message), TurnOptions{Attributes: p.attributes(), OnDelta: onDelta})
+	t.activity.turns.Add(1)
+	if err != nil {
+		t.activity.failedTurns.Add(1)
+		return result, err
+	}
+	noteRequest(r.Context(), sess.threadID, result.TraceID)
+	return result, nil
+}
+
+// turnError is the body of a failed turn: the error, its boterr type, and
+// what to tell the user.
+type turnError struct {
+	Error   string `json:"error"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// writeTurnError answers a failed turn with the status boterr maps it to.
+func writeTurnError(w http.ResponseWriter, err error) {
+	status := boterr.HTTPStatus(err)
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, status, newTurnError(err))
+}
+
+func newTurnError(err error) turnError {
+	return turnError{Error: err.Error(), Type: boterr.Name(err), Message: boterr.UserMessage(err)}
+}
+
+// ticket returns the ticket named in the path if p may see it: requesters
+// see their own tickets, approvers and admins any of the tenant's. The
+// store is shared, so tickets of other tenants are unknown.
+func (s *server) ticket(w http.ResponseWriter, r *http.Request, p *principal) (AccessRequest, bool) {
+	id := r.PathValue("id")
+	ticket, ok := s.tickets.get(id)
+	if !ok || ticket.tenantName() != p.tenant.Name {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown ticket %q", id))
+		return AccessRequest{}, false
+	}
+	if !p.has(roleApprover) && ticket.RequesterEmail != p.ID {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: ticket %s is not yours", errForbidden, id))
+		return AccessRequest{}, false
+	}
+	return ticket, true
+}
+
+func (s *server) getTicket(w http.ResponseWriter, r *http.Request, p *principal) {
+	if ticket, ok := s.ticket(w, r, p); ok {
+		writeJSON(w, http.StatusOK, ticket)
+	}
+}
+
+// decisionBody is the optional body of an approve or deny request. The
+// approver is always the caller.
+type decisionBody struct {
+	Comment string `json:"comment"`
+}
+
+func (s *server) approveTicket(w http.ResponseWriter, r *http.Request, p *principal) {
+	s.decideTicket(w, r, p, false)
+}
+
+func (s *server) denyTicket(w http.ResponseWriter, r *http.Request, p *principal) {
+	s.decideTicket(w, r, p, true)
+}
+
+// decideTicket records p's decision on the ticket's next approval step.
+// Decisions the approval chain doesn't allow p to make are forbidden.
+func (s *server) decideTicket(w http.ResponseWriter, r *http.Request, p *principal, deny bool) {
+	ticket, ok := s.ticket(w, r, p)
+	if !ok {
+		return
+	}
+	var body decisionBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if deny && strings.TrimSpace(body.Comment) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("a denial needs a comment giving the reason"))
+		return
+	}
+	ctx := withTenant(r.Context(), p.tenant.Name)
+	ticket, err := decideTicket(ctx, s.tracer, s.tickets, p.tenant.bot.approvals, decisionRequest{
+		TicketID: ticket.ID, Approver: p.ID, Comment: body.Comment, Deny: deny,
+	}, p.attributes()...)
+	switch {
+	case errors.Is(err, errApprovalPolicy):
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: %w", errForbidden, err))
+	case err != nil:
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeJSON(w, http.StatusOK, ticket)
+	}
+}
+
+type commentRequest struct {
+	Body string `json:"body"`
+	// DraftReply has the bot draft a reply from the ticket's conversation.
+	DraftReply bool `json:"draft_reply"`
+}
+
+type commentsResponse struct {
+	Comments []ticketComment `json:"comments"`
+}
+
+func (s *server) listComments(w http.ResponseWriter, r *http.Request, p *principal) {
+	ticket, ok := s.ticket(w, r, p)
+	if !ok {
+		return
+	}
+	comments, err := s.tickets.comments(ticket.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, commentsResponse{Comments: comments})
+}
+
+// getTranscript answers with the Markdown transcript attached to the
+// ticket when it was submitted.
+func (s *server) getTranscript(w http.ResponseWriter, r *http.Request, p *principal) {
+	ticket, ok := s.ticket(w, r, p)
+	if !ok {
+		return
+	}
+	markdown, ok, err := s.tickets.attachment(ticket.ID, transcriptAttachment)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("ticket %s has no transcript attached", ticket.ID))
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	io.WriteString(w, markdown)
+}
+
+// addComment adds p's comment to the ticket's thread, and a reply drafted
+// by the tenant's bot when asked. It answers with the comments added.
+func (s *server) addComment(w http.ResponseWriter, r *http.Request, p *principal) {
+	ticket, ok := s.ticket(w, r, p)
+	if !ok {
+		return
+	}
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the comment body is empty"))
+		return
+	}
+	var drafter *replyDrafter
+	if req.DraftReply {
+		drafter = p.tenant.bot.replyDrafter()
+	}
+	ctx := withTenant(r.Context(), p.tenant.Name)
+	added, err := commentOnTicket(ctx, s.tracer, s.tickets, drafter, ticket.ID, p.ID, req.Body, p.attributes()...)
+	if err != nil && len(added) == 0 {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// The comment was added even if its reply could not be drafted
+	if err != nil {
+		log.Printf("Drafting a reply on %s: %v", ticket.ID, err)
+	}
+	writeJSON(w, http.StatusCreated, commentsResponse{Comments: added})
+}
+
+type budgetsResponse struct {
+	Tenant []budgetState            `json:"tenant"`
+	Users  map[string][]budgetState `json:"users"`
+}
+
+// budgets reports the tenant's budgets and those of every user who has
+// spent this month.
+func (s *server) budgets(w http.ResponseWriter, r *http.Request, p *principal) {
+	t := p.tenant
+	tenantScope, _ := spendScopes(t.Name, "")
+	_, month := periodStarts(time.Now())
+	users, err := s.tickets.spenders(t.Name, month)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := budgetsResponse{Tenant: []budgetState{}, Users: map[string][]budgetState{}}
+	states, err := s.tickets.budgetStates(t.bot.budget, t.Name, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, st := range states {
+		if st.Scope == tenantScope {
+			resp.Tenant = append(resp.Tenant, st)
+		}
+	}
+	for _, user := range users {
+		states, err := s.tickets.budgetStates(t.bot.budget, t.Name, user)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Users[user] = []budgetState{}
+		for _, st := range states {
+			if st.Scope != tenantScope {
+				resp.Users[user] = append(resp.Users[user], st)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// refuseWhileDraining turns requests away with 503 once shutdown has
+// started, so a load balancer retries them on another instance.
+func (s *server) refuseWhileDraining(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, errors.New("server is shutting down"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+type tracingRequest struct {
+	Verbosity string `json:"verbosity"`
+}
+
+type tracingResponse struct {
+	Verbosity payload.Verbosity `json:"verbosity"`
+}
+
+// setTracing switches trace verbosity between full and metadata for the
+// whole process, so every tenant is affected. An empty verbosity only
+// reports the current one.
+func (s *server) setTracing(w http.ResponseWriter, r *http.Request, p *principal) {
+	var req tracingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if req.Verbosity != "" {
+		v, err := payload.ParseVerbosity(req.Verbosity)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		payload.SetVerbosity(v)
+		log.Printf("Trace verbosity set to %s by %s", v, p.ID)
+	}
+	writeJSON(w, http.StatusOK, tracingResponse{Verbosity: payload.CurrentVerbosity()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
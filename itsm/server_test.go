@@ -0,0 +1,64 @@
+package itsm
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestServerTicketTenant(t *testing.T) {
+	store := testStore(t)
+	s := authServer(true)
+	s.tickets = store
+	// Tickets from the CLI, an import or before a restart are found by the
+	// tenant stored with them
+	for id, tenant := range map[string]string{"AR-1": "acme", "AR-2": "globex"} {
+		ticket := AccessRequest{ID: id, Type: "access_request", Resource: "github", Status: statusDraft, RequesterEmail: "ada@example.com"}
+		if err := store.put(withTenant(context.Background(), tenant), ticket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := store.get("AR-1"); got.Tenant != "acme" {
+		t.Fatalf("stored tenant = %q, want acme", got.Tenant)
+	}
+
+	for _, tt := range []struct {
+		key, id string
+		status  int
+	}{
+		{key: "acme-token", id: "AR-1", status: http.StatusOK},
+		{key: "ada-key", id: "AR-1", status: http.StatusOK},
+		{key: "acme-token", id: "AR-2", status: http.StatusNotFound},
+		{key: "hank-key", id: "AR-2", status: http.StatusOK},
+		{key: "hank-key", id: "AR-1", status: http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/tickets/"+tt.id, nil)
+		r.SetPathValue("id", tt.id)
+		r.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		s.authorize(roleRequester, s.getTicket)(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s getting %s: status %d, want %d (%s)", tt.key, tt.id, w.Code, tt.status, w.Body)
+		}
+	}
+}
+
+func TestSessionEviction(t *testing.T) {
+	s := &server{sessionIdle: time.Hour}
+	acme := &tenant{sessions: map[string]*serverSession{}}
+	s.tenants = []*tenant{acme}
+	at := time.Now()
+	for id, idle := range map[string]time.Duration{"idle": 2 * time.Hour, "recent": time.Minute, "swept": time.Minute} {
+		acme.sessions[id] = &serverSession{lastUsed: at.Add(-idle)}
+	}
+
+	acme.evictIdleSessions(s.sessionIdle, at)
+	s.dropSessions(retentionSweep{SessionIDs: []string{"swept"}})
+	if ids := slices.Sorted(maps.Keys(acme.sessions)); !slices.Equal(ids, []string{"recent"}) {
+		t.Errorf("sessions left = %q, want recent only", ids)
+	}
+}
@@ -0,0 +1,814 @@
+// Package itsm is the ITSM access-request assistant: a traced bot that
+// drafts access tickets from a conversation, screens and provisions them,
+// and the go-bot-itsm command around it. Services embed it with NewBot,
+// NewSession and RunTurn:
+//
+//	tickets, err := itsm.OpenTicketStore("")
+//	bot, err := itsm.NewBot(&client, otel.Tracer("my-service"), tickets, false)
+//	s := bot.NewSession()
+//	result, err := s.RunTurn(ctx, "I need read access to Snowflake for a week")
+package itsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/feedback"
+	"go-tracing-demo/guardrail"
+	"go-tracing-demo/historytrim"
+	"go-tracing-demo/jobs"
+	"go-tracing-demo/knowledge"
+	"go-tracing-demo/otlpexport"
+	"go-tracing-demo/payload"
+	"go-tracing-demo/persona"
+	"go-tracing-demo/tools"
+)
+
+// Bot is what every conversation shares: clients, configuration and the
+// ticket store. Sessions may use it concurrently.
+type Bot struct {
+	client   *anthropic.Client
+	tracer   trace.Tracer
+	tickets  *TicketStore
+	feedback *feedback.Client
+	// jobs runs the work left after a turn is answered
+	jobs     *jobs.Runner
+	payloads payload.Options
+	tracing  bool
+	dryRun   bool
+	// deterministicIDs derives each turn's trace ID from the session ID and
+	// turn index, so a retried turn keeps the original's trace ID.
+	deterministicIDs bool
+
+	bot, chatBot *persona.Persona
+	inputPolicy  *guardrail.Policy
+	executor     *tools.Executor
+	locale       string
+	systemPrompt string
+	driftMode    driftAction
+	planner      bool
+
+	sodRules            []sodRule
+	approvals           approvalPolicy
+	autoApprove         bool
+	justificationMin    float64
+	clarificationBudget int
+	budget              budgetConfig
+	historyTrimmer      *historytrim.Trimmer
+	contextGuard        *historytrim.Guard
+	policyDocs          *knowledge.Base
+	trackers            []connector.Tracker
+	ticketTemplate      *ticketTemplate
+	// embedder, when set, lets /search find conversations by meaning
+	embedder *embedder
+	// models picks the model for turns and for each kind of side call
+	models chat.Models
+}
+
+// NewBot loads the bot's configuration from the environment and
+// registers its tools. client should carry the tracing and rate limiting
+// options the bot's calls are to have; tickets are kept in the store.
+// With dryRun, connectors and webhooks are only logged and traced.
+func NewBot(client *anthropic.Client, tracer trace.Tracer, tickets *TicketStore, dryRun bool) (*Bot, error) {
+	b := &Bot{
+		client:   client,
+		tracer:   tracer,
+		tickets:  tickets,
+		feedback: feedback.FromEnv(),
+		tracing:  !otlpexport.TracingDisabled(),
+		dryRun:   dryRun,
+
+		deterministicIDs: otlpexport.DeterministicIDs(),
+	}
+	var err error
+
+	if b.models, err = chat.ModelsFromEnv(); err != nil {
+		return nil, err
+	}
+
+	if b.jobs, err = jobs.FromEnv(); err != nil {
+		return nil, err
+	}
+	if b.jobs != nil {
+		b.jobs.Tracer = tracer
+	}
+
+	// Large prompts, completions and ticket JSON may go out as events or compressed
+	if b.payloads, err = payload.FromEnv(); err != nil {
+		return nil, err
+	}
+
+	if b.inputPolicy, err = guardrail.Load(os.Getenv("INPUT_POLICY_FILE")); err != nil {
+		return nil, fmt.Errorf("loading input policy: %w", err)
+	}
+
+	personas, err := persona.Load(os.Getenv("PERSONAS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("loading personas: %w", err)
+	}
+	if b.bot, err = personas.Get("itsm"); err != nil {
+		return nil, err
+	}
+	// Off-topic turns can be routed to the generic chat persona
+	if b.chatBot, err = personas.Get("chat"); err != nil {
+		return nil, err
+	}
+
+	// Draft-time checks: SoD conflicts block auto-approval
+	if b.sodRules, err = loadSoDRules(); err != nil {
+		return nil, fmt.Errorf("loading SoD rules: %w", err)
+	}
+	b.autoApprove, _ = strconv.ParseBool(os.Getenv("ITSM_AUTO_APPROVE"))
+	// Who may decide each approval step, and who stands in for whom
+	if b.approvals, err = loadApprovalPolicy(); err != nil {
+		return nil, err
+	}
+
+	// Justifications scoring below this are sent back for more detail
+	if b.justificationMin, err = justificationThresholdFromEnv(); err != nil {
+		return nil, err
+	}
+
+	// Clarifying rounds allowed before the ticket goes to a human
+	if b.clarificationBudget, err = clarificationBudgetFromEnv(); err != nil {
+		return nil, err
+	}
+
+	// Long histories are cut to HISTORY_MAX_TOKENS before each request
+	if b.historyTrimmer, err = historytrim.FromEnv(client, b.models.Summary); err != nil {
+		return nil, err
+	}
+	if b.contextGuard, err = historytrim.GuardFromEnv(b.models.Chat); err != nil {
+		return nil, err
+	}
+
+	// Policy sections answers must cite, when POLICY_DOCS_DIR is set
+	if b.policyDocs, err = knowledge.FromEnv(); err != nil {
+		return nil, fmt.Errorf("loading policy documents: %w", err)
+	}
+
+	// Spend budgets for the tenant and each requester
+	if b.budget, err = budgetsFromEnv(); err != nil {
+		return nil, err
+	}
+
+	simConfig, err := simulatorConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	connectors := map[string]connector.Connector{}
+	github, err := connector.GitHubFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if github != nil {
+		connectors[github.Name()] = github
+	}
+	snowflake, err := connector.SnowflakeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if snowflake != nil {
+		connectors[snowflake.Name()] = snowflake
+	}
+	datadog, err := connector.DatadogFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if datadog != nil {
+		connectors[datadog.Name()] = datadog
+	}
+	// The organization's own ticket fields
+	if b.ticketTemplate, err = loadTicketTemplate(os.Getenv("ITSM_TICKET_TEMPLATE")); err != nil {
+		return nil, err
+	}
+	// Ticketing systems submitted tickets and their transcripts go to
+	if b.trackers, err = connector.TrackersFromEnv(); err != nil {
+		return nil, err
+	}
+	// Embeddings of stored turns, for /search by meaning
+	b.embedder = embedderFromEnv()
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	for name, c := range connectors {
+		connectors[name] = injector.Connector(c)
+	}
+	registerProvisioningTool(tickets, simConfig, connectors)
+
+	// Tools the persona may call, run concurrently under the turn span
+	if len(b.bot.Tools) > 0 {
+		toolConfig, err := tools.ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if summarize, _ := strconv.ParseBool(os.Getenv("TOOL_RESULT_SUMMARIZE")); summarize {
+			toolConfig.Summarize = chat.ToolResultSummarizer(client, b.models.Summary)
+		}
+		toolset, err := tools.Lookup(b.bot.Tools...)
+		if err != nil {
+			return nil, fmt.Errorf("persona %s: %w", b.bot.Name, err)
+		}
+		b.executor = tools.NewExecutor(tracer, toolConfig, toolset)
+	}
+
+	b.locale = resolveLocale()
+	b.systemPrompt = localizedSystemPrompt(b.bot.SystemPrompt(b.locale), b.locale)
+
+	if b.driftMode, err = resolveDriftAction(); err != nil {
+		return nil, err
+	}
+
+	// Planner mode: plan first, then execute each step as its own span
+	b.planner, _ = strconv.ParseBool(os.Getenv("ITSM_PLANNER"))
+	return b, nil
+}
+
+// Session is one conversation: its thread, its ticket and its history.
+// A Session is not safe for concurrent use.
+type Session struct {
+	*Bot
+
+	threadID       string
+	ticketID       string
+	requester      string
+	clarifications int
+	history        []anthropic.MessageParam
+
+	// Answered turns, oldest first, so /undo and /retry can rewind them
+	turns []turnRecord
+
+	// Set after /fork so the next turn links back to where the branch started
+	forkedFrom string
+	forkLinks  []trace.Link
+
+	// imported counts the history messages brought in with --import
+	imported int
+	// compactedTurns counts the turns folded into the summary by the last
+	// /compact; they can't be undone or retried.
+	compactedTurns int
+}
+
+// NewSession starts a conversation with a new session ID, for
+// ITSM_REQUESTER_EMAIL unless SetRequester changes it.
+func (b *Bot) NewSession() *Session {
+	return &Session{
+		Bot:       b,
+		threadID:  uuid.New().String(),
+		requester: os.Getenv("ITSM_REQUESTER_EMAIL"),
+	}
+}
+
+// replyDrafter drafts replies to ticket comments with the bot's chat
+// model.
+func (b *Bot) replyDrafter() *replyDrafter {
+	return &replyDrafter{client: b.client, model: b.models.Chat}
+}
+
+// Wait waits for the jobs and feedback the bot's turns left running in the
+// background. Call it before exiting, then flush the tracer provider.
+func (b *Bot) Wait() {
+	b.jobs.Wait()
+	b.feedback.Wait()
+}
+
+// ID is the session ID the turns are threaded by in LangSmith.
+func (s *Session) ID() string {
+	return s.threadID
+}
+
+// SetRequester sets the email the session's ticket is requested by. It
+// only affects a ticket not drafted yet.
+func (s *Session) SetRequester(email string) {
+	s.requester = email
+}
+
+// TicketID is the ID of the session's ticket, empty until a turn drafts one.
+func (s *Session) TicketID() string {
+	return s.ticketID
+}
+
+// History is the conversation so far.
+func (s *Session) History() []anthropic.MessageParam {
+	return s.history
+}
+
+// Import continues an imported conversation, under its session ID when it
+// has one.
+func (s *Session) Import(imported chat.Imported) {
+	s.history = imported.Messages
+	s.imported = len(imported.Messages)
+	if imported.SessionID != "" {
+		s.threadID = imported.SessionID
+	}
+}
+
+// TurnSpans are the span contexts of the answered turns, oldest first.
+func (s *Session) TurnSpans() []trace.SpanContext {
+	spans := make([]trace.SpanContext, len(s.turns))
+	for i, t := range s.turns {
+		spans[i] = t.Span
+	}
+	return spans
+}
+
+// CanRewind reports whether there is a turn Undo or Retry can rewind.
+func (s *Session) CanRewind() bool {
+	return len(s.turns) > s.compactedTurns
+}
+
+// Fork continues the conversation under a new session ID, keeping the
+// history and the ticket. The next turn links back to the last one of the
+// old session. It returns the old session ID.
+func (s *Session) Fork() string {
+	s.forkedFrom = s.threadID
+	s.threadID = uuid.New().String()
+	s.forkLinks = nil
+	if len(s.turns) > 0 {
+		s.forkLinks = append(s.forkLinks, trace.Link{
+			SpanContext: s.turns[len(s.turns)-1].Span,
+			Attributes: []attribute.KeyValue{
+				attribute.String("langsmith.metadata.forked_from", s.forkedFrom),
+			},
+		})
+	}
+	return s.forkedFrom
+}
+
+// Undo removes the last exchange from the history. Changes the turn made
+// to the ticket are kept.
+func (s *Session) Undo() error {
+	if !s.CanRewind() {
+		return chat.ErrNoTurn
+	}
+	s.turns = s.turns[:len(s.turns)-1]
+	s.history = s.history[:len(s.history)-2]
+	return nil
+}
+
+// Retry answers the last turn's message again, replacing its answer. It is
+// Turn with opts.Regenerate set.
+func (s *Session) Retry(ctx context.Context, opts TurnOptions) (TurnResult, error) {
+	opts.Regenerate = true
+	return s.Turn(ctx, "", opts)
+}
+
+// TurnOptions vary how a turn is run.
+type TurnOptions struct {
+	// Regenerate answers the last turn's message again instead of the new one.
+	Regenerate  bool
+	Temperature param.Opt[float64]
+	// Canned names the canned prompt the message came from.
+	Canned string
+	// Attributes are added to the turn span.
+	Attributes []attribute.KeyValue
+	// OnDelta, when set, streams the reply: it gets each piece of text as
+	// the model writes it.
+	OnDelta func(text string)
+}
+
+// TurnResult is what a turn produced: the chat result, with the ticket the
+// turn worked on.
+type TurnResult struct {
+	chat.TurnResult
+	// TicketID is the session's ticket, and TicketDraft the ticket as this
+	// turn left it when the turn drafted one.
+	TicketID    string         `json:"ticket_id,omitempty"`
+	TicketDraft *AccessRequest `json:"ticket_draft,omitempty"`
+	// Escalated is set when this turn handed the ticket to a human, with
+	// Handoff summarizing it for them.
+	Escalated bool   `json:"escalated,omitempty"`
+	Handoff   string `json:"handoff,omitempty"`
+	// BudgetWarning is set when the turn ran close to a spend budget.
+	BudgetWarning string `json:"budget_warning,omitempty"`
+	// Citations are the policy sections the answer cited, when policy
+	// documents are loaded.
+	Citations []string `json:"citations,omitempty"`
+}
+
+// RunTurn answers input within one traced turn span. It is Turn with no
+// options.
+func (s *Session) RunTurn(ctx context.Context, input string) (TurnResult, error) {
+	return s.Turn(ctx, input, TurnOptions{})
+}
+
+// Turn answers userMessage within one traced turn span. History is only
+// updated once the turn succeeds. Errors are of the boterr kinds where they
+// have one, and are recorded on the turn span; a message the input policy
+// blocks fails with boterr.ErrGuardrailBlocked, whose user message is the
+// policy's response. Regenerating with no turn to rewind, on a new session
+// or right after compaction, fails with chat.ErrNoTurn.
+func (s *Session) Turn(ctx context.Context, userMessage string, opts TurnOptions) (result TurnResult, err error) {
+	// Set by /retry when the last answer is being regenerated
+	var regenerated *turnRecord
+	if opts.Regenerate {
+		if !s.CanRewind() {
+			return result, chat.ErrNoTurn
+		}
+		regenerated = &s.turns[len(s.turns)-1]
+		userMessage = regenerated.Prompt
+		opts.Canned = regenerated.Canned
+	}
+
+	var messages []anthropic.MessageParam
+	if regenerated != nil {
+		// Answer the last user message again, dropping the previous reply
+		messages = s.history[:len(s.history)-1]
+	} else {
+		messages = append(s.history,
+			anthropic.NewUserMessage(anthropic.NewTextBlock(userMessage)),
+		)
+	}
+
+	// Span per turn (threaded via session_id)
+	turnCtx, turnSpan := s.startTurnSpan(ctx, userMessage, messages, opts, regenerated)
+	defer turnSpan.End()
+	defer func() { boterr.Record(turnSpan, err) }()
+	s.forkLinks = nil
+
+	// Ticket revisions written during the turn name the requester and the turn
+	turnIndex := len(s.turns)
+	if regenerated != nil {
+		turnIndex--
+	}
+	turnCtx = withChange(turnCtx, change{Actor: s.requester, SessionID: s.threadID, TurnIndex: turnIndex})
+
+	// Screen the input before it reaches the model
+	decision := s.inputPolicy.Check(userMessage)
+	turnSpan.SetAttributes(attribute.String("guardrail.input.outcome", string(decision.Outcome)))
+	if decision.Rule != "" {
+		turnSpan.SetAttributes(attribute.String("guardrail.input.rule", decision.Rule))
+	}
+	if decision.Outcome == guardrail.OutcomeBlocked {
+		turnSpan.SetAttributes(attribute.String("gen_ai.completion", s.inputPolicy.Response))
+		return result, boterr.New(boterr.ErrGuardrailBlocked, s.inputPolicy.Response, fmt.Errorf("message blocked by input policy rule %q", decision.Rule))
+	}
+
+	// Turns over a spend budget stop here, before any model call
+	warning, err := s.checkBudget(turnCtx, turnSpan, s.requester)
+	if err != nil {
+		return result, err
+	}
+	result.BudgetWarning = warning
+
+	// Keep the conversation on access requests
+	system := s.systemPrompt
+	category := "access_request_demo"
+	if s.driftMode != driftOff && !classifyTopic(turnCtx, s.client, s.tracer, s.models.Classifier, messages, userMessage, s.driftMode) {
+		switch s.driftMode {
+		case driftSteer:
+			system += "\n\n" + steerBackInstruction
+			category = "off_topic"
+		case driftChat:
+			system = localizedSystemPrompt(s.chatBot.SystemPrompt(s.locale), s.locale)
+			category = "general_chat"
+			turnSpan.SetAttributes(s.chatBot.Attributes()...)
+		}
+	}
+	turnSpan.SetAttributes(attribute.String("itsm.category", category))
+
+	// Fold this message into the session's ticket and show it to the model
+	drafting := s.bot.Extraction == "access_request" && category == "access_request_demo"
+	if drafting {
+		draft := inferAccessRequestDraft(userMessage)
+		draft.Fields = s.ticketTemplate.extract(userMessage)
+		merge := s.ticketID != ""
+		if !merge {
+			// A session submits one ticket, even when it is resumed
+			// elsewhere (e.g. from an exported conversation)
+			draft.RequesterEmail = s.requester
+			s.ticketTemplate.apply(&draft)
+			key := submissionKey(s.threadID)
+			id, decision, err := s.tickets.submit(turnCtx, key, draft)
+			if err != nil {
+				log.Printf("Saving ticket %s: %v", draft.ID, err)
+				id = draft.ID
+			}
+			turnSpan.SetAttributes(
+				attribute.String("idempotency.key", key),
+				attribute.String("idempotency.decision", decision),
+			)
+			s.ticketID = id
+			merge = decision == idempotencyReplayed
+		}
+		if merge {
+			s.tickets.update(turnCtx, s.ticketID, func(t *AccessRequest) error {
+				mergeDraft(t, draft)
+				return nil
+			})
+		}
+
+		// Grade the justification given so far; judge errors keep the last grade
+		justification, err := scoreJustification(turnCtx, s.client, s.tracer, s.models.Classifier, userTexts(messages))
+		if err != nil {
+			log.Printf("Scoring justification: %v", err)
+		} else {
+			s.tickets.update(turnCtx, s.ticketID, func(t *AccessRequest) error {
+				t.JustificationScore = justification.Score
+				t.NeedsJustification = justification.Score < s.justificationMin
+				if justification.Justification != "" {
+					t.BusinessJustif = justification.Justification
+				}
+				return nil
+			})
+			turnSpan.SetAttributes(attribute.Float64("itsm.justification.score", justification.Score))
+			s.feedback.PostAsync(turnSpan.SpanContext(), feedback.Score{
+				Key:     "justification_quality",
+				Score:   justification.Score,
+				Comment: justification.Rationale,
+			})
+		}
+
+		ticket, err := screenDraft(turnCtx, s.tickets, s.sodRules, s.ticketID, s.autoApprove)
+		if err != nil {
+			log.Printf("Screening ticket %s: %v", s.ticketID, err)
+		}
+		system += "\n\n" + ticketContext(ticket)
+		if instruction := s.ticketTemplate.instruction(); instruction != "" {
+			system += "\n\n" + instruction
+		}
+
+		// Each incomplete turn costs a clarifying round; past the budget a
+		// human takes over instead of the bot asking again
+		if ticket.Status == statusDraft && len(missingFields(ticket)) > 0 {
+			s.clarifications++
+		}
+		turnSpan.SetAttributes(attribute.Int("itsm.clarification_rounds", s.clarifications))
+		switch {
+		case ticket.Status == statusDraft && s.clarifications > s.clarificationBudget:
+			handoff, err := writeHandoff(turnCtx, s.client, s.tracer, s.models.Summary, messages, ticket)
+			if err != nil {
+				log.Printf("Writing handoff summary: %v", err)
+				handoff = "Incomplete after " + strconv.Itoa(s.clarificationBudget) + " clarifying rounds; missing: " + strings.Join(missingFields(ticket), ", ")
+			}
+			ticket, _ = s.tickets.update(turnCtx, s.ticketID, func(t *AccessRequest) error {
+				t.Status = statusEscalated
+				t.HandoffSummary = handoff
+				return nil
+			})
+			turnSpan.SetAttributes(attribute.Bool("langsmith.metadata.escalated", true))
+			system += "\n\n" + fmt.Sprintf(escalationInstruction, ticket.ID)
+			result.Escalated = true
+			result.Handoff = handoff
+		case ticket.NeedsJustification:
+			missing := strings.Join(justification.Missing, ", ")
+			if missing == "" {
+				missing = "more detail"
+			}
+			system += "\n\n" + fmt.Sprintf(askForJustification, missing)
+		}
+	}
+
+	if s.planner && category == "access_request_demo" {
+		turnSpan.SetAttributes(attribute.Bool("itsm.planner", true))
+		var run toolRunner
+		if s.executor != nil {
+			run = s.executor.Call
+		}
+		var err error
+		system, err = runPlanner(turnCtx, s.client, s.tracer, s.models.Chat, system, messages, s.bot.Tools, run)
+		if err != nil {
+			log.Printf("Planner failed, answering without a plan: %v", err)
+		}
+	}
+
+	// Ground policy statements in the sections relevant to this message
+	var policy []knowledge.Chunk
+	if s.policyDocs != nil && category == "access_request_demo" {
+		policy = s.policyDocs.Retrieve(turnCtx, s.tracer, userMessage)
+		if len(policy) > 0 {
+			system += "\n\n" + knowledge.Instruction(policy)
+		}
+	}
+
+	// The full history is kept; only the request is trimmed
+	request, err := s.historyTrimmer.Trim(turnCtx, messages)
+	if err != nil {
+		log.Printf("Trimming history, sending it whole: %v", err)
+	}
+	var contextWarning string
+	if request, contextWarning, err = s.contextGuard.Check(turnCtx, turnSpan, system, request, 1024); err != nil {
+		log.Printf("Checking the context window: %v", err)
+	}
+	resp, err := chat.GenerateStream(turnCtx, s.client, anthropic.MessageNewParams{
+		Model:       anthropic.Model(s.models.Chat),
+		MaxTokens:   1024,
+		Temperature: opts.Temperature,
+		System: []anthropic.TextBlockParam{
+			{Text: system},
+		},
+		Messages: request,
+	}, s.executor, opts.OnDelta)
+	if err != nil {
+		return result, err
+	}
+	result.TurnResult = chat.NewTurnResult(s.threadID, s.models.Chat, turnSpan.SpanContext(), resp)
+	result.ContextWarning = contextWarning
+
+	responseText := resp.Text
+	s.recordCompletion(turnSpan, resp)
+	s.recordSpend(turnCtx, turnSpan, s.requester, s.models.Chat, resp)
+	if s.policyDocs != nil && category == "access_request_demo" {
+		citations := knowledge.Check(responseText, policy)
+		citations.Record(turnSpan)
+		result.Citations = citations.Cited
+		score := feedback.Score{Key: "citations_valid", Comment: strings.Join(citations.Invalid, ", ")}
+		if citations.Valid() {
+			score.Score = 1
+		}
+		s.feedback.PostAsync(turnSpan.SpanContext(), score)
+	}
+
+	// Only access-request turns produce a ticket draft; tools may have
+	// changed its status during the turn
+	result.TicketID = s.ticketID
+	if drafting {
+		ticket, _ := s.tickets.get(s.ticketID)
+		result.TicketDraft = &ticket
+	}
+	if drafting && s.tracing {
+		ticket := *result.TicketDraft
+		s.payloads.JSON(turnSpan, "itsm.ticket_draft_json", s.tickets.classes.mask(ticket))
+		turnSpan.SetAttributes(
+			attribute.String("itsm.ticket.id", ticket.ID),
+			attribute.String("itsm.ticket.status", ticket.Status),
+			attribute.Int("itsm.ticket.revision", s.tickets.latestRevision(ticket.ID)),
+		)
+
+		// Cheap automatic checks, posted as pass/fail feedback on this run
+		var scores []feedback.Score
+		for _, c := range checkTurn(responseText, ticket, s.ticketTemplate) {
+			turnSpan.SetAttributes(attribute.Bool("eval."+c.Key, c.Pass))
+			score := feedback.Score{Key: c.Key, Comment: c.Detail}
+			if c.Pass {
+				score.Score = 1
+			}
+			scores = append(scores, score)
+		}
+		// Posted once the user has the answer, by a job linked to this turn
+		run := turnSpan.SpanContext()
+		s.jobs.Go(turnCtx, "feedback.post", func(ctx context.Context) error {
+			var errs []error
+			for _, score := range scores {
+				if err := s.feedback.Post(ctx, run, score); err != nil {
+					errs = append(errs, fmt.Errorf("posting %s feedback: %w", score.Key, err))
+				}
+			}
+			return errors.Join(errs...)
+		}, attribute.Int("feedback.count", len(scores)))
+	}
+
+	// Add assistant response to history
+	s.history = append(messages,
+		anthropic.NewAssistantMessage(anthropic.NewTextBlock(responseText)),
+	)
+	// Kept with the ticket, so replies to approvers can draw on it later
+	if s.ticketID != "" {
+		if err := s.tickets.saveConversation(s.threadID, s.ticketID, s.requester, s.history); err != nil {
+			log.Printf("Saving the conversation of %s: %v", s.ticketID, err)
+		}
+		// Each turn is indexed for /search, with the trace it is in
+		index := len(s.turns)
+		if regenerated != nil {
+			index--
+		}
+		if err := s.tickets.saveTurn(s.threadID, s.ticketID, index, userMessage, responseText, turnSpan.SpanContext()); err != nil {
+			log.Printf("Indexing the conversation of %s: %v", s.ticketID, err)
+		} else if s.embedder != nil {
+			session, text := s.threadID, userMessage+"\n\n"+responseText
+			s.jobs.Go(turnCtx, "search.embed", func(ctx context.Context) error {
+				vector, err := s.embedder.embed(ctx, s.tracer, text)
+				if err != nil {
+					return err
+				}
+				return s.tickets.setTurnEmbedding(session, index, vector)
+			}, attribute.Int("search.turn_index", index))
+		}
+		// On submission the transcript goes with the ticket, for approvers
+		if ticket, ok := s.tickets.get(s.ticketID); ok && submitted(ticket) {
+			id, session := s.ticketID, s.threadID
+			s.jobs.Go(turnCtx, "transcript.attach", func(ctx context.Context) error {
+				return attachTranscript(ctx, s.tracer, s.tickets, s.trackers, s.ticketTemplate, id, session)
+			}, attribute.String("itsm.ticket.id", id))
+		}
+	}
+
+	record := turnRecord{Prompt: userMessage, Canned: opts.Canned, Span: turnSpan.SpanContext()}
+	if regenerated != nil {
+		*regenerated = record
+	} else {
+		s.turns = append(s.turns, record)
+	}
+
+	return result, nil
+}
+
+// compact replaces the history with a summary of it, written by the
+// summary model.
+func (s *Session) compact(ctx context.Context) (historytrim.Compaction, error) {
+	compacted, c, err := historytrim.Compact(ctx, s.tracer, historytrim.ModelSummarizer(s.client, s.models.Summary), s.history,
+		attribute.String("langsmith.trace.name", "history_compact"),
+		attribute.String("langsmith.metadata.session_id", s.threadID),
+	)
+	if err != nil {
+		return c, err
+	}
+	s.history, s.compactedTurns = compacted, len(s.turns)
+	return c, nil
+}
+
+// startTurnSpan starts the turn span. With tracing disabled it builds no
+// attributes or links, allocates nothing and returns a non-recording span,
+// never the caller's, which the turn would end and mark as failed.
+func (s *Session) startTurnSpan(ctx context.Context, userMessage string, messages []anthropic.MessageParam, opts TurnOptions, regenerated *turnRecord) (context.Context, trace.Span) {
+	if !s.tracing {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	links := s.forkLinks
+	if regenerated != nil {
+		links = append(links, trace.Link{SpanContext: regenerated.Span})
+	}
+	index := len(s.turns)
+	if regenerated != nil {
+		index--
+	}
+	traceName, spanName := s.bot.TurnNames(persona.NameVars{SessionID: s.threadID, TurnIndex: index, Prompt: userMessage})
+	startOpts := []trace.SpanStartOption{
+		trace.WithAttributes(s.turnAttributes(traceName, userMessage, index, messages, opts, regenerated != nil)...),
+		trace.WithLinks(links...),
+	}
+	if s.deterministicIDs {
+		ctx = otlpexport.WithTraceKey(ctx, s.threadID+"/"+strconv.Itoa(index))
+		startOpts = append(startOpts, trace.WithAttributes(attribute.Int("langsmith.metadata.turn_index", index)))
+	}
+	return s.tracer.Start(ctx, spanName, startOpts...)
+}
+
+// recordCompletion sets the model's answer and usage on the turn span.
+func (s *Session) recordCompletion(span trace.Span, resp chat.Completion) {
+	if !s.tracing {
+		return
+	}
+	s.payloads.String(span, "gen_ai.completion", resp.Text)
+	span.SetAttributes(
+		attribute.Int64("gen_ai.usage.input_tokens", resp.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", resp.OutputTokens),
+		attribute.StringSlice("gen_ai.response.finish_reasons", resp.FinishReasons),
+		attribute.Bool("continued", resp.Continued),
+		attribute.Int("gen_ai.tool.call_count", len(resp.ToolCalls)),
+	)
+}
+
+// turnAttributes are the attributes a turn span starts with. messages is
+// the conversation sent to the model, this turn's message included.
+func (s *Session) turnAttributes(traceName, userMessage string, index int, messages []anthropic.MessageParam, opts TurnOptions, regeneration bool) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("langsmith.trace.name", traceName),
+		attribute.String("langsmith.metadata.session_id", s.threadID),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("gen_ai.prompt", userMessage),
+		attribute.String("gen_ai.request.model", s.models.Chat),
+		attribute.String("langsmith.metadata.user.locale", s.locale),
+		// Conversation length, to relate it to latency and cost across traces
+		attribute.Int("conversation.turn_index", index),
+		attribute.Int("conversation.message_count", len(messages)),
+		attribute.Int("conversation.history_tokens", chat.HistoryTokens(messages)),
+	}
+	attrs = append(attrs, s.bot.Attributes()...)
+	attrs = append(attrs, opts.Attributes...)
+	if s.dryRun {
+		attrs = append(attrs, attribute.Bool("langsmith.metadata.dry_run", true))
+	}
+	if opts.Canned != "" {
+		attrs = append(attrs, attribute.String("langsmith.metadata.canned_prompt", opts.Canned))
+	}
+	if s.forkedFrom != "" {
+		attrs = append(attrs, attribute.String("langsmith.metadata.forked_from", s.forkedFrom))
+	}
+	if s.imported > 0 {
+		attrs = append(attrs, attribute.Int("langsmith.metadata.imported_messages", s.imported))
+	}
+	if regeneration {
+		attrs = append(attrs, attribute.Bool("regeneration", true))
+		if opts.Temperature.Valid() {
+			attrs = append(attrs, attribute.Float64("gen_ai.request.temperature", opts.Temperature.Value))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,54 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+
+	"go-tracing-demo/chat"
+)
+
+func TestRegenerateWithoutTurn(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		session *Session
+	}{
+		{name: "new session", session: &Session{}},
+		{name: "after compaction", session: &Session{turns: []turnRecord{{Prompt: "hi"}}, compactedTurns: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.session.Turn(context.Background(), "", TurnOptions{Regenerate: true})
+			if !errors.Is(err, chat.ErrNoTurn) {
+				t.Errorf("Turn() error = %v, want chat.ErrNoTurn", err)
+			}
+		})
+	}
+}
+
+func TestSessionRewind(t *testing.T) {
+	user := anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))
+	assistant := anthropic.NewAssistantMessage(anthropic.NewTextBlock("hello"))
+	s := &Session{threadID: "session-1"}
+	s.Import(chat.Imported{SessionID: "imported", Messages: []anthropic.MessageParam{user, assistant}})
+	if s.ID() != "imported" || len(s.History()) != 2 || s.CanRewind() {
+		t.Fatalf("after Import: ID %s, %d messages, CanRewind %v", s.ID(), len(s.History()), s.CanRewind())
+	}
+
+	// One answered turn of the session's own
+	s.history = append(s.history, user, assistant)
+	s.turns = append(s.turns, turnRecord{Prompt: "hi"})
+	if old := s.Fork(); old != "imported" || s.ID() == "imported" || len(s.forkLinks) != 1 {
+		t.Errorf("Fork() = %s, now %s with %d links", old, s.ID(), len(s.forkLinks))
+	}
+	if err := s.Undo(); err != nil || len(s.History()) != 2 {
+		t.Errorf("Undo() = %v, %d messages left, want the imported 2", err, len(s.History()))
+	}
+	if err := s.Undo(); !errors.Is(err, chat.ErrNoTurn) {
+		t.Errorf("Undo() of an imported message = %v, want chat.ErrNoTurn", err)
+	}
+	if _, err := s.Retry(context.Background(), TurnOptions{}); !errors.Is(err, chat.ErrNoTurn) {
+		t.Errorf("Retry() = %v, want chat.ErrNoTurn", err)
+	}
+}
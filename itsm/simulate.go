@@ -0,0 +1,278 @@
+package itsm
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/langchain-ai/langsmith-go/examples/otel_anthropic/traceanthropic"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/chat"
+	"go-tracing-demo/connector"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/ratelimit"
+	"go-tracing-demo/tracehooks"
+)
+
+// simulatedUserPrompt makes the user model play the employee. The scenario
+// is appended.
+const simulatedUserPrompt = `You are role-playing an employee chatting with an IT service desk assistant that handles access requests.
+Stay in character and write only the employee's next message: no quotes, no stage directions, no explanations.
+Keep messages short, the way people type in chat. Answer the assistant's questions according to your scenario.
+When your request has been submitted, provisioned, handed to a human, or clearly cannot go further, reply with exactly [DONE].
+
+Your scenario:
+%s`
+
+// simulatedDone is how the user model ends a conversation.
+const simulatedDone = "[DONE]"
+
+// simulatedBehaviors vary how cooperative the simulated employee is, so the
+// traces cover clarifications, drift and escalations as well as the happy path.
+var simulatedBehaviors = map[string]string{
+	"cooperative": "You give clear answers and a concrete business reason as soon as you are asked.",
+	"vague":       "You are vague at first: you leave out the access level or duration and give a weak reason until asked twice.",
+	"terse":       "You answer in as few words as possible and never volunteer details.",
+	"drifting":    "Halfway through you ask an unrelated IT question (e.g. about a laptop or VPN) before returning to your request.",
+	"impatient":   "You are in a hurry, push to skip steps and ask for admin rights even though you only need to read data.",
+}
+
+var (
+	simulatedResources = []string{"snowflake", "snowflake prod", "datadog", "github", "datadog production"}
+	simulatedLevels    = []string{"read", "write", "admin"}
+	simulatedDurations = []string{"24 hours", "7 days", "2 weeks", "permanently"}
+)
+
+// simulatedScenario is one generated conversation's setup.
+type simulatedScenario struct {
+	Behavior string
+	Brief    string
+}
+
+func randomScenario() simulatedScenario {
+	behaviors := make([]string, 0, len(simulatedBehaviors))
+	for name := range simulatedBehaviors {
+		behaviors = append(behaviors, name)
+	}
+	behavior := behaviors[rand.IntN(len(behaviors))]
+	brief := fmt.Sprintf("You need %s access to %s for %s to finish a task your manager gave you. %s",
+		simulatedLevels[rand.IntN(len(simulatedLevels))],
+		simulatedResources[rand.IntN(len(simulatedResources))],
+		simulatedDurations[rand.IntN(len(simulatedDurations))],
+		simulatedBehaviors[behavior],
+	)
+	return simulatedScenario{Behavior: behavior, Brief: brief}
+}
+
+// simulationResult summarizes one generated conversation.
+type simulationResult struct {
+	Index    int
+	Session  string
+	Behavior string
+	Turns    int
+	Status   string
+	Err      error
+	Elapsed  time.Duration
+}
+
+// runSimulation implements "simulate": a second model plays the user in N
+// ITSM conversations run concurrently, each traced like a real session.
+func runSimulation(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	conversations := fs.Int("conversations", 10, "number of conversations to generate")
+	concurrency := fs.Int("concurrency", 4, "conversations run at once")
+	maxTurns := fs.Int("max-turns", 8, "turns after which a conversation is cut off")
+	userModel := fs.String("user-model", chat.DefaultClassifierModel, "model that plays the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *conversations < 1 || *concurrency < 1 || *maxTurns < 1 {
+		return errors.New("simulate: --conversations, --concurrency and --max-turns must be at least 1")
+	}
+	resolved, err := chat.ResolveModel(*userModel)
+	if err != nil {
+		return fmt.Errorf("simulate: --user-model: %w", err)
+	}
+	*userModel = resolved
+
+	anthropicKey := os.Getenv("ANTHROPIC_API_KEY")
+	if anthropicKey == "" {
+		return errors.New("ANTHROPIC_API_KEY is required")
+	}
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	// The bot is traced as usual; the simulated user is not, so the project
+	// only holds the traces a real user would produce
+	// Both sides of the conversation use the same key, and its rate limits
+	scheduler, err := ratelimit.FromEnv()
+	if err != nil {
+		return err
+	}
+	// Faults are only injected into the bot's calls, which are the ones traced
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return err
+	}
+	client := anthropic.NewClient(
+		option.WithAPIKey(anthropicKey),
+		option.WithHTTPClient(traceanthropic.Client()),
+		tracehooks.Option(),
+		scheduler.Option(),
+		injector.Option(),
+	)
+	userClient := anthropic.NewClient(option.WithAPIKey(anthropicKey), scheduler.Option())
+
+	// Simulated conversations must never grant real access or reach the
+	// webhook, so connectors run dry and tickets stay in memory
+	tickets, err := OpenTicketStore("")
+	if err != nil {
+		return err
+	}
+	defer tickets.Close()
+	bot, err := NewBot(&client, otel.Tracer("go-bot-itsm"), tickets, true)
+	if err != nil {
+		return err
+	}
+	ctx := connector.WithDryRun(context.Background())
+
+	simulationID := uuid.New().String()
+	fmt.Printf("Simulating %d conversations (%d at a time), simulation %s\n\n", *conversations, *concurrency, simulationID)
+
+	start := time.Now()
+	jobs := make(chan int)
+	results := make(chan simulationResult)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results <- simulateConversation(ctx, bot, &userClient, *userModel, simulationID, i, *maxTurns)
+			}
+		}()
+	}
+	go func() {
+		for i := range *conversations {
+			jobs <- i + 1
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var turns, failed int
+	statuses := map[string]int{}
+	for r := range results {
+		turns += r.Turns
+		statuses[r.Status]++
+		line := fmt.Sprintf("#%-3d %-11s %2d turns  %-12s %s", r.Index, r.Behavior, r.Turns, r.Status, r.Elapsed.Round(time.Millisecond))
+		if r.Err != nil {
+			failed++
+			line += "  error: " + r.Err.Error()
+		}
+		fmt.Println(line)
+	}
+
+	var summary []string
+	for status, n := range statuses {
+		summary = append(summary, fmt.Sprintf("%s=%d", status, n))
+	}
+	fmt.Printf("\n%d conversations, %d turns in %s (%d failed); tickets: %s\n",
+		*conversations, turns, time.Since(start).Round(time.Millisecond), failed, strings.Join(summary, " "))
+
+	bot.Wait()
+	fmt.Println("Flushing traces to LangSmith...")
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		if err := tp.ForceFlush(ctx); err != nil {
+			return fmt.Errorf("flushing traces: %w", err)
+		}
+	}
+	return nil
+}
+
+// simulateConversation plays one conversation until the user model is done
+// or maxTurns is reached.
+func simulateConversation(ctx context.Context, bot *Bot, userClient *anthropic.Client, userModel, simulationID string, index, maxTurns int) simulationResult {
+	scenario := randomScenario()
+	s := bot.NewSession()
+	s.requester = fmt.Sprintf("sim-user-%d@example.com", index)
+	result := simulationResult{Index: index, Session: s.threadID, Behavior: scenario.Behavior, Status: "no_ticket"}
+	start := time.Now()
+	defer func() { result.Elapsed = time.Since(start) }()
+
+	opts := TurnOptions{Attributes: []attribute.KeyValue{
+		attribute.Bool("langsmith.metadata.simulated", true),
+		attribute.String("langsmith.metadata.simulation_id", simulationID),
+		attribute.String("langsmith.metadata.simulated_behavior", scenario.Behavior),
+	}}
+
+	// The user model sees the conversation from the employee's side: its
+	// own lines are assistant turns and the bot's replies are user turns
+	userHistory := []anthropic.MessageParam{
+		anthropic.NewUserMessage(anthropic.NewTextBlock("(The assistant is waiting. Write your first message.)")),
+	}
+	for result.Turns < maxTurns {
+		resp, err := userClient.Messages.New(ctx, anthropic.MessageNewParams{
+			Model:       anthropic.Model(userModel),
+			MaxTokens:   300,
+			Temperature: param.NewOpt(1.0),
+			System: []anthropic.TextBlockParam{
+				{Text: fmt.Sprintf(simulatedUserPrompt, scenario.Brief)},
+			},
+			Messages: userHistory,
+		})
+		if err != nil {
+			result.Err = fmt.Errorf("user model: %w", err)
+			break
+		}
+		var message strings.Builder
+		for _, block := range resp.Content {
+			if block.Type == "text" {
+				message.WriteString(block.Text)
+			}
+		}
+		userMessage := strings.TrimSpace(message.String())
+		if userMessage == "" || strings.Contains(userMessage, simulatedDone) {
+			break
+		}
+
+		reply, err := s.Turn(ctx, userMessage, opts)
+		result.Turns++
+		if errors.Is(err, boterr.ErrGuardrailBlocked) {
+			// The employee sees the policy's response and carries on
+			reply.Reply, err = boterr.UserMessage(err), nil
+		}
+		if err != nil {
+			result.Err = err
+			break
+		}
+		userHistory = append(userHistory,
+			anthropic.NewAssistantMessage(anthropic.NewTextBlock(userMessage)),
+			anthropic.NewUserMessage(anthropic.NewTextBlock(reply.Reply)),
+		)
+	}
+
+	if ticket, ok := bot.tickets.get(s.ticketID); ok {
+		result.Status = ticket.Status
+	}
+	return result
+}
@@ -0,0 +1,184 @@
+package itsm
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//go:embed sod_rules.json
+var defaultSoDRules []byte
+
+// sodRule forbids one person holding both roles. A role is
+// "<resource>:<access level>"; the level may be "*" to match any.
+type sodRule struct {
+	Name   string    `json:"name"`
+	Roles  [2]string `json:"roles"`
+	Reason string    `json:"reason"`
+}
+
+// sodConflict records that a ticket would complete a forbidden pair with a
+// grant the requester already holds.
+type sodConflict struct {
+	Rule          string `json:"rule"`
+	Role          string `json:"role"`
+	ConflictsWith string `json:"conflicts_with"`
+	TicketID      string `json:"conflicting_ticket_id"`
+	Warning       string `json:"warning"`
+}
+
+// loadSoDRules reads the rules from ITSM_SOD_RULES if set, otherwise it
+// falls back to the built-in sod_rules.json.
+func loadSoDRules() ([]sodRule, error) {
+	data := defaultSoDRules
+	if path := os.Getenv("ITSM_SOD_RULES"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading SoD rules: %w", err)
+		}
+	}
+
+	var rules []sodRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing SoD rules: %w", err)
+	}
+	return rules, nil
+}
+
+// roleOf is the role a ticket grants, in rule notation.
+func roleOf(t AccessRequest) string {
+	return t.Resource + ":" + t.AccessLevel
+}
+
+func roleMatches(pattern, role string) bool {
+	pResource, pLevel, _ := strings.Cut(pattern, ":")
+	resource, level, _ := strings.Cut(role, ":")
+	return pResource == resource && (pLevel == "*" || pLevel == level)
+}
+
+// sodConflicts checks t against every grant the requester already holds.
+func sodConflicts(rules []sodRule, t AccessRequest, held []AccessRequest) []sodConflict {
+	role := roleOf(t)
+	var conflicts []sodConflict
+	for _, rule := range rules {
+		for i, pattern := range rule.Roles {
+			if !roleMatches(pattern, role) {
+				continue
+			}
+			other := rule.Roles[1-i]
+			for _, g := range held {
+				if g.ID == t.ID || !roleMatches(other, roleOf(g)) {
+					continue
+				}
+				conflicts = append(conflicts, sodConflict{
+					Rule:          rule.Name,
+					Role:          role,
+					ConflictsWith: roleOf(g),
+					TicketID:      g.ID,
+					Warning:       fmt.Sprintf("Separation of duties: %s conflicts with %s already granted on %s. %s.", role, roleOf(g), g.ID, rule.Reason),
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+// Auto-approval outcomes, recorded as itsm.auto_approval.
+const (
+	autoApproved       = "approved"
+	autoBlockedSoD     = "blocked_sod"
+	autoIneligible     = "ineligible"
+	autoAlreadyDecided = "already_decided"
+)
+
+// screenDraft runs the draft-time checks on the session's ticket: it
+// records SoD conflicts (also as span events) and anomalies against the
+// requester's history on the ticket and, when autoApprove is set, approves
+// complete low-risk drafts without conflicts.
+func screenDraft(ctx context.Context, store *TicketStore, rules []sodRule, ticketID string, autoApprove bool) (AccessRequest, error) {
+	span := trace.SpanFromContext(ctx)
+	ticket, ok := store.get(ticketID)
+	if !ok {
+		return AccessRequest{}, fmt.Errorf("unknown ticket %q", ticketID)
+	}
+	grants, err := activeGrants(store, "", time.Now())
+	if err != nil {
+		return ticket, err
+	}
+	var held []AccessRequest
+	for _, g := range grants {
+		if g.RequesterEmail == ticket.RequesterEmail {
+			held = append(held, g)
+		}
+	}
+	conflicts := sodConflicts(rules, ticket, held)
+
+	history, err := requesterHistory(store, ticket.RequesterEmail, ticket.ID)
+	if err != nil {
+		return ticket, err
+	}
+	anomalies := detectAnomalies(ticket, history)
+	signals := make([]string, len(anomalies))
+	for i, a := range anomalies {
+		signals[i] = a.Signal
+	}
+	span.SetAttributes(
+		attribute.Int("langsmith.metadata.requester.history_count", len(history)),
+		attribute.StringSlice("langsmith.metadata.anomalies", signals),
+		attribute.Int("langsmith.metadata.anomaly_count", len(anomalies)),
+	)
+
+	span.SetAttributes(attribute.Int("itsm.sod.conflict_count", len(conflicts)))
+	for _, c := range conflicts {
+		span.AddEvent("sod_conflict", trace.WithAttributes(
+			attribute.String("sod.rule", c.Rule),
+			attribute.String("sod.requested_role", c.Role),
+			attribute.String("sod.conflicting_role", c.ConflictsWith),
+			attribute.String("sod.conflicting_ticket_id", c.TicketID),
+		))
+	}
+
+	return store.update(ctx, ticketID, func(t *AccessRequest) error {
+		t.SoDConflicts = conflicts
+		t.Anomalies = anomalies
+		if len(anomalies) > 0 {
+			t.RiskLevel = "high"
+		}
+		if !autoApprove {
+			return nil
+		}
+		decision := autoApproval(t)
+		span.SetAttributes(attribute.String("itsm.auto_approval", decision))
+		if decision == autoApproved {
+			t.Status = statusApproved
+			t.ApprovedBy = "auto-approval"
+		}
+		return nil
+	})
+}
+
+// autoApproval decides whether t may skip human approval: it must be a
+// complete, non-high-risk draft with a sufficient justification and no SoD
+// conflicts.
+func autoApproval(t *AccessRequest) string {
+	switch {
+	case t.Status != statusDraft:
+		return autoAlreadyDecided
+	case len(t.SoDConflicts) > 0:
+		return autoBlockedSoD
+	case t.NeedsJustification:
+		return autoIneligible
+	case t.RiskLevel == "high", t.Resource == "unknown", t.AccessLevel == "unknown", t.Duration == "unknown":
+		return autoIneligible
+	default:
+		return autoApproved
+	}
+}
@@ -0,0 +1,405 @@
+package itsm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"go-tracing-demo/payload"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tickets (
+	id         TEXT PRIMARY KEY,
+	status     TEXT NOT NULL,
+	resource   TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ticket_revisions (
+	ticket_id  TEXT NOT NULL REFERENCES tickets (id),
+	revision   INTEGER NOT NULL,
+	actor      TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	turn_index INTEGER NOT NULL,
+	trace_id   TEXT NOT NULL,
+	span_id    TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (ticket_id, revision)
+);
+CREATE TABLE IF NOT EXISTS approval_decisions (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	ticket_id    TEXT NOT NULL REFERENCES tickets (id),
+	step         TEXT NOT NULL,
+	decision     TEXT NOT NULL,
+	approver     TEXT NOT NULL,
+	on_behalf_of TEXT NOT NULL,
+	delegation   TEXT NOT NULL,
+	comment      TEXT NOT NULL,
+	session_id   TEXT NOT NULL,
+	trace_id     TEXT NOT NULL,
+	span_id      TEXT NOT NULL,
+	created_at   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ticket_comments (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	ticket_id   TEXT NOT NULL REFERENCES tickets (id),
+	author      TEXT NOT NULL,
+	body        TEXT NOT NULL,
+	drafted     INTEGER NOT NULL,
+	in_reply_to INTEGER REFERENCES ticket_comments (id),
+	trace_id    TEXT NOT NULL,
+	span_id     TEXT NOT NULL,
+	created_at  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS conversations (
+	session_id TEXT PRIMARY KEY,
+	ticket_id  TEXT NOT NULL,
+	requester  TEXT NOT NULL,
+	messages   TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS conversation_turns USING fts5 (
+	prompt, reply,
+	session_id UNINDEXED, ticket_id UNINDEXED, turn_index UNINDEXED,
+	trace_id UNINDEXED, span_id UNINDEXED, created_at UNINDEXED,
+	embedding UNINDEXED
+);
+CREATE TABLE IF NOT EXISTS ticket_attachments (
+	ticket_id    TEXT NOT NULL REFERENCES tickets (id),
+	name         TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	content      TEXT NOT NULL,
+	session_id   TEXT NOT NULL,
+	trace_id     TEXT NOT NULL,
+	span_id      TEXT NOT NULL,
+	created_at   TEXT NOT NULL,
+	PRIMARY KEY (ticket_id, name)
+);
+CREATE TABLE IF NOT EXISTS ticket_external_records (
+	ticket_id   TEXT NOT NULL REFERENCES tickets (id),
+	tracker     TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	updated_at  TEXT NOT NULL,
+	PRIMARY KEY (ticket_id, tracker)
+);
+CREATE TABLE IF NOT EXISTS review_campaigns (
+	id         TEXT PRIMARY KEY,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS review_tasks (
+	id          TEXT PRIMARY KEY,
+	campaign_id TEXT NOT NULL REFERENCES review_campaigns (id),
+	ticket_id   TEXT NOT NULL REFERENCES tickets (id),
+	owner       TEXT NOT NULL,
+	state       TEXT NOT NULL DEFAULT 'open',
+	created_at  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id        TEXT NOT NULL UNIQUE,
+	type            TEXT NOT NULL,
+	payload         TEXT NOT NULL,
+	state           TEXT NOT NULL DEFAULT 'pending',
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TEXT NOT NULL,
+	last_error      TEXT,
+	created_at      TEXT NOT NULL,
+	delivered_at    TEXT
+);
+CREATE TABLE IF NOT EXISTS spend (
+	scope         TEXT NOT NULL,
+	day           TEXT NOT NULL,
+	input_tokens  INTEGER NOT NULL,
+	output_tokens INTEGER NOT NULL,
+	cost_usd      REAL NOT NULL,
+	PRIMARY KEY (scope, day)
+);
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key        TEXT PRIMARY KEY,
+	operation  TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	result     TEXT,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+`
+
+// TicketStore persists tickets and their revisions, review campaigns,
+// outbox events, spend and idempotency keys in SQLite. With no path the
+// database lives in memory for the session. The store uses a single
+// connection, so tools running concurrently are serialized and each update
+// is one transaction.
+type TicketStore struct {
+	db *sql.DB
+	// classes marks the confidential fields, which sealer encrypts at rest
+	classes fieldClasses
+	sealer  *payload.Encryptor
+}
+
+// OpenTicketStore opens (creating if needed) the database at path, or an
+// in-memory database when path is empty. Confidential fields, as
+// ITSM_FIELD_CLASSIFICATION marks them, are encrypted with ITSM_DB_KEY when
+// it is set.
+func OpenTicketStore(path string) (*TicketStore, error) {
+	classes, err := loadFieldClasses()
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := fieldSealerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	dsn := ":memory:"
+	if path != "" {
+		dsn = "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	return &TicketStore{db: db, classes: classes, sealer: sealer}, nil
+}
+
+// Close closes the database.
+func (s *TicketStore) Close() error {
+	return s.db.Close()
+}
+
+// Ticket returns the ticket with id.
+func (s *TicketStore) Ticket(id string) (AccessRequest, bool) {
+	return s.get(id)
+}
+
+// get returns the ticket with id.
+func (s *TicketStore) get(id string) (AccessRequest, bool) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM tickets WHERE id = ?`, id).Scan(&data)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Loading ticket %s: %v", id, err)
+		}
+		return AccessRequest{}, false
+	}
+	t, err := s.decodeTicket(data)
+	if err != nil {
+		log.Printf("Decoding ticket %s: %v", id, err)
+		return AccessRequest{}, false
+	}
+	return t, true
+}
+
+// put stores t, replacing any ticket with the same ID, as a new revision
+// and queues a ticket.created event. The ticket records the trace context of ctx, the
+// conversation turn it originates from.
+func (s *TicketStore) put(ctx context.Context, t AccessRequest) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.insert(ctx, tx, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// submit stores t as the ticket submitted under idempotency key, unless an
+// earlier call already did: then it returns the ID of that ticket and
+// idempotencyReplayed. The key is claimed and completed in the same
+// transaction as the ticket is stored, so a crash leaves neither behind and
+// the decision is never idempotencyInDoubt.
+func (s *TicketStore) submit(ctx context.Context, key string, t AccessRequest) (string, string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+	decision, stored, err := claimTx(tx, key, "submit")
+	if err != nil || decision != idempotencyNew {
+		return string(stored), decision, err
+	}
+	if err := s.insert(ctx, tx, t); err != nil {
+		return "", "", err
+	}
+	if _, err := tx.Exec(`UPDATE idempotency_keys SET state = 'done', result = ?, updated_at = ? WHERE key = ?`, t.ID, now(), key); err != nil {
+		return "", "", err
+	}
+	return t.ID, decision, tx.Commit()
+}
+
+// submissionKey derives the idempotency key for the ticket a session
+// submits.
+func submissionKey(sessionID string) string {
+	return "submit:" + sessionID
+}
+
+// insert stores new ticket t in tx with its first revision and a
+// ticket.created event.
+func (s *TicketStore) insert(ctx context.Context, tx *sql.Tx, t AccessRequest) error {
+	if tp, ts := traceContext(ctx); tp != "" {
+		t.TraceParent, t.TraceState = tp, ts
+	}
+	if t.Tenant == "" {
+		t.Tenant = tenantOf(ctx)
+	}
+	data, err := s.save(tx, t)
+	if err != nil {
+		return err
+	}
+	if err := addRevision(ctx, tx, t, data, ""); err != nil {
+		return err
+	}
+	sealed := s.seal(t)
+	return enqueue(ctx, tx, outboxEvent{Type: "ticket.created", Ticket: &sealed})
+}
+
+// update applies fn to the ticket with id in one transaction and returns
+// the result, stored as a new revision when fn changed it. If fn fails
+// nothing is written.
+func (s *TicketStore) update(ctx context.Context, id string, fn func(*AccessRequest) error) (AccessRequest, error) {
+	return s.updateTx(ctx, id, func(_ queryExecer, t *AccessRequest) error { return fn(t) })
+}
+
+// updateTx is update with fn also given the transaction, for rows that
+// must be written together with the ticket.
+func (s *TicketStore) updateTx(ctx context.Context, id string, fn func(tx queryExecer, t *AccessRequest) error) (AccessRequest, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	defer tx.Rollback()
+
+	var data string
+	if err := tx.QueryRow(`SELECT data FROM tickets WHERE id = ?`, id).Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AccessRequest{}, fmt.Errorf("unknown ticket %q", id)
+		}
+		return AccessRequest{}, err
+	}
+	t, err := s.decodeTicket(data)
+	if err != nil {
+		return AccessRequest{}, fmt.Errorf("decoding ticket %s: %w", id, err)
+	}
+	previous := t.Status
+	if err := fn(tx, &t); err != nil {
+		return t, err
+	}
+	saved, err := s.save(tx, t)
+	if err != nil {
+		return t, err
+	}
+	if err := addRevision(ctx, tx, t, saved, data); err != nil {
+		return t, err
+	}
+	// Status changes are announced in the same transaction that makes them
+	if t.Status != previous {
+		sealed := s.seal(t)
+		if err := enqueue(ctx, tx, outboxEvent{Type: "ticket." + t.Status, Ticket: &sealed}); err != nil {
+			return t, err
+		}
+	}
+	return t, tx.Commit()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// save writes t and returns the JSON it was stored as.
+func (s *TicketStore) save(db execer, t AccessRequest) (string, error) {
+	data, err := s.encodeTicket(t)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+		INSERT INTO tickets (id, status, resource, created_at, updated_at, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status, resource = excluded.resource,
+			updated_at = excluded.updated_at, data = excluded.data`,
+		t.ID, t.Status, t.Resource, t.CreatedAt, now(), data)
+	return data, err
+}
+
+// Idempotency decisions, recorded on spans as idempotency.decision.
+const (
+	// idempotencyNew means the key was unused and the operation should run.
+	idempotencyNew = "new"
+	// idempotencyReplayed means the operation already completed; the stored
+	// result is returned instead of running it again.
+	idempotencyReplayed = "replayed"
+	// idempotencyInDoubt means an earlier attempt started but never
+	// recorded an outcome (e.g. the process crashed), so it may have taken
+	// effect. The operation is not retried automatically.
+	idempotencyInDoubt = "in_doubt"
+)
+
+// claim reserves key for operation. For a new key it returns
+// idempotencyNew and the caller must later complete or release it. For a
+// completed key it returns idempotencyReplayed with the stored result.
+func (s *TicketStore) claim(key, operation string) (string, []byte, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+	decision, result, err := claimTx(tx, key, operation)
+	if err != nil || decision != idempotencyNew {
+		return decision, result, err
+	}
+	return decision, nil, tx.Commit()
+}
+
+// claimTx is claim within tx; the key is only reserved once tx commits.
+func claimTx(tx queryExecer, key, operation string) (string, []byte, error) {
+	var state string
+	var result sql.NullString
+	err := tx.QueryRow(`SELECT state, result FROM idempotency_keys WHERE key = ?`, key).Scan(&state, &result)
+	switch {
+	case err == nil && state == "done":
+		return idempotencyReplayed, []byte(result.String), nil
+	case err == nil:
+		return idempotencyInDoubt, nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return "", nil, err
+	}
+
+	ts := now()
+	if _, err := tx.Exec(`INSERT INTO idempotency_keys (key, operation, state, created_at, updated_at) VALUES (?, ?, 'pending', ?, ?)`,
+		key, operation, ts, ts); err != nil {
+		return "", nil, err
+	}
+	return idempotencyNew, nil, nil
+}
+
+// complete stores the result of a claimed operation.
+func (s *TicketStore) complete(key string, result []byte) error {
+	_, err := s.db.Exec(`UPDATE idempotency_keys SET state = 'done', result = ?, updated_at = ? WHERE key = ?`,
+		string(result), now(), key)
+	return err
+}
+
+// release frees a claimed key after the operation failed without effect,
+// so a retry runs it again.
+func (s *TicketStore) release(key string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,70 @@
+package itsm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClaim(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		before       func(store *TicketStore, key string)
+		wantDecision string
+		wantResult   string
+	}{
+		{name: "unused", before: func(*TicketStore, string) {}, wantDecision: idempotencyNew},
+		{name: "completed", before: func(store *TicketStore, key string) {
+			store.claim(key, "grant")
+			store.complete(key, []byte(`{"external_id":"42"}`))
+		}, wantDecision: idempotencyReplayed, wantResult: `{"external_id":"42"}`},
+		{name: "never finished", before: func(store *TicketStore, key string) {
+			store.claim(key, "grant")
+		}, wantDecision: idempotencyInDoubt},
+		{name: "released", before: func(store *TicketStore, key string) {
+			store.claim(key, "grant")
+			store.release(key)
+		}, wantDecision: idempotencyNew},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore(t)
+			tt.before(store, "grant:github:AR-1:00")
+			decision, result, err := store.claim("grant:github:AR-1:00", "grant")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decision != tt.wantDecision || string(result) != tt.wantResult {
+				t.Errorf("claim() = %s, %q, want %s, %q", decision, result, tt.wantDecision, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	store := testStore(t)
+	key := submissionKey("session-1")
+	first := AccessRequest{ID: "AR-1", Type: "access_request", Resource: "github", Status: statusDraft}
+	again := AccessRequest{ID: "AR-2", Type: "access_request", Resource: "github", Status: statusDraft}
+
+	for _, tt := range []struct {
+		ticket       AccessRequest
+		wantID       string
+		wantDecision string
+	}{
+		{ticket: first, wantID: "AR-1", wantDecision: idempotencyNew},
+		{ticket: again, wantID: "AR-1", wantDecision: idempotencyReplayed},
+	} {
+		id, decision, err := store.submit(context.Background(), key, tt.ticket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != tt.wantID || decision != tt.wantDecision {
+			t.Errorf("submit(%s) = %s, %s, want %s, %s", tt.ticket.ID, id, decision, tt.wantID, tt.wantDecision)
+		}
+	}
+	var tickets, events int
+	store.db.QueryRow(`SELECT count(*) FROM tickets`).Scan(&tickets)
+	store.db.QueryRow(`SELECT count(*) FROM outbox WHERE type = 'ticket.created'`).Scan(&events)
+	if tickets != 1 || events != 1 {
+		t.Errorf("%d tickets and %d ticket.created events, want 1 of each", tickets, events)
+	}
+}
@@ -0,0 +1,74 @@
+package itsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-tracing-demo/boterr"
+)
+
+type streamRequest struct {
+	// SessionID continues a session; without it a new one is opened.
+	SessionID string `json:"session_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+// streamTurn runs a turn like runTurn but answers with server-sent events:
+// a session event naming the session, a delta event per piece of the reply
+// as the model writes it, then a usage event holding the turn response
+// with its token counts. A turn that fails once the stream has started
+// ends with an error event instead. The turn is traced like any other.
+func (s *server) streamTurn(w http.ResponseWriter, r *http.Request, p *principal) {
+	var req streamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("message is required"))
+		return
+	}
+	var sess *serverSession
+	if req.SessionID != "" {
+		var ok bool
+		if sess, ok = s.session(w, p, req.SessionID); !ok {
+			return
+		}
+	}
+
+	release, err := p.tenant.quota.acquire()
+	if err != nil {
+		writeTurnError(w, err)
+		return
+	}
+	defer release()
+	if sess == nil {
+		sess = s.openSession(p, "")
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(event string, data any) {
+		body, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+		rc.Flush()
+	}
+
+	send("session", sessionResponse{SessionID: sess.threadID})
+	resp, err := s.turn(r, p, sess, req.Message, func(text string) {
+		send("delta", map[string]string{"text": text})
+	})
+	if err != nil {
+		send("error", struct {
+			turnError
+			Status int `json:"status"`
+		}{newTurnError(err), boterr.HTTPStatus(err)})
+		return
+	}
+	send("usage", resp)
+}
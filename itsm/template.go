@@ -0,0 +1,275 @@
+package itsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ticketTemplate extends the ticket with an organization's own fields,
+// such as a cost center or data classification, without code changes: the
+// bot asks for them, drafts pick them out of messages, and trackers get
+// them in their own field names.
+type ticketTemplate struct {
+	Name   string          `json:"name"`
+	Fields []templateField `json:"fields"`
+	// Mappings name the fields in each tracker, by tracker then field, as
+	// in {"servicenow": {"cost_center": "u_cost_center"}}. Unmapped fields
+	// are not sent.
+	Mappings map[string]map[string]string `json:"mappings,omitempty"`
+}
+
+// templateField is one extra ticket field. Its value is one of Values or
+// matches Pattern, which is also how drafts find it in a message.
+type templateField struct {
+	// Name is the key in the ticket's fields, such as "cost_center".
+	Name        string `json:"name"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Required fields are "unknown" until given, and keep the ticket
+	// incomplete like a missing resource does.
+	Required bool     `json:"required,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	// Aliases map other words for a value to it, as in {"europe": "eu"}.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	Pattern string            `json:"pattern,omitempty"`
+	Default string            `json:"default,omitempty"`
+
+	pattern, whole *regexp.Regexp
+}
+
+// loadTicketTemplate reads the template at path. Without a path, tickets
+// have only the built-in fields and the template is nil.
+func loadTicketTemplate(path string) (*ticketTemplate, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading ticket template: %w", err)
+	}
+	var tmpl ticketTemplate
+	if err := json.Unmarshal(data, &tmpl); err != nil {
+		return nil, fmt.Errorf("parsing ticket template %s: %w", path, err)
+	}
+	return &tmpl, tmpl.validate()
+}
+
+var validFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func (tmpl *ticketTemplate) validate() error {
+	seen := map[string]bool{}
+	for i := range tmpl.Fields {
+		f := &tmpl.Fields[i]
+		switch {
+		case !validFieldName.MatchString(f.Name):
+			return fmt.Errorf("ticket template: field name %q is not lower_snake_case", f.Name)
+		case seen[f.Name] || importColumns[f.Name] != nil:
+			return fmt.Errorf("ticket template: field %q is already defined", f.Name)
+		case (len(f.Values) > 0) == (f.Pattern != ""):
+			return fmt.Errorf("ticket template: field %q needs either values or a pattern", f.Name)
+		}
+		seen[f.Name] = true
+		if f.Pattern != "" {
+			var err error
+			if f.pattern, err = regexp.Compile(f.Pattern); err != nil {
+				return fmt.Errorf("ticket template: field %q: %w", f.Name, err)
+			}
+			// A value must be a match on its own, not contain one
+			f.whole = regexp.MustCompile(`^(?:` + f.Pattern + `)$`)
+		}
+		for alias, value := range f.Aliases {
+			if !slices.Contains(f.Values, value) {
+				return fmt.Errorf("ticket template: field %q: alias %q is for %q, which is not one of its values", f.Name, alias, value)
+			}
+		}
+		if f.Default != "" {
+			if err := f.check(f.Default); err != nil {
+				return fmt.Errorf("ticket template: field %q: default: %w", f.Name, err)
+			}
+		}
+	}
+	for tracker, fields := range tmpl.Mappings {
+		for name := range fields {
+			if !seen[name] {
+				return fmt.Errorf("ticket template: %s mapping names unknown field %q", tracker, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (f templateField) label() string {
+	if f.Label != "" {
+		return f.Label
+	}
+	return strings.ReplaceAll(f.Name, "_", " ")
+}
+
+// check reports why v is not a value of f.
+func (f templateField) check(v string) error {
+	switch {
+	case len(f.Values) > 0 && !slices.Contains(f.Values, v):
+		return fmt.Errorf("%q is not one of %s", v, strings.Join(f.Values, ", "))
+	case f.whole != nil && !f.whole.MatchString(v):
+		return fmt.Errorf("%q does not match %s", v, f.Pattern)
+	}
+	return nil
+}
+
+// field returns the template's field called name.
+func (tmpl *ticketTemplate) field(name string) (templateField, bool) {
+	if tmpl == nil {
+		return templateField{}, false
+	}
+	for _, f := range tmpl.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return templateField{}, false
+}
+
+// apply sets up the template's fields on a new draft: defaults where
+// there are any, and "unknown" for the other required fields.
+func (tmpl *ticketTemplate) apply(t *AccessRequest) {
+	if tmpl == nil {
+		return
+	}
+	for _, f := range tmpl.Fields {
+		if _, ok := t.Fields[f.Name]; ok {
+			continue
+		}
+		switch {
+		case f.Default != "":
+			setField(t, f.Name, f.Default)
+		case f.Required:
+			setField(t, f.Name, "unknown")
+		}
+	}
+}
+
+func setField(t *AccessRequest, name, v string) {
+	if t.Fields == nil {
+		t.Fields = map[string]string{}
+	}
+	t.Fields[name] = v
+}
+
+// extract picks the template's fields out of a message, the way
+// inferAccessRequestDraft does the built-in ones: a listed value or alias
+// as a word of its own, or the first match of the pattern.
+func (tmpl *ticketTemplate) extract(userMessage string) map[string]string {
+	if tmpl == nil {
+		return nil
+	}
+	words := strings.FieldsFunc(strings.ToLower(userMessage), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+	extracted := map[string]string{}
+	for _, f := range tmpl.Fields {
+		switch {
+		case f.pattern != nil:
+			if m := f.pattern.FindString(userMessage); m != "" {
+				extracted[f.Name] = m
+			}
+		case len(f.Values) > 0:
+			for _, w := range words {
+				if v, ok := f.Aliases[w]; ok {
+					extracted[f.Name] = v
+					break
+				}
+				if i := slices.IndexFunc(f.Values, func(v string) bool { return strings.EqualFold(v, w) }); i >= 0 {
+					extracted[f.Name] = f.Values[i]
+					break
+				}
+			}
+		}
+	}
+	return extracted
+}
+
+// problems checks t's template fields: values valid, and no fields the
+// template doesn't define.
+func (tmpl *ticketTemplate) problems(t AccessRequest) []string {
+	var problems []string
+	for _, name := range sortedKeys(t.Fields) {
+		f, ok := tmpl.field(name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("field %q is not in the ticket template", name))
+			continue
+		}
+		if v := t.Fields[name]; v != "unknown" {
+			if err := f.check(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %v", name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// instruction tells the model which extra fields to collect.
+func (tmpl *ticketTemplate) instruction() string {
+	if tmpl == nil || len(tmpl.Fields) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("This organization's tickets also have these fields, under \"fields\" in the ticket. Ask for required fields that are still unknown, together with the other missing details:")
+	for _, f := range tmpl.Fields {
+		fmt.Fprintf(&b, "\n- %s (%s", f.Name, f.label())
+		if f.Required {
+			b.WriteString(", required")
+		}
+		b.WriteString("):")
+		if f.Description != "" {
+			b.WriteString(" " + f.Description)
+		}
+		switch {
+		case len(f.Values) > 0:
+			b.WriteString(" One of: " + strings.Join(f.Values, ", ") + ".")
+		case f.Pattern != "":
+			b.WriteString(" Format: " + f.Pattern + ".")
+		}
+	}
+	return b.String()
+}
+
+// mapped returns t's fields in tracker's field names, for the fields the
+// template maps for it.
+func (tmpl *ticketTemplate) mapped(tracker string, t AccessRequest) map[string]string {
+	if tmpl == nil {
+		return nil
+	}
+	out := map[string]string{}
+	for name, target := range tmpl.Mappings[tracker] {
+		if v, ok := t.Fields[name]; ok && v != "unknown" {
+			out[target] = v
+		}
+	}
+	return out
+}
+
+// missingTemplateFields lists t's required template fields that are still
+// unknown, in name order.
+func missingTemplateFields(t AccessRequest) []string {
+	var missing []string
+	for _, name := range sortedKeys(t.Fields) {
+		if t.Fields[name] == "unknown" {
+			missing = append(missing, strings.ReplaceAll(name, "_", " "))
+		}
+	}
+	return missing
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,271 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"go-tracing-demo/boterr"
+	"go-tracing-demo/faults"
+	"go-tracing-demo/otlpexport"
+)
+
+// tenantConfig is one team served by "serve", selected by the bearer token
+// on its requests. String values go through os.ExpandEnv, so keys can stay
+// in the environment ("${PAYMENTS_ANTHROPIC_KEY}").
+type tenantConfig struct {
+	Name            string `json:"name"`
+	Token           string `json:"token"`
+	AnthropicAPIKey string `json:"anthropic_api_key"`
+	LangSmithAPIKey string `json:"langsmith_api_key"`
+	// Project defaults to go-bot-itsm-<name>.
+	Project         string `json:"project,omitempty"`
+	InputPolicyFile string `json:"input_policy_file,omitempty"`
+	// TicketTemplateFile replaces ITSM_TICKET_TEMPLATE for the tenant.
+	TicketTemplateFile string `json:"ticket_template_file,omitempty"`
+	// Quotas; zero means unlimited.
+	RequestsPerMinute  int `json:"requests_per_minute,omitempty"`
+	MaxConcurrentTurns int `json:"max_concurrent_turns,omitempty"`
+	// APIKeys authenticate the tenant's people with their roles. The token
+	// authenticates as the tenant itself, with every role.
+	APIKeys []apiKey `json:"api_keys,omitempty"`
+	// Budgets replace the ITSM_*BUDGET* settings for the tenant.
+	Budgets *budgetConfig `json:"budgets,omitempty"`
+}
+
+// loadTenants reads the tenants file at path. With no path there is a
+// single "default" tenant configured from the environment, which needs no
+// token; its API keys, if any, come from ITSM_API_KEYS_FILE.
+func loadTenants(path string) ([]tenantConfig, error) {
+	if path == "" {
+		project := os.Getenv("LANGSMITH_PROJECT")
+		if project == "" {
+			project = "go-bot-itsm"
+		}
+		var keys []apiKey
+		if keysFile := os.Getenv("ITSM_API_KEYS_FILE"); keysFile != "" {
+			var err error
+			if keys, err = loadAPIKeys(keysFile); err != nil {
+				return nil, err
+			}
+		}
+		return []tenantConfig{{
+			Name:            "default",
+			AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
+			LangSmithAPIKey: os.Getenv("LANGSMITH_API_KEY"),
+			Project:         project,
+			InputPolicyFile: os.Getenv("INPUT_POLICY_FILE"),
+			APIKeys:         keys,
+		}}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Tenants []tenantConfig `json:"tenants"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Tenants) == 0 {
+		return nil, fmt.Errorf("%s defines no tenants", path)
+	}
+	for i := range file.Tenants {
+		if file.Tenants[i].Project == "" {
+			file.Tenants[i].Project = "go-bot-itsm-" + file.Tenants[i].Name
+		}
+	}
+	return file.Tenants, validateTenants(file.Tenants)
+}
+
+func validateTenants(tenants []tenantConfig) error {
+	names := map[string]bool{}
+	// Tokens and API keys share one namespace, or a request would be
+	// ambiguous about who sent it
+	secrets := map[string]bool{}
+	for _, t := range tenants {
+		switch {
+		case t.Name == "":
+			return errors.New("tenants: every tenant needs a name")
+		case names[t.Name]:
+			return fmt.Errorf("tenants: duplicate tenant %q", t.Name)
+		case t.Token == "":
+			return fmt.Errorf("tenant %s: token is required", t.Name)
+		case t.AnthropicAPIKey == "":
+			return fmt.Errorf("tenant %s: anthropic_api_key is required", t.Name)
+		case t.LangSmithAPIKey == "" && !otlpexport.TracingDisabled():
+			return fmt.Errorf("tenant %s: langsmith_api_key is required", t.Name)
+		case t.RequestsPerMinute < 0 || t.MaxConcurrentTurns < 0:
+			return fmt.Errorf("tenant %s: quotas can't be negative", t.Name)
+		}
+		if t.Budgets != nil {
+			if err := t.Budgets.validate(); err != nil {
+				return fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+		}
+		if secrets[t.Token] {
+			return fmt.Errorf("tenant %s: token is already in use", t.Name)
+		}
+		secrets[t.Token] = true
+		if err := validateAPIKeys(t.Name, t.APIKeys, secrets); err != nil {
+			return err
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// withTenant marks ctx as serving tenant, so spans started under it are
+// exported to the tenant's project.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantOf is the tenant ctx serves, "default" outside server mode's
+// tenants.
+func tenantOf(ctx context.Context) string {
+	if name, ok := ctx.Value(tenantKey{}).(string); ok {
+		return name
+	}
+	return "default"
+}
+
+// tenantTagger is a span processor that records the tenant of the request
+// on every span started for it, including model calls and tool spans.
+type tenantTagger struct{}
+
+func (tenantTagger) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if name, ok := parent.Value(tenantKey{}).(string); ok {
+		s.SetAttributes(attribute.String("langsmith.metadata.tenant", name))
+	}
+}
+
+func (tenantTagger) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (tenantTagger) Shutdown(context.Context) error   { return nil }
+func (tenantTagger) ForceFlush(context.Context) error { return nil }
+
+// tenantRouter exports each tenant's spans with the tenant's LangSmith key
+// and project. Spans outside a tenant request, such as outbox deliveries,
+// go to the default exporter.
+type tenantRouter struct {
+	fallback  sdktrace.SpanExporter
+	exporters map[string]sdktrace.SpanExporter
+}
+
+func newTenantRouter(ctx context.Context, tenants []tenantConfig) (*tenantRouter, error) {
+	exportOpts, err := otlpexport.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// Tenant exports fail like the default one under fault injection
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if injector != nil {
+		exportOpts.Transport = injector.Transport(nil)
+	}
+	// Tenant keys come from the config file rather than the environment
+	for _, t := range tenants {
+		exportOpts.AddSecrets(t.LangSmithAPIKey, t.AnthropicAPIKey)
+	}
+	r := &tenantRouter{exporters: map[string]sdktrace.SpanExporter{}}
+	for _, t := range tenants {
+		if r.exporters[t.Name], err = otlpexport.NewLangSmithExporter(ctx, t.LangSmithAPIKey, t.Project, exportOpts); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	return r, nil
+}
+
+// wrap is an otlpexport.ExporterWrapper that makes exporter the fallback.
+func (r *tenantRouter) wrap(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	r.fallback = exporter
+	return r
+}
+
+func (r *tenantRouter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	byExporter := map[sdktrace.SpanExporter][]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		exporter := r.fallback
+		for _, kv := range s.Attributes() {
+			if kv.Key == "langsmith.metadata.tenant" {
+				if e, ok := r.exporters[kv.Value.AsString()]; ok {
+					exporter = e
+				}
+				break
+			}
+		}
+		byExporter[exporter] = append(byExporter[exporter], s)
+	}
+	var errs []error
+	for exporter, batch := range byExporter {
+		errs = append(errs, exporter.ExportSpans(ctx, batch))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *tenantRouter) Shutdown(ctx context.Context) error {
+	errs := []error{r.fallback.Shutdown(ctx)}
+	for _, e := range r.exporters {
+		errs = append(errs, e.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// quota enforces a tenant's request rate and concurrent turns.
+type quota struct {
+	perMinute int
+	slots     chan struct{}
+
+	mu          sync.Mutex
+	windowStart time.Time
+	used        int
+}
+
+func newQuota(perMinute, concurrent int) *quota {
+	q := &quota{perMinute: perMinute}
+	if concurrent > 0 {
+		q.slots = make(chan struct{}, concurrent)
+	}
+	return q
+}
+
+// errQuota is returned when a tenant is over one of its quotas.
+var errQuota = errors.New("quota exceeded")
+
+// acquire takes a turn slot, or fails with errQuota; call the returned
+// release when the turn is done.
+func (q *quota) acquire() (release func(), err error) {
+	if q.perMinute > 0 {
+		q.mu.Lock()
+		if now := time.Now(); now.Sub(q.windowStart) >= time.Minute {
+			q.windowStart, q.used = now, 0
+		}
+		if q.used >= q.perMinute {
+			q.mu.Unlock()
+			return nil, boterr.New(boterr.ErrRateLimited, "", fmt.Errorf("%w: %d requests per minute", errQuota, q.perMinute))
+		}
+		q.used++
+		q.mu.Unlock()
+	}
+	if q.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case q.slots <- struct{}{}:
+		return func() { <-q.slots }, nil
+	default:
+		return nil, boterr.New(boterr.ErrRateLimited, "", fmt.Errorf("%w: %d concurrent turns", errQuota, cap(q.slots)))
+	}
+}
@@ -0,0 +1,38 @@
+package itsm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTenantRouterFaults checks that injected export failures reach the
+// tenants' exporters, not only the default one.
+func TestTenantRouterFaults(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+	t.Setenv("OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTLP_RETRY_MAX_ELAPSED_TIME", "0")
+	t.Setenv("FAULT_EXPORT_FAILURE_RATE", "1")
+
+	r, err := newTenantRouter(context.Background(), []tenantConfig{{Name: "acme", LangSmithAPIKey: "acme-langsmith-key", Project: "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := tracetest.SpanStub{Name: "turn", Attributes: []attribute.KeyValue{attribute.String("langsmith.metadata.tenant", "acme")}}
+	if err := r.exporters["acme"].ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{span.Snapshot()}); err == nil {
+		t.Error("ExportSpans() succeeded, want the injected 503")
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d export requests reached the endpoint, want none", n)
+	}
+}
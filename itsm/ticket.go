@@ -0,0 +1,67 @@
+package itsm
+
+import "encoding/json"
+
+// Ticket statuses, in lifecycle order.
+const (
+	statusDraft        = "draft"
+	statusApproved     = "approved"
+	statusProvisioning = "provisioning"
+	statusProvisioned  = "provisioned"
+	statusFailed       = "failed"
+	// statusEscalated tickets were handed to a human agent.
+	statusEscalated = "escalated"
+	// statusDenied tickets were turned down by an approver.
+	statusDenied = "denied"
+)
+
+// mergeDraft folds the fields inferred from a new message into an existing
+// draft, so details given over several turns end up on one ticket. Changing
+// what an approved ticket grants sends it back to draft, and its approval
+// chain starts over.
+func mergeDraft(t *AccessRequest, d AccessRequest) {
+	before := *t
+	defer func() {
+		changed := t.Resource != before.Resource || t.AccessLevel != before.AccessLevel || t.Duration != before.Duration
+		if changed && (t.Status == statusApproved || len(t.Approvals) > 0) {
+			t.Status = statusDraft
+			t.ApprovedBy = ""
+			t.Approvals, t.Escalations = nil, nil
+		}
+	}()
+	if d.Resource != "unknown" {
+		t.Resource = d.Resource
+	}
+	if d.AccessLevel != "unknown" {
+		t.AccessLevel = d.AccessLevel
+	}
+	if d.Duration != "unknown" {
+		t.Duration = d.Duration
+	}
+	if d.RiskLevel == "high" {
+		t.RiskLevel = "high"
+	}
+	for name, v := range d.Fields {
+		if v != "unknown" {
+			setField(t, name, v)
+		}
+	}
+}
+
+// tenantName is the tenant t belongs to. Tickets stored before tickets
+// recorded one belong to the default tenant.
+func (t AccessRequest) tenantName() string {
+	if t.Tenant == "" {
+		return "default"
+	}
+	return t.Tenant
+}
+
+// ticketContext describes the current ticket for the system prompt so the
+// model can refer to it (and pass its ID to tools).
+func ticketContext(t AccessRequest) string {
+	// Trace context and the tenant mean nothing to the model
+	t.TraceParent, t.TraceState, t.Tenant = "", "", ""
+	ticketJSON, _ := json.MarshalIndent(t, "", "  ")
+	return "Current ticket for this conversation (use its id with tools):\n" + string(ticketJSON)
+}
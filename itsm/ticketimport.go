@@ -0,0 +1,218 @@
+package itsm
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// importColumns are the CSV columns "tickets import" reads, by the ticket
+// field they set. Only resource is required.
+var importColumns = map[string]func(t *AccessRequest, v string){
+	"id":                     func(t *AccessRequest, v string) { t.ID = v },
+	"requested_for":          func(t *AccessRequest, v string) { t.RequestedFor = v },
+	"requester_email":        func(t *AccessRequest, v string) { t.RequesterEmail = v },
+	"resource":               func(t *AccessRequest, v string) { t.Resource = v },
+	"access_level":           func(t *AccessRequest, v string) { t.AccessLevel = v },
+	"duration":               func(t *AccessRequest, v string) { t.Duration = v },
+	"business_justification": func(t *AccessRequest, v string) { t.BusinessJustif = v },
+	"risk_level":             func(t *AccessRequest, v string) { t.RiskLevel = v },
+	"approvals_required":     func(t *AccessRequest, v string) { t.ApprovalsRequired = v },
+	"created_at":             func(t *AccessRequest, v string) { t.CreatedAt = v },
+}
+
+// importTickets implements "tickets import": one draft per CSV row, such as
+// when moving off a spreadsheet-based process. Each row is traced as its
+// own ticket_import trace; rows that fail validation are reported by row
+// number and skipped, and the rest are imported.
+func importTickets(args []string) error {
+	fs := flag.NewFlagSet("tickets import", flag.ContinueOnError)
+	validate := fs.Bool("validate", false, "check the rows without creating tickets")
+	tenant := fs.String("tenant", "default", "server tenant the tickets belong to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: tickets import [--validate] [--tenant name] <file.csv>")
+	}
+	path := fs.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	// Rows with the wrong number of fields are reported, not fatal
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading the header row: %w", err)
+	}
+	// The ticket template's fields are columns too
+	tmpl, err := loadTicketTemplate(os.Getenv("ITSM_TICKET_TEMPLATE"))
+	if err != nil {
+		return err
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := tmpl.field(header[i]); importColumns[header[i]] == nil && !ok {
+			return fmt.Errorf("unknown column %q (known: id, requested_for, requester_email, resource, access_level, duration, business_justification, risk_level, approvals_required, created_at, and the ticket template's fields)", name)
+		}
+	}
+	if !slices.Contains(header, "resource") {
+		return errors.New("the resource column is required")
+	}
+
+	store, err := openPersistedStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	tracer := otel.Tracer("go-bot-itsm")
+	importID := uuid.New().String()
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "import"
+	}
+	ctx := withChange(withTenant(context.Background(), *tenant), change{Actor: actor, TurnIndex: -1})
+
+	var imported, failed int
+	// Row 1 is the header
+	for row := 2; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A malformed line can't be told apart from the next one
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		src := importSource{file: filepath.Base(path), importID: importID, row: row, validate: *validate}
+		t, err := importRow(ctx, tracer, store, tmpl, src, header, record)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "row %d: %v\n", row, err)
+			continue
+		}
+		imported++
+		who := t.RequestedFor
+		if t.RequesterEmail != "" {
+			who = t.RequesterEmail
+		}
+		fmt.Printf("row %d: %s %s on %s for %s\n", row, t.ID, t.AccessLevel, t.Resource, who)
+	}
+
+	verb := "Imported"
+	if *validate {
+		verb = "Validated"
+	}
+	fmt.Printf("%s %d tickets from %s, %d rows failed (import %s)\n", verb, imported, path, failed, importID)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", failed, imported+failed)
+	}
+	return nil
+}
+
+// importSource is where an imported row comes from.
+type importSource struct {
+	file, importID string
+	row            int
+	// validate only checks the row
+	validate bool
+}
+
+// importRow turns record into a draft, validates it against the ticket
+// schema and stores it, in a ticket_import span that starts a trace of its
+// own. Validation problems fail the row, listed together.
+func importRow(ctx context.Context, tracer trace.Tracer, store *TicketStore, tmpl *ticketTemplate, src importSource, header, record []string) (AccessRequest, error) {
+	ctx, span := tracer.Start(ctx, "ticket_import", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("langsmith.trace.name", "ticket_import"),
+		attribute.String("langsmith.span.kind", "chain"),
+		attribute.String("langsmith.metadata.import_id", src.importID),
+		attribute.String("itsm.import.file", src.file),
+		attribute.Int("itsm.import.row", src.row),
+		attribute.Bool("itsm.import.validate_only", src.validate),
+	))
+	defer span.End()
+	fail := func(err error) (AccessRequest, error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return AccessRequest{}, err
+	}
+
+	t := AccessRequest{
+		Type:               "access_request",
+		RequestedFor:       "self",
+		Resource:           "unknown",
+		AccessLevel:        "unknown",
+		Duration:           "unknown",
+		ApprovalsRequired:  "manager + system_owner",
+		Status:             statusDraft,
+		CreatedAt:          time.Now().UTC().Format(time.RFC3339),
+		RecommendedActions: "collect justification; confirm duration; route for approval; provision access; log audit",
+	}
+	for i, v := range record {
+		if v = strings.TrimSpace(v); v != "" && i < len(header) {
+			if set := importColumns[header[i]]; set != nil {
+				set(&t, v)
+			} else {
+				setField(&t, header[i], v)
+			}
+		}
+	}
+	tmpl.apply(&t)
+	if t.ID == "" {
+		t.ID = "AR-" + strings.ToUpper(uuid.New().String()[:8])
+	}
+	if t.RiskLevel == "" {
+		t.RiskLevel = "medium"
+		if t.AccessLevel == "admin" || strings.Contains(t.Resource, "prod") {
+			t.RiskLevel = "high"
+		}
+	}
+	span.SetAttributes(
+		attribute.String("itsm.ticket.id", t.ID),
+		attribute.String("itsm.ticket.resource", t.Resource),
+	)
+
+	problems := validateTicket(t, tmpl)
+	if len(record) != len(header) {
+		problems = append(problems, fmt.Sprintf("%d fields for %d columns", len(record), len(header)))
+	}
+	if _, exists := store.get(t.ID); exists {
+		problems = append(problems, fmt.Sprintf("ticket %s already exists", t.ID))
+	}
+	if len(problems) > 0 {
+		span.SetAttributes(attribute.StringSlice("itsm.import.problems", problems))
+		return fail(errors.New(strings.Join(problems, "; ")))
+	}
+	if src.validate {
+		return t, nil
+	}
+	if err := store.put(ctx, t); err != nil {
+		return fail(fmt.Errorf("saving %s: %w", t.ID, err))
+	}
+	return t, nil
+}
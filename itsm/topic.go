@@ -0,0 +1,118 @@
+package itsm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const topicClassifierPrompt = `You classify messages sent to an IT service desk assistant that only handles ACCESS REQUESTS.
+Reply with exactly one word:
+- on_topic: the message asks for, changes, or follows up on access to systems, tools, data or permissions, or it answers a question the assistant just asked
+- off_topic: anything else`
+
+// steerBackInstruction is appended to the system prompt for off-topic turns.
+const steerBackInstruction = `The user's latest message is outside the scope of access requests.
+Do not answer it. Briefly and politely explain that you can only help with access requests, then ask what access they need.`
+
+// driftAction controls what happens when the user strays from access requests.
+type driftAction string
+
+const (
+	// driftSteer keeps the ITSM persona and asks the model to steer back.
+	driftSteer driftAction = "steer"
+	// driftChat answers off-topic turns with the generic chat persona.
+	driftChat driftAction = "chat"
+	// driftOff skips topic classification.
+	driftOff driftAction = "off"
+)
+
+// resolveDriftAction reads ITSM_OFF_TOPIC, defaulting to "steer".
+func resolveDriftAction() (driftAction, error) {
+	switch action := driftAction(os.Getenv("ITSM_OFF_TOPIC")); action {
+	case "":
+		return driftSteer, nil
+	case driftSteer, driftChat, driftOff:
+		return action, nil
+	default:
+		return "", fmt.Errorf("ITSM_OFF_TOPIC must be steer, chat or off, got %q", action)
+	}
+}
+
+// classifyTopic reports whether userMessage is about access requests, using
+// the previous assistant reply as context so short answers ("7 days") count
+// as on topic. Failures are recorded on the span and treated as on topic.
+func classifyTopic(ctx context.Context, client *anthropic.Client, tracer trace.Tracer, model string, history []anthropic.MessageParam, userMessage string, action driftAction) bool {
+	ctx, span := tracer.Start(ctx, "topic_classification",
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("gen_ai.request.model", model),
+		),
+	)
+	defer span.End()
+
+	var transcript strings.Builder
+	if reply := lastAssistantText(history); reply != "" {
+		fmt.Fprintf(&transcript, "Assistant: %s\n\n", reply)
+	}
+	fmt.Fprintf(&transcript, "User: %s", userMessage)
+
+	resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: 5,
+		System: []anthropic.TextBlockParam{
+			{Text: topicClassifierPrompt},
+		},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(transcript.String())),
+		},
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("itsm.topic", "unknown"))
+		return true
+	}
+
+	var label string
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			label = strings.ToLower(strings.TrimSpace(block.Text))
+		}
+	}
+	topic := "on_topic"
+	if strings.HasPrefix(label, "off_topic") {
+		topic = "off_topic"
+	}
+
+	span.SetAttributes(
+		attribute.String("itsm.topic", topic),
+		attribute.String("itsm.topic.action", string(action)),
+		attribute.Int64("gen_ai.usage.input_tokens", resp.Usage.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", resp.Usage.OutputTokens),
+	)
+	return topic == "on_topic"
+}
+
+// lastAssistantText returns the text of the latest assistant message in history.
+func lastAssistantText(history []anthropic.MessageParam) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role != anthropic.MessageParamRoleAssistant {
+			continue
+		}
+		var parts []string
+		for _, block := range history[i].Content {
+			if block.OfText != nil {
+				parts = append(parts, block.OfText.Text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package itsm
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceContext returns the W3C traceparent and tracestate of the span in
+// ctx, or empty strings when there is no valid span.
+func traceContext(ctx context.Context) (traceparent, tracestate string) {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier["traceparent"], carrier["tracestate"]
+}
+
+// spanContextOf parses a traceparent and tracestate back into a span
+// context; it is invalid when traceparent is empty or malformed.
+func spanContextOf(traceparent, tracestate string) trace.SpanContext {
+	carrier := propagation.MapCarrier{"traceparent": traceparent, "tracestate": tracestate}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+	return trace.SpanContextFromContext(ctx)
+}
+
+// linkToOrigin links span to the conversation turn that created t, so
+// approvals, provisioning and revocations that happen later can be followed
+// back to the request. Spans in the originating trace need no link.
+func linkToOrigin(span trace.Span, t AccessRequest) {
+	if origin := originOf(t); origin.IsValid() && origin.TraceID() != span.SpanContext().TraceID() {
+		span.AddLink(trace.Link{SpanContext: origin, Attributes: []attribute.KeyValue{
+			attribute.String("itsm.ticket.id", t.ID),
+		}})
+	}
+}
+
+// originOf is the span context of the turn that created t.
+func originOf(t AccessRequest) trace.SpanContext {
+	return spanContextOf(t.TraceParent, t.TraceState)
+}
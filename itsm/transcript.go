@@ -0,0 +1,301 @@
+package itsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/otlpexport"
+)
+
+// transcript is a saved conversation: every span it produced, as exported.
+type transcript struct {
+	RecordedAt time.Time        `json:"recorded_at"`
+	Spans      []transcriptSpan `json:"spans"`
+}
+
+type transcriptSpan struct {
+	TraceID      string                `json:"trace_id"`
+	SpanID       string                `json:"span_id"`
+	ParentSpanID string                `json:"parent_span_id,omitempty"`
+	Name         string                `json:"name"`
+	Kind         string                `json:"kind"`
+	Start        time.Time             `json:"start_time"`
+	End          time.Time             `json:"end_time"`
+	Attributes   []transcriptAttribute `json:"attributes,omitempty"`
+	Events       []transcriptEvent     `json:"events,omitempty"`
+	Links        []transcriptLink      `json:"links,omitempty"`
+	StatusCode   string                `json:"status_code,omitempty"`
+	StatusDesc   string                `json:"status_description,omitempty"`
+}
+
+type transcriptEvent struct {
+	Name       string                `json:"name"`
+	Time       time.Time             `json:"time"`
+	Attributes []transcriptAttribute `json:"attributes,omitempty"`
+}
+
+type transcriptLink struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+// transcriptAttribute keeps the attribute's type, which JSON alone would
+// lose for integers and empty slices.
+type transcriptAttribute struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func encodeAttributes(kvs []attribute.KeyValue) []transcriptAttribute {
+	out := make([]transcriptAttribute, 0, len(kvs))
+	for _, kv := range kvs {
+		v, err := json.Marshal(kv.Value.AsInterface())
+		if err != nil {
+			continue
+		}
+		out = append(out, transcriptAttribute{Key: string(kv.Key), Type: kv.Value.Type().String(), Value: v})
+	}
+	return out
+}
+
+func decodeAttributes(attrs []transcriptAttribute) ([]attribute.KeyValue, error) {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var kv attribute.KeyValue
+		var err error
+		switch a.Type {
+		case "BOOL":
+			var v bool
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.Bool(a.Key, v)
+		case "INT64":
+			var v int64
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.Int64(a.Key, v)
+		case "FLOAT64":
+			var v float64
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.Float64(a.Key, v)
+		case "STRING":
+			var v string
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.String(a.Key, v)
+		case "BOOLSLICE":
+			var v []bool
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.BoolSlice(a.Key, v)
+		case "INT64SLICE":
+			var v []int64
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.Int64Slice(a.Key, v)
+		case "FLOAT64SLICE":
+			var v []float64
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.Float64Slice(a.Key, v)
+		case "STRINGSLICE":
+			var v []string
+			err = json.Unmarshal(a.Value, &v)
+			kv = attribute.StringSlice(a.Key, v)
+		default:
+			err = fmt.Errorf("unknown type %q", a.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", a.Key, err)
+		}
+		out = append(out, kv)
+	}
+	return out, nil
+}
+
+// transcriptRecorder is an otlpexport.ExporterWrapper that keeps every exported span
+// and writes them to path when the exporter shuts down.
+type transcriptRecorder struct {
+	sdktrace.SpanExporter
+	path string
+
+	mu    sync.Mutex
+	spans []transcriptSpan
+}
+
+func newTranscriptRecorder(path string) otlpexport.ExporterWrapper {
+	return func(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+		return &transcriptRecorder{SpanExporter: exporter, path: path}
+	}
+}
+
+func (r *transcriptRecorder) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	for _, s := range spans {
+		ts := transcriptSpan{
+			TraceID:    s.SpanContext().TraceID().String(),
+			SpanID:     s.SpanContext().SpanID().String(),
+			Name:       s.Name(),
+			Kind:       s.SpanKind().String(),
+			Start:      s.StartTime(),
+			End:        s.EndTime(),
+			Attributes: encodeAttributes(s.Attributes()),
+		}
+		if s.Parent().IsValid() {
+			ts.ParentSpanID = s.Parent().SpanID().String()
+		}
+		for _, e := range s.Events() {
+			ts.Events = append(ts.Events, transcriptEvent{Name: e.Name, Time: e.Time, Attributes: encodeAttributes(e.Attributes)})
+		}
+		for _, l := range s.Links() {
+			ts.Links = append(ts.Links, transcriptLink{TraceID: l.SpanContext.TraceID().String(), SpanID: l.SpanContext.SpanID().String()})
+		}
+		if st := s.Status(); st.Code != codes.Unset {
+			ts.StatusCode, ts.StatusDesc = st.Code.String(), st.Description
+		}
+		r.spans = append(r.spans, ts)
+	}
+	r.mu.Unlock()
+	return r.SpanExporter.ExportSpans(ctx, spans)
+}
+
+// Shutdown runs after the provider's last flush, so the transcript is
+// complete.
+func (r *transcriptRecorder) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(transcript{RecordedAt: time.Now().UTC(), Spans: r.spans}, "", "  ")
+	r.mu.Unlock()
+	if err == nil {
+		err = os.WriteFile(r.path, data, 0o644)
+	}
+	if err != nil {
+		err = fmt.Errorf("writing transcript: %w", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "Saved %d spans to %s\n", len(r.spans), r.path)
+	}
+	return errors.Join(err, r.SpanExporter.Shutdown(ctx))
+}
+
+var spanKinds = map[string]trace.SpanKind{
+	"internal": trace.SpanKindInternal,
+	"server":   trace.SpanKindServer,
+	"client":   trace.SpanKindClient,
+	"producer": trace.SpanKindProducer,
+	"consumer": trace.SpanKindConsumer,
+}
+
+// replayTranscript implements "replay": the spans of a saved conversation
+// are sent again with the same names, nesting, attributes and durations,
+// without calling the model. Replayed spans get new IDs, and by default
+// the conversation is moved so it ends now.
+func replayTranscript(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	originalTimes := fs.Bool("original-times", false, "keep the recorded timestamps instead of moving the conversation to now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [--original-times] <transcript.json>")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var t transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("parsing %s: %w", fs.Arg(0), err)
+	}
+	if len(t.Spans) == 0 {
+		return fmt.Errorf("%s has no spans", fs.Arg(0))
+	}
+
+	shutdown, err := initCommandTracer()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	// Parents start no later than their children, so sorting by start time,
+	// and by depth for ties, lets every span find its parent's new context
+	parents := map[string]string{}
+	for _, s := range t.Spans {
+		parents[s.SpanID] = s.ParentSpanID
+	}
+	depth := func(id string) int {
+		n := 0
+		for p, ok := parents[id]; ok && n < len(parents); p, ok = parents[p] {
+			n++
+		}
+		return n
+	}
+	spans := slices.Clone(t.Spans)
+	slices.SortStableFunc(spans, func(a, b transcriptSpan) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return depth(a.SpanID) - depth(b.SpanID)
+	})
+	var shift time.Duration
+	if !*originalTimes {
+		last := spans[0].End
+		for _, s := range spans {
+			if s.End.After(last) {
+				last = s.End
+			}
+		}
+		shift = time.Since(last)
+	}
+
+	tracer := otel.Tracer("go-bot-itsm/replay")
+	started := map[string]trace.Span{}
+	for _, s := range spans {
+		attrs, err := decodeAttributes(s.Attributes)
+		if err != nil {
+			return fmt.Errorf("span %s: %w", s.Name, err)
+		}
+		ctx := context.Background()
+		parent, ok := started[s.ParentSpanID]
+		if ok {
+			ctx = trace.ContextWithSpan(ctx, parent)
+		} else {
+			attrs = append(attrs, attribute.String("langsmith.metadata.replayed_from", s.TraceID))
+		}
+		opts := []trace.SpanStartOption{
+			trace.WithTimestamp(s.Start.Add(shift)),
+			trace.WithSpanKind(spanKinds[s.Kind]),
+			trace.WithAttributes(attrs...),
+		}
+		for _, l := range s.Links {
+			// Links to spans in the transcript point at their replays
+			if linked, ok := started[l.SpanID]; ok {
+				opts = append(opts, trace.WithLinks(trace.Link{SpanContext: linked.SpanContext()}))
+			}
+		}
+		_, span := tracer.Start(ctx, s.Name, opts...)
+		for _, e := range s.Events {
+			eventAttrs, err := decodeAttributes(e.Attributes)
+			if err != nil {
+				return fmt.Errorf("event %s: %w", e.Name, err)
+			}
+			span.AddEvent(e.Name, trace.WithTimestamp(e.Time.Add(shift)), trace.WithAttributes(eventAttrs...))
+		}
+		switch s.StatusCode {
+		case codes.Error.String():
+			span.SetStatus(codes.Error, s.StatusDesc)
+		case codes.Ok.String():
+			span.SetStatus(codes.Ok, "")
+		}
+		span.End(trace.WithTimestamp(s.End.Add(shift)))
+		started[s.SpanID] = span
+	}
+	fmt.Fprintf(os.Stderr, "Replayed %d spans from %s\n", len(spans), fs.Arg(0))
+	return nil
+}
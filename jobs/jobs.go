@@ -0,0 +1,123 @@
+// Package jobs runs the work a turn leaves behind, such as recording spend
+// and posting evaluation feedback, in the background, so the user gets the
+// answer without waiting on side effects. Each job is traced as the root of
+// its own trace, linked to the span that started it, so the traces stay
+// connected without holding the turn's trace open.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Runner runs jobs in the background, a bounded number at once. A nil
+// Runner runs each job before Go returns.
+type Runner struct {
+	// Tracer traces the jobs; it defaults to the global provider's.
+	Tracer trace.Tracer
+	// Timeout bounds each job.
+	Timeout time.Duration
+
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+// New returns a Runner running up to workers jobs at once, each for up to
+// timeout.
+func New(workers int, timeout time.Duration) *Runner {
+	return &Runner{Timeout: timeout, slots: make(chan struct{}, workers)}
+}
+
+// FromEnv returns a Runner running JOB_WORKERS jobs at once (default 4),
+// each for up to JOB_TIMEOUT (default 30s). JOB_WORKERS=0 returns nil, so
+// jobs run inline.
+func FromEnv() (*Runner, error) {
+	workers := 4
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("JOB_WORKERS must be a non-negative integer, got %q", v)
+		}
+		workers = n
+	}
+	timeout := 30 * time.Second
+	if v := os.Getenv("JOB_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("JOB_TIMEOUT must be a positive duration, got %q", v)
+		}
+		timeout = d
+	}
+	if workers == 0 {
+		return nil, nil
+	}
+	return New(workers, timeout), nil
+}
+
+// Go runs fn as the job name, traced as a span of that name with attrs and
+// a link to the span of ctx. fn's context keeps the values of ctx, such as
+// a dry run or tenant, but not its cancellation, so a job outlives the
+// request that started it. Errors are recorded on the job span and logged.
+func (r *Runner) Go(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) {
+	ctx = context.WithoutCancel(ctx)
+	if r == nil {
+		run(ctx, otel.Tracer("go-tracing-demo/jobs"), 0, name, fn, attrs)
+		return
+	}
+	tracer := r.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer("go-tracing-demo/jobs")
+	}
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		r.slots <- struct{}{}
+		defer func() { <-r.slots }()
+		run(ctx, tracer, r.Timeout, name, fn, attrs)
+	}()
+}
+
+// Wait blocks until the jobs started so far have finished. Call it before
+// exiting, ahead of flushing the tracer provider.
+func (r *Runner) Wait() {
+	if r == nil {
+		return
+	}
+	r.pending.Wait()
+}
+
+func run(ctx context.Context, tracer trace.Tracer, timeout time.Duration, name string, fn func(context.Context) error, attrs []attribute.KeyValue) {
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("langsmith.trace.name", name),
+			attribute.String("langsmith.span.kind", "chain"),
+			attribute.String("job.name", name),
+		}, attrs...)...),
+	}
+	if parent := trace.SpanContextFromContext(ctx); parent.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: parent}))
+	}
+	ctx, span := tracer.Start(ctx, name, opts...)
+	defer span.End()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := fn(ctx); err != nil {
+		log.Printf("Job %s: %v", name, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
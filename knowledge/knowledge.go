@@ -0,0 +1,278 @@
+// Package knowledge grounds answers in policy documents: it retrieves the
+// sections relevant to a message, asks the model to cite the ones it uses,
+// and checks those citations against what was retrieved, so answers that
+// cite policy nobody provided show up on the trace.
+package knowledge
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Chunk is one section of a policy document.
+type Chunk struct {
+	// DocID is the document's file name without its extension.
+	DocID string
+	// Section is the slug of the section's heading, such as
+	// "privileged-access"; text before the first heading is "intro".
+	Section string
+	Heading string
+	Text    string
+
+	terms map[string]int
+}
+
+// Citation is how the model cites c: [doc-id#section].
+func (c Chunk) Citation() string {
+	return "[" + c.DocID + "#" + c.Section + "]"
+}
+
+// Base is the policy documents, split into sections for retrieval.
+type Base struct {
+	// TopK is how many sections Retrieve returns at most.
+	TopK   int
+	chunks []Chunk
+	// docFreq counts the sections each term appears in
+	docFreq map[string]int
+}
+
+// FromEnv loads the Markdown documents in POLICY_DOCS_DIR, retrieving up to
+// POLICY_TOP_K sections per message (default 3). It returns nil when the
+// directory is not set, which leaves retrieval off.
+func FromEnv() (*Base, error) {
+	dir := os.Getenv("POLICY_DOCS_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	b, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("POLICY_TOP_K"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("POLICY_TOP_K must be a positive integer, got %q", v)
+		}
+		b.TopK = n
+	}
+	return b, nil
+}
+
+// Load reads every .md file in dir, split at its headings.
+func Load(dir string) (*Base, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no policy documents (*.md) in %s", dir)
+	}
+	b := &Base{TopK: 3, docFreq: map[string]int{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading policy document: %w", err)
+		}
+		docID := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		for _, c := range split(docID, string(data)) {
+			c.terms = map[string]int{}
+			for _, term := range terms(c.Heading + " " + c.Text) {
+				c.terms[term]++
+			}
+			for term := range c.terms {
+				b.docFreq[term]++
+			}
+			b.chunks = append(b.chunks, c)
+		}
+	}
+	return b, nil
+}
+
+// split cuts a document into a chunk per heading. Sections whose slugs
+// repeat within the document are numbered, so each citation is unique.
+func split(docID, text string) []Chunk {
+	var chunks []Chunk
+	current := Chunk{DocID: docID, Section: "intro"}
+	var body strings.Builder
+	seen := map[string]int{}
+	flush := func() {
+		if current.Text = strings.TrimSpace(body.String()); current.Text != "" {
+			chunks = append(chunks, current)
+		}
+		body.Reset()
+	}
+	for _, line := range strings.Split(text, "\n") {
+		heading, ok := strings.CutPrefix(strings.TrimLeft(line, "#"), " ")
+		if !strings.HasPrefix(line, "#") || !ok {
+			body.WriteString(line + "\n")
+			continue
+		}
+		flush()
+		heading = strings.TrimSpace(heading)
+		section := slug(heading)
+		if seen[section]++; seen[section] > 1 {
+			section += "-" + strconv.Itoa(seen[section])
+		}
+		current = Chunk{DocID: docID, Section: section, Heading: heading}
+	}
+	flush()
+	return chunks
+}
+
+// slug lowercases s and joins its words with hyphens.
+func slug(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+}
+
+// terms are the lowercase words of s worth matching on.
+func terms(s string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) > 2 && !stopWords[w] {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "with": true, "that": true,
+	"this": true, "you": true, "can": true, "need": true, "have": true, "from": true,
+	"please": true, "would": true, "like": true, "not": true, "but": true, "all": true,
+}
+
+// Retrieve returns the sections most relevant to query, best first, in a
+// policy_retrieval span. Sections sharing no terms with the query are left
+// out, so it may return none. A nil Base retrieves nothing.
+func (b *Base) Retrieve(ctx context.Context, tracer trace.Tracer, query string) []Chunk {
+	if b == nil {
+		return nil
+	}
+	_, span := tracer.Start(ctx, "policy_retrieval", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "retriever"),
+		attribute.String("retrieval.query", query),
+		attribute.Int("retrieval.top_k", b.TopK),
+	))
+	defer span.End()
+
+	type scored struct {
+		chunk Chunk
+		score float64
+	}
+	var ranked []scored
+	n := float64(len(b.chunks))
+	for _, c := range b.chunks {
+		score := 0.0
+		for _, term := range terms(query) {
+			if tf := c.terms[term]; tf > 0 {
+				idf := math.Log(1 + n/float64(b.docFreq[term]))
+				score += idf * (1 + math.Log(float64(tf)))
+			}
+		}
+		if score > 0 {
+			ranked = append(ranked, scored{c, score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	if len(ranked) > b.TopK {
+		ranked = ranked[:b.TopK]
+	}
+
+	chunks := make([]Chunk, len(ranked))
+	ids := make([]string, len(ranked))
+	scores := make([]float64, len(ranked))
+	for i, r := range ranked {
+		chunks[i], ids[i], scores[i] = r.chunk, r.chunk.Citation(), r.score
+	}
+	span.SetAttributes(
+		attribute.Int("retrieval.document_count", len(chunks)),
+		attribute.StringSlice("retrieval.document_ids", ids),
+		attribute.Float64Slice("retrieval.scores", scores),
+	)
+	return chunks
+}
+
+// Instruction is the system prompt addition that gives the model chunks
+// and requires it to cite them.
+func Instruction(chunks []Chunk) string {
+	var b strings.Builder
+	b.WriteString("Company policy relevant to this message. Base policy statements on these snippets only, and cite each snippet you rely on right after the statement, exactly as its label, like [access-policy#privileged-access]. Do not cite anything else. If the snippets don't cover the question, say so instead of guessing.\n")
+	for _, c := range chunks {
+		fmt.Fprintf(&b, "\n%s %s\n%s\n", c.Citation(), c.Heading, c.Text)
+	}
+	return b.String()
+}
+
+var citationPattern = regexp.MustCompile(`\[([A-Za-z0-9][A-Za-z0-9_.-]*)#([a-z0-9][a-z0-9-]*)\]`)
+
+// Citations are the citations in an answer, checked against the chunks
+// retrieved for it.
+type Citations struct {
+	// Cited lists each distinct citation, in order of appearance; Invalid
+	// those that name no retrieved chunk.
+	Cited   []string
+	Invalid []string
+	// Retrieved is how many chunks the answer could cite.
+	Retrieved int
+}
+
+// Check finds the citations in answer and validates them against chunks.
+func Check(answer string, chunks []Chunk) Citations {
+	retrieved := map[string]bool{}
+	for _, c := range chunks {
+		retrieved[c.Citation()] = true
+	}
+	c := Citations{Retrieved: len(chunks)}
+	seen := map[string]bool{}
+	for _, m := range citationPattern.FindAllString(answer, -1) {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		c.Cited = append(c.Cited, m)
+		if !retrieved[m] {
+			c.Invalid = append(c.Invalid, m)
+		}
+	}
+	return c
+}
+
+// Valid reports whether the answer cited policy, and only retrieved
+// policy. An answer with nothing retrieved to cite is valid as long as it
+// cites nothing.
+func (c Citations) Valid() bool {
+	if c.Retrieved == 0 {
+		return len(c.Cited) == 0
+	}
+	return len(c.Cited) > 0 && len(c.Invalid) == 0
+}
+
+// Record sets the citation check on span, for hallucination monitoring.
+func (c Citations) Record(span trace.Span) {
+	span.SetAttributes(
+		attribute.Int("rag.retrieved_count", c.Retrieved),
+		attribute.StringSlice("rag.citations", c.Cited),
+		attribute.Int("rag.citation_count", len(c.Cited)),
+		attribute.Int("rag.invalid_citation_count", len(c.Invalid)),
+		attribute.Bool("rag.citations_valid", c.Valid()),
+		attribute.Bool("rag.citation_missing", c.Retrieved > 0 && len(c.Cited) == 0),
+	)
+	if len(c.Invalid) > 0 {
+		span.SetAttributes(attribute.StringSlice("rag.invalid_citations", c.Invalid))
+	}
+}
@@ -0,0 +1,101 @@
+package otlpexport
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// AttributeFilter decides which attribute keys are exported, matching keys
+// against path.Match globs such as gen_ai.* or langsmith.metadata.*. It
+// applies to span, event and link attributes.
+type AttributeFilter struct {
+	// Allow, when set, lists the only keys that are exported.
+	Allow []string
+	// Deny lists keys that are never exported, even when allowed.
+	Deny []string
+}
+
+// StripAttributes wraps exporter so spans lose the attributes
+// o.Attributes doesn't keep. It should wrap the exporter that sends spans
+// out, beneath every other wrapper, so attributes added at export are
+// filtered too. With no filter exporter is returned as is.
+func (o Options) StripAttributes(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	if len(o.Attributes.Allow)+len(o.Attributes.Deny) == 0 {
+		return exporter
+	}
+	return &attributeExporter{SpanExporter: exporter, filter: o.Attributes}
+}
+
+type attributeExporter struct {
+	sdktrace.SpanExporter
+	filter AttributeFilter
+}
+
+func (e *attributeExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	filtered := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		filtered[i] = filteredSpan{ReadOnlySpan: s, filter: e.filter}
+	}
+	return e.SpanExporter.ExportSpans(ctx, filtered)
+}
+
+// Keep reports whether key may be exported.
+func (f AttributeFilter) Keep(key string) bool {
+	if len(f.Allow) > 0 && !matchAny(f.Allow, key) {
+		return false
+	}
+	return !matchAny(f.Deny, key)
+}
+
+func (f AttributeFilter) apply(attrs []attribute.KeyValue) ([]attribute.KeyValue, int) {
+	kept := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		if f.Keep(string(kv.Key)) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept, len(attrs) - len(kept)
+}
+
+// filteredSpan reports a span with only the attributes filter keeps. The
+// stripped ones count as dropped, as if over the attribute limit.
+type filteredSpan struct {
+	sdktrace.ReadOnlySpan
+	filter AttributeFilter
+}
+
+func (s filteredSpan) Attributes() []attribute.KeyValue {
+	kept, _ := s.filter.apply(s.ReadOnlySpan.Attributes())
+	return kept
+}
+
+func (s filteredSpan) DroppedAttributes() int {
+	_, stripped := s.filter.apply(s.ReadOnlySpan.Attributes())
+	return s.ReadOnlySpan.DroppedAttributes() + stripped
+}
+
+func (s filteredSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		var stripped int
+		e.Attributes, stripped = s.filter.apply(e.Attributes)
+		e.DroppedAttributeCount += stripped
+		out[i] = e
+	}
+	return out
+}
+
+func (s filteredSpan) Links() []sdktrace.Link {
+	links := s.ReadOnlySpan.Links()
+	out := make([]sdktrace.Link, len(links))
+	for i, l := range links {
+		var stripped int
+		l.Attributes, stripped = s.filter.apply(l.Attributes)
+		l.DroppedAttributeCount += stripped
+		out[i] = l
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package otlpexport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ClockReferenceURL is asked for the time when TRACE_CLOCK_OFFSET is auto.
+const ClockReferenceURL = "https://api.smith.langchain.com"
+
+// Skew wraps exporter so every exported timestamp is shifted by the clock
+// offset: TRACE_CLOCK_OFFSET as given, or measured against
+// ClockReferenceURL when it is auto. Durations are unaffected, since the
+// SDK takes them from the monotonic clock. With no offset exporter is
+// returned as is.
+func (o Options) Skew(ctx context.Context, exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	offset := o.ClockOffset
+	if o.MeasureClock {
+		measured, err := MeasureClockOffset(ctx, &http.Client{Timeout: 5 * time.Second}, ClockReferenceURL)
+		if err != nil {
+			log.Printf("Measuring clock offset: %v", err)
+		} else {
+			log.Printf("Clock offset against %s: %s", ClockReferenceURL, measured)
+			offset = measured
+		}
+	}
+	if offset == 0 {
+		return exporter
+	}
+	return &skewExporter{SpanExporter: exporter, offset: offset}
+}
+
+// MeasureClockOffset estimates how far the local clock is behind url's, NTP
+// style: the server's Date header is taken to be read halfway through the
+// round trip. Date has one-second resolution, so offsets under a second
+// are reported as zero.
+func MeasureClockOffset(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(sent)
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return 0, errors.New("no Date header in response")
+	}
+	server, err := http.ParseTime(date)
+	if err != nil {
+		return 0, fmt.Errorf("parsing Date header %q: %w", date, err)
+	}
+	offset := server.Sub(sent.Add(rtt / 2))
+	if offset > -time.Second && offset < time.Second {
+		return 0, nil
+	}
+	return offset.Round(time.Second), nil
+}
+
+type skewExporter struct {
+	sdktrace.SpanExporter
+	offset time.Duration
+}
+
+func (e *skewExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	skewed := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		skewed[i] = skewedSpan{ReadOnlySpan: s, offset: e.offset}
+	}
+	return e.SpanExporter.ExportSpans(ctx, skewed)
+}
+
+// skewedSpan reports a span's timestamps shifted by offset.
+type skewedSpan struct {
+	sdktrace.ReadOnlySpan
+	offset time.Duration
+}
+
+func (s skewedSpan) StartTime() time.Time { return s.ReadOnlySpan.StartTime().Add(s.offset) }
+func (s skewedSpan) EndTime() time.Time   { return s.ReadOnlySpan.EndTime().Add(s.offset) }
+
+func (s skewedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	if len(events) == 0 {
+		return events
+	}
+	shifted := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		e.Time = e.Time.Add(s.offset)
+		shifted[i] = e
+	}
+	return shifted
+}
@@ -0,0 +1,107 @@
+package otlpexport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// maxFileLine caps one export batch read back from an OTLP file.
+const maxFileLine = 64 << 20
+
+// NewFileExporter returns an exporter that appends spans to path instead of
+// sending them: one OTLP JSON ExportTraceServiceRequest per line, as the
+// OpenTelemetry Collector's file exporter writes them. Upload sends the
+// file on later.
+func NewFileExporter(ctx context.Context, path string) (*otlptrace.Exporter, error) {
+	return otlptrace.New(ctx, &fileClient{path: path})
+}
+
+// fileClient is an otlptrace.Client that writes to a file.
+type fileClient struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (c *fileClient) Start(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening trace file: %w", err)
+	}
+	c.f = f
+	return nil
+}
+
+func (c *fileClient) Stop(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
+
+func (c *fileClient) UploadTraces(_ context.Context, spans []*tracepb.ResourceSpans) error {
+	line, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return fmt.Errorf("trace file %s is closed", c.path)
+	}
+	_, err = c.f.Write(append(line, '\n'))
+	return err
+}
+
+// Upload sends every batch in an OTLP JSON file written by NewFileExporter
+// with client, in order, and returns how many spans it sent. It stops at
+// the first batch that fails, so a rerun after fixing the cause sends the
+// earlier batches again.
+func Upload(ctx context.Context, path string, client otlptrace.Client) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := client.Start(ctx); err != nil {
+		return 0, err
+	}
+	defer client.Stop(ctx)
+
+	sent := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxFileLine)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := protojson.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return sent, fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		if err := client.UploadTraces(ctx, req.ResourceSpans); err != nil {
+			return sent, fmt.Errorf("uploading %s line %d: %w", path, n, err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				sent += len(ss.Spans)
+			}
+		}
+	}
+	return sent, scanner.Err()
+}
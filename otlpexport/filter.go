@@ -0,0 +1,144 @@
+package otlpexport
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanRename renames spans whose name matches Pattern, a path.Match glob.
+type SpanRename struct {
+	Pattern string
+	Name    string
+}
+
+// SpanFilter drops or renames spans before they are exported, to keep
+// low-value spans such as health checks out of LangSmith.
+type SpanFilter struct {
+	// Drop holds globs; spans matching one are not exported, and their
+	// children are attached to the nearest kept ancestor.
+	Drop   []string
+	Rename []SpanRename
+}
+
+// spanFilterFromEnv reads SPAN_DROP, comma-separated globs, and
+// SPAN_RENAME, comma-separated glob=name pairs.
+func spanFilterFromEnv() (SpanFilter, error) {
+	var f SpanFilter
+	var err error
+	if f.Drop, err = globsFromEnv("SPAN_DROP"); err != nil {
+		return f, err
+	}
+	for _, r := range strings.Split(os.Getenv("SPAN_RENAME"), ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		pattern, name, ok := strings.Cut(r, "=")
+		pattern, name = strings.TrimSpace(pattern), strings.TrimSpace(name)
+		if !ok || pattern == "" || name == "" {
+			return f, fmt.Errorf("SPAN_RENAME entries must be pattern=name, got %q", r)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, fmt.Errorf("SPAN_RENAME: bad pattern %q", pattern)
+		}
+		f.Rename = append(f.Rename, SpanRename{Pattern: pattern, Name: name})
+	}
+	return f, nil
+}
+
+// globsFromEnv reads the comma-separated globs in the variable name.
+func globsFromEnv(name string) ([]string, error) {
+	var globs []string
+	for _, p := range strings.Split(os.Getenv(name), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%s: bad pattern %q", name, p)
+		}
+		globs = append(globs, p)
+	}
+	return globs, nil
+}
+
+// matchAny reports whether name matches one of globs.
+func matchAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter wraps next, the processor that exports, so spans are dropped and
+// renamed by o.SpanFilter first. Spans are matched on the name they are
+// started with. With no rules next is returned as is.
+func (o Options) Filter(next sdktrace.SpanProcessor) sdktrace.SpanProcessor {
+	if len(o.SpanFilter.Drop)+len(o.SpanFilter.Rename) == 0 {
+		return next
+	}
+	return &filterProcessor{SpanProcessor: next, filter: o.SpanFilter, dropped: map[trace.SpanID]trace.SpanContext{}}
+}
+
+type filterProcessor struct {
+	sdktrace.SpanProcessor
+	filter SpanFilter
+
+	mu sync.Mutex
+	// dropped maps each dropped span still open to its parent.
+	dropped map[trace.SpanID]trace.SpanContext
+}
+
+func (p *filterProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	name := s.Name()
+	if matchAny(p.filter.Drop, name) {
+		p.mu.Lock()
+		p.dropped[s.SpanContext().SpanID()] = s.Parent()
+		p.mu.Unlock()
+		return
+	}
+	for _, r := range p.filter.Rename {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			s.SetName(r.Name)
+			break
+		}
+	}
+	p.SpanProcessor.OnStart(ctx, s)
+}
+
+func (p *filterProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	p.mu.Lock()
+	if _, ok := p.dropped[s.SpanContext().SpanID()]; ok {
+		delete(p.dropped, s.SpanContext().SpanID())
+		p.mu.Unlock()
+		return
+	}
+	parent, reparented := s.Parent(), false
+	for {
+		grandparent, ok := p.dropped[parent.SpanID()]
+		if !ok {
+			break
+		}
+		parent, reparented = grandparent, true
+	}
+	p.mu.Unlock()
+	if reparented {
+		s = reparentedSpan{ReadOnlySpan: s, parent: parent}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// reparentedSpan reports a span as the child of parent.
+type reparentedSpan struct {
+	sdktrace.ReadOnlySpan
+	parent trace.SpanContext
+}
+
+func (s reparentedSpan) Parent() trace.SpanContext { return s.parent }
@@ -0,0 +1,184 @@
+package otlpexport
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Health accounts for every sampled span that ends: it is exported,
+// waiting in the batch queue, or dropped. The batch processor drops spans
+// silently when its queue is full or an export fails, so without this
+// telemetry can go missing unnoticed.
+type Health struct {
+	maxQueue int64
+
+	accepted atomic.Int64
+	exported atomic.Int64
+	failed   atomic.Int64
+	overflow atomic.Int64
+
+	mu        sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+}
+
+// Stats is a snapshot of a Health.
+type Stats struct {
+	Exported int64
+	// Queued spans have ended but are not exported yet.
+	Queued int64
+	// Dropped spans were either turned away by a full queue or were in a
+	// batch that failed to export after retries.
+	Dropped     int64
+	LastError   error
+	LastErrorAt time.Time
+}
+
+// NewHealth returns an empty Health; wire it in with Batcher.
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Batcher returns a batch span processor for exporter, wrapped so h sees
+// every span that ends and every export.
+func (h *Health) Batcher(exporter sdktrace.SpanExporter, opts ...sdktrace.BatchSpanProcessorOption) sdktrace.SpanProcessor {
+	o := sdktrace.BatchSpanProcessorOptions{MaxQueueSize: sdktrace.DefaultMaxQueueSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	h.maxQueue = int64(o.MaxQueueSize)
+	return &healthProcessor{
+		SpanProcessor: sdktrace.NewBatchSpanProcessor(&healthExporter{SpanExporter: exporter, h: h}, opts...),
+		h:             h,
+	}
+}
+
+// Stats returns the current counts.
+func (h *Health) Stats() Stats {
+	exported, failed := h.exported.Load(), h.failed.Load()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Stats{
+		Exported:    exported,
+		Queued:      h.accepted.Load() - exported - failed,
+		Dropped:     failed + h.overflow.Load(),
+		LastError:   h.lastErr,
+		LastErrorAt: h.lastErrAt,
+	}
+}
+
+// RegisterMetrics publishes the counts as otlp.export.spans.* instruments
+// on meter.
+func (h *Health) RegisterMetrics(meter metric.Meter) error {
+	exported, err := meter.Int64ObservableCounter("otlp.export.spans.exported",
+		metric.WithDescription("Spans exported successfully"))
+	if err != nil {
+		return err
+	}
+	queued, err := meter.Int64ObservableGauge("otlp.export.spans.queued",
+		metric.WithDescription("Spans ended but not exported yet"))
+	if err != nil {
+		return err
+	}
+	dropped, err := meter.Int64ObservableCounter("otlp.export.spans.dropped",
+		metric.WithDescription("Spans dropped by a full queue or a failed export"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s := h.Stats()
+		o.ObserveInt64(exported, s.Exported)
+		o.ObserveInt64(queued, s.Queued)
+		o.ObserveInt64(dropped, s.Dropped)
+		return nil
+	}, exported, queued, dropped)
+	return err
+}
+
+// Log writes the counts as one log line.
+func (h *Health) Log() {
+	s := h.Stats()
+	if s.LastError != nil {
+		log.Printf("span export: %d exported, %d queued, %d dropped; last error at %s: %v",
+			s.Exported, s.Queued, s.Dropped, s.LastErrorAt.Format(time.RFC3339), s.LastError)
+		return
+	}
+	log.Printf("span export: %d exported, %d queued, %d dropped", s.Exported, s.Queued, s.Dropped)
+}
+
+// LogEvery logs the counts every interval while they change, until stop is
+// called. A zero interval logs nothing.
+func (h *Health) LogEvery(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last Stats
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// An idle session shouldn't fill the log with the same line
+				if s := h.Stats(); s.changedFrom(last) {
+					last = s
+					h.Log()
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (s Stats) changedFrom(last Stats) bool {
+	return s.Exported != last.Exported || s.Queued != last.Queued ||
+		s.Dropped != last.Dropped || !s.LastErrorAt.Equal(last.LastErrorAt)
+}
+
+// healthProcessor turns spans away itself once the queue is full, so that
+// every dropped span is counted.
+type healthProcessor struct {
+	sdktrace.SpanProcessor
+	h *Health
+}
+
+func (p *healthProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	// The batch processor ignores unsampled spans too
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+	h := p.h
+	if h.accepted.Load()-h.exported.Load()-h.failed.Load() >= h.maxQueue {
+		h.overflow.Add(1)
+		return
+	}
+	h.accepted.Add(1)
+	p.SpanProcessor.OnEnd(s)
+}
+
+type healthExporter struct {
+	sdktrace.SpanExporter
+	h *Health
+}
+
+func (e *healthExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		e.h.failed.Add(int64(len(spans)))
+		e.h.mu.Lock()
+		e.h.lastErr, e.h.lastErrAt = err, time.Now()
+		e.h.mu.Unlock()
+		return err
+	}
+	e.h.exported.Add(int64(len(spans)))
+	return nil
+}
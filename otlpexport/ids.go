@@ -0,0 +1,66 @@
+package otlpexport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DeterministicIDs reports whether TRACE_DETERMINISTIC_IDS asks for trace
+// IDs derived from trace keys.
+func DeterministicIDs() bool {
+	on, _ := strconv.ParseBool(os.Getenv("TRACE_DETERMINISTIC_IDS"))
+	return on
+}
+
+type traceKey struct{}
+
+// WithTraceKey returns a context whose root spans get a trace ID derived
+// from key when the tracer provider uses IDGenerator, so the same key
+// always maps to the same trace.
+func WithTraceKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, traceKey{}, key)
+}
+
+// TraceIDFor is the trace ID IDGenerator derives from key.
+func TraceIDFor(key string) trace.TraceID {
+	sum := sha256.Sum256([]byte(key))
+	var id trace.TraceID
+	copy(id[:], sum[:])
+	return id
+}
+
+// IDGenerator derives trace IDs from the trace key in the context and
+// generates random IDs otherwise. Span IDs stay random: LangSmith keys runs
+// by span ID, so a replayed turn with the original's span ID would
+// overwrite it instead of sitting next to it.
+type IDGenerator struct{}
+
+func (IDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var tid trace.TraceID
+	if key, ok := ctx.Value(traceKey{}).(string); ok {
+		tid = TraceIDFor(key)
+	} else {
+		binary.BigEndian.PutUint64(tid[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(tid[8:], rand.Uint64())
+	}
+	return tid, newSpanID()
+}
+
+func (IDGenerator) NewSpanID(context.Context, trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var sid trace.SpanID
+	// A zero span ID is invalid
+	for !sid.IsValid() {
+		binary.BigEndian.PutUint64(sid[:], rand.Uint64())
+	}
+	return sid
+}
@@ -0,0 +1,121 @@
+package otlpexport
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// minMaskLen keeps short values such as "eu", which would match ordinary
+// text, from being masked.
+const minMaskLen = 4
+
+// Masker replaces values that only become known while the process runs,
+// such as confidential ticket fields, in exported spans and any other text
+// that leaves the process. Unlike Scrub's secrets, values are added as they
+// are seen. With a limit, only the values added most recently are kept, so
+// a long-running process masks the records it is working on without
+// keeping every value it has ever read.
+type Masker struct {
+	// Mask replaces each value.
+	Mask string
+	// Limit caps the values kept; zero keeps them all.
+	Limit int
+
+	mu sync.Mutex
+	// values holds each value's element in recent, most recently added
+	// first.
+	values   map[string]*list.Element
+	recent   *list.List
+	replacer *strings.Replacer
+	sorted   []string
+}
+
+// NewMasker returns a Masker replacing values with mask, keeping up to
+// limit of them (zero for no limit).
+func NewMasker(mask string, limit int) *Masker {
+	return &Masker{Mask: mask, Limit: limit, values: map[string]*list.Element{}, recent: list.New()}
+}
+
+// Add registers values to be masked from now on. A value already
+// registered counts as recently added again.
+func (m *Masker) Add(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range values {
+		if len(v) < minMaskLen {
+			continue
+		}
+		if e, ok := m.values[v]; ok {
+			m.recent.MoveToFront(e)
+			continue
+		}
+		m.values[v] = m.recent.PushFront(v)
+		if m.Limit > 0 && m.recent.Len() > m.Limit {
+			delete(m.values, m.recent.Remove(m.recent.Back()).(string))
+		}
+		m.replacer = nil
+	}
+}
+
+// snapshot returns the values, longest first so a value is replaced whole
+// before any value inside it, and a replacer for them.
+func (m *Masker) snapshot() ([]string, *strings.Replacer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.replacer == nil {
+		// A new slice, since exports may still be using the last one
+		m.sorted = make([]string, 0, len(m.values))
+		for v := range m.values {
+			m.sorted = append(m.sorted, v)
+		}
+		sort.Slice(m.sorted, func(i, j int) bool { return len(m.sorted[i]) > len(m.sorted[j]) })
+		pairs := make([]string, 0, 2*len(m.sorted))
+		for _, v := range m.sorted {
+			pairs = append(pairs, v, m.Mask)
+		}
+		m.replacer = strings.NewReplacer(pairs...)
+	}
+	return m.sorted, m.replacer
+}
+
+// Replace masks the registered values in s.
+func (m *Masker) Replace(s string) string {
+	if m == nil {
+		return s
+	}
+	values, replacer := m.snapshot()
+	if len(values) == 0 {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// Wrap returns exporter with the registered values masked in span names,
+// string attributes, events and status descriptions, as Scrub does for
+// secrets. A nil Masker returns exporter as is.
+func (m *Masker) Wrap(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	if m == nil {
+		return exporter
+	}
+	return &maskExporter{SpanExporter: exporter, m: m}
+}
+
+type maskExporter struct {
+	sdktrace.SpanExporter
+	m *Masker
+}
+
+func (e *maskExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	values, replacer := e.m.snapshot()
+	if len(values) == 0 {
+		return e.SpanExporter.ExportSpans(ctx, spans)
+	}
+	// The values known as of this batch, scrubbed like secrets
+	scrub := &scrubExporter{SpanExporter: e.SpanExporter, secrets: values, replacer: replacer}
+	return scrub.ExportSpans(ctx, spans)
+}
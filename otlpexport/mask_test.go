@@ -0,0 +1,13 @@
+package otlpexport
+
+import "testing"
+
+func TestMaskerLimit(t *testing.T) {
+	m := NewMasker("***", 2)
+	m.Add("alpha", "bravo")
+	m.Add("alpha", "charlie")
+	// bravo was added least recently, so charlie pushed it out
+	if got := m.Replace("alpha bravo charlie eu"); got != "*** bravo *** eu" {
+		t.Errorf("Replace() = %q", got)
+	}
+}
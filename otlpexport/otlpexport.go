@@ -0,0 +1,266 @@
+// Package otlpexport holds the tracing pipeline settings the bots share:
+// OTLP compression and retry/backoff for transient LangSmith errors, health
+// and size accounting for spans on their way out, and context propagators.
+// Init puts them together into the tracer provider each binary runs with.
+package otlpexport
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Defaults match the OTLP exporter's own retry defaults.
+const (
+	DefaultInitialInterval = 5 * time.Second
+	DefaultMaxInterval     = 30 * time.Second
+	DefaultMaxElapsedTime  = time.Minute
+)
+
+// DefaultHealthLogInterval is how often export health is logged.
+const DefaultHealthLogInterval = 5 * time.Minute
+
+// DefaultShutdownTimeout is how long spans still queued at exit may take
+// to export.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Options configure the OTLP HTTP exporter.
+type Options struct {
+	// Gzip compresses export requests; gen_ai payloads compress well.
+	Gzip bool
+	// Retry backs off exponentially from InitialInterval up to MaxInterval
+	// and gives up on a batch after MaxElapsedTime. A zero MaxElapsedTime
+	// disables retries.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+	// HealthLogInterval is how often Health logs its counts; zero turns
+	// the periodic line off.
+	HealthLogInterval time.Duration
+	// ShutdownTimeout bounds the final flush at exit.
+	ShutdownTimeout time.Duration
+	// MaxQueueSize and MaxExportBatchSize size the batch processor; zero
+	// keeps the SDK's defaults. BlockOnFullQueue makes a full queue hold
+	// up the span that overflows it instead of dropping the span.
+	MaxQueueSize       int
+	MaxExportBatchSize int
+	BlockOnFullQueue   bool
+	// SpanBudget is the estimated span size in bytes above which a span is
+	// reported; zero only records sizes.
+	SpanBudget int
+	// ClockOffset is added to every exported timestamp to correct a skewed
+	// local clock; MeasureClock measures it at startup instead.
+	ClockOffset  time.Duration
+	MeasureClock bool
+	// File, when set, is where spans are written as OTLP JSON instead of
+	// being sent, for environments without network access.
+	File string
+	// SpanFilter drops and renames spans before export.
+	SpanFilter SpanFilter
+	// Attributes limits which attribute keys leave the process.
+	Attributes AttributeFilter
+	// Secrets are values replaced with Redacted wherever they appear.
+	Secrets []string
+	// Schemas are the attribute schemas spans are exported in.
+	Schemas []string
+	// Endpoint, when set, is the OTLP traces URL spans are sent to instead
+	// of LangSmith, with Headers in place of the LangSmith key and project.
+	Endpoint string
+	Headers  map[string]string
+	// Transport, when set, sends the export requests, for wrapping them
+	// (see faults.Injector.Transport).
+	Transport http.RoundTripper
+}
+
+// FromEnv reads OTLP_COMPRESSION (gzip or none, default gzip) and
+// OTLP_RETRY_INITIAL_INTERVAL, OTLP_RETRY_MAX_INTERVAL,
+// OTLP_RETRY_MAX_ELAPSED_TIME, OTLP_HEALTH_LOG_INTERVAL and
+// OTLP_SHUTDOWN_TIMEOUT (durations, default 5s, 30s, 1m, 5m and 10s),
+// OTLP_MAX_QUEUE_SIZE, OTLP_MAX_EXPORT_BATCH_SIZE and
+// OTLP_BLOCK_ON_FULL_QUEUE, OTLP_SPAN_BUDGET (bytes, default 262144) and
+// TRACE_CLOCK_OFFSET (a duration, possibly negative, or auto), OTLP_FILE,
+// SPAN_DROP and SPAN_RENAME (see SpanFilter), and TRACE_ATTRIBUTES_ALLOW
+// and TRACE_ATTRIBUTES_DENY, comma-separated globs (see AttributeFilter).
+// Secrets are read from SecretEnvVars and SECRET_ENV_VARS, and the export
+// target from TRACE_SCHEMA, OTLP_ENDPOINT and OTLP_HEADERS (comma-separated
+// key=value pairs).
+func FromEnv() (Options, error) {
+	o := Options{
+		Gzip:            true,
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		MaxElapsedTime:  DefaultMaxElapsedTime,
+
+		HealthLogInterval: DefaultHealthLogInterval,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+		SpanBudget:        DefaultSpanBudget,
+
+		File:     os.Getenv("OTLP_FILE"),
+		Secrets:  secretsFromEnv(),
+		Endpoint: os.Getenv("OTLP_ENDPOINT"),
+	}
+	switch c := os.Getenv("OTLP_COMPRESSION"); c {
+	case "", "gzip":
+	case "none":
+		o.Gzip = false
+	default:
+		return o, fmt.Errorf("OTLP_COMPRESSION must be gzip or none, got %q", c)
+	}
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"OTLP_RETRY_INITIAL_INTERVAL", &o.InitialInterval},
+		{"OTLP_RETRY_MAX_INTERVAL", &o.MaxInterval},
+		{"OTLP_RETRY_MAX_ELAPSED_TIME", &o.MaxElapsedTime},
+		{"OTLP_HEALTH_LOG_INTERVAL", &o.HealthLogInterval},
+		{"OTLP_SHUTDOWN_TIMEOUT", &o.ShutdownTimeout},
+	} {
+		v := os.Getenv(d.name)
+		if v == "" {
+			continue
+		}
+		n, err := time.ParseDuration(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("%s must be a duration, got %q", d.name, v)
+		}
+		*d.dst = n
+	}
+	if v := os.Getenv("OTLP_SPAN_BUDGET"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("OTLP_SPAN_BUDGET must be a byte count, got %q", v)
+		}
+		o.SpanBudget = n
+	}
+	for _, c := range []struct {
+		name string
+		dst  *int
+	}{
+		{"OTLP_MAX_QUEUE_SIZE", &o.MaxQueueSize},
+		{"OTLP_MAX_EXPORT_BATCH_SIZE", &o.MaxExportBatchSize},
+	} {
+		v := os.Getenv(c.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return o, fmt.Errorf("%s must be a positive span count, got %q", c.name, v)
+		}
+		*c.dst = n
+	}
+	if v := os.Getenv("OTLP_BLOCK_ON_FULL_QUEUE"); v != "" {
+		block, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("OTLP_BLOCK_ON_FULL_QUEUE must be a boolean, got %q", v)
+		}
+		o.BlockOnFullQueue = block
+	}
+	switch v := os.Getenv("TRACE_CLOCK_OFFSET"); v {
+	case "":
+	case "auto":
+		o.MeasureClock = true
+	default:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return o, fmt.Errorf("TRACE_CLOCK_OFFSET must be a duration or auto, got %q", v)
+		}
+		o.ClockOffset = d
+	}
+	var err error
+	if o.SpanFilter, err = spanFilterFromEnv(); err != nil {
+		return o, err
+	}
+	if o.Attributes.Allow, err = globsFromEnv("TRACE_ATTRIBUTES_ALLOW"); err != nil {
+		return o, err
+	}
+	if o.Attributes.Deny, err = globsFromEnv("TRACE_ATTRIBUTES_DENY"); err != nil {
+		return o, err
+	}
+	if o.Schemas, err = schemasFromEnv(); err != nil {
+		return o, err
+	}
+	for _, h := range strings.Split(os.Getenv("OTLP_HEADERS"), ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(h, "=")
+		if !ok {
+			return o, fmt.Errorf("OTLP_HEADERS entries must be key=value, got %q", h)
+		}
+		if o.Headers == nil {
+			o.Headers = map[string]string{}
+		}
+		o.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return o, nil
+}
+
+// ToLangSmith reports whether spans go to LangSmith, and so need its API
+// key: neither OTLP_FILE nor OTLP_ENDPOINT sends them elsewhere.
+func ToLangSmith() bool {
+	return os.Getenv("OTLP_FILE") == "" && os.Getenv("OTLP_ENDPOINT") == ""
+}
+
+// EndpointOptions returns the otlptracehttp options that send spans to
+// projectName in LangSmith with apiKey, or to o.Endpoint when it is set.
+func (o Options) EndpointOptions(apiKey, projectName string) []otlptracehttp.Option {
+	if o.Endpoint != "" {
+		return append([]otlptracehttp.Option{
+			otlptracehttp.WithEndpointURL(o.Endpoint),
+			otlptracehttp.WithHeaders(o.Headers),
+		}, o.HTTPOptions()...)
+	}
+	return append([]otlptracehttp.Option{
+		otlptracehttp.WithEndpoint("api.smith.langchain.com"),
+		otlptracehttp.WithURLPath("/otel/v1/traces"),
+		otlptracehttp.WithHeaders(map[string]string{
+			"x-api-key":         apiKey,
+			"Langsmith-Project": projectName,
+		}),
+	}, o.HTTPOptions()...)
+}
+
+// BatcherOptions returns the batch processor options for o. Batches go
+// out every second, so spans show up in LangSmith while a chat runs.
+func (o Options) BatcherOptions() []sdktrace.BatchSpanProcessorOption {
+	opts := []sdktrace.BatchSpanProcessorOption{sdktrace.WithBatchTimeout(time.Second)}
+	if o.MaxQueueSize > 0 {
+		opts = append(opts, sdktrace.WithMaxQueueSize(o.MaxQueueSize))
+	}
+	if o.MaxExportBatchSize > 0 {
+		opts = append(opts, sdktrace.WithMaxExportBatchSize(o.MaxExportBatchSize))
+	}
+	if o.BlockOnFullQueue {
+		opts = append(opts, sdktrace.WithBlocking())
+	}
+	return opts
+}
+
+// HTTPOptions returns the otlptracehttp options for o.
+func (o Options) HTTPOptions() []otlptracehttp.Option {
+	compression := otlptracehttp.NoCompression
+	if o.Gzip {
+		compression = otlptracehttp.GzipCompression
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithCompression(compression),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{
+			Enabled:         o.MaxElapsedTime > 0,
+			InitialInterval: o.InitialInterval,
+			MaxInterval:     o.MaxInterval,
+			MaxElapsedTime:  o.MaxElapsedTime,
+		}),
+	}
+	if o.Transport != nil {
+		opts = append(opts, otlptracehttp.WithHTTPClient(&http.Client{Transport: o.Transport}))
+	}
+	return opts
+}
@@ -0,0 +1,46 @@
+package otlpexport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// DefaultPropagators is used when OTEL_PROPAGATORS is not set.
+const DefaultPropagators = "tracecontext,baggage"
+
+// PropagatorFromEnv builds the text map propagator named by
+// OTEL_PROPAGATORS, a comma-separated list of tracecontext, baggage, b3
+// (single header), b3multi, jaeger or none. Extraction tries them in order
+// and injection writes all of them, so callers on B3 or Jaeger headers stay
+// in the same trace.
+func PropagatorFromEnv() (propagation.TextMapPropagator, error) {
+	names := os.Getenv("OTEL_PROPAGATORS")
+	if names == "" {
+		names = DefaultPropagators
+	}
+	var props []propagation.TextMapPropagator
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "tracecontext":
+			props = append(props, propagation.TraceContext{})
+		case "baggage":
+			props = append(props, propagation.Baggage{})
+		case "b3":
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case "jaeger":
+			props = append(props, jaeger.Jaeger{})
+		case "none":
+			return propagation.NewCompositeTextMapPropagator(), nil
+		default:
+			return nil, fmt.Errorf("OTEL_PROPAGATORS: unknown propagator %q (want tracecontext, baggage, b3, b3multi, jaeger or none)", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
@@ -0,0 +1,228 @@
+package otlpexport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Attribute schemas spans can be exported in.
+const (
+	SchemaLangSmith     = "langsmith"
+	SchemaLangfuse      = "langfuse"
+	SchemaOpenInference = "openinference"
+)
+
+// schemaMapper returns the attributes a backend expects for a span with
+// attrs, the attributes the bots set. root is set for spans without a
+// local parent.
+type schemaMapper func(attrs []attribute.KeyValue, root bool) []attribute.KeyValue
+
+// schemaMappers hold every schema but LangSmith's, whose keys the bots set
+// themselves.
+var schemaMappers = map[string]schemaMapper{
+	SchemaLangfuse:      langfuseAttributes,
+	SchemaOpenInference: openInferenceAttributes,
+}
+
+// schemasFromEnv reads TRACE_SCHEMA, a comma-separated list of schemas
+// (default langsmith).
+func schemasFromEnv() ([]string, error) {
+	v := os.Getenv("TRACE_SCHEMA")
+	if v == "" {
+		return []string{SchemaLangSmith}, nil
+	}
+	var schemas []string
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if _, ok := schemaMappers[s]; !ok && s != SchemaLangSmith {
+			return nil, fmt.Errorf("TRACE_SCHEMA entries must be langsmith, langfuse or openinference, got %q", s)
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// MapSchema wraps exporter so spans carry the attribute names of every
+// schema in o.Schemas. langsmith.* keys are kept only when the list
+// includes langsmith. It should wrap the exporter that sends spans
+// directly, so filters, scrubbing and encryption above it work on the
+// bots' own keys and their results are what get mapped. With only the
+// LangSmith schema exporter is returned as is.
+func (o Options) MapSchema(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	e := &schemaExporter{SpanExporter: exporter}
+	for _, s := range o.Schemas {
+		if s == SchemaLangSmith {
+			e.keepLangSmith = true
+		} else {
+			e.mappers = append(e.mappers, schemaMappers[s])
+		}
+	}
+	if len(e.mappers) == 0 {
+		return exporter
+	}
+	return e
+}
+
+type schemaExporter struct {
+	sdktrace.SpanExporter
+	mappers       []schemaMapper
+	keepLangSmith bool
+}
+
+func (e *schemaExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	mapped := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		mapped[i] = mappedSpan{ReadOnlySpan: s, e: e}
+	}
+	return e.SpanExporter.ExportSpans(ctx, mapped)
+}
+
+// mappedSpan reports a span's attributes in the exporter's schemas.
+type mappedSpan struct {
+	sdktrace.ReadOnlySpan
+	e *schemaExporter
+}
+
+func (s mappedSpan) Attributes() []attribute.KeyValue {
+	attrs := s.ReadOnlySpan.Attributes()
+	root := !s.Parent().IsValid() || s.Parent().IsRemote()
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		if s.e.keepLangSmith || !strings.HasPrefix(string(kv.Key), "langsmith.") {
+			out = append(out, kv)
+		}
+	}
+	for _, m := range s.e.mappers {
+		out = append(out, m(attrs, root)...)
+	}
+	return out
+}
+
+// langfuseObservationTypes maps langsmith.span.kind to Langfuse's
+// observation types.
+var langfuseObservationTypes = map[string]string{
+	"llm":       "generation",
+	"tool":      "tool",
+	"chain":     "chain",
+	"retriever": "retriever",
+}
+
+// langfuseAttributes maps to the attributes Langfuse reads from OTLP spans.
+// Langfuse understands gen_ai.* usage and model attributes as they are.
+func langfuseAttributes(attrs []attribute.KeyValue, root bool) []attribute.KeyValue {
+	var out []attribute.KeyValue
+	for _, kv := range attrs {
+		key := string(kv.Key)
+		switch key {
+		case "langsmith.trace.name":
+			out = append(out, attribute.KeyValue{Key: "langfuse.trace.name", Value: kv.Value})
+		case "langsmith.metadata.session_id":
+			out = append(out, attribute.KeyValue{Key: "langfuse.session.id", Value: kv.Value})
+		case "langsmith.metadata.user_id":
+			out = append(out, attribute.KeyValue{Key: "langfuse.user.id", Value: kv.Value})
+		case "langsmith.span.kind":
+			t, ok := langfuseObservationTypes[kv.Value.AsString()]
+			if !ok {
+				t = "span"
+			}
+			out = append(out, attribute.String("langfuse.observation.type", t))
+		case "gen_ai.prompt":
+			out = append(out, attribute.KeyValue{Key: "langfuse.observation.input", Value: kv.Value})
+		case "gen_ai.completion":
+			out = append(out, attribute.KeyValue{Key: "langfuse.observation.output", Value: kv.Value})
+		default:
+			if name, ok := strings.CutPrefix(key, "langsmith.metadata."); ok {
+				scope := "langfuse.observation.metadata."
+				if root {
+					scope = "langfuse.trace.metadata."
+				}
+				out = append(out, attribute.KeyValue{Key: attribute.Key(scope + name), Value: kv.Value})
+			}
+		}
+	}
+	return out
+}
+
+// openInferenceKinds maps langsmith.span.kind to OpenInference span kinds.
+var openInferenceKinds = map[string]string{
+	"llm":       "LLM",
+	"chain":     "CHAIN",
+	"tool":      "TOOL",
+	"retriever": "RETRIEVER",
+	"embedding": "EMBEDDING",
+}
+
+// openInferenceAttributes maps to the OpenInference semantic conventions
+// that Arize Phoenix reads. Every span gets a kind: its langsmith.span.kind,
+// else LLM for model calls, which carry gen_ai.system, else CHAIN. Inputs
+// and outputs get a MIME type, application/json when they parse as JSON,
+// and langsmith.metadata.* is gathered into the metadata JSON object.
+func openInferenceAttributes(attrs []attribute.KeyValue, _ bool) []attribute.KeyValue {
+	var out []attribute.KeyValue
+	kind := ""
+	metadata := map[string]any{}
+	for _, kv := range attrs {
+		key := string(kv.Key)
+		switch key {
+		case "langsmith.span.kind":
+			if k, ok := openInferenceKinds[kv.Value.AsString()]; ok {
+				kind = k
+			}
+		case "gen_ai.system":
+			if kind == "" {
+				kind = "LLM"
+			}
+			out = append(out, attribute.KeyValue{Key: "llm.provider", Value: kv.Value}, attribute.KeyValue{Key: "llm.system", Value: kv.Value})
+		case "gen_ai.prompt":
+			out = append(out, openInferenceValue("input", kv.Value.AsString())...)
+		case "gen_ai.completion":
+			out = append(out, openInferenceValue("output", kv.Value.AsString())...)
+		case "gen_ai.request.model":
+			out = append(out, attribute.KeyValue{Key: "llm.model_name", Value: kv.Value})
+		case "gen_ai.usage.input_tokens":
+			out = append(out, attribute.KeyValue{Key: "llm.token_count.prompt", Value: kv.Value})
+		case "gen_ai.usage.output_tokens":
+			out = append(out, attribute.KeyValue{Key: "llm.token_count.completion", Value: kv.Value})
+		case "gen_ai.tool.name":
+			out = append(out, attribute.KeyValue{Key: "tool.name", Value: kv.Value})
+		case "langsmith.metadata.session_id":
+			out = append(out, attribute.KeyValue{Key: "session.id", Value: kv.Value})
+		case "langsmith.metadata.user_id":
+			out = append(out, attribute.KeyValue{Key: "user.id", Value: kv.Value})
+		default:
+			if name, ok := strings.CutPrefix(key, "langsmith.metadata."); ok {
+				metadata[name] = kv.Value.AsInterface()
+			}
+		}
+	}
+	if kind == "" {
+		kind = "CHAIN"
+	}
+	out = append(out, attribute.String("openinference.span.kind", kind))
+	if len(metadata) > 0 {
+		if data, err := json.Marshal(metadata); err == nil {
+			out = append(out, attribute.String("metadata", string(data)))
+		}
+	}
+	return out
+}
+
+// openInferenceValue records value as prefix.value with its MIME type.
+func openInferenceValue(prefix, value string) []attribute.KeyValue {
+	mime := "text/plain"
+	if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			mime = "application/json"
+		}
+	}
+	return []attribute.KeyValue{
+		attribute.String(prefix+".value", value),
+		attribute.String(prefix+".mime_type", mime),
+	}
+}
@@ -0,0 +1,161 @@
+package otlpexport
+
+import (
+	"context"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Redacted replaces secret values in exported spans.
+const Redacted = "[REDACTED]"
+
+// SecretEnvVars are the variables whose values are scrubbed from spans.
+// SECRET_ENV_VARS adds more.
+var SecretEnvVars = []string{
+	"LANGSMITH_API_KEY",
+	"ANTHROPIC_API_KEY",
+	"DD_API_KEY",
+	"DD_APP_KEY",
+	"GITHUB_TOKEN",
+	"SNOWFLAKE_DSN",
+	"USER_ID_HMAC_KEY",
+	"TRACE_PAYLOAD_KEY",
+	"DIGEST_SLACK_WEBHOOK_URL",
+	"DIGEST_SMTP_PASSWORD",
+}
+
+// minSecretLen keeps short values, which would match ordinary text, from
+// being scrubbed.
+const minSecretLen = 8
+
+// secretsFromEnv returns the values of SecretEnvVars and of the
+// comma-separated SECRET_ENV_VARS. The password in a DSN such as
+// user:password@account is a secret of its own, since it may be echoed
+// without the rest.
+func secretsFromEnv() []string {
+	names := slices.Clone(SecretEnvVars)
+	for _, n := range strings.Split(os.Getenv("SECRET_ENV_VARS"), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	var secrets []string
+	for _, n := range names {
+		v := os.Getenv(n)
+		secrets = append(secrets, v)
+		if userinfo, _, ok := strings.Cut(v, "@"); ok {
+			if _, password, ok := strings.Cut(userinfo, ":"); ok {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+	return secrets
+}
+
+// AddSecrets adds values to be scrubbed, such as per-tenant API keys from
+// a config file.
+func (o *Options) AddSecrets(values ...string) {
+	o.Secrets = append(o.Secrets, values...)
+}
+
+// Scrub wraps exporter so every occurrence of o.Secrets in span names,
+// string attributes, events and status descriptions is replaced with
+// Redacted, in case a prompt or tool result echoes a credential. It should
+// wrap the exporter beneath other wrappers, like StripAttributes. With no
+// secrets exporter is returned as is.
+func (o Options) Scrub(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	var secrets []string
+	seen := map[string]bool{}
+	for _, s := range o.Secrets {
+		if len(s) >= minSecretLen && !seen[s] {
+			seen[s] = true
+			secrets = append(secrets, s)
+		}
+	}
+	if len(secrets) == 0 {
+		return exporter
+	}
+	// Longer secrets first, so a DSN is replaced whole before its password
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, Redacted)
+	}
+	return &scrubExporter{SpanExporter: exporter, secrets: secrets, replacer: strings.NewReplacer(pairs...)}
+}
+
+type scrubExporter struct {
+	sdktrace.SpanExporter
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+func (e *scrubExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	scrubbed := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		scrubbed[i] = scrubbedSpan{ReadOnlySpan: s, e: e}
+	}
+	return e.SpanExporter.ExportSpans(ctx, scrubbed)
+}
+
+// scrub replaces secrets in s, without allocating when there are none.
+func (e *scrubExporter) scrub(s string) string {
+	for _, secret := range e.secrets {
+		if strings.Contains(s, secret) {
+			return e.replacer.Replace(s)
+		}
+	}
+	return s
+}
+
+func (e *scrubExporter) scrubAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, len(attrs))
+	for i, kv := range attrs {
+		switch kv.Value.Type() {
+		case attribute.STRING:
+			kv.Value = attribute.StringValue(e.scrub(kv.Value.AsString()))
+		case attribute.STRINGSLICE:
+			values := kv.Value.AsStringSlice()
+			for j, v := range values {
+				values[j] = e.scrub(v)
+			}
+			kv.Value = attribute.StringSliceValue(values)
+		}
+		out[i] = kv
+	}
+	return out
+}
+
+// scrubbedSpan reports a span with secrets replaced.
+type scrubbedSpan struct {
+	sdktrace.ReadOnlySpan
+	e *scrubExporter
+}
+
+func (s scrubbedSpan) Name() string { return s.e.scrub(s.ReadOnlySpan.Name()) }
+
+func (s scrubbedSpan) Attributes() []attribute.KeyValue {
+	return s.e.scrubAttributes(s.ReadOnlySpan.Attributes())
+}
+
+func (s scrubbedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, ev := range events {
+		ev.Name = s.e.scrub(ev.Name)
+		ev.Attributes = s.e.scrubAttributes(ev.Attributes)
+		out[i] = ev
+	}
+	return out
+}
+
+func (s scrubbedSpan) Status() sdktrace.Status {
+	st := s.ReadOnlySpan.Status()
+	st.Description = s.e.scrub(st.Description)
+	return st
+}
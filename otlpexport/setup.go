@@ -0,0 +1,162 @@
+package otlpexport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go-tracing-demo/faults"
+	"go-tracing-demo/payload"
+	"go-tracing-demo/tracehooks"
+)
+
+// ExporterWrapper decorates a span exporter, e.g. to record or mask spans.
+type ExporterWrapper func(sdktrace.SpanExporter) sdktrace.SpanExporter
+
+// Setup is what a binary's tracer provider needs beyond the environment.
+type Setup struct {
+	// ServiceName is the service.name resource attribute and names the
+	// meter the export metrics are published on.
+	ServiceName string
+	APIKey      string
+	Project     string
+	// UserID, when set, is recorded on every span.
+	UserID string
+	// Wrap decorates the LangSmith exporter, innermost first.
+	Wrap []ExporterWrapper
+	// Redact, when set, sees every span before anything else does, e.g. to
+	// mask values that must not leave the process.
+	Redact ExporterWrapper
+}
+
+// TracingDisabled reports whether TRACING_DISABLED turns tracing off.
+func TracingDisabled() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv("TRACING_DISABLED"))
+	return disabled
+}
+
+// NewLangSmithExporter returns an OTLP exporter that sends spans to
+// project with apiKey, or writes them to OTLP_FILE when it is set.
+// Secrets are scrubbed, and payloads encrypted when TRACE_PAYLOAD_KEY is
+// set, on the way out.
+func NewLangSmithExporter(ctx context.Context, apiKey, project string, opts Options) (sdktrace.SpanExporter, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	// Offline, spans wait in OTLP_FILE for go-bot-itsm's upload command
+	if opts.File != "" {
+		exporter, err = NewFileExporter(ctx, opts.File)
+	} else {
+		exporter, err = otlptracehttp.New(ctx, opts.EndpointOptions(apiKey, project)...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating exporter: %w", err)
+	}
+	encryptor, err := payload.EncryptorFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return opts.Skew(ctx, opts.StripAttributes(opts.Scrub(encryptor.WrapExporter(opts.MapSchema(exporter))))), nil
+}
+
+// Init sets up the global tracer provider and propagator from s and the
+// environment, and returns the function that flushes and shuts it down.
+func Init(s Setup) (func(), error) {
+	// A no-op provider makes every span non-recording, so the hot path
+	// skips attribute building and export entirely
+	if TracingDisabled() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func() {}, nil
+	}
+	tracehooks.FromEnv()
+	if err := payload.VerbosityFromEnv(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(s.ServiceName),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+
+	exportOpts, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	injector, err := faults.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if injector != nil {
+		exportOpts.Transport = injector.Transport(nil)
+	}
+	propagator, err := PropagatorFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := NewLangSmithExporter(ctx, s.APIKey, s.Project, exportOpts)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range s.Wrap {
+		exporter = w(exporter)
+	}
+	exporter = tracehooks.WrapExporter(exporter)
+	if s.Redact != nil {
+		exporter = s.Redact(exporter)
+	}
+
+	meter := otel.Meter(s.ServiceName)
+	budget, err := NewSizeBudget(exportOpts.SpanBudget, meter)
+	if err != nil {
+		return nil, fmt.Errorf("registering span size metrics: %w", err)
+	}
+	health := NewHealth()
+	tpOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSpanProcessor(tracehooks.Processor{}),
+		sdktrace.WithSpanProcessor(budget),
+		sdktrace.WithSpanProcessor(payload.StripWhenMetadata(exportOpts.Filter(health.Batcher(exporter, exportOpts.BatcherOptions()...)))),
+		sdktrace.WithResource(res),
+	}
+	if DeterministicIDs() {
+		tpOpts = append(tpOpts, sdktrace.WithIDGenerator(IDGenerator{}))
+	}
+	if p := NewUserProcessor(s.UserID); p != nil {
+		tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(p))
+	}
+	tp := sdktrace.NewTracerProvider(tpOpts...)
+	if err := health.RegisterMetrics(meter); err != nil {
+		return nil, fmt.Errorf("registering export metrics: %w", err)
+	}
+	stopHealthLog := health.LogEvery(exportOpts.HealthLogInterval)
+	// SIGHUP switches between full payloads and metadata only
+	stopToggle := payload.ToggleOn(syscall.SIGHUP)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, exportOpts.ShutdownTimeout)
+		defer cancel()
+		if err := tp.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down tracer: %v", err)
+		}
+		stopHealthLog()
+		stopToggle()
+		health.Log()
+	}, nil
+}
@@ -0,0 +1,115 @@
+package otlpexport
+
+import (
+	"context"
+	"log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultSpanBudget is the span size in bytes above which SizeBudget warns.
+// LangSmith rejects oversized spans, so stay well clear of its limit.
+const DefaultSpanBudget = 256 << 10
+
+// fieldOverhead approximates the protobuf tag and length prefix of a field.
+const fieldOverhead = 4
+
+// SizeBudget is a span processor that records the estimated serialized size
+// of every sampled span and warns when one exceeds Budget bytes.
+type SizeBudget struct {
+	Budget int
+
+	size metric.Int64Histogram
+	over metric.Int64Counter
+}
+
+// NewSizeBudget records sizes as otlp.span.size and spans over budget as
+// otlp.span.over_budget on meter. A zero budget only records sizes.
+func NewSizeBudget(budget int, meter metric.Meter) (*SizeBudget, error) {
+	size, err := meter.Int64Histogram("otlp.span.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("Estimated serialized size of ended spans"))
+	if err != nil {
+		return nil, err
+	}
+	over, err := meter.Int64Counter("otlp.span.over_budget",
+		metric.WithDescription("Spans larger than the span size budget"))
+	if err != nil {
+		return nil, err
+	}
+	return &SizeBudget{Budget: budget, size: size, over: over}, nil
+}
+
+func (b *SizeBudget) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (b *SizeBudget) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+	ctx := context.Background()
+	size, largest, largestSize := SpanSize(s)
+	name := metric.WithAttributes(attribute.String("span.name", s.Name()))
+	b.size.Record(ctx, int64(size), name)
+	if b.Budget <= 0 || size <= b.Budget {
+		return
+	}
+	b.over.Add(ctx, 1, name)
+	log.Printf("span %s (trace %s) is about %d bytes, over the %d byte budget; largest attribute is %s (%d bytes)",
+		s.Name(), s.SpanContext().TraceID(), size, b.Budget, largest, largestSize)
+}
+
+func (b *SizeBudget) Shutdown(context.Context) error   { return nil }
+func (b *SizeBudget) ForceFlush(context.Context) error { return nil }
+
+// SpanSize estimates the OTLP-encoded size of s in bytes, without resource
+// attributes (which are sent once per batch). It also returns the largest
+// attribute, as that is usually the payload to trim.
+func SpanSize(s sdktrace.ReadOnlySpan) (size int, largest string, largestSize int) {
+	// Trace, span and parent IDs, kind, start and end times, status
+	size = 16 + 8 + 8 + 2 + 8 + 8 + len(s.Status().Description) + 6*fieldOverhead
+	size += len(s.Name()) + fieldOverhead
+	for _, kv := range s.Attributes() {
+		n := attrSize(kv)
+		size += n
+		if n > largestSize {
+			largest, largestSize = string(kv.Key), n
+		}
+	}
+	for _, e := range s.Events() {
+		size += len(e.Name) + 8 + 2*fieldOverhead
+		for _, kv := range e.Attributes {
+			size += attrSize(kv)
+		}
+	}
+	for _, l := range s.Links() {
+		size += 16 + 8 + 2*fieldOverhead
+		for _, kv := range l.Attributes {
+			size += attrSize(kv)
+		}
+	}
+	return size, largest, largestSize
+}
+
+func attrSize(kv attribute.KeyValue) int {
+	n := len(kv.Key) + 2*fieldOverhead
+	switch kv.Value.Type() {
+	case attribute.STRING:
+		n += len(kv.Value.AsString())
+	case attribute.STRINGSLICE:
+		for _, v := range kv.Value.AsStringSlice() {
+			n += len(v) + fieldOverhead
+		}
+	// Slices of scalars are packed; 8 bytes covers the widest element
+	case attribute.BOOLSLICE:
+		n += 8 * len(kv.Value.AsBoolSlice())
+	case attribute.INT64SLICE:
+		n += 8 * len(kv.Value.AsInt64Slice())
+	case attribute.FLOAT64SLICE:
+		n += 8 * len(kv.Value.AsFloat64Slice())
+	default:
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package otlpexport
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// UserIDFromEnv returns the user identity to record on spans: id, as given
+// by a --user flag, or else USER_ID. Nothing is recorded unless one is
+// set, so capturing an identity is always opted into. With
+// USER_ID_HASH=hmac the identity is replaced by its HMAC-SHA256 under
+// USER_ID_HMAC_KEY, which still groups a user's traces but can't be
+// reversed without the key.
+func UserIDFromEnv(id string) (string, error) {
+	if id == "" {
+		id = os.Getenv("USER_ID")
+	}
+	switch mode := os.Getenv("USER_ID_HASH"); mode {
+	case "", "raw":
+		return id, nil
+	case "hmac":
+		key := os.Getenv("USER_ID_HMAC_KEY")
+		if key == "" {
+			return "", errors.New("USER_ID_HASH=hmac needs USER_ID_HMAC_KEY")
+		}
+		if id == "" {
+			return "", nil
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(id))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	default:
+		return "", fmt.Errorf("USER_ID_HASH must be raw or hmac, got %q", mode)
+	}
+}
+
+// UserProcessor records a user identity on every span as
+// langsmith.metadata.user_id.
+type UserProcessor struct {
+	attr attribute.KeyValue
+}
+
+// NewUserProcessor returns a processor recording id, or nil when id is
+// empty.
+func NewUserProcessor(id string) *UserProcessor {
+	if id == "" {
+		return nil
+	}
+	return &UserProcessor{attr: attribute.String("langsmith.metadata.user_id", id)}
+}
+
+func (p *UserProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(p.attr)
+}
+
+func (p *UserProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *UserProcessor) Shutdown(context.Context) error   { return nil }
+func (p *UserProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,182 @@
+package payload
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// encryptedPrefix marks an encrypted value: the prefix, then base64 of the
+// GCM nonce followed by the sealed payload.
+const encryptedPrefix = "enc:v1:"
+
+// encryptedValue finds encrypted values in any text, such as an exported
+// OTLP JSON file.
+var encryptedValue = regexp.MustCompile(`enc:v1:[A-Za-z0-9+/=]+`)
+
+// Encryptor seals payload attributes with AES-256-GCM, so traces keep their
+// structure and metadata while prompts, completions and ticket JSON can only
+// be read with the key.
+type Encryptor struct {
+	aead cipher.AEAD
+	// nonceKey derives the nonces of EncryptStable
+	nonceKey []byte
+}
+
+// NewEncryptor returns an Encryptor for a 32-byte key.
+func NewEncryptor(key []byte) (*Encryptor, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("payload key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("go-tracing-demo/payload stable nonce"))
+	return &Encryptor{aead: aead, nonceKey: mac.Sum(nil)}, nil
+}
+
+// EncryptorFromEnv returns an Encryptor for TRACE_PAYLOAD_KEY, 32 bytes in
+// base64 (openssl rand -base64 32), or nil when it isn't set.
+func EncryptorFromEnv() (*Encryptor, error) {
+	v := os.Getenv("TRACE_PAYLOAD_KEY")
+	if v == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.New("TRACE_PAYLOAD_KEY must be base64")
+	}
+	e, err := NewEncryptor(key)
+	if err != nil {
+		return nil, fmt.Errorf("TRACE_PAYLOAD_KEY: %w", err)
+	}
+	return e, nil
+}
+
+// Encrypt seals plaintext into an enc:v1: value.
+func (e *Encryptor) Encrypt(plaintext string) string {
+	nonce := make([]byte, e.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// EncryptStable seals plaintext like Encrypt, but always into the same
+// value, with a nonce derived from the plaintext. Stored values can then be
+// compared without opening them, at the cost of showing which are equal.
+func (e *Encryptor) EncryptStable(plaintext string) string {
+	mac := hmac.New(sha256.New, e.nonceKey)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:e.aead.NonceSize()]
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// IsEncrypted reports whether v is an enc:v1: value.
+func IsEncrypted(v string) bool {
+	return strings.HasPrefix(v, encryptedPrefix)
+}
+
+// Decrypt opens one enc:v1: value.
+func (e *Encryptor) Decrypt(value string) (string, error) {
+	data, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return "", errors.New("not an encrypted payload")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return "", errors.New("malformed encrypted payload")
+	}
+	n := e.aead.NonceSize()
+	plaintext, err := e.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", errors.New("can't decrypt payload: wrong key or corrupted value")
+	}
+	return string(plaintext), nil
+}
+
+// DecryptAll replaces every enc:v1: value in text with its plaintext.
+// Values this key can't open are left as they are and counted in failed.
+func (e *Encryptor) DecryptAll(text string) (out string, failed int) {
+	out = encryptedValue.ReplaceAllStringFunc(text, func(v string) string {
+		plaintext, err := e.Decrypt(v)
+		if err != nil {
+			failed++
+			return v
+		}
+		return plaintext
+	})
+	return out, failed
+}
+
+// WrapExporter returns exporter with payload attributes (see IsPayload)
+// encrypted on spans and their events. A nil Encryptor returns exporter as
+// is.
+func (e *Encryptor) WrapExporter(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	if e == nil {
+		return exporter
+	}
+	return encryptingExporter{SpanExporter: exporter, enc: e}
+}
+
+type encryptingExporter struct {
+	sdktrace.SpanExporter
+	enc *Encryptor
+}
+
+func (x encryptingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	sealed := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		sealed[i] = encryptedSpan{ReadOnlySpan: s, enc: x.enc}
+	}
+	return x.SpanExporter.ExportSpans(ctx, sealed)
+}
+
+// encryptedSpan reports a span with its payload attributes encrypted.
+type encryptedSpan struct {
+	sdktrace.ReadOnlySpan
+	enc *Encryptor
+}
+
+func (s encryptedSpan) seal(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, len(attrs))
+	for i, kv := range attrs {
+		if kv.Value.Type() == attribute.STRING && IsPayload(string(kv.Key)) {
+			kv = attribute.String(string(kv.Key), s.enc.Encrypt(kv.Value.AsString()))
+		}
+		out[i] = kv
+	}
+	return out
+}
+
+func (s encryptedSpan) Attributes() []attribute.KeyValue {
+	return s.seal(s.ReadOnlySpan.Attributes())
+}
+
+func (s encryptedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		e.Attributes = s.seal(e.Attributes)
+		out[i] = e
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package payload
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncryptorRoundTrip(t *testing.T) {
+	enc, err := NewEncryptor(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{"", "hello", `{"id":"AR-1","resource":"snowflake_prod"}`} {
+		for name, sealed := range map[string]string{"Encrypt": enc.Encrypt(plaintext), "EncryptStable": enc.EncryptStable(plaintext)} {
+			if !IsEncrypted(sealed) || strings.Contains(sealed, plaintext) && plaintext != "" {
+				t.Errorf("%s(%q) = %q, want an opaque enc:v1: value", name, plaintext, sealed)
+			}
+			if got, err := enc.Decrypt(sealed); err != nil || got != plaintext {
+				t.Errorf("Decrypt(%s(%q)) = %q, %v", name, plaintext, got, err)
+			}
+		}
+	}
+	if enc.Encrypt("hello") == enc.Encrypt("hello") {
+		t.Error("Encrypt reused a nonce")
+	}
+	if enc.EncryptStable("hello") != enc.EncryptStable("hello") {
+		t.Error("EncryptStable isn't stable")
+	}
+
+	text := "a " + enc.Encrypt("one") + " b " + enc.Encrypt("two")
+	if got, failed := enc.DecryptAll(text); got != "a one b two" || failed != 0 {
+		t.Errorf("DecryptAll() = %q, %d failed", got, failed)
+	}
+}
+
+func TestEncryptorWrongKey(t *testing.T) {
+	enc, _ := NewEncryptor(bytes.Repeat([]byte{1}, 32))
+	other, _ := NewEncryptor(bytes.Repeat([]byte{2}, 32))
+	sealed := enc.Encrypt("hello")
+
+	if got, err := other.Decrypt(sealed); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("Decrypt() with another key = %q, %v, want a wrong key error", got, err)
+	}
+	if got, failed := other.DecryptAll("a " + sealed); got != "a "+sealed || failed != 1 {
+		t.Errorf("DecryptAll() with another key = %q, %d failed, want the value left as is", got, failed)
+	}
+	for _, v := range []string{"hello", encryptedPrefix + "!!!", encryptedPrefix + "AAAA"} {
+		if _, err := enc.Decrypt(v); err == nil {
+			t.Errorf("Decrypt(%q) succeeded", v)
+		}
+	}
+	if _, err := NewEncryptor(make([]byte, 16)); err == nil {
+		t.Error("NewEncryptor() accepted a 16-byte key")
+	}
+}
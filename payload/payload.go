@@ -0,0 +1,166 @@
+// Package payload attaches large span payloads (prompts, completions, ticket
+// JSON) with as little copying as possible, and can move payloads above a
+// size threshold out of plain attributes.
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Mode controls how payloads above the threshold are attached.
+type Mode string
+
+const (
+	// ModeAttribute sets the payload as a plain string attribute.
+	ModeAttribute Mode = "attribute"
+	// ModeEvent attaches the payload to a span event named after the key
+	// (gen_ai.prompt becomes a gen_ai.content.prompt event).
+	ModeEvent Mode = "event"
+	// ModeCompressed sets a single gzip+base64 attribute, <key>.gzip_b64.
+	ModeCompressed Mode = "compressed"
+)
+
+// DefaultThreshold is the payload size in bytes above which Mode applies.
+const DefaultThreshold = 16 << 10
+
+// maxPooled keeps unusually large buffers from being held by the pool.
+const maxPooled = 1 << 20
+
+// Options say how to attach payloads. The zero value sets every payload as
+// a plain attribute.
+type Options struct {
+	Mode      Mode
+	Threshold int
+}
+
+// FromEnv reads TRACE_PAYLOAD_MODE (default attribute) and
+// TRACE_PAYLOAD_THRESHOLD (bytes, default 16384).
+func FromEnv() (Options, error) {
+	o := Options{Mode: ModeAttribute, Threshold: DefaultThreshold}
+	switch m := Mode(os.Getenv("TRACE_PAYLOAD_MODE")); m {
+	case "":
+	case ModeAttribute, ModeEvent, ModeCompressed:
+		o.Mode = m
+	default:
+		return o, fmt.Errorf("TRACE_PAYLOAD_MODE must be attribute, event or compressed, got %q", m)
+	}
+	if v := os.Getenv("TRACE_PAYLOAD_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("TRACE_PAYLOAD_THRESHOLD must be a byte count, got %q", v)
+		}
+		o.Threshold = n
+	}
+	return o, nil
+}
+
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// jsonEncoder is pooled with its buffer so the encoder's own indent buffer
+// is reused too.
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var encoders = sync.Pool{New: func() any {
+	e := &jsonEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetIndent("", "  ")
+	return e
+}}
+
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooled {
+		buffers.Put(buf)
+	}
+}
+
+// String attaches value under key. Nothing is done for spans that are not
+// recording.
+func (o Options) String(span trace.Span, key, value string) {
+	if !span.IsRecording() {
+		return
+	}
+	if o.Mode == "" || o.Mode == ModeAttribute || len(value) <= o.Threshold {
+		span.SetAttributes(attribute.String(key, value))
+		return
+	}
+	switch o.Mode {
+	case ModeEvent:
+		span.AddEvent(eventName(key), trace.WithAttributes(attribute.String(key, value)))
+	case ModeCompressed:
+		span.SetAttributes(
+			attribute.String(key+".gzip_b64", compress([]byte(value))),
+			attribute.Int(key+".size", len(value)),
+		)
+	}
+}
+
+// JSON attaches v as indented JSON under key. The JSON is encoded with a
+// pooled encoder and copied once, into the attribute string. v is not
+// encoded at all for spans that are not recording.
+func (o Options) JSON(span trace.Span, key string, v any) error {
+	if !span.IsRecording() {
+		return nil
+	}
+	e := encoders.Get().(*jsonEncoder)
+	e.buf.Reset()
+	defer func() {
+		if e.buf.Cap() <= maxPooled {
+			encoders.Put(e)
+		}
+	}()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	data := bytes.TrimSuffix(e.buf.Bytes(), []byte("\n"))
+	if o.Mode == ModeCompressed && len(data) > o.Threshold {
+		span.SetAttributes(
+			attribute.String(key+".gzip_b64", compress(data)),
+			attribute.Int(key+".size", len(data)),
+		)
+		return nil
+	}
+	o.String(span, key, string(data))
+	return nil
+}
+
+// eventName follows the GenAI convention of gen_ai.content.<kind> events
+// for gen_ai.<kind> payloads; other keys name their own event.
+func eventName(key string) string {
+	if kind, ok := strings.CutPrefix(key, "gen_ai."); ok {
+		return "gen_ai.content." + kind
+	}
+	return key
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+func compress(data []byte) string {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(buf)
+	zw.Write(data)
+	zw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,77 @@
+package payload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type benchTicket struct {
+	ID            string `json:"id"`
+	Resource      string `json:"resource"`
+	AccessLevel   string `json:"access_level"`
+	Justification string `json:"business_justification"`
+	Actions       string `json:"recommended_actions"`
+}
+
+var ticket = benchTicket{
+	ID:            "AR-1A2B3C4D",
+	Resource:      "snowflake_prod",
+	AccessLevel:   "read",
+	Justification: strings.Repeat("Debugging the revenue dashboard for finance. ", 20),
+	Actions:       "collect justification; confirm duration; route for approval; provision access; log audit",
+}
+
+// BenchmarkTicketJSON compares the pooled encoder with the
+// json.MarshalIndent + string conversion it replaces.
+func BenchmarkTicketJSON(b *testing.B) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	tracer := tp.Tracer("bench")
+	ctx := context.Background()
+
+	b.Run("MarshalIndent", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			_, span := tracer.Start(ctx, "turn")
+			data, _ := json.MarshalIndent(ticket, "", "  ")
+			span.SetAttributes(attribute.String("itsm.ticket_draft_json", string(data)))
+			span.End()
+		}
+	})
+	b.Run("Options.JSON", func(b *testing.B) {
+		b.ReportAllocs()
+		var o Options
+		for range b.N {
+			_, span := tracer.Start(ctx, "turn")
+			o.JSON(span, "itsm.ticket_draft_json", ticket)
+			span.End()
+		}
+	})
+}
+
+// BenchmarkLargeCompletion compares the modes for a completion above the
+// threshold.
+func BenchmarkLargeCompletion(b *testing.B) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	tracer := tp.Tracer("bench")
+	ctx := context.Background()
+	completion := strings.Repeat("Here is your ticket draft. ", 4<<10)
+
+	for _, mode := range []Mode{ModeAttribute, ModeEvent, ModeCompressed} {
+		o := Options{Mode: mode, Threshold: DefaultThreshold}
+		b.Run(fmt.Sprintf("mode=%s", mode), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				_, span := tracer.Start(ctx, "turn")
+				o.String(span, "gen_ai.completion", completion)
+				span.End()
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package payload
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Verbosity says whether spans carry payloads or only metadata.
+type Verbosity string
+
+const (
+	// VerbosityFull captures prompts, completions and ticket JSON.
+	VerbosityFull Verbosity = "full"
+	// VerbosityMetadata strips payloads, keeping names, timings, token
+	// counts and the rest of the metadata.
+	VerbosityMetadata Verbosity = "metadata"
+)
+
+// metadataOnly is the process-wide verbosity, so it can be changed while
+// the bots run.
+var metadataOnly atomic.Bool
+
+// ParseVerbosity parses full or metadata.
+func ParseVerbosity(s string) (Verbosity, error) {
+	switch v := Verbosity(s); v {
+	case VerbosityFull, VerbosityMetadata:
+		return v, nil
+	default:
+		return "", fmt.Errorf("trace verbosity must be full or metadata, got %q", s)
+	}
+}
+
+// VerbosityFromEnv sets the verbosity from TRACE_VERBOSITY (default full).
+func VerbosityFromEnv() error {
+	v := os.Getenv("TRACE_VERBOSITY")
+	if v == "" {
+		v = string(VerbosityFull)
+	}
+	parsed, err := ParseVerbosity(v)
+	if err != nil {
+		return fmt.Errorf("TRACE_VERBOSITY: %w", err)
+	}
+	SetVerbosity(parsed)
+	return nil
+}
+
+// CurrentVerbosity returns the verbosity spans are exported with.
+func CurrentVerbosity() Verbosity {
+	if metadataOnly.Load() {
+		return VerbosityMetadata
+	}
+	return VerbosityFull
+}
+
+// SetVerbosity changes the verbosity. It applies to every span that ends
+// from then on, including spans already open.
+func SetVerbosity(v Verbosity) {
+	metadataOnly.Store(v == VerbosityMetadata)
+}
+
+// ToggleOn flips the verbosity between full and metadata each time the
+// process receives sig, typically SIGHUP, until stop is called.
+func ToggleOn(sig os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				v := VerbosityMetadata
+				if CurrentVerbosity() == VerbosityMetadata {
+					v = VerbosityFull
+				}
+				SetVerbosity(v)
+				log.Printf("Trace verbosity is now %s", v)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// IsPayload reports whether an attribute or event name holds a payload:
+// gen_ai prompts, completions and content events, ticket JSON and
+// compressed payloads.
+func IsPayload(key string) bool {
+	for _, prefix := range []string{"gen_ai.prompt", "gen_ai.completion", "gen_ai.content."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "_json") || strings.HasSuffix(key, ".gzip_b64")
+}
+
+// StripWhenMetadata wraps next, the processor that exports, so spans
+// ending while the verbosity is metadata go out without payload attributes
+// and events.
+func StripWhenMetadata(next sdktrace.SpanProcessor) sdktrace.SpanProcessor {
+	return stripProcessor{next}
+}
+
+type stripProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+func (p stripProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if metadataOnly.Load() {
+		s = strippedSpan{s}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// strippedSpan reports a span without its payload attributes and events.
+type strippedSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s strippedSpan) Attributes() []attribute.KeyValue {
+	var kept []attribute.KeyValue
+	for _, kv := range s.ReadOnlySpan.Attributes() {
+		if !IsPayload(string(kv.Key)) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+func (s strippedSpan) Events() []sdktrace.Event {
+	var kept []sdktrace.Event
+	for _, e := range s.ReadOnlySpan.Events() {
+		if !IsPayload(e.Name) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
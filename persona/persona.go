@@ -0,0 +1,182 @@
+// Package persona defines the bots as data: each persona bundles its system
+// prompts, tools, extraction schema and tracing metadata so new bots can be
+// added through config instead of code.
+package persona
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//go:embed personas.json
+var builtinPersonas []byte
+
+// Persona describes one bot.
+type Persona struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// DisplayName prefixes the bot's replies in the terminal.
+	DisplayName string `json:"display_name"`
+	// TraceName and SpanName name the per-turn span in LangSmith. Both may
+	// be templates; see TurnNames.
+	TraceName string `json:"trace_name"`
+	SpanName  string `json:"span_name"`
+	// SystemPrompts is keyed by locale; "en" is the fallback.
+	SystemPrompts map[string]string `json:"system_prompts"`
+	// Tools lists the tool names the persona may call.
+	Tools []string `json:"tools"`
+	// Extraction names the structured object drafted from the conversation
+	// (e.g. "access_request"); empty means none.
+	Extraction string `json:"extraction"`
+	// Metadata is added to every turn span as langsmith.metadata.<key>.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// SystemPrompt returns the prompt for locale, falling back to English.
+func (p *Persona) SystemPrompt(locale string) string {
+	if prompt, ok := p.SystemPrompts[locale]; ok {
+		return prompt
+	}
+	return p.SystemPrompts["en"]
+}
+
+// NameVars fill the placeholders of trace and span name templates.
+type NameVars struct {
+	SessionID string
+	TurnIndex int
+	Prompt    string
+}
+
+// promptChars is how much of the prompt {prompt} puts in a name.
+const promptChars = 40
+
+// TurnNames returns the trace and span names of a turn. TraceName and
+// SpanName may contain {persona}, {session_id}, {turn_index} and {prompt},
+// the first 40 characters of the user's message on one line.
+func (p *Persona) TurnNames(v NameVars) (traceName, spanName string) {
+	// Names without placeholders, the usual case, cost nothing
+	if !strings.Contains(p.TraceName, "{") && !strings.Contains(p.SpanName, "{") {
+		return p.TraceName, p.SpanName
+	}
+	prompt := strings.Join(strings.Fields(v.Prompt), " ")
+	if utf8.RuneCountInString(prompt) > promptChars {
+		prompt = string([]rune(prompt)[:promptChars]) + "…"
+	}
+	r := strings.NewReplacer(
+		"{persona}", p.Name,
+		"{session_id}", v.SessionID,
+		"{turn_index}", strconv.Itoa(v.TurnIndex),
+		"{prompt}", prompt,
+	)
+	return r.Replace(p.TraceName), r.Replace(p.SpanName)
+}
+
+// Attributes returns the persona name and metadata tags as span attributes.
+func (p *Persona) Attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("langsmith.metadata.persona", p.Name),
+	}
+	keys := make([]string, 0, len(p.Metadata))
+	for k := range p.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, attribute.String("langsmith.metadata."+k, p.Metadata[k]))
+	}
+	return attrs
+}
+
+// Registry holds the available personas in definition order.
+type Registry struct {
+	personas []*Persona
+}
+
+// Load returns the built-in personas, extended or overridden (by name) by
+// the personas in path when it is not empty. TRACE_NAME_TEMPLATE and
+// SPAN_NAME_TEMPLATE, when set, name the turns of every persona.
+func Load(path string) (*Registry, error) {
+	r := &Registry{}
+	if err := r.add(builtinPersonas); err != nil {
+		return nil, fmt.Errorf("built-in personas: %w", err)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading personas: %w", err)
+		}
+		if err := r.add(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	traceName, spanName := os.Getenv("TRACE_NAME_TEMPLATE"), os.Getenv("SPAN_NAME_TEMPLATE")
+	for _, p := range r.personas {
+		if traceName != "" {
+			p.TraceName = traceName
+		}
+		if spanName != "" {
+			p.SpanName = spanName
+		}
+	}
+	return r, nil
+}
+
+func (r *Registry) add(data []byte) error {
+	var personas []*Persona
+	if err := json.Unmarshal(data, &personas); err != nil {
+		return fmt.Errorf("parsing personas: %w", err)
+	}
+
+	for _, p := range personas {
+		if p.Name == "" {
+			return fmt.Errorf("persona without a name")
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if p.TraceName == "" {
+			p.TraceName = "go-bot-" + p.Name
+		}
+		if p.SpanName == "" {
+			p.SpanName = p.Name + "_turn"
+		}
+
+		if i := r.index(p.Name); i >= 0 {
+			r.personas[i] = p
+		} else {
+			r.personas = append(r.personas, p)
+		}
+	}
+	return nil
+}
+
+func (r *Registry) index(name string) int {
+	for i, p := range r.personas {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the persona called name.
+func (r *Registry) Get(name string) (*Persona, error) {
+	if i := r.index(name); i >= 0 {
+		return r.personas[i], nil
+	}
+	return nil, fmt.Errorf("unknown persona %q", name)
+}
+
+// All returns every persona in definition order.
+func (r *Registry) All() []*Persona {
+	return r.personas
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/boterr"
+)
+
+// DefaultMaxQueued is how many model calls may wait for a Pool slot.
+const DefaultMaxQueued = 256
+
+// ErrQueueFull is returned for a model call that arrives while a Pool's
+// queue is full.
+var ErrQueueFull = errors.New("too many model calls queued")
+
+// Pool bounds the model calls in flight across every client it is added
+// to, so a burst of turns queues instead of exhausting memory or the
+// provider's quotas. Waiting calls are let through round-robin by session,
+// so one busy session can't starve the others.
+type Pool struct {
+	// MaxInFlight is how many calls may be sent at once.
+	MaxInFlight int
+	// MaxQueued is how many calls may wait; calls beyond it fail with
+	// ErrQueueFull.
+	MaxQueued int
+
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+	// queues holds each session's waiting calls in arrival order, and
+	// sessions the sessions with waiting calls in the order they are
+	// served.
+	queues   map[string][]*waiter
+	sessions []string
+}
+
+type waiter struct {
+	ready chan struct{}
+	// granted is set, under the pool's lock, once the call holds a slot
+	granted bool
+}
+
+// PoolFromEnv returns a Pool configured by MODEL_MAX_IN_FLIGHT and
+// MODEL_MAX_QUEUED (default 256), or nil when MODEL_MAX_IN_FLIGHT isn't
+// set.
+func PoolFromEnv() (*Pool, error) {
+	v := os.Getenv("MODEL_MAX_IN_FLIGHT")
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("MODEL_MAX_IN_FLIGHT must be a positive number of calls, got %q", v)
+	}
+	p := NewPool(n)
+	if v := os.Getenv("MODEL_MAX_QUEUED"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("MODEL_MAX_QUEUED must be a number of calls, got %q", v)
+		}
+		p.MaxQueued = n
+	}
+	return p, nil
+}
+
+// NewPool returns a Pool sending up to maxInFlight calls at once, with the
+// default queue size.
+func NewPool(maxInFlight int) *Pool {
+	return &Pool{MaxInFlight: maxInFlight, MaxQueued: DefaultMaxQueued, queues: map[string][]*waiter{}}
+}
+
+type sessionKey struct{}
+
+// WithSession marks ctx as serving session, whose model calls the Pool
+// queues together. Calls without a session share one queue.
+func WithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// Option returns the request option that puts the pool in front of a
+// client's requests. A nil Pool adds nothing.
+func (p *Pool) Option() option.RequestOption {
+	if p == nil {
+		return option.WithMiddleware()
+	}
+	return option.WithMiddleware(p.Middleware)
+}
+
+// Middleware waits for a slot in the pool and sends req. The slot is held
+// until the response headers arrive, so a streamed response doesn't keep it
+// while its body is read.
+func (p *Pool) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if err := p.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	defer p.release()
+	return next(req)
+}
+
+// RegisterMetrics reports the calls in flight and queued as gauges.
+func (p *Pool) RegisterMetrics(meter metric.Meter) error {
+	inFlight, err := meter.Int64ObservableGauge("anthropic.pool.in_flight",
+		metric.WithDescription("Model calls being sent"))
+	if err != nil {
+		return err
+	}
+	queued, err := meter.Int64ObservableGauge("anthropic.pool.queued",
+		metric.WithDescription("Model calls waiting for a slot"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		o.ObserveInt64(inFlight, int64(p.inFlight))
+		o.ObserveInt64(queued, int64(p.queued))
+		return nil
+	}, inFlight, queued)
+	return err
+}
+
+// acquire blocks until a call may be sent. A wait is traced as a
+// generation_queue_wait span under the caller's span.
+func (p *Pool) acquire(ctx context.Context) error {
+	session, _ := ctx.Value(sessionKey{}).(string)
+	p.mu.Lock()
+	if p.inFlight < p.MaxInFlight && p.queued == 0 {
+		p.inFlight++
+		p.mu.Unlock()
+		return nil
+	}
+	if p.queued >= p.MaxQueued {
+		p.mu.Unlock()
+		return boterr.New(boterr.ErrProviderUnavailable, "", fmt.Errorf("%w: the queue holds %d", ErrQueueFull, p.MaxQueued))
+	}
+	w := &waiter{ready: make(chan struct{})}
+	if len(p.queues[session]) == 0 {
+		p.sessions = append(p.sessions, session)
+	}
+	p.queues[session] = append(p.queues[session], w)
+	p.queued++
+	depth := p.queued
+	p.mu.Unlock()
+
+	start := time.Now()
+	_, span := otel.Tracer("go-tracing-demo/ratelimit").Start(ctx, "generation_queue_wait",
+		trace.WithAttributes(attribute.Int("anthropic.pool.queue_depth", depth)))
+	defer span.End()
+	select {
+	case <-w.ready:
+		span.SetAttributes(attribute.Int64("anthropic.pool.queue_wait_ms", time.Since(start).Milliseconds()))
+		return nil
+	case <-ctx.Done():
+	}
+	span.SetAttributes(attribute.Int64("anthropic.pool.queue_wait_ms", time.Since(start).Milliseconds()))
+	span.RecordError(ctx.Err())
+	p.mu.Lock()
+	if w.granted {
+		// Granted as ctx ended: hand the slot on
+		p.mu.Unlock()
+		p.release()
+		return ctx.Err()
+	}
+	p.remove(session, w)
+	p.mu.Unlock()
+	return ctx.Err()
+}
+
+// release frees a slot and grants it to the next session in turn.
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	for p.inFlight < p.MaxInFlight && len(p.sessions) > 0 {
+		session := p.sessions[0]
+		p.sessions = p.sessions[1:]
+		q := p.queues[session]
+		w := q[0]
+		if len(q) > 1 {
+			p.queues[session] = q[1:]
+			// Back of the line until every other session had a turn
+			p.sessions = append(p.sessions, session)
+		} else {
+			delete(p.queues, session)
+		}
+		p.queued--
+		p.inFlight++
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// remove takes a waiter that gave up out of its session's queue.
+func (p *Pool) remove(session string, w *waiter) {
+	q := p.queues[session]
+	for i, qw := range q {
+		if qw == w {
+			q = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+	p.queued--
+	if len(q) > 0 {
+		p.queues[session] = q
+		return
+	}
+	delete(p.queues, session)
+	for i, s := range p.sessions {
+		if s == session {
+			p.sessions = append(p.sessions[:i:i], p.sessions[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package ratelimit keeps concurrent sessions sharing one Anthropic API key
+// under its rate limits. It reads the anthropic-ratelimit-* headers of every
+// response and holds back requests while the key is out of requests or
+// tokens, instead of letting them fail with 429.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for FromEnv.
+const (
+	DefaultTokenHeadroom = 4000
+	DefaultMaxWait       = time.Minute
+)
+
+// Scheduler delays requests that would exceed the rate limits last reported
+// by the API. Share one Scheduler between all clients using the same key.
+type Scheduler struct {
+	// TokenHeadroom is how many tokens must remain before a request is
+	// sent; below it requests wait for the token limit to reset.
+	TokenHeadroom int64
+	// MaxWait caps how long a request is held back. A request that would
+	// have to wait longer is sent anyway and left to the SDK's retries.
+	MaxWait time.Duration
+
+	mu sync.Mutex
+	// Unknown (-1) until the first response reports them
+	requestsRemaining int64
+	tokensRemaining   int64
+	requestsReset     time.Time
+	tokensReset       time.Time
+	// blockedUntil is set from retry-after on 429 responses
+	blockedUntil time.Time
+	inFlight     int64
+}
+
+// FromEnv returns a Scheduler configured by RATELIMIT_TOKEN_HEADROOM
+// (default 4000) and RATELIMIT_MAX_WAIT (default 1m), or nil when
+// RATELIMIT_SCHEDULER is off.
+func FromEnv() (*Scheduler, error) {
+	if on, err := strconv.ParseBool(os.Getenv("RATELIMIT_SCHEDULER")); err == nil && !on {
+		return nil, nil
+	}
+	s := New()
+	if v := os.Getenv("RATELIMIT_TOKEN_HEADROOM"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("RATELIMIT_TOKEN_HEADROOM must be a token count, got %q", v)
+		}
+		s.TokenHeadroom = n
+	}
+	if v := os.Getenv("RATELIMIT_MAX_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("RATELIMIT_MAX_WAIT must be a duration, got %q", v)
+		}
+		s.MaxWait = d
+	}
+	return s, nil
+}
+
+// New returns a Scheduler with the default headroom and maximum wait.
+func New() *Scheduler {
+	return &Scheduler{
+		TokenHeadroom:     DefaultTokenHeadroom,
+		MaxWait:           DefaultMaxWait,
+		requestsRemaining: -1,
+		tokensRemaining:   -1,
+	}
+}
+
+// Option returns the request option that puts the scheduler in front of a
+// client's requests. A nil Scheduler adds nothing.
+func (s *Scheduler) Option() option.RequestOption {
+	if s == nil {
+		return option.WithMiddleware()
+	}
+	return option.WithMiddleware(s.Middleware)
+}
+
+// Middleware waits for a slot under the rate limits, sends req and records
+// the limits its response reports. It runs once per attempt, so the SDK's
+// retries are scheduled too.
+func (s *Scheduler) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if err := s.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := next(req)
+	s.release(resp)
+	return resp, err
+}
+
+// acquire blocks until a request may be sent. A wait is traced as a
+// ratelimit_wait span under the caller's span.
+func (s *Scheduler) acquire(ctx context.Context) error {
+	var span trace.Span
+	start := time.Now()
+	for {
+		wait, reason := s.reserve(time.Now())
+		if wait == 0 || time.Since(start)+wait > s.MaxWait {
+			if span != nil {
+				span.SetAttributes(
+					attribute.Int64("anthropic.ratelimit.queue_wait_ms", time.Since(start).Milliseconds()),
+					attribute.Bool("anthropic.ratelimit.gave_up", wait != 0),
+				)
+				span.End()
+			}
+			if wait != 0 {
+				// Over MaxWait: send now and count it like any other request
+				s.mu.Lock()
+				s.inFlight++
+				s.mu.Unlock()
+			}
+			return nil
+		}
+		if span == nil {
+			_, span = otel.Tracer("go-tracing-demo/ratelimit").Start(ctx, "ratelimit_wait",
+				trace.WithAttributes(attribute.String("anthropic.ratelimit.reason", reason)))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			span.SetAttributes(attribute.Int64("anthropic.ratelimit.queue_wait_ms", time.Since(start).Milliseconds()))
+			span.RecordError(ctx.Err())
+			span.End()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserve takes a slot and returns zero, or returns how long to wait for
+// one and why.
+func (s *Scheduler) reserve(now time.Time) (time.Duration, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Before(s.blockedUntil) {
+		return s.blockedUntil.Sub(now), "retry_after"
+	}
+	// Requests in flight will use up what the last response reported
+	if s.requestsRemaining >= 0 && s.requestsRemaining-s.inFlight <= 0 && now.Before(s.requestsReset) {
+		return s.requestsReset.Sub(now), "requests"
+	}
+	if s.tokensRemaining >= 0 && s.tokensRemaining < s.TokenHeadroom && now.Before(s.tokensReset) {
+		return s.tokensReset.Sub(now), "tokens"
+	}
+	s.inFlight++
+	return 0, ""
+}
+
+// release frees the request's slot and records the limits in resp.
+func (s *Scheduler) release(resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if resp == nil {
+		return
+	}
+	h := resp.Header
+	if n, err := strconv.ParseInt(h.Get("anthropic-ratelimit-requests-remaining"), 10, 64); err == nil {
+		s.requestsRemaining = n
+		s.requestsReset, _ = time.Parse(time.RFC3339, h.Get("anthropic-ratelimit-requests-reset"))
+	}
+	if n, err := strconv.ParseInt(h.Get("anthropic-ratelimit-tokens-remaining"), 10, 64); err == nil {
+		s.tokensRemaining = n
+		s.tokensReset, _ = time.Parse(time.RFC3339, h.Get("anthropic-ratelimit-tokens-reset"))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if secs, err := strconv.ParseFloat(h.Get("retry-after"), 64); err == nil {
+			s.blockedUntil = time.Now().Add(time.Duration(secs * float64(time.Second)))
+		}
+	}
+}
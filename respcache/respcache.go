@@ -0,0 +1,144 @@
+// Package respcache replays Anthropic responses from disk for requests it
+// has seen before, so re-running a demo script doesn't spend tokens again.
+// Requests are matched exactly: the key is a hash of the whole request body,
+// which holds the model, system prompt and messages.
+package respcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultDir is where FromEnv keeps responses unless RESPONSE_CACHE_DIR is
+// set.
+const DefaultDir = ".cache/responses"
+
+// Cache stores one file per response in Dir.
+type Cache struct {
+	Dir string
+}
+
+// Open returns a Cache in dir, creating it if needed.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating response cache: %w", err)
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// FromEnv opens the cache in RESPONSE_CACHE_DIR (default DefaultDir) when
+// enabled is set, as by a --cache flag, and returns nil otherwise.
+func FromEnv(enabled bool) (*Cache, error) {
+	if !enabled {
+		return nil, nil
+	}
+	dir := os.Getenv("RESPONSE_CACHE_DIR")
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return Open(dir)
+}
+
+// Option returns the request option that serves a client's requests from
+// the cache. A nil Cache adds nothing.
+func (c *Cache) Option() option.RequestOption {
+	if c == nil {
+		return option.WithMiddleware()
+	}
+	return option.WithMiddleware(c.Middleware)
+}
+
+// Middleware answers Messages requests from the cache, or sends them and
+// caches successful responses. Other and streaming requests pass through.
+func (c *Cache) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/v1/messages") || req.Body == nil {
+		return next(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	var params struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil || params.Stream {
+		return next(req)
+	}
+
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+	path := filepath.Join(c.Dir, key+".json")
+	if cached, err := os.ReadFile(path); err == nil {
+		return c.hit(req, key, params.Model, cached)
+	}
+
+	resp, err := next(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Printf("Caching response: %v", err)
+	}
+	return resp, nil
+}
+
+// hit answers req with a cached response body. Its usage is zeroed, since
+// no tokens were spent, and the hit is traced as a response_cache span.
+func (c *Cache) hit(req *http.Request, key, model string, cached []byte) (*http.Response, error) {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(cached, &msg); err != nil {
+		return nil, fmt.Errorf("reading cached response %s: %w", key, err)
+	}
+	msg["usage"] = json.RawMessage(`{"input_tokens":0,"output_tokens":0}`)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := otel.Tracer("go-tracing-demo/respcache").Start(req.Context(), "response_cache", trace.WithAttributes(
+		attribute.String("langsmith.span.kind", "llm"),
+		attribute.String("gen_ai.system", "anthropic"),
+		attribute.String("gen_ai.request.model", model),
+		attribute.Bool("cache.hit", true),
+		attribute.String("cache.key", key),
+		attribute.Int("gen_ai.usage.input_tokens", 0),
+		attribute.Int("gen_ai.usage.output_tokens", 0),
+		attribute.Float64("langsmith.metadata.cost_usd", 0),
+	))
+	span.End()
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
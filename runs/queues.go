@@ -0,0 +1,58 @@
+package runs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Queue is a LangSmith annotation queue: a list of runs waiting for a human
+// reviewer to label them.
+type Queue struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// FindQueue returns the annotation queue called name, or nil if there is
+// none.
+func (c *Client) FindQueue(ctx context.Context, name string) (*Queue, error) {
+	var queues []Queue
+	if err := c.Do(ctx, http.MethodGet, "/annotation-queues?name="+url.QueryEscape(name), nil, &queues); err != nil {
+		return nil, fmt.Errorf("looking up annotation queue %s: %w", name, err)
+	}
+	// The name parameter matches substrings, so check for an exact match
+	for _, q := range queues {
+		if q.Name == name {
+			return &q, nil
+		}
+	}
+	return nil, nil
+}
+
+// EnsureQueue returns the annotation queue called name, creating it with
+// description if it does not exist yet.
+func (c *Client) EnsureQueue(ctx context.Context, name, description string) (*Queue, error) {
+	q, err := c.FindQueue(ctx, name)
+	if err != nil || q != nil {
+		return q, err
+	}
+	q = &Queue{}
+	body := map[string]any{"name": name, "description": description}
+	if err := c.Do(ctx, http.MethodPost, "/annotation-queues", body, q); err != nil {
+		return nil, fmt.Errorf("creating annotation queue %s: %w", name, err)
+	}
+	return q, nil
+}
+
+// AddToQueue adds runs to the annotation queue with ID queueID.
+func (c *Client) AddToQueue(ctx context.Context, queueID string, runIDs []string) error {
+	if len(runIDs) == 0 {
+		return nil
+	}
+	if err := c.Do(ctx, http.MethodPost, "/annotation-queues/"+queueID+"/runs", runIDs, nil); err != nil {
+		return fmt.Errorf("adding runs to annotation queue: %w", err)
+	}
+	return nil
+}
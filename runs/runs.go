@@ -0,0 +1,212 @@
+// Package runs reads traced runs back from the LangSmith API, so the
+// traces the bots produce can be evaluated and curated.
+package runs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Run is the subset of a LangSmith run the evaluators use.
+type Run struct {
+	ID        string         `json:"id"`
+	TraceID   string         `json:"trace_id"`
+	Name      string         `json:"name"`
+	RunType   string         `json:"run_type"`
+	StartTime string         `json:"start_time"`
+	EndTime   string         `json:"end_time"`
+	Inputs    map[string]any `json:"inputs"`
+	Outputs   map[string]any `json:"outputs"`
+	Error     string         `json:"error"`
+	// ReferenceExampleID is the dataset example an experiment run answered.
+	ReferenceExampleID string `json:"reference_example_id"`
+	Extra              struct {
+		Metadata map[string]any `json:"metadata"`
+	} `json:"extra"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	// FeedbackStats maps feedback keys to their aggregate, e.g.
+	// {"helpfulness": {"n": 1, "avg": 0.8}}.
+	FeedbackStats map[string]struct {
+		N   int     `json:"n"`
+		Avg float64 `json:"avg"`
+	} `json:"feedback_stats"`
+}
+
+// Latency is the run's wall-clock duration, or zero if it has not ended.
+func (r Run) Latency() time.Duration {
+	start, err1 := ParseTime(r.StartTime)
+	end, err2 := ParseTime(r.EndTime)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// ParseTime parses a run time. LangSmith gives times in UTC, with or
+// without the zone.
+func ParseTime(ts string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05.999999999", ts)
+	}
+	return t, err
+}
+
+// Client queries the LangSmith REST API.
+type Client struct {
+	APIKey  string
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// FromEnv uses LANGSMITH_API_KEY and LANGSMITH_ENDPOINT (default
+// https://api.smith.langchain.com).
+func FromEnv() *Client {
+	base := os.Getenv("LANGSMITH_ENDPOINT")
+	if base == "" {
+		base = "https://api.smith.langchain.com"
+	}
+	return &Client{
+		APIKey:  os.Getenv("LANGSMITH_API_KEY"),
+		BaseURL: base,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Query selects runs. Project is a project name; the other fields are
+// optional.
+type Query struct {
+	Project string
+	// Name only returns runs with this name, e.g. "itsm_turn".
+	Name string
+	// Since only returns runs started after this time.
+	Since time.Time
+	// Filter is an extra LangSmith filter expression, e.g.
+	// `has(metadata, '{"escalated": true}')`.
+	Filter string
+	Limit  int
+}
+
+// List returns the root runs matching q, newest first.
+func (c *Client) List(ctx context.Context, q Query) ([]Run, error) {
+	projectID, err := c.ProjectID(ctx, q.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]any{
+		"session": []string{projectID},
+		"is_root": true,
+		"limit":   q.Limit,
+	}
+	if !q.Since.IsZero() {
+		body["start_time"] = q.Since.UTC().Format(time.RFC3339)
+	}
+	var filters []string
+	if q.Name != "" {
+		filters = append(filters, fmt.Sprintf("eq(name, %q)", q.Name))
+	}
+	if q.Filter != "" {
+		filters = append(filters, q.Filter)
+	}
+	switch len(filters) {
+	case 1:
+		body["filter"] = filters[0]
+	case 2:
+		body["filter"] = fmt.Sprintf("and(%s, %s)", filters[0], filters[1])
+	}
+
+	var resp struct {
+		Runs []Run `json:"runs"`
+	}
+	if err := c.Do(ctx, http.MethodPost, "/runs/query", body, &resp); err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	return resp.Runs, nil
+}
+
+// Project is a LangSmith tracing project. Experiments are projects with a
+// reference dataset.
+type Project struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	ReferenceDatasetID string `json:"reference_dataset_id"`
+}
+
+// Project looks up a project (or experiment) by name.
+func (c *Client) Project(ctx context.Context, name string) (*Project, error) {
+	var projects []Project
+	if err := c.Do(ctx, http.MethodGet, "/sessions?name="+url.QueryEscape(name), nil, &projects); err != nil {
+		return nil, fmt.Errorf("looking up project %s: %w", name, err)
+	}
+	if len(projects) == 0 {
+		return nil, fmt.Errorf("project %s not found", name)
+	}
+	return &projects[0], nil
+}
+
+// ProjectID resolves a project name to its ID.
+func (c *Client) ProjectID(ctx context.Context, name string) (string, error) {
+	p, err := c.Project(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return p.ID, nil
+}
+
+// Do sends an authenticated JSON request to path and decodes the response
+// into out (when not nil).
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("LangSmith returned %d: %s", resp.StatusCode, data)
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// DeleteTraces deletes whole traces, by trace ID, from the project with
+// ID projectID.
+func (c *Client) DeleteTraces(ctx context.Context, projectID string, traceIDs []string) error {
+	body := map[string]any{"session_id": projectID, "trace_ids": traceIDs}
+	if err := c.Do(ctx, http.MethodPost, "/runs/delete", body, nil); err != nil {
+		return fmt.Errorf("deleting traces: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package survey asks the user to rate a chat session when it ends, posts
+// the rating to LangSmith as feedback on the session's first turn, and keeps
+// a local copy so satisfaction can be tracked without LangSmith.
+package survey
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go-tracing-demo/feedback"
+)
+
+// FeedbackKey is the LangSmith feedback key ratings are posted under.
+const FeedbackKey = "user_rating"
+
+// DefaultFile is where responses are kept unless SURVEY_FILE is set.
+const DefaultFile = "surveys.jsonl"
+
+// Response is one completed survey.
+type Response struct {
+	SessionID string    `json:"session_id"`
+	Persona   string    `json:"persona"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	Turns     int       `json:"turns"`
+	Rating    int       `json:"rating"`
+	Comment   string    `json:"comment,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Enabled reports whether SESSION_SURVEY turns the survey on.
+func Enabled() bool {
+	on, _ := strconv.ParseBool(os.Getenv("SESSION_SURVEY"))
+	return on
+}
+
+// Run asks the survey on r and w and records the answer for the session
+// whose first turn is root. Errors are logged.
+func Run(ctx context.Context, r *bufio.Reader, w io.Writer, fb *feedback.Client, root trace.SpanContext, resp Response) {
+	rating, comment, ok := Ask(r, w)
+	if !ok {
+		return
+	}
+	resp.Rating, resp.Comment, resp.At = rating, comment, time.Now().UTC()
+	if err := Record(ctx, fb, root, resp); err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Fprintln(w, "Thanks for the feedback!")
+}
+
+// Ask prompts for a 1-5 rating and an optional comment. An empty rating
+// skips the survey, and ok is false.
+func Ask(r *bufio.Reader, w io.Writer) (rating int, comment string, ok bool) {
+	for {
+		fmt.Fprint(w, "\nHow did the bot do, from 1 (poor) to 5 (great)? Press Enter to skip: ")
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return 0, "", false
+		}
+		rating, convErr := strconv.Atoi(line)
+		if convErr == nil && rating >= 1 && rating <= 5 {
+			break
+		}
+		if err != nil {
+			return 0, "", false
+		}
+		fmt.Fprintln(w, "Please enter a number from 1 to 5.")
+	}
+	fmt.Fprint(w, "Anything to add? (optional): ")
+	comment, _ = r.ReadString('\n')
+	return rating, strings.TrimSpace(comment), true
+}
+
+// Record posts resp as feedback on the run for root, when it is valid, and
+// appends it to SURVEY_FILE (default DefaultFile). Both are attempted; the
+// first error is returned.
+func Record(ctx context.Context, fb *feedback.Client, root trace.SpanContext, resp Response) error {
+	var postErr error
+	if root.IsValid() {
+		resp.TraceID = root.TraceID().String()
+		postErr = fb.Post(ctx, root, feedback.Score{
+			Key:     FeedbackKey,
+			Score:   float64(resp.Rating),
+			Comment: resp.Comment,
+			Source:  "app",
+		})
+		if postErr != nil {
+			postErr = fmt.Errorf("posting rating: %w", postErr)
+		}
+	}
+
+	path := os.Getenv("SURVEY_FILE")
+	if path == "" {
+		path = DefaultFile
+	}
+	line, err := json.Marshal(resp)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if postErr != nil {
+		return postErr
+	}
+	if err != nil {
+		return fmt.Errorf("saving rating: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	// Timezone conversion must work in slim containers without zoneinfo
+	_ "time/tzdata"
+)
+
+// Built-in date tools so expiry dates are computed rather than guessed.
+func init() {
+	Register("current_time", currentTime,
+		WithDescription("Get the current date and time, optionally in an IANA timezone such as Europe/Amsterdam."))
+	Register("date_add", dateAdd,
+		WithDescription("Add calendar years, months, days and hours to a date, e.g. to compute when 7 days of access expires."))
+	Register("business_days_add", businessDaysAdd,
+		WithDescription("Add business days (Monday to Friday, skipping listed holidays) to a date."))
+	Register("business_days_between", businessDaysBetween,
+		WithDescription("Count business days (Monday to Friday, skipping listed holidays) from start up to but not including end."))
+	Register("convert_timezone", convertTimezone,
+		WithDescription("Convert a date and time from one IANA timezone to another."))
+}
+
+// dateLayouts are the formats accepted for date inputs, most specific first.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", time.DateOnly}
+
+type dateResult struct {
+	Date    string `json:"date"`
+	Weekday string `json:"weekday"`
+}
+
+func newDateResult(t time.Time) dateResult {
+	return dateResult{Date: t.Format(time.RFC3339), Weekday: t.Weekday().String()}
+}
+
+// parseDate parses value in loc, returning now when value is empty.
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Now().In(loc), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q, use YYYY-MM-DD or RFC 3339", value)
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+type currentTimeInput struct {
+	Timezone string `json:"timezone,omitempty" description:"IANA timezone, default UTC"`
+}
+
+func currentTime(_ context.Context, in currentTimeInput) (dateResult, error) {
+	loc, err := loadLocation(in.Timezone)
+	if err != nil {
+		return dateResult{}, err
+	}
+	return newDateResult(time.Now().In(loc)), nil
+}
+
+type dateAddInput struct {
+	Start    string `json:"start,omitempty" description:"Start date (YYYY-MM-DD or RFC 3339), default now"`
+	Timezone string `json:"timezone,omitempty" description:"IANA timezone of the start date, default UTC"`
+	Years    int    `json:"years,omitempty"`
+	Months   int    `json:"months,omitempty"`
+	Days     int    `json:"days,omitempty"`
+	Hours    int    `json:"hours,omitempty"`
+}
+
+func dateAdd(_ context.Context, in dateAddInput) (dateResult, error) {
+	loc, err := loadLocation(in.Timezone)
+	if err != nil {
+		return dateResult{}, err
+	}
+	start, err := parseDate(in.Start, loc)
+	if err != nil {
+		return dateResult{}, err
+	}
+	end := start.AddDate(in.Years, in.Months, in.Days).Add(time.Duration(in.Hours) * time.Hour)
+	return newDateResult(end), nil
+}
+
+// maxBusinessDays bounds business_days_add to about fourteen years either
+// way, far beyond any access duration, so one call can't spin for long.
+const maxBusinessDays = 3650
+
+type businessDaysAddInput struct {
+	Start    string   `json:"start,omitempty" description:"Start date (YYYY-MM-DD), default today"`
+	Days     int      `json:"days" description:"Business days to add, at most 3650 either way; negative counts backwards"`
+	Holidays []string `json:"holidays,omitempty" description:"Dates (YYYY-MM-DD) that are not business days"`
+}
+
+func businessDaysAdd(ctx context.Context, in businessDaysAddInput) (dateResult, error) {
+	if in.Days > maxBusinessDays || in.Days < -maxBusinessDays {
+		return dateResult{}, fmt.Errorf("days must be between -%d and %d, got %d", maxBusinessDays, maxBusinessDays, in.Days)
+	}
+	start, err := parseDate(in.Start, time.UTC)
+	if err != nil {
+		return dateResult{}, err
+	}
+	holidays, err := holidaySet(in.Holidays)
+	if err != nil {
+		return dateResult{}, err
+	}
+
+	step := 1
+	if in.Days < 0 {
+		step = -1
+	}
+	day := start
+	for remaining := in.Days * step; remaining > 0; {
+		// Holidays can make the walk much longer than days
+		if err := ctx.Err(); err != nil {
+			return dateResult{}, err
+		}
+		day = day.AddDate(0, 0, step)
+		if isBusinessDay(day, holidays) {
+			remaining--
+		}
+	}
+	return newDateResult(day), nil
+}
+
+type businessDaysBetweenInput struct {
+	Start    string   `json:"start" description:"First date (YYYY-MM-DD), counted"`
+	End      string   `json:"end" description:"Last date (YYYY-MM-DD), not counted"`
+	Holidays []string `json:"holidays,omitempty" description:"Dates (YYYY-MM-DD) that are not business days"`
+}
+
+type businessDaysBetweenResult struct {
+	BusinessDays int `json:"business_days"`
+	CalendarDays int `json:"calendar_days"`
+}
+
+func businessDaysBetween(ctx context.Context, in businessDaysBetweenInput) (businessDaysBetweenResult, error) {
+	start, err := parseDate(in.Start, time.UTC)
+	if err != nil {
+		return businessDaysBetweenResult{}, err
+	}
+	end, err := parseDate(in.End, time.UTC)
+	if err != nil {
+		return businessDaysBetweenResult{}, err
+	}
+	if end.Before(start) {
+		return businessDaysBetweenResult{}, fmt.Errorf("end %s is before start %s", in.End, in.Start)
+	}
+	holidays, err := holidaySet(in.Holidays)
+	if err != nil {
+		return businessDaysBetweenResult{}, err
+	}
+
+	var result businessDaysBetweenResult
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if err := ctx.Err(); err != nil {
+			return businessDaysBetweenResult{}, err
+		}
+		result.CalendarDays++
+		if isBusinessDay(day, holidays) {
+			result.BusinessDays++
+		}
+	}
+	return result, nil
+}
+
+type convertTimezoneInput struct {
+	Time string `json:"time" description:"Date and time (YYYY-MM-DD HH:MM or RFC 3339)"`
+	From string `json:"from" description:"IANA timezone the time is in, ignored if time has an offset"`
+	To   string `json:"to" description:"IANA timezone to convert to"`
+}
+
+func convertTimezone(_ context.Context, in convertTimezoneInput) (dateResult, error) {
+	from, err := loadLocation(in.From)
+	if err != nil {
+		return dateResult{}, err
+	}
+	to, err := loadLocation(in.To)
+	if err != nil {
+		return dateResult{}, err
+	}
+	t, err := parseDate(in.Time, from)
+	if err != nil {
+		return dateResult{}, err
+	}
+	return newDateResult(t.In(to)), nil
+}
+
+func holidaySet(dates []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		t, err := time.Parse(time.DateOnly, d)
+		if err != nil {
+			return nil, fmt.Errorf("holiday %q must be YYYY-MM-DD", d)
+		}
+		set[t.Format(time.DateOnly)] = true
+	}
+	return set, nil
+}
+
+func isBusinessDay(t time.Time, holidays map[string]bool) bool {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	return !holidays[t.Format(time.DateOnly)]
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBusinessDaysAdd(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		in      businessDaysAddInput
+		want    string
+		wantErr string
+	}{
+		{name: "over a weekend", in: businessDaysAddInput{Start: "2026-03-06", Days: 1}, want: "2026-03-09T00:00:00Z"},
+		{name: "backwards", in: businessDaysAddInput{Start: "2026-03-09", Days: -1}, want: "2026-03-06T00:00:00Z"},
+		{name: "past a holiday", in: businessDaysAddInput{Start: "2026-03-06", Days: 1, Holidays: []string{"2026-03-09"}}, want: "2026-03-10T00:00:00Z"},
+		{name: "at the limit", in: businessDaysAddInput{Start: "2026-03-06", Days: maxBusinessDays}, want: "2040-03-02T00:00:00Z"},
+		{name: "too many", in: businessDaysAddInput{Start: "2026-03-06", Days: maxBusinessDays + 1}, wantErr: "between -3650 and 3650"},
+		{name: "too many backwards", in: businessDaysAddInput{Start: "2026-03-06", Days: -1 << 40}, wantErr: "between -3650 and 3650"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := businessDaysAdd(context.Background(), tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("businessDaysAdd() = %v, %v, want %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.Date != tt.want {
+				t.Errorf("businessDaysAdd() = %s, %v, want %s", got.Date, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusinessDaysCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := businessDaysAdd(ctx, businessDaysAddInput{Start: "2026-03-06", Days: 10}); !errors.Is(err, context.Canceled) {
+		t.Errorf("businessDaysAdd() error = %v, want context.Canceled", err)
+	}
+	if _, err := businessDaysBetween(ctx, businessDaysBetweenInput{Start: "0001-01-01", End: "9999-12-31"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("businessDaysBetween() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,247 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Call is one tool_use block emitted by the model.
+type Call struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// Result is the outcome of a Call, sent back as a tool_result block.
+type Result struct {
+	ID      string
+	Content string
+	IsError bool
+}
+
+// Summarizer condenses a tool result to roughly maxTokens tokens.
+type Summarizer func(ctx context.Context, tool, content string, maxTokens int) (string, error)
+
+// Config controls tool execution.
+type Config struct {
+	// Workers is the maximum number of tool calls running at once.
+	Workers int
+	// Timeout limits each call unless the tool sets its own.
+	Timeout time.Duration
+	// MaxResultTokens is the estimated size above which a result is
+	// compacted before it is sent back to the model; 0 disables the limit.
+	MaxResultTokens int
+	// Summarize compacts oversized results. When nil, or when it fails,
+	// results are truncated instead.
+	Summarize Summarizer
+}
+
+// ConfigFromEnv reads TOOL_WORKERS (default 4), TOOL_TIMEOUT (a Go
+// duration, default 30s) and TOOL_RESULT_MAX_TOKENS (default 4000).
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Workers: 4, Timeout: 30 * time.Second, MaxResultTokens: 4000}
+	if v := os.Getenv("TOOL_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("TOOL_WORKERS must be a positive integer, got %q", v)
+		}
+		cfg.Workers = n
+	}
+	if v := os.Getenv("TOOL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("TOOL_TIMEOUT: %w", err)
+		}
+		cfg.Timeout = d
+	}
+	if v := os.Getenv("TOOL_RESULT_MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("TOOL_RESULT_MAX_TOKENS must be a non-negative integer, got %q", v)
+		}
+		cfg.MaxResultTokens = n
+	}
+	return cfg, nil
+}
+
+// Executor runs tool calls with a bounded worker pool.
+type Executor struct {
+	tools     map[string]Tool
+	order     []string
+	workers   int
+	timeout   time.Duration
+	maxTokens int
+	summarize Summarizer
+	tracer    trace.Tracer
+}
+
+// NewExecutor returns an executor for toolset.
+func NewExecutor(tracer trace.Tracer, cfg Config, toolset []Tool) *Executor {
+	e := &Executor{
+		tools:     make(map[string]Tool, len(toolset)),
+		workers:   max(cfg.Workers, 1),
+		timeout:   cfg.Timeout,
+		maxTokens: cfg.MaxResultTokens,
+		summarize: cfg.Summarize,
+		tracer:    tracer,
+	}
+	for _, t := range toolset {
+		if _, dup := e.tools[t.Name]; !dup {
+			e.order = append(e.order, t.Name)
+		}
+		e.tools[t.Name] = t
+	}
+	return e
+}
+
+// Params returns the tool definitions for the Anthropic request.
+func (e *Executor) Params() []anthropic.ToolUnionParam {
+	params := make([]anthropic.ToolUnionParam, 0, len(e.order))
+	for _, name := range e.order {
+		params = append(params, e.tools[name].Param())
+	}
+	return params
+}
+
+// Run executes calls concurrently. Results are returned in the order of
+// calls, and every call gets a span that is a child of ctx's span.
+func (e *Executor) Run(ctx context.Context, calls []Call) []Result {
+	results := make([]Result, len(calls))
+	sem := make(chan struct{}, e.workers)
+	var wg sync.WaitGroup
+
+	for i, call := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = e.run(ctx, call)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Call runs a single tool by name, for callers outside the model's tool loop.
+func (e *Executor) Call(ctx context.Context, name string, input json.RawMessage) (string, error) {
+	r := e.run(ctx, Call{ID: "direct", Name: name, Input: input})
+	if r.IsError {
+		return "", errors.New(r.Content)
+	}
+	return r.Content, nil
+}
+
+func (e *Executor) run(ctx context.Context, call Call) Result {
+	ctx, span := e.tracer.Start(ctx, call.Name,
+		trace.WithAttributes(
+			attribute.String("langsmith.span.kind", "tool"),
+			attribute.String("gen_ai.tool.name", call.Name),
+			attribute.String("gen_ai.tool.call.id", call.ID),
+			attribute.String("gen_ai.prompt", string(call.Input)),
+		),
+	)
+	defer span.End()
+
+	content, err := e.invoke(ctx, call)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Result{ID: call.ID, Content: err.Error(), IsError: true}
+	}
+
+	content = e.compact(ctx, span, call.Name, content)
+	span.SetAttributes(attribute.String("gen_ai.completion", content))
+	return Result{ID: call.ID, Content: content}
+}
+
+// compact shrinks results over the token limit so large outputs don't blow
+// the context window, recording the sizes and compression ratio on span.
+func (e *Executor) compact(ctx context.Context, span trace.Span, tool, content string) string {
+	original := EstimateTokens(content)
+	method := "none"
+	if e.maxTokens > 0 && original > e.maxTokens {
+		method = "truncated"
+		compacted := ""
+		if e.summarize != nil {
+			summary, err := e.summarize(ctx, tool, content, e.maxTokens)
+			if err == nil && EstimateTokens(summary) <= e.maxTokens {
+				method, compacted = "summarized", summary
+			} else if err != nil {
+				span.AddEvent("summarize_failed", trace.WithAttributes(attribute.String("error", err.Error())))
+			}
+		}
+		if method == "truncated" {
+			compacted = Truncate(content, e.maxTokens)
+		}
+		content = compacted
+	}
+
+	final := EstimateTokens(content)
+	ratio := 1.0
+	if original > 0 {
+		ratio = float64(final) / float64(original)
+	}
+	span.SetAttributes(
+		attribute.String("tool.result.compaction", method),
+		attribute.Int("tool.result.original_tokens", original),
+		attribute.Int("tool.result.tokens", final),
+		attribute.Float64("tool.result.compression_ratio", ratio),
+	)
+	return content
+}
+
+func (e *Executor) invoke(ctx context.Context, call Call) (string, error) {
+	t, ok := e.tools[call.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", call.Name)
+	}
+
+	timeout := e.timeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type outcome struct {
+		content string
+		err     error
+	}
+	// Buffered so a handler that ignores ctx can still finish after a timeout
+	done := make(chan outcome, 1)
+	go func() {
+		// A panicking tool fails its call, not the whole turn
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("tool %q panicked: %v", call.Name, r)}
+			}
+		}()
+		content, err := t.Handler(ctx, call.Input)
+		done <- outcome{content, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.content, o.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("tool %q timed out after %s", call.Name, timeout)
+		}
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Option configures a tool passed to Register.
+type Option func(*Tool)
+
+// WithDescription sets the description the model sees for the tool.
+func WithDescription(description string) Option {
+	return func(t *Tool) { t.Description = description }
+}
+
+// WithTimeout overrides the executor's default timeout for the tool.
+func WithTimeout(d time.Duration) Option {
+	return func(t *Tool) { t.Timeout = d }
+}
+
+// Register adds a tool whose input schema is derived from fn's input struct
+// (see Schema), so the definition and the handler can't drift apart. The
+// model's input is decoded into In; a string result is returned as is and
+// anything else is sent back as JSON. Register panics if In is not a struct,
+// so it is meant to be called from init or main.
+func Register[In, Out any](name string, fn func(context.Context, In) (Out, error), opts ...Option) {
+	schema, err := Schema(reflect.TypeFor[In]())
+	if err != nil {
+		panic(fmt.Sprintf("tools.Register(%q): %v", name, err))
+	}
+
+	t := Tool{
+		Name:        name,
+		InputSchema: schema,
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var in In
+			if len(raw) > 0 {
+				if err := json.Unmarshal(raw, &in); err != nil {
+					return "", fmt.Errorf("invalid input for %s: %w", name, err)
+				}
+			}
+
+			out, err := fn(ctx, in)
+			if err != nil {
+				return "", err
+			}
+			if s, ok := any(out).(string); ok {
+				return s, nil
+			}
+			data, err := json.Marshal(out)
+			if err != nil {
+				return "", fmt.Errorf("encoding %s result: %w", name, err)
+			}
+			return string(data), nil
+		},
+	}
+	for _, opt := range opts {
+		opt(&t)
+	}
+	Add(t)
+}
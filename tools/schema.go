@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	durationType   = reflect.TypeFor[Duration]()
+	stdDuration    = reflect.TypeFor[time.Duration]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// Duration is a time.Duration for tool inputs. It is given as a Go
+// duration string such as "90m" or "24h", which is what its schema tells
+// the model; encoding/json would read a plain time.Duration as nanoseconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as 90m or 24h: %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, in the same form.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Schema derives a tool input schema from a struct type. Property names come
+// from `json` tags; fields without omitempty are required. A `description`
+// tag documents a field and an `enum` tag lists allowed values separated by
+// commas.
+//
+//	type grantInput struct {
+//		Resource string `json:"resource" description:"System to grant access to"`
+//		Level    string `json:"level" enum:"read,write,admin"`
+//		Days     int    `json:"days,omitempty"`
+//	}
+//
+// A tools.Duration is advertised as a duration string; a plain
+// time.Duration is rejected, since the model can't be told its unit.
+func Schema(t reflect.Type) (anthropic.ToolInputSchemaParam, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return anthropic.ToolInputSchemaParam{}, fmt.Errorf("tool input must be a struct, got %s", t)
+	}
+
+	props, required, err := structProperties(t)
+	if err != nil {
+		return anthropic.ToolInputSchemaParam{}, err
+	}
+	return anthropic.ToolInputSchemaParam{Properties: props, Required: required}, nil
+}
+
+func structProperties(t reflect.Type) (map[string]any, []string, error) {
+	props := map[string]any{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := typeSchema(f.Type)
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if desc := f.Tag.Get("description"); desc != "" {
+			prop["description"] = desc
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			prop["enum"] = strings.Split(enum, ",")
+		}
+		props[name] = prop
+
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	return props, required, nil
+}
+
+func typeSchema(t reflect.Type) (map[string]any, error) {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	case durationType:
+		return map[string]any{"type": "string", "description": "Go duration such as 90m or 24h"}, nil
+	case rawMessageType:
+		return map[string]any{}, nil
+	case stdDuration:
+		return nil, errors.New("time.Duration decodes from nanoseconds; use tools.Duration")
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, got %s", t.Key())
+		}
+		values, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		props, required, err := structProperties(t)
+		if err != nil {
+			return nil, err
+		}
+		schema := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
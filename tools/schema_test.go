@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationSchemaMatchesDecoding(t *testing.T) {
+	type input struct {
+		Timeout Duration `json:"timeout"`
+	}
+	schema, err := Schema(reflect.TypeFor[input]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := schema.Properties.(map[string]any)["timeout"].(map[string]any)["type"]; typ != "string" {
+		t.Fatalf("timeout is advertised as %v, want string", typ)
+	}
+
+	for _, tt := range []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: `{"timeout":"24h"}`, want: 24 * time.Hour},
+		{in: `{"timeout":"90m"}`, want: 90 * time.Minute},
+		{in: `{"timeout":86400000000000}`, wantErr: true},
+		{in: `{"timeout":"a day"}`, wantErr: true},
+	} {
+		var got input
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err != nil) != tt.wantErr || time.Duration(got.Timeout) != tt.want {
+			t.Errorf("decoding %s = %v, %v, want %v (error %v)", tt.in, time.Duration(got.Timeout), err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSchemaRejectsTimeDuration(t *testing.T) {
+	type input struct {
+		Timeout time.Duration `json:"timeout"`
+	}
+	if _, err := Schema(reflect.TypeFor[input]()); err == nil || !strings.Contains(err.Error(), "tools.Duration") {
+		t.Errorf("Schema() error = %v, want it to point at tools.Duration", err)
+	}
+}
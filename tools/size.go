@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// charsPerToken is the rough ratio used to estimate token counts without a
+// tokenizer round trip.
+const charsPerToken = 4
+
+// EstimateTokens returns an approximate token count for s.
+func EstimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + charsPerToken - 1) / charsPerToken
+}
+
+// Truncate cuts s to about maxTokens tokens, keeping the start and the end
+// since tool output usually leads with headers and ends with totals.
+func Truncate(s string, maxTokens int) string {
+	runes := []rune(s)
+	budget := maxTokens * charsPerToken
+	if len(runes) <= budget {
+		return s
+	}
+
+	marker := fmt.Sprintf("\n\n[... %d of %d characters truncated ...]\n\n", len(runes)-budget, len(runes))
+	keep := max(budget-utf8.RuneCountInString(marker), 0)
+	head := keep * 3 / 4
+	tail := keep - head
+	return string(runes[:head]) + marker + string(runes[len(runes)-tail:])
+}
@@ -0,0 +1,80 @@
+// Package tools defines the tools the model can call and runs tool calls
+// concurrently, each in its own span under the turn.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Handler executes a tool call and returns its result as text.
+type Handler func(ctx context.Context, input json.RawMessage) (string, error)
+
+// Tool is a callable the model can use.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema anthropic.ToolInputSchemaParam
+	Handler     Handler
+	// Timeout overrides the executor's default per-call timeout.
+	Timeout time.Duration
+}
+
+// Param returns the tool definition sent to the Anthropic API.
+func (t Tool) Param() anthropic.ToolUnionParam {
+	return anthropic.ToolUnionParam{
+		OfTool: &anthropic.ToolParam{
+			Name:        t.Name,
+			Description: anthropic.String(t.Description),
+			InputSchema: t.InputSchema,
+		},
+	}
+}
+
+var (
+	mu         sync.RWMutex
+	registered = map[string]Tool{}
+)
+
+// Add makes t available by name to Lookup. Adding a tool with the same name
+// replaces the previous one.
+func Add(t Tool) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered[t.Name] = t
+}
+
+// Lookup returns the tools with the given names, in the same order.
+func Lookup(names ...string) ([]Tool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	found := make([]Tool, 0, len(names))
+	for _, name := range names {
+		t, ok := registered[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		found = append(found, t)
+	}
+	return found, nil
+}
+
+// Names returns the names of all added tools, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
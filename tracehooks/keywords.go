@@ -0,0 +1,70 @@
+package tracehooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Keywords returns a hook that records which of keywords the request's
+// messages mention, matched case-insensitively, as prompt.keywords. Nothing
+// is recorded when none match.
+func Keywords(keywords []string) RequestHook {
+	lower := make([]string, len(keywords))
+	for i, k := range keywords {
+		lower[i] = strings.ToLower(k)
+	}
+	return func(_ *http.Request, body []byte) []attribute.KeyValue {
+		var params struct {
+			Messages []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		if json.Unmarshal(body, &params) != nil {
+			return nil
+		}
+		var text strings.Builder
+		for _, m := range params.Messages {
+			var s string
+			if json.Unmarshal(m.Content, &s) == nil {
+				text.WriteString(strings.ToLower(s))
+				continue
+			}
+			var blocks []struct {
+				Text string `json:"text"`
+			}
+			json.Unmarshal(m.Content, &blocks)
+			for _, b := range blocks {
+				text.WriteString(strings.ToLower(b.Text))
+				text.WriteByte('\n')
+			}
+		}
+		var found []string
+		for i, k := range lower {
+			if strings.Contains(text.String(), k) {
+				found = append(found, keywords[i])
+			}
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		return []attribute.KeyValue{attribute.StringSlice("prompt.keywords", found)}
+	}
+}
+
+// FromEnv registers a Keywords hook for the comma-separated
+// PROMPT_KEYWORDS, if set.
+func FromEnv() {
+	var keywords []string
+	for _, k := range strings.Split(os.Getenv("PROMPT_KEYWORDS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	if len(keywords) > 0 {
+		OnRequest(Keywords(keywords))
+	}
+}
@@ -0,0 +1,184 @@
+// Package tracehooks lets embedders add their own attributes to the spans
+// of the traced Anthropic client, computed from the raw request and
+// response, without forking the instrumentation. Hooks are registered with
+// OnRequest and OnResponse; the client carries Option, and the tracer
+// provider carries Processor and an exporter wrapped with WrapExporter.
+package tracehooks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestHook returns attributes for a request's span. body is the request
+// body, which the hook must not modify.
+type RequestHook func(req *http.Request, body []byte) []attribute.KeyValue
+
+// ResponseHook returns attributes for a request's span once its response
+// is in. body is nil for streaming responses, which are left unread.
+type ResponseHook func(resp *http.Response, body []byte) []attribute.KeyValue
+
+var (
+	mu            sync.RWMutex
+	requestHooks  []RequestHook
+	responseHooks []ResponseHook
+
+	// pending holds response attributes for spans that ended before their
+	// response was read, until the span is exported.
+	pendingMu sync.Mutex
+	pending   = map[trace.SpanID][]attribute.KeyValue{}
+)
+
+// OnRequest adds a hook run on every Messages request, before it is sent.
+// It is meant to be called from init or main.
+func OnRequest(h RequestHook) {
+	mu.Lock()
+	defer mu.Unlock()
+	requestHooks = append(requestHooks, h)
+}
+
+// OnResponse adds a hook run on every Messages response. It is meant to be
+// called from init or main.
+func OnResponse(h ResponseHook) {
+	mu.Lock()
+	defer mu.Unlock()
+	responseHooks = append(responseHooks, h)
+}
+
+// call is a request in flight, passed in its context from the middleware
+// to the processor.
+type call struct {
+	attrs []attribute.KeyValue
+	span  sdktrace.ReadWriteSpan
+}
+
+type callKey struct{}
+
+// Option returns the request option that runs the hooks. It must come
+// before other middleware, so spans those start are tagged too.
+func Option() option.RequestOption {
+	return option.WithMiddleware(middleware)
+}
+
+func middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	mu.RLock()
+	reqHooks, respHooks := requestHooks, responseHooks
+	mu.RUnlock()
+	if len(reqHooks)+len(respHooks) == 0 || req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/v1/messages") {
+		return next(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	c := &call{}
+	for _, h := range reqHooks {
+		c.attrs = append(c.attrs, h(req, body)...)
+	}
+	resp, err := next(req.WithContext(context.WithValue(req.Context(), callKey{}, c)))
+	if err != nil || len(respHooks) == 0 || c.span == nil {
+		return resp, err
+	}
+
+	var respBody []byte
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		respBody, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	}
+	var attrs []attribute.KeyValue
+	for _, h := range respHooks {
+		attrs = append(attrs, h(resp, respBody)...)
+	}
+	if len(attrs) == 0 {
+		return resp, nil
+	}
+	// A streaming span is still open; a buffered one usually has ended, so
+	// its attributes are added on export
+	if c.span.IsRecording() {
+		c.span.SetAttributes(attrs...)
+		return resp, nil
+	}
+	if c.span.SpanContext().IsSampled() {
+		pendingMu.Lock()
+		pending[c.span.SpanContext().SpanID()] = attrs
+		pendingMu.Unlock()
+	}
+	return resp, nil
+}
+
+// Processor records request hook attributes on the span the traced client
+// starts for a request, and remembers it for the response hooks.
+type Processor struct{}
+
+func (Processor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok || c.span != nil {
+		return
+	}
+	c.span = s
+	s.SetAttributes(c.attrs...)
+}
+
+func (Processor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (Processor) Shutdown(context.Context) error   { return nil }
+func (Processor) ForceFlush(context.Context) error { return nil }
+
+// WrapExporter returns exporter with response hook attributes added to
+// spans that ended before their response was read. It relies on the batch
+// processor exporting after the response hooks have run, which a
+// synchronous exporter doesn't wait for.
+func WrapExporter(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	return hookedExporter{exporter}
+}
+
+type hookedExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e hookedExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	pendingMu.Lock()
+	if len(pending) > 0 {
+		out := make([]sdktrace.ReadOnlySpan, len(spans))
+		for i, s := range spans {
+			out[i] = s
+			if attrs, ok := pending[s.SpanContext().SpanID()]; ok {
+				delete(pending, s.SpanContext().SpanID())
+				out[i] = hookedSpan{s, attrs}
+			}
+		}
+		spans = out
+	}
+	pendingMu.Unlock()
+	return e.SpanExporter.ExportSpans(ctx, spans)
+}
+
+// hookedSpan reports a span's attributes with extra ones appended.
+type hookedSpan struct {
+	sdktrace.ReadOnlySpan
+	extra []attribute.KeyValue
+}
+
+func (s hookedSpan) Attributes() []attribute.KeyValue {
+	return slices.Concat(s.ReadOnlySpan.Attributes(), s.extra)
+}